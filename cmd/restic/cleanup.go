@@ -67,12 +67,14 @@ func RunCleanupHandlers() {
 	cleanupHandlers.list = nil
 }
 
-// CleanupHandler handles the SIGINT signal.
+// CleanupHandler handles the SIGINT signal. It runs the cleanup handlers and
+// exits with the code of a process terminated by SIGINT, so that an
+// interrupted command is not mistaken for a successful one.
 func CleanupHandler(c <-chan os.Signal) {
 	for s := range c {
 		debug.Log("signal %v received, cleaning up", s)
 		fmt.Printf("%sInterrupt received, cleaning up\n", ClearLine())
-		Exit(0)
+		Exit(exitCodeInterrupted)
 	}
 }
 
@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -16,6 +20,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -42,6 +47,22 @@ given as the arguments.
 			return errors.Fatal("cannot use both `--stdin` and `--files-from -`")
 		}
 
+		if backupOptions.Stdin && backupOptions.FilesFromRaw == "-" {
+			return errors.Fatal("cannot use both `--stdin` and `--files-from-raw -`")
+		}
+
+		if backupOptions.FilesFrom == "-" && backupOptions.FilesFromRaw == "-" {
+			return errors.Fatal("cannot use both `--files-from -` and `--files-from-raw -`")
+		}
+
+		if backupOptions.Stdin && backupOptions.UseFsSnapshot {
+			return errors.Fatal("cannot use both `--stdin` and `--use-fs-snapshot`")
+		}
+
+		if backupOptions.Stdin && backupOptions.DryRun {
+			return errors.Fatal("cannot use both `--stdin` and `--dry-run`")
+		}
+
 		if backupOptions.Stdin {
 			return readBackupFromStdin(backupOptions, globalOptions, args)
 		}
@@ -52,19 +73,33 @@ given as the arguments.
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
-	Parent           string
-	Force            bool
-	Excludes         []string
-	ExcludeFiles     []string
-	ExcludeOtherFS   bool
-	ExcludeIfPresent []string
-	ExcludeCaches    bool
-	Stdin            bool
-	StdinFilename    string
-	Tags             []string
-	Hostname         string
-	FilesFrom        string
-	TimeStamp        string
+	Parent                  string
+	Force                   bool
+	Excludes                []string
+	ExcludeFiles            []string
+	Includes                []string
+	IncludeFiles            []string
+	InsensitiveExcludes     []string
+	InsensitiveExcludeFiles []string
+	InsensitiveIncludes     []string
+	InsensitiveIncludeFiles []string
+	ExcludeOtherFS          bool
+	ExcludeIfPresent        []string
+	ExcludeCaches           bool
+	ExcludeLargerThan       string
+	Stdin                   bool
+	StdinFilename           string
+	Tags                    []string
+	Hostname                string
+	FilesFrom               string
+	FilesFromRaw            string
+	TimeStamp               string
+	UseFsSnapshot           bool
+	NoXattrs                bool
+	DryRun                  bool
+	CheckpointInterval      time.Duration
+	ReadConcurrency         uint
+	Compression             string
 }
 
 var backupOptions BackupOptions
@@ -77,19 +112,35 @@ func init() {
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
 	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
 	f.StringArrayVar(&backupOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+	f.StringArrayVarP(&backupOptions.Includes, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.IncludeFiles, "include-file", nil, "read include patterns from a `file` (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
+	f.StringArrayVar(&backupOptions.InsensitiveExcludeFiles, "iexclude-file", nil, "same as --exclude-file but ignores the casing of filenames in patterns read from `file`")
+	f.StringArrayVar(&backupOptions.InsensitiveIncludes, "iinclude", nil, "same as --include `pattern` but ignores the casing of filenames")
+	f.StringArrayVar(&backupOptions.InsensitiveIncludeFiles, "iinclude-file", nil, "same as --include-file but ignores the casing of filenames in patterns read from `file`")
 	f.BoolVarP(&backupOptions.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems")
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes filename[:header], exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file`)
+	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "file name to use when reading from stdin")
 	f.StringArrayVar(&backupOptions.Tags, "tag", nil, "add a `tag` for the new snapshot (can be specified multiple times)")
 	f.StringVar(&backupOptions.Hostname, "hostname", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
 	f.StringVar(&backupOptions.FilesFrom, "files-from", "", "read the files to backup from file (can be combined with file args)")
+	f.StringVar(&backupOptions.FilesFromRaw, "files-from-raw", "", "read the NUL separated files to backup from file (can be combined with file args)")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "time of the backup (ex. '2012-11-01 22:08:41') (default: now)")
+	f.BoolVar(&backupOptions.NoXattrs, "no-xattrs", false, "do not save extended attributes")
+	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
+	f.UintVar(&backupOptions.ReadConcurrency, "read-concurrency", 0, "read and chunk `n` files in parallel (default: 10)")
+	f.StringVar(&backupOptions.Compression, "compression", restic.CompressionAuto, "compression `mode` for new data, one of off, auto or max (only used if the repository supports compression)")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 5*time.Minute, "save the index of the data uploaded so far every `duration`, so that an interrupted backup can be resumed (0 to disable)")
+	if runtime.GOOS == "windows" {
+		f.BoolVar(&backupOptions.UseFsSnapshot, "use-fs-snapshot", false, "use a Volume Shadow Copy to read the files (requires administrator rights)")
+	}
 }
 
 func newScanProgress(gopts GlobalOptions) *restic.Progress {
-	if gopts.Quiet {
+	if gopts.Quiet || gopts.JSON {
 		return nil
 	}
 
@@ -110,7 +161,7 @@ func newScanProgress(gopts GlobalOptions) *restic.Progress {
 }
 
 func newArchiveProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
-	if gopts.Quiet {
+	if gopts.Quiet || gopts.JSON {
 		return nil
 	}
 
@@ -167,7 +218,7 @@ func newArchiveProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress
 }
 
 func newArchiveStdinProgress(gopts GlobalOptions) *restic.Progress {
-	if gopts.Quiet {
+	if gopts.Quiet || gopts.JSON {
 		return nil
 	}
 
@@ -210,6 +261,77 @@ func newArchiveStdinProgress(gopts GlobalOptions) *restic.Progress {
 	return archiveProgress
 }
 
+// backupSummary is printed as JSON when a backup is complete.
+type backupSummary struct {
+	MessageType    string  `json:"message_type"` // "summary"
+	SnapshotID     string  `json:"snapshot_id,omitempty"`
+	DryRun         bool    `json:"dry_run,omitempty"`
+	FilesNew       uint64  `json:"files_new"`
+	FilesChanged   uint64  `json:"files_changed"`
+	DataAdded      uint64  `json:"data_added"`
+	FilesProcessed uint64  `json:"files_processed"`
+	DirsProcessed  uint64  `json:"dirs_processed"`
+	BytesProcessed uint64  `json:"bytes_processed"`
+	Errors         uint64  `json:"errors"`
+	TotalDuration  float64 `json:"total_duration"` // in seconds
+}
+
+// newJSONSummaryProgress returns a progress which does not print anything,
+// but records the final statistics in summary.
+func newJSONSummaryProgress(summary *backupSummary) *restic.Progress {
+	p := restic.NewProgress()
+	p.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
+		summary.FilesProcessed = s.Files
+		summary.DirsProcessed = s.Dirs
+		summary.BytesProcessed = s.Bytes
+		summary.Errors = s.Errors
+		summary.TotalDuration = d.Seconds()
+	}
+	return p
+}
+
+// printBackupSummary writes the JSON summary for the snapshot id to stdout.
+func printBackupSummary(gopts GlobalOptions, summary backupSummary, id restic.ID) error {
+	summary.MessageType = "summary"
+	if !id.IsNull() {
+		summary.SnapshotID = id.String()
+	}
+	return json.NewEncoder(gopts.stdout).Encode(summary)
+}
+
+// loadBackupIndex loads the index of the repository. The index cannot be read
+// with a write-only key, in this case new data is not deduplicated against the
+// data already contained in the repository.
+func loadBackupIndex(repo *repository.Repository) error {
+	if repo.WriteOnly() {
+		Verbosef("using a write-only key, data is not deduplicated against existing snapshots\n")
+		return nil
+	}
+
+	return repo.LoadIndex(context.TODO())
+}
+
+// checkQuota returns an error if the repository has already reached the
+// maximum size, so that no data is uploaded.
+func checkQuota(ctx context.Context, repo *repository.Repository) error {
+	max := repo.MaxSize()
+	if max == 0 {
+		return nil
+	}
+
+	size, err := repo.Size(ctx)
+	if err != nil {
+		return err
+	}
+	debug.Log("repository uses %d of %d bytes", size, max)
+	if size >= max {
+		return errors.Fatalf("repository size quota exceeded: %v of %v used, run forget and prune to free space",
+			formatBytes(size), formatBytes(max))
+	}
+
+	return nil
+}
+
 // filterExisting returns a slice of all existing items, or an error if no
 // items exist at all.
 func filterExisting(items []string) (result []string, err error) {
@@ -248,13 +370,26 @@ func readBackupFromStdin(opts BackupOptions, gopts GlobalOptions, args []string)
 		return err
 	}
 
+	if repo.ReadOnly() {
+		return errors.Fatal("backup cannot be run with a read-only key")
+	}
+
+	if err = repo.SetCompression(opts.Compression); err != nil {
+		return err
+	}
+
 	lock, err := lockRepo(repo)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
 	}
 
-	err = repo.LoadIndex(context.TODO())
+	err = loadBackupIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	err = checkQuota(gopts.ctx, repo)
 	if err != nil {
 		return err
 	}
@@ -265,11 +400,21 @@ func readBackupFromStdin(opts BackupOptions, gopts GlobalOptions, args []string)
 		Hostname:   opts.Hostname,
 	}
 
-	_, id, err := r.Archive(context.TODO(), opts.StdinFilename, os.Stdin, newArchiveStdinProgress(gopts))
+	var summary backupSummary
+	p := newArchiveStdinProgress(gopts)
+	if gopts.JSON {
+		p = newJSONSummaryProgress(&summary)
+	}
+
+	_, id, err := r.Archive(gopts.ctx, opts.StdinFilename, os.Stdin, p)
 	if err != nil {
 		return err
 	}
 
+	if gopts.JSON {
+		return printBackupSummary(gopts, summary, id)
+	}
+
 	Verbosef("archived as %v\n", id.Str())
 	return nil
 }
@@ -311,8 +456,42 @@ func readLinesFromFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
+// readFilenamesRaw reads a list of file names separated by NUL bytes from the
+// file filename (or the standard input for a dash), as printed e.g. by
+// "find -print0". Empty names are ignored.
+func readFilenamesRaw(filename string) ([]string, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(buf), "\x00") {
+		if name == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
 func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
-	if opts.FilesFrom == "-" && gopts.password == "" {
+	if (opts.FilesFrom == "-" || opts.FilesFromRaw == "-") && gopts.password == "" {
 		return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 	}
 
@@ -321,10 +500,20 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	fromfileRaw, err := readFilenamesRaw(opts.FilesFromRaw)
+	if err != nil {
+		return err
+	}
+
 	// merge files from files-from into normal args so we can reuse the normal
 	// args checks and have the ability to use both files-from and args at the
 	// same time
 	args = append(args, fromfile...)
+	args = append(args, fromfileRaw...)
+	if len(args) == 0 {
+		// fall back to the paths configured in the profile
+		args = gopts.profilePaths
+	}
 	if len(args) == 0 {
 		return errors.Fatal("nothing to backup, please specify target files/dirs")
 	}
@@ -356,17 +545,47 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 
 	// add patterns from file
 	if len(opts.ExcludeFiles) > 0 {
-		opts.Excludes = append(opts.Excludes, readExcludePatternsFromFiles(opts.ExcludeFiles)...)
+		opts.Excludes = append(opts.Excludes, readPatternsFromFiles(opts.ExcludeFiles)...)
 	}
 
 	if len(opts.Excludes) > 0 {
 		rejectFuncs = append(rejectFuncs, rejectByPattern(opts.Excludes))
 	}
 
+	if len(opts.InsensitiveExcludeFiles) > 0 {
+		opts.InsensitiveExcludes = append(opts.InsensitiveExcludes, readPatternsFromFiles(opts.InsensitiveExcludeFiles)...)
+	}
+
+	if len(opts.InsensitiveExcludes) > 0 {
+		rejectFuncs = append(rejectFuncs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
+	}
+
+	// add include patterns from file
+	if len(opts.IncludeFiles) > 0 {
+		opts.Includes = append(opts.Includes, readPatternsFromFiles(opts.IncludeFiles)...)
+	}
+
+	if len(opts.InsensitiveIncludeFiles) > 0 {
+		opts.InsensitiveIncludes = append(opts.InsensitiveIncludes, readPatternsFromFiles(opts.InsensitiveIncludeFiles)...)
+	}
+
+	if len(opts.Includes) > 0 || len(opts.InsensitiveIncludes) > 0 {
+		rejectFuncs = append(rejectFuncs, rejectByInclude(opts.Includes, opts.InsensitiveIncludes))
+	}
+
 	if opts.ExcludeCaches {
 		opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
 	}
 
+	if opts.ExcludeLargerThan != "" {
+		maxSize, err := parseSizeStr(opts.ExcludeLargerThan)
+		if err != nil {
+			return errors.Fatalf("invalid value for --exclude-larger-than: %v", err)
+		}
+
+		rejectFuncs = append(rejectFuncs, rejectBySize(maxSize))
+	}
+
 	for _, spec := range opts.ExcludeIfPresent {
 		f, err := rejectIfPresent(spec)
 		if err != nil {
@@ -381,6 +600,14 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if repo.ReadOnly() {
+		return errors.Fatal("backup cannot be run with a read-only key")
+	}
+
+	if err = repo.SetCompression(opts.Compression); err != nil {
+		return err
+	}
+
 	lock, err := lockRepo(repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -397,13 +624,22 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		rejectFuncs = append(rejectFuncs, f)
 	}
 
-	err = repo.LoadIndex(context.TODO())
+	err = loadBackupIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	err = checkQuota(gopts.ctx, repo)
 	if err != nil {
 		return err
 	}
 
 	var parentSnapshotID *restic.ID
 
+	if repo.WriteOnly() && opts.Parent != "" {
+		return errors.Fatal("--parent cannot be used with a write-only key")
+	}
+
 	// Force using a parent
 	if !opts.Force && opts.Parent != "" {
 		id, err := restic.FindSnapshot(repo, opts.Parent)
@@ -414,8 +650,9 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		parentSnapshotID = &id
 	}
 
-	// Find last snapshot to set it as parent, if not already set
-	if !opts.Force && parentSnapshotID == nil {
+	// Find last snapshot to set it as parent, if not already set, snapshots
+	// cannot be read with a write-only key
+	if !opts.Force && parentSnapshotID == nil && !repo.WriteOnly() {
 		id, err := restic.FindLatestSnapshot(context.TODO(), repo, target, []restic.TagList{opts.Tags}, opts.Hostname)
 		if err == nil {
 			parentSnapshotID = &id
@@ -428,6 +665,20 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		Verbosef("using parent snapshot %v\n", parentSnapshotID.Str())
 	}
 
+	var localVss *fs.LocalVss
+	if opts.UseFsSnapshot {
+		localVss, err = fs.NewLocalVss(target, Verbosef)
+		if err != nil {
+			return errors.Fatalf("unable to create file system snapshot: %v", err)
+		}
+
+		defer func() {
+			if err := localVss.DeleteSnapshots(); err != nil {
+				Warnf("unable to remove file system snapshot: %v\n", err)
+			}
+		}()
+	}
+
 	Verbosef("scan %v\n", target)
 
 	selectFilter := func(item string, fi os.FileInfo) bool {
@@ -447,10 +698,35 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 	arch := archiver.New(repo)
 	arch.Excludes = opts.Excludes
 	arch.SelectFilter = selectFilter
+	arch.SkipExtendedAttributes = opts.NoXattrs
+	arch.CheckpointInterval = opts.CheckpointInterval
+	arch.ReadConcurrency = opts.ReadConcurrency
+	if localVss != nil {
+		arch.FS = localVss
+	}
+
+	if !opts.DryRun {
+		// when the backup is interrupted, save the index for all packs which
+		// have been uploaded so far, so that the next backup can reuse them
+		AddCleanupHandler(func() error {
+			debug.Log("saving index for already uploaded packs")
+			return repo.SaveIndex(context.Background())
+		})
+	}
+
+	var summary backupSummary
+	var summaryMutex sync.Mutex
+	var warnings uint64
 
 	arch.Warn = func(dir string, fi os.FileInfo, err error) {
+		summaryMutex.Lock()
+		warnings++
+		summaryMutex.Unlock()
+
 		// TODO: make ignoring errors configurable
 		Warnf("%s\rwarning for %s: %v\n", ClearLine(), dir, err)
+		logEvent("file_error", logFields{"path": dir, "error": err})
+		notifyError(dir, err)
 	}
 
 	timeStamp := time.Now()
@@ -461,19 +737,87 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
-	_, id, err := arch.Snapshot(context.TODO(), newArchiveProgress(gopts, stat), target, opts.Tags, opts.Hostname, parentSnapshotID, timeStamp)
+	arch.DryRun = opts.DryRun
+	arch.Changed = func(item string, modified bool) {
+		summaryMutex.Lock()
+		defer summaryMutex.Unlock()
+
+		action := "new"
+		if modified {
+			summary.FilesChanged++
+			action = "modified"
+		} else {
+			summary.FilesNew++
+		}
+
+		if opts.DryRun && !gopts.JSON {
+			Printf("%s\rwould add %-8s %v\n", ClearLine(), action, item)
+		}
+	}
+
+	p := newArchiveProgress(gopts, stat)
+	if gopts.JSON {
+		p = newJSONSummaryProgress(&summary)
+	}
+
+	sn, id, err := arch.Snapshot(gopts.ctx, p, target, opts.Tags, opts.Hostname, parentSnapshotID, timeStamp)
 	if err != nil {
 		return err
 	}
 
+	if !opts.DryRun {
+		// pack files saved by earlier backups must be kept as long as the
+		// new snapshot which references them
+		err = repo.ExtendObjectLock(gopts.ctx, *sn.Tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	summary.DataAdded = arch.DataAdded()
+	summary.DryRun = opts.DryRun
+
+	// the other statistics in summary are only collected in JSON mode
+	stats := map[string]interface{}{
+		"files_new":     summary.FilesNew,
+		"files_changed": summary.FilesChanged,
+		"data_added":    summary.DataAdded,
+	}
+	if !id.IsNull() {
+		stats["snapshot_id"] = id.String()
+	}
+	setNotificationStats(stats)
+
+	// the snapshot has been saved, but some files are missing from it
+	var incomplete error
+	if warnings > 0 {
+		incomplete = withExitCode(exitCodeIncomplete,
+			errors.Fatalf("%d source files or directories could not be read, the snapshot is incomplete", warnings))
+	}
+
+	if gopts.JSON {
+		if err := printBackupSummary(gopts, summary, id); err != nil {
+			return err
+		}
+		return incomplete
+	}
+
+	if opts.DryRun {
+		Printf("would add %d new and %d modified files, %s of new data\n",
+			summary.FilesNew, summary.FilesChanged, formatBytes(summary.DataAdded))
+		return incomplete
+	}
+
 	Verbosef("snapshot %s saved\n", id.Str())
 
-	return nil
+	return incomplete
 }
 
-func readExcludePatternsFromFiles(excludeFiles []string) []string {
-	var excludes []string
-	for _, filename := range excludeFiles {
+// readPatternsFromFiles reads the patterns from the given files, empty lines
+// and comments are ignored.
+func readPatternsFromFiles(files []string) []string {
+	var patterns []string
+	for _, filename := range files {
 		err := func() (err error) {
 			file, err := fs.Open(filename)
 			if err != nil {
@@ -501,14 +845,14 @@ func readExcludePatternsFromFiles(excludeFiles []string) []string {
 				}
 
 				line = os.ExpandEnv(line)
-				excludes = append(excludes, line)
+				patterns = append(patterns, line)
 			}
 			return scanner.Err()
 		}()
 		if err != nil {
-			Warnf("error reading exclude patterns: %v:", err)
+			Warnf("error reading patterns: %v:", err)
 			return nil
 		}
 	}
-	return excludes
+	return patterns
 }
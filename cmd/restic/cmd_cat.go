@@ -156,7 +156,7 @@ func runCat(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		hash := restic.Hash(buf)
+		hash := repo.Config().Hash.Sum(buf)
 		if !hash.Equal(id) {
 			fmt.Fprintf(stderr, "Warning: hash of data does not match ID, want\n  %v\ngot:\n  %v\n", id.String(), hash.String())
 		}
@@ -172,7 +172,7 @@ func runCat(gopts GlobalOptions, args []string) error {
 			}
 			blob := list[0]
 
-			buf := make([]byte, blob.Length)
+			buf := restic.NewBlobBuffer(int(blob.DataLength()))
 			n, err := repo.LoadBlob(context.TODO(), t, id, buf)
 			if err != nil {
 				return err
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -20,6 +25,17 @@ var cmdCheck = &cobra.Command{
 The "check" command tests the repository for errors and reports any errors it
 finds. It can also be used to read all data and therefore simulate a restore.
 
+With --read-data-subset, only a part of the data is read, either a percentage
+of the pack files selected at random (e.g. 10%) or the n-th of t groups of pack
+files (e.g. 2/7). Running check with the groups 1/t to t/t reads all data once,
+so a full verification can be spread over several runs.
+
+The time each pack was last verified is recorded in the repository. With
+--read-data-window, check reads the packs which have not been verified for the
+longest time, as many as needed so that all packs are verified at least once
+within the given duration when check runs regularly. Packs which have never been
+verified or not within the duration are always read.
+
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
 `,
@@ -31,9 +47,12 @@ repository and not use a local cache.
 
 // CheckOptions bundles all options for the 'check' command.
 type CheckOptions struct {
-	ReadData    bool
-	CheckUnused bool
-	WithCache   bool
+	ReadData       bool
+	ReadDataSubset string
+	ReadDataWindow time.Duration
+	CheckUnused    bool
+	WithCache      bool
+	WaitThaw       bool
 }
 
 var checkOptions CheckOptions
@@ -43,12 +62,24 @@ func init() {
 
 	f := cmdCheck.Flags()
 	f.BoolVar(&checkOptions.ReadData, "read-data", false, "read all data blobs")
+	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read a `subset` of data packs, given as n/t for the n-th of t groups or as a percentage (e.g. 10%)")
+	f.DurationVar(&checkOptions.ReadDataWindow, "read-data-window", 0, "read the least recently verified data packs, so that all packs are verified at least once within `duration`")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
+	f.BoolVar(&checkOptions.WaitThaw, "wait-thaw", false, "wait until archived pack files have been restored by the backend instead of exiting")
+}
+
+// checkSummary is printed as JSON when the check is complete.
+type checkSummary struct {
+	MessageType string   `json:"message_type"` // "summary"
+	NumErrors   int      `json:"num_errors"`
+	Errors      []string `json:"errors"`
+	Hints       []string `json:"hints"`
+	UnusedBlobs []string `json:"unused_blobs,omitempty"`
 }
 
 func newReadProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
-	if gopts.Quiet {
+	if gopts.Quiet || gopts.JSON {
 		return nil
 	}
 
@@ -77,11 +108,103 @@ func newReadProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
 	return readProgress
 }
 
+// dataSubset is a parsed value of --read-data-subset. Either bucket and
+// total or percentage are set.
+type dataSubset struct {
+	bucket, total uint
+	percentage    float64
+}
+
+// parseDataSubset parses the argument to --read-data-subset.
+func parseDataSubset(s string) (dataSubset, error) {
+	var sub dataSubset
+
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return sub, errors.Fatalf("invalid percentage %q for --read-data-subset, must be above 0%% and at most 100%%", s)
+		}
+		sub.percentage = p
+		return sub, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return sub, errors.Fatalf("invalid value %q for --read-data-subset, must be n/t or a percentage", s)
+	}
+
+	bucket, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return sub, errors.Fatalf("invalid group %q for --read-data-subset", parts[0])
+	}
+	total, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return sub, errors.Fatalf("invalid number of groups %q for --read-data-subset", parts[1])
+	}
+	if total < 1 || total > 256 {
+		return sub, errors.Fatalf("number of groups for --read-data-subset must be between 1 and 256")
+	}
+	if bucket < 1 || bucket > total {
+		return sub, errors.Fatalf("group for --read-data-subset must be between 1 and %d", total)
+	}
+
+	sub.bucket, sub.total = uint(bucket), uint(total)
+	return sub, nil
+}
+
+// selectPacks returns the packs from allPacks which belong to the subset. A
+// pack belongs to the group n of t if the first byte of its ID modulo t is
+// n-1, so that the groups 1/t to t/t together contain all packs. A
+// percentage selects packs at random.
+func (sub dataSubset) selectPacks(allPacks restic.IDSet) restic.IDSet {
+	packs := restic.NewIDSet()
+
+	if sub.percentage > 0 {
+		n := int(math.Ceil(float64(len(allPacks)) * sub.percentage / 100))
+		list := allPacks.List()
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for i, j := range rnd.Perm(len(list)) {
+			if i >= n {
+				break
+			}
+			packs.Insert(list[j])
+		}
+		return packs
+	}
+
+	for id := range allPacks {
+		if uint(id[0])%sub.total == sub.bucket-1 {
+			packs.Insert(id)
+		}
+	}
+	return packs
+}
+
 func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	if len(args) != 0 {
 		return errors.Fatal("check has no arguments")
 	}
 
+	modes := 0
+	for _, given := range []bool{opts.ReadData, opts.ReadDataSubset != "", opts.ReadDataWindow > 0} {
+		if given {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return errors.Fatal("only one of --read-data, --read-data-subset and --read-data-window can be given")
+	}
+
+	var subset dataSubset
+	if opts.ReadDataSubset != "" {
+
+		var err error
+		subset, err = parseDataSubset(opts.ReadDataSubset)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !opts.WithCache {
 		// do not use a cache for the checker
 		gopts.NoCache = true
@@ -103,26 +226,52 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	chkr := checker.New(repo)
 
+	summary := checkSummary{
+		MessageType: "summary",
+		Errors:      []string{},
+		Hints:       []string{},
+	}
+
+	// printSummary records the summary for notifications and writes it as
+	// JSON to stdout, in JSON mode.
+	printSummary := func() error {
+		summary.NumErrors = len(summary.Errors)
+		setNotificationStats(summary)
+
+		if !gopts.JSON {
+			return nil
+		}
+		return json.NewEncoder(gopts.stdout).Encode(summary)
+	}
+
 	Verbosef("Load indexes\n")
 	hints, errs := chkr.LoadIndex(context.TODO())
 
 	dupFound := false
 	for _, hint := range hints {
-		Printf("%v\n", hint)
+		if gopts.JSON {
+			summary.Hints = append(summary.Hints, hint.Error())
+		} else {
+			Printf("%v\n", hint)
+		}
 		if _, ok := hint.(checker.ErrDuplicatePacks); ok {
 			dupFound = true
 		}
 	}
 
-	if dupFound {
-		Printf("\nrun `restic rebuild-index' to correct this\n")
+	if dupFound && !gopts.JSON {
+		Printf("\nrun `restic repair index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
 		for _, err := range errs {
 			Warnf("error: %v\n", err)
+			summary.Errors = append(summary.Errors, err.Error())
+		}
+		if err := printSummary(); err != nil {
+			return err
 		}
-		return errors.Fatal("LoadIndex returned errors")
+		return withExitCode(exitCodeCorrupt, errors.Fatal("LoadIndex returned errors"))
 	}
 
 	errorsFound := false
@@ -133,6 +282,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	for err := range errChan {
 		errorsFound = true
+		summary.Errors = append(summary.Errors, err.Error())
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
 
@@ -142,6 +292,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	for err := range errChan {
 		errorsFound = true
+		summary.Errors = append(summary.Errors, err.Error())
 		if e, ok := err.(checker.TreeError); ok {
 			fmt.Fprintf(os.Stderr, "error for tree %v:\n", e.ID.Str())
 			for _, treeErr := range e.Errors {
@@ -152,29 +303,78 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	for _, id := range chkr.UsedObsoletePacks() {
+		hint := fmt.Sprintf("pack %v has been marked for deletion by prune, but contains data referenced by snapshots, the next prune adds it to the index again", id.Str())
+		summary.Hints = append(summary.Hints, hint)
+		Warnf("warning: %v\n", hint)
+	}
+
 	if opts.CheckUnused {
 		for _, id := range chkr.UnusedBlobs() {
 			Verbosef("unused blob %v\n", id.Str())
+			summary.UnusedBlobs = append(summary.UnusedBlobs, id.String())
 			errorsFound = true
 		}
 	}
 
-	if opts.ReadData {
-		Verbosef("Read all data\n")
+	if opts.ReadData || opts.ReadDataSubset != "" || opts.ReadDataWindow > 0 {
+		now := time.Now()
+		state, err := checker.LoadScrubState(context.TODO(), repo)
+		if err != nil {
+			return err
+		}
+
+		var packs restic.IDSet
+		switch {
+		case opts.ReadData:
+			packs = chkr.GetPacks()
+			Verbosef("Read all data\n")
+		case opts.ReadDataWindow > 0:
+			packs = state.Select(chkr.GetPacks(), opts.ReadDataWindow, now)
+			Verbosef("Read data of %d of %d packs, so that all packs are verified within %v\n", len(packs), chkr.CountPacks(), opts.ReadDataWindow)
+		default:
+			packs = subset.selectPacks(chkr.GetPacks())
+			Verbosef("Read data of %d of %d packs (subset %v)\n", len(packs), chkr.CountPacks(), opts.ReadDataSubset)
+		}
 
-		p := newReadProgress(gopts, restic.Stat{Blobs: chkr.CountPacks()})
-		errChan := make(chan error)
+		// archived pack files must be restored by the backend first
+		err = thawPacks(context.TODO(), repo, packs, opts.WaitThaw)
+		if err != nil {
+			return err
+		}
 
-		go chkr.ReadData(context.TODO(), p, errChan)
+		errChan := make(chan error)
+		if opts.ReadData {
+			p := newReadProgress(gopts, restic.Stat{Blobs: chkr.CountPacks()})
+			go chkr.ReadData(context.TODO(), p, errChan)
+		} else {
+			p := newReadProgress(gopts, restic.Stat{Blobs: uint64(len(packs))})
+			go chkr.ReadPacks(context.TODO(), packs, p, errChan)
+		}
 
+		failed := restic.NewIDSet()
 		for err := range errChan {
+			if e, ok := err.(checker.PackError); ok {
+				failed.Insert(e.ID)
+			}
 			errorsFound = true
+			summary.Errors = append(summary.Errors, err.Error())
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
+
+		// record which packs have been verified, so that --read-data-window
+		// reads the least recently verified packs next time
+		if _, err := state.Save(context.TODO(), repo, chkr.GetPacks(), packs.Sub(failed), now); err != nil {
+			Warnf("unable to save the verification state: %v\n", err)
+		}
+	}
+
+	if err := printSummary(); err != nil {
+		return err
 	}
 
 	if errorsFound {
-		return errors.Fatal("repository contains errors")
+		return withExitCode(exitCodeCorrupt, errors.Fatal("repository contains errors"))
 	}
 
 	Verbosef("No errors were found\n")
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseDataSubset(t *testing.T) {
+	var tests = []struct {
+		input string
+		sub   dataSubset
+	}{
+		{"1/1", dataSubset{bucket: 1, total: 1}},
+		{"2/7", dataSubset{bucket: 2, total: 7}},
+		{"7/7", dataSubset{bucket: 7, total: 7}},
+		{"10%", dataSubset{percentage: 10}},
+		{"0.5%", dataSubset{percentage: 0.5}},
+		{"100%", dataSubset{percentage: 100}},
+	}
+
+	for _, test := range tests {
+		sub, err := parseDataSubset(test.input)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.sub, sub)
+	}
+
+	for _, input := range []string{"", "0/7", "8/7", "1/0", "1/257", "x/7", "1/2/3", "0%", "101%", "x%"} {
+		_, err := parseDataSubset(input)
+		rtest.Assert(t, err != nil, "expected error for %q", input)
+	}
+}
+
+func TestSelectPacks(t *testing.T) {
+	allPacks := restic.NewIDSet()
+	for i := 0; i < 100; i++ {
+		allPacks.Insert(restic.NewRandomID())
+	}
+
+	// the groups contain all packs exactly once
+	seen := restic.NewIDSet()
+	for bucket := uint(1); bucket <= 7; bucket++ {
+		packs := dataSubset{bucket: bucket, total: 7}.selectPacks(allPacks)
+		for id := range packs {
+			rtest.Assert(t, !seen.Has(id), "pack %v selected in more than one group", id.Str())
+			seen.Insert(id)
+		}
+	}
+	rtest.Assert(t, seen.Equals(allPacks), "groups do not contain all packs")
+
+	packs := dataSubset{percentage: 10}.selectPacks(allPacks)
+	rtest.Equals(t, 10, len(packs))
+	for id := range packs {
+		rtest.Assert(t, allPacks.Has(id), "unknown pack %v selected", id.Str())
+	}
+
+	rtest.Equals(t, 100, len(dataSubset{percentage: 100}.selectPacks(allPacks)))
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdCopy = &cobra.Command{
+	Use:   "copy [flags] [snapshotID ...]",
+	Short: "Copy snapshots from one repository to another",
+	Long: `
+The "copy" command copies one or more snapshots from one repository to another
+repository. Note that this will have to read (download) and write (upload) the
+entire snapshot(s) due to the different encryption keys on the source and
+destination, and that transferred files are not re-chunked, which may break
+their deduplication with data already stored in the destination repository.
+If the repositories use different hash algorithms (see "restic init --hash"),
+the IDs of all blobs are computed again for the destination and the trees are
+saved with the new IDs.
+
+If both repositories use the same master key (e.g. because the destination
+was created as a copy of the source) and are stored with the same service,
+whole pack files are copied by the storage server instead (S3 and GCS only),
+without transferring the data through this client. Pack files are copied with all
+blobs they contain, "prune" removes blobs which are not needed afterwards.
+
+The source repository is given with the global options (--repo,
+--password-file), the destination repository with --repo2 and
+--password-file2 (or --password-command2). Snapshots which already exist in the destination repository
+are skipped. If no snapshot ID is given, all snapshots (matching the filters)
+are copied.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCopy(copyOptions, globalOptions, args)
+	},
+}
+
+// CopyOptions bundles all options for the copy command.
+type CopyOptions struct {
+	Repo2            string
+	PasswordFile2    string
+	PasswordCommand2 string
+	Host             string
+	Tags             restic.TagLists
+	Paths            []string
+}
+
+var copyOptions CopyOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCopy)
+
+	f := cmdCopy.Flags()
+	f.StringVar(&copyOptions.Repo2, "repo2", os.Getenv("RESTIC_REPOSITORY2"), "destination repository to copy snapshots to (default: $RESTIC_REPOSITORY2)")
+	f.StringVar(&copyOptions.PasswordFile2, "password-file2", os.Getenv("RESTIC_PASSWORD_FILE2"), "read the destination repository password from a file (default: $RESTIC_PASSWORD_FILE2)")
+	f.StringVar(&copyOptions.PasswordCommand2, "password-command2", os.Getenv("RESTIC_PASSWORD_COMMAND2"), "specify a shell `command` to obtain the destination repository password (default: $RESTIC_PASSWORD_COMMAND2)")
+	f.StringVarP(&copyOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
+	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// openDestinationRepository opens the repository snapshots are copied to.
+func openDestinationRepository(opts CopyOptions, gopts GlobalOptions) (*repository.Repository, error) {
+	if opts.Repo2 == "" {
+		return nil, errors.Fatal("Please specify destination repository location (--repo2)")
+	}
+
+	dstGopts := gopts
+	dstGopts.Repo = opts.Repo2
+	dstGopts.PasswordFile = opts.PasswordFile2
+	dstGopts.PasswordCommand = opts.PasswordCommand2
+	dstGopts.PasswordKeychain = gopts.PasswordKeychain && opts.PasswordFile2 == "" && opts.PasswordCommand2 == ""
+
+	pwd, err := resolvePassword(dstGopts, "RESTIC_PASSWORD2")
+	if err != nil {
+		return nil, err
+	}
+
+	if pwd == "" {
+		pwd, err = ReadPassword(GlobalOptions{}, "enter password for destination repository: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+	dstGopts.password = pwd
+
+	return OpenRepository(dstGopts)
+}
+
+// snapshotKey identifies snapshots with the same contents across
+// repositories.
+type snapshotKey struct {
+	tree restic.ID
+	time int64
+}
+
+func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	srcRepo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	dstRepo, err := openDestinationRepository(opts, gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		srcLock, err := lockRepo(srcRepo)
+		defer unlockRepo(srcLock)
+		if err != nil {
+			return err
+		}
+
+		dstLock, err := lockRepo(dstRepo)
+		defer unlockRepo(dstLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	debug.Log("loading source index")
+	if err := srcRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	debug.Log("loading destination index")
+	if err := dstRepo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	if err := checkQuota(ctx, dstRepo); err != nil {
+		return err
+	}
+
+	existing := make(map[snapshotKey]struct{})
+	for sn := range FindFilteredSnapshots(ctx, dstRepo, "", nil, nil, nil) {
+		if sn.Tree == nil {
+			continue
+		}
+		existing[snapshotKey{*sn.Tree, sn.Time.UnixNano()}] = struct{}{}
+	}
+
+	c := &copier{
+		src:     srcRepo,
+		dst:     dstRepo,
+		visited: restic.NewIDSet(),
+		saved:   restic.NewBlobSet(),
+		packs:   restic.NewIDSet(),
+	}
+
+	if !srcRepo.Config().Hash.Equal(dstRepo.Config().Hash) {
+		debug.Log("repositories use different hash algorithms, computing new IDs")
+		c.rehash = true
+		c.ids = make(map[restic.ID]restic.ID)
+	}
+
+	if srcRepo.SameKey(dstRepo) {
+		debug.Log("repositories use the same key, trying server-side copy")
+		if err := dstRepo.CopySessionKeys(ctx, srcRepo); err != nil {
+			return err
+		}
+		c.serverSide = true
+	}
+
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Host, opts.Tags, opts.Paths, args) {
+		Verbosef("snapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
+
+		if sn.Tree == nil {
+			Warnf("snapshot %s has nil tree, skipping\n", sn.ID().Str())
+			continue
+		}
+
+		if _, ok := existing[snapshotKey{*sn.Tree, sn.Time.UnixNano()}]; ok {
+			Verbosef("  skipping, snapshot already exists in the destination repository\n")
+			continue
+		}
+
+		tree, err := c.copyTree(ctx, *sn.Tree)
+		if err != nil {
+			return err
+		}
+
+		if c.rehash {
+			// the tree has a new ID in the destination repository
+			if _, ok := existing[snapshotKey{tree, sn.Time.UnixNano()}]; ok {
+				Verbosef("  skipping, snapshot already exists in the destination repository\n")
+				continue
+			}
+		}
+
+		debug.Log("flushing packs and saving the index")
+		if err := dstRepo.Flush(); err != nil {
+			return err
+		}
+
+		if err := dstRepo.SaveIndex(ctx); err != nil {
+			return err
+		}
+
+		// pack files which are already in the destination repository must
+		// be kept as long as the new snapshot
+		if err := dstRepo.ExtendObjectLock(ctx, tree); err != nil {
+			return err
+		}
+
+		// keep the ID of the original snapshot
+		if sn.Original == nil {
+			sn.Original = sn.ID()
+		}
+		sn.Tree = &tree
+
+		newID, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+		Verbosef("  copied to snapshot %s\n", newID.Str())
+
+		existing[snapshotKey{tree, sn.Time.UnixNano()}] = struct{}{}
+	}
+
+	return nil
+}
+
+// copier transfers blobs from the src to the dst repository.
+type copier struct {
+	src, dst *repository.Repository
+	buf      []byte
+
+	// visited contains the trees which have already been processed
+	visited restic.IDSet
+
+	// saved contains all blobs which were saved to dst, they are not
+	// contained in the index before the packs have been flushed
+	saved restic.BlobSet
+
+	// serverSide is set while whole pack files are copied by the backend,
+	// packs contains the pack files copied this way
+	serverSide bool
+	packs      restic.IDSet
+
+	// rehash is set if the repositories use different hash algorithms, ids
+	// maps the IDs of the blobs in src to their IDs in dst then
+	rehash bool
+	ids    map[restic.ID]restic.ID
+}
+
+// copyPack copies the pack file containing the blob with a server-side copy.
+// It returns false if this is not possible, server-side copies are disabled
+// for all following blobs then.
+func (c *copier) copyPack(ctx context.Context, h restic.BlobHandle) (bool, error) {
+	blobs, err := c.src.Index().Lookup(h.ID, h.Type)
+	if err != nil {
+		return false, err
+	}
+	id := blobs[0].PackID
+
+	copied, err := c.dst.CopyPack(ctx, c.src, id)
+	if errors.IsFatal(errors.Cause(err)) {
+		return false, err
+	}
+	if err != nil {
+		Warnf("server-side copy of pack %v failed, copying the data through this client: %v\n", id.Str(), err)
+	}
+	if !copied {
+		debug.Log("server-side copy is not possible, copying blobs")
+		c.serverSide = false
+		return false, nil
+	}
+
+	debug.Log("copied pack %v", id.Str())
+	c.packs.Insert(id)
+	return true, nil
+}
+
+// copyBlob copies the blob from src to dst, unless it exists in dst. It
+// returns the ID of the blob in dst.
+func (c *copier) copyBlob(ctx context.Context, h restic.BlobHandle) (restic.ID, error) {
+	if c.rehash {
+		if id, ok := c.ids[h.ID]; ok {
+			return id, nil
+		}
+	} else if c.saved.Has(h) || c.dst.Index().Has(h.ID, h.Type) {
+		return h.ID, nil
+	}
+
+	if c.serverSide {
+		copied, err := c.copyPack(ctx, h)
+		if err != nil || copied {
+			return h.ID, err
+		}
+	}
+
+	size, err := c.src.LookupBlobSize(h.ID, h.Type)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if l := restic.CiphertextLength(int(size)); cap(c.buf) < l {
+		c.buf = make([]byte, l)
+	}
+
+	n, err := c.src.LoadBlob(ctx, h.Type, h.ID, c.buf[:cap(c.buf)])
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	dh := h
+	if c.rehash {
+		dh.ID = c.dst.Config().Hash.Sum(c.buf[:n])
+		c.ids[h.ID] = dh.ID
+		if c.saved.Has(dh) || c.dst.Index().Has(dh.ID, dh.Type) {
+			return dh.ID, nil
+		}
+	}
+
+	debug.Log("copy %v as %v", h, dh.ID.Str())
+	if _, err := c.dst.SaveBlob(ctx, dh.Type, c.buf[:n], dh.ID); err != nil {
+		return restic.ID{}, err
+	}
+
+	c.saved.Insert(dh)
+	return dh.ID, nil
+}
+
+// copyTree copies the tree with the given id and all blobs referenced by it.
+// It returns the ID of the tree in dst, which differs from id if the trees are
+// saved with new IDs.
+func (c *copier) copyTree(ctx context.Context, id restic.ID) (restic.ID, error) {
+	if c.rehash {
+		if newID, ok := c.ids[id]; ok {
+			return newID, nil
+		}
+	} else {
+		if c.visited.Has(id) {
+			return id, nil
+		}
+		c.visited.Insert(id)
+
+		// when the tree is already contained in the destination repository,
+		// so is all data referenced by it
+		if c.treeInDestination(id) {
+			return id, nil
+		}
+	}
+
+	tree, err := c.src.LoadTree(ctx, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case "file":
+			for i, blobID := range node.Content {
+				newID, err := c.copyBlob(ctx, restic.BlobHandle{ID: blobID, Type: restic.DataBlob})
+				if err != nil {
+					return restic.ID{}, err
+				}
+				node.Content[i] = newID
+			}
+		case "dir":
+			if node.Subtree == nil {
+				continue
+			}
+
+			newID, err := c.copyTree(ctx, *node.Subtree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			node.Subtree = &newID
+		}
+	}
+
+	if c.rehash {
+		// the tree refers to the new IDs, so it is saved again
+		newID, err := c.dst.SaveTree(ctx, tree)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		c.ids[id] = newID
+		return newID, nil
+	}
+
+	return c.copyBlob(ctx, restic.BlobHandle{ID: id, Type: restic.TreeBlob})
+}
+
+// treeInDestination returns true if the tree is contained in the destination
+// repository. This is not the case for trees in pack files copied by the
+// server, the pack file may have been copied because of another tree.
+func (c *copier) treeInDestination(id restic.ID) bool {
+	blobs, err := c.dst.Index().Lookup(id, restic.TreeBlob)
+	if err != nil {
+		return false
+	}
+
+	for _, pb := range blobs {
+		if c.packs.Has(pb.PackID) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/schedule"
+)
+
+var cmdDaemon = &cobra.Command{
+	Use:   "daemon [flags]",
+	Short: "Run commands according to a schedule",
+	Long: `
+The "daemon" command runs restic commands such as backup, forget, prune and
+check according to a schedule until it is interrupted.
+
+The schedule file contains one job per line: a cron expression with the five
+fields minute, hour, day of month, month and day of week (or one of the
+shortcuts @hourly, @daily, @weekly, @monthly and @yearly), followed by the
+arguments for restic. Empty lines and lines starting with # are ignored:
+
+    # minute hour dom month dow  arguments
+    0 3 * * *    backup /home
+    30 4 * * 0   forget --keep-daily 7 --keep-weekly 4 --prune
+    @monthly     check --read-data
+
+Each job is run as a separate restic process with the environment of the
+daemon, so the repository and password can be set with environment variables
+such as RESTIC_REPOSITORY and RESTIC_PASSWORD_FILE, or in the arguments of
+the job. Jobs are run one after the other. If a job is due again while its
+previous run is still running or waiting, the new run is skipped.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDaemon(daemonOptions, globalOptions, args)
+	},
+}
+
+// DaemonOptions collects all options for the daemon command.
+type DaemonOptions struct {
+	Schedule string
+	Jitter   time.Duration
+}
+
+var daemonOptions DaemonOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdDaemon)
+
+	f := cmdDaemon.Flags()
+	f.StringVar(&daemonOptions.Schedule, "schedule", "", "read the jobs from `file`")
+	f.DurationVar(&daemonOptions.Jitter, "jitter", 0, "delay each run by a random `duration` up to this value")
+}
+
+// daemonJob is a line of the schedule file.
+type daemonJob struct {
+	schedule *schedule.Schedule
+	args     []string
+	next     time.Time
+
+	// pending is set while the job is waiting to be run or running.
+	pending bool
+}
+
+func (j *daemonJob) String() string {
+	return strings.Join(j.args, " ")
+}
+
+// parseDaemonJob parses a line of the schedule file.
+func parseDaemonJob(line string) (*daemonJob, error) {
+	fields := strings.Fields(line)
+
+	n := 5
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		n = 1
+	}
+
+	if len(fields) <= n {
+		return nil, errors.Errorf("job %q has no arguments", line)
+	}
+
+	spec := strings.Join(fields[:n], " ")
+	sched, err := schedule.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// split the arguments again so that quoting is supported
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimSpace(rest)
+		rest = rest[len(fields[i]):]
+	}
+
+	name, args, err := backend.SplitShellArgs(strings.TrimSpace(rest))
+	if err != nil {
+		return nil, errors.Errorf("job %q: %v", line, err)
+	}
+
+	return &daemonJob{
+		schedule: sched,
+		args:     append([]string{name}, args...),
+	}, nil
+}
+
+// readDaemonSchedule reads the jobs from the schedule file.
+func readDaemonSchedule(filename string) ([]*daemonJob, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to open schedule: %v", err)
+	}
+	defer f.Close()
+
+	var jobs []*daemonJob
+	sc := bufio.NewScanner(f)
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		job, err := parseDaemonJob(line)
+		if err != nil {
+			return nil, errors.Fatalf("%v:%d: %v", filename, lineno, err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Fatalf("unable to read schedule: %v", err)
+	}
+
+	if len(jobs) == 0 {
+		return nil, errors.Fatalf("schedule %v does not contain any jobs", filename)
+	}
+
+	return jobs, nil
+}
+
+func runDaemon(opts DaemonOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("daemon has no arguments")
+	}
+
+	if opts.Schedule == "" {
+		return errors.Fatal("please specify a schedule with --schedule")
+	}
+
+	jobs, err := readDaemonSchedule(opts.Schedule)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "Executable")
+	}
+
+	ctx := gopts.ctx
+	now := time.Now()
+	for _, job := range jobs {
+		job.next = job.schedule.Next(now)
+		if job.next.IsZero() {
+			Warnf("job %q is never due\n", job)
+			continue
+		}
+		Verbosef("job %q is due at %v\n", job, job.next.Format(TimeFormat))
+	}
+
+	var m sync.Mutex
+	queue := make(chan *daemonJob, len(jobs))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for job := range queue {
+			runDaemonJob(ctx, exe, job, opts.Jitter)
+
+			m.Lock()
+			job.pending = false
+			m.Unlock()
+		}
+	}()
+
+	defer func() {
+		close(queue)
+		wg.Wait()
+	}()
+
+	for {
+		var next time.Time
+		for _, job := range jobs {
+			if !job.next.IsZero() && (next.IsZero() || job.next.Before(next)) {
+				next = job.next
+			}
+		}
+
+		if next.IsZero() {
+			return errors.Fatal("no job is ever due")
+		}
+
+		debug.Log("sleeping until %v", next)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return nil
+		}
+
+		now := time.Now()
+		for _, job := range jobs {
+			if job.next.IsZero() || job.next.After(now) {
+				continue
+			}
+			job.next = job.schedule.Next(now)
+
+			m.Lock()
+			if job.pending {
+				m.Unlock()
+				Warnf("job %q is still running, skipping this run\n", job)
+				logEvent("job_skipped", logFields{"job": job.String()})
+				continue
+			}
+			job.pending = true
+			m.Unlock()
+
+			queue <- job
+		}
+	}
+}
+
+// runDaemonJob runs restic with the arguments of job after a random delay of
+// up to jitter.
+func runDaemonJob(ctx context.Context, exe string, job *daemonJob, jitter time.Duration) {
+	if jitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	Verbosef("running job %q\n", job)
+	logEvent("job_start", logFields{"job": job.String()})
+
+	cmd := exec.Command(exe, job.args...)
+	cmd.Stdout = globalOptions.stdout
+	cmd.Stderr = globalOptions.stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil {
+		Warnf("job %q failed: %v\n", job, err)
+		logEvent("job_finish", logFields{"job": job.String(), "error": err})
+		return
+	}
+
+	Verbosef("job %q finished after %v\n", job, time.Since(start).Round(time.Second))
+	logEvent("job_finish", logFields{"job": job.String()})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseDaemonJob(t *testing.T) {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		line string
+		args []string
+		next time.Time
+	}{
+		{
+			"0 3 * * *   backup /home",
+			[]string{"backup", "/home"},
+			time.Date(2018, 1, 3, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			"@daily forget --keep-daily 7 --prune",
+			[]string{"forget", "--keep-daily", "7", "--prune"},
+			time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			`*/10 * * * * backup "/home/user/my files"`,
+			[]string{"backup", "/home/user/my files"},
+			time.Date(2018, 1, 2, 3, 10, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			job, err := parseDaemonJob(test.line)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.args, job.args)
+			rtest.Equals(t, test.next, job.schedule.Next(start))
+		})
+	}
+
+	for _, line := range []string{"0 3 * * *", "@daily", "0 3 * * backup /home", `0 3 * * * backup "/home`} {
+		_, err := parseDaemonJob(line)
+		rtest.Assert(t, err != nil, "expected an error for %q", line)
+	}
+}
+
+func TestReadDaemonSchedule(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "schedule")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("# comment\n\n0 3 * * * backup /home\n@weekly check\n"), 0600))
+
+	jobs, err := readDaemonSchedule(filename)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(jobs))
+	rtest.Equals(t, "check", jobs[1].String())
+
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("# no jobs\n"), 0600))
+	_, err = readDaemonSchedule(filename)
+	rtest.Assert(t, err != nil, "expected an error for a schedule without jobs")
+}
@@ -1,169 +1,310 @@
-// xbuild debug
-
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"context"
-	"encoding/json"
-	"fmt"
 	"io"
 	"os"
+	"path"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/pack"
-	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
-
-	"github.com/restic/restic/internal/worker"
 )
 
 var cmdDump = &cobra.Command{
-	Use:   "dump [indexes|snapshots|all|packs]",
-	Short: "Dump data structures",
+	Use:   "dump [flags] snapshotID file",
+	Short: "Print a backed-up file to stdout",
 	Long: `
-The "dump" command dumps data structures from the repository as JSON objects. It
-is used for debugging purposes only.`,
+The "dump" command extracts a file from a snapshot from the repository and
+writes its contents to stdout, e.g. to pipe it into another program.
+
+If the path refers to a directory, the directory and all files and
+directories within it are written to stdout as an archive. The format of the
+archive is set with --archive, it can be "tar" (the default) or "zip". The
+path "/" refers to the whole snapshot.
+
+The special snapshot "latest" can be used to use the latest snapshot in the
+repository.
+`,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runDump(globalOptions, args)
+		return runDump(dumpOptions, globalOptions, args)
 	},
 }
 
+// DumpOptions collects all options for the dump command.
+type DumpOptions struct {
+	Host    string
+	Paths   []string
+	Tags    restic.TagLists
+	Archive string
+}
+
+var dumpOptions DumpOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdDump)
+
+	flags := cmdDump.Flags()
+	flags.StringVarP(&dumpOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
+	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
+	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
+	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` for directories as \"tar\" or \"zip\"")
+}
+
+// splitPath returns the components of the slash-separated path p.
+func splitPath(p string) []string {
+	var parts []string
+	for _, part := range strings.Split(path.Clean(p), "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
 }
 
-func prettyPrintJSON(wr io.Writer, item interface{}) error {
-	buf, err := json.MarshalIndent(item, "", "  ")
+// findNode returns the node for the path below the tree id. For the empty
+// path, a node for the tree itself is returned.
+func findNode(ctx context.Context, repo restic.Repository, id restic.ID, parts []string) (*restic.Node, error) {
+	if len(parts) == 0 {
+		return &restic.Node{Type: "dir", Mode: os.ModeDir | 0755, Subtree: &id}, nil
+	}
+
+	tree, err := repo.LoadTree(ctx, id)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	for _, node := range tree.Nodes {
+		if node.Name != parts[0] {
+			continue
+		}
+
+		if len(parts) == 1 {
+			return node, nil
+		}
+
+		if node.Type != "dir" || node.Subtree == nil {
+			return nil, errors.Errorf("%v is not a directory", node.Name)
+		}
+
+		return findNode(ctx, repo, *node.Subtree, parts[1:])
 	}
 
-	_, err = wr.Write(append(buf, '\n'))
-	return err
+	return nil, errors.Errorf("path %v not found in snapshot", parts[0])
 }
 
-func debugPrintSnapshots(repo *repository.Repository, wr io.Writer) error {
-	for id := range repo.List(context.TODO(), restic.SnapshotFile) {
-		snapshot, err := restic.LoadSnapshot(context.TODO(), repo, id)
+// writeFileContent writes the contents of the file node to w.
+func writeFileContent(ctx context.Context, repo restic.Repository, node *restic.Node, w io.Writer) error {
+	var buf []byte
+	for _, id := range node.Content {
+		size, err := repo.LookupBlobSize(id, restic.DataBlob)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "LoadSnapshot(%v): %v", id.Str(), err)
-			continue
+			return err
 		}
 
-		fmt.Fprintf(wr, "snapshot_id: %v\n", id)
+		buf = buf[:cap(buf)]
+		if len(buf) < restic.CiphertextLength(int(size)) {
+			buf = restic.NewBlobBuffer(int(size))
+		}
 
-		err = prettyPrintJSON(wr, snapshot)
+		n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
 		if err != nil {
 			return err
 		}
+
+		if _, err = w.Write(buf[:n]); err != nil {
+			return errors.Wrap(err, "Write")
+		}
 	}
 
 	return nil
 }
 
-const dumpPackWorkers = 10
-
-// Pack is the struct used in printPacks.
-type Pack struct {
-	Name string `json:"name"`
+// dumpArchiver writes nodes to an archive.
+type dumpArchiver interface {
+	// add writes the node with the given name to the archive.
+	add(ctx context.Context, name string, node *restic.Node) error
+	Close() error
+}
 
-	Blobs []Blob `json:"blobs"`
+// tarArchiver writes nodes to a tar archive.
+type tarArchiver struct {
+	repo restic.Repository
+	w    *tar.Writer
 }
 
-// Blob is the struct used in printPacks.
-type Blob struct {
-	Type   restic.BlobType `json:"type"`
-	Length uint            `json:"length"`
-	ID     restic.ID       `json:"id"`
-	Offset uint            `json:"offset"`
+func (a *tarArchiver) add(ctx context.Context, name string, node *restic.Node) error {
+	hdr := &tar.Header{
+		Name:       name,
+		Mode:       int64(node.Mode.Perm()),
+		Uid:        int(node.UID),
+		Gid:        int(node.GID),
+		Uname:      node.User,
+		Gname:      node.Group,
+		ModTime:    node.ModTime,
+		AccessTime: node.AccessTime,
+		ChangeTime: node.ChangeTime,
+	}
+
+	switch node.Type {
+	case "file":
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(node.Size)
+	case "dir":
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case "symlink":
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = node.LinkTarget
+	default:
+		debug.Log("skipping %v with type %v", name, node.Type)
+		return nil
+	}
+
+	if err := a.w.WriteHeader(hdr); err != nil {
+		return errors.Wrap(err, "WriteHeader")
+	}
+
+	if node.Type == "file" {
+		return writeFileContent(ctx, a.repo, node, a.w)
+	}
+	return nil
 }
 
-func printPacks(repo *repository.Repository, wr io.Writer) error {
-	f := func(ctx context.Context, job worker.Job) (interface{}, error) {
-		name := job.Data.(string)
+func (a *tarArchiver) Close() error {
+	return a.w.Close()
+}
 
-		h := restic.Handle{Type: restic.DataFile, Name: name}
+// zipArchiver writes nodes to a zip archive.
+type zipArchiver struct {
+	repo restic.Repository
+	w    *zip.Writer
+}
 
-		blobInfo, err := repo.Backend().Stat(ctx, h)
-		if err != nil {
-			return nil, err
-		}
+func (a *zipArchiver) add(ctx context.Context, name string, node *restic.Node) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: node.ModTime,
+	}
 
-		blobs, err := pack.List(repo.Key(), restic.ReaderAt(repo.Backend(), h), blobInfo.Size)
-		if err != nil {
-			return nil, err
-		}
+	switch node.Type {
+	case "file":
+		hdr.Method = zip.Deflate
+		hdr.UncompressedSize64 = node.Size
+	case "dir":
+		hdr.Name += "/"
+	case "symlink":
+	default:
+		debug.Log("skipping %v with type %v", name, node.Type)
+		return nil
+	}
+	hdr.SetMode(node.Mode)
 
-		return blobs, nil
+	w, err := a.w.CreateHeader(hdr)
+	if err != nil {
+		return errors.Wrap(err, "CreateHeader")
 	}
 
-	jobCh := make(chan worker.Job)
-	resCh := make(chan worker.Job)
-	wp := worker.New(context.TODO(), dumpPackWorkers, f, jobCh, resCh)
+	switch node.Type {
+	case "file":
+		return writeFileContent(ctx, a.repo, node, w)
+	case "symlink":
+		_, err = w.Write([]byte(node.LinkTarget))
+		return errors.Wrap(err, "Write")
+	}
+	return nil
+}
 
-	go func() {
-		for name := range repo.Backend().List(context.TODO(), restic.DataFile) {
-			jobCh <- worker.Job{Data: name}
-		}
-		close(jobCh)
-	}()
+func (a *zipArchiver) Close() error {
+	return a.w.Close()
+}
 
-	for job := range resCh {
-		name := job.Data.(string)
+// archiveTree adds all nodes within the tree id to the archive.
+func archiveTree(ctx context.Context, repo restic.Repository, a dumpArchiver, prefix string, id restic.ID) error {
+	tree, err := repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
 
-		if job.Error != nil {
-			fmt.Fprintf(os.Stderr, "error for pack %v: %v\n", name, job.Error)
-			continue
+	for _, node := range tree.Nodes {
+		name := path.Join(prefix, node.Name)
+		if err := a.add(ctx, name, node); err != nil {
+			return err
 		}
 
-		entries := job.Result.([]restic.Blob)
-		p := Pack{
-			Name:  name,
-			Blobs: make([]Blob, len(entries)),
-		}
-		for i, blob := range entries {
-			p.Blobs[i] = Blob{
-				Type:   blob.Type,
-				Length: blob.Length,
-				ID:     blob.ID,
-				Offset: blob.Offset,
+		if node.Type == "dir" && node.Subtree != nil {
+			if err := archiveTree(ctx, repo, a, name, *node.Subtree); err != nil {
+				return err
 			}
 		}
-
-		prettyPrintJSON(os.Stdout, p)
 	}
 
-	wp.Wait()
-
 	return nil
 }
 
-func dumpIndexes(repo restic.Repository) error {
-	for id := range repo.List(context.TODO(), restic.IndexFile) {
-		fmt.Printf("index_id: %v\n", id)
+// dumpNode writes the node to w, a file as is and a directory as an archive
+// of the given format.
+func dumpNode(ctx context.Context, repo restic.Repository, node *restic.Node, format string, w io.Writer) error {
+	switch node.Type {
+	case "file":
+		return writeFileContent(ctx, repo, node, w)
+	case "dir":
+	default:
+		return errors.Fatalf("cannot dump %v of type %v", node.Name, node.Type)
+	}
 
-		idx, err := repository.LoadIndex(context.TODO(), repo, id)
-		if err != nil {
+	var a dumpArchiver
+	switch format {
+	case "tar":
+		a = &tarArchiver{repo: repo, w: tar.NewWriter(w)}
+	case "zip":
+		a = &zipArchiver{repo: repo, w: zip.NewWriter(w)}
+	default:
+		return errors.Fatalf("unknown archive format %q", format)
+	}
+
+	// the directory itself is contained in the archive, except for the root
+	// directory of the snapshot
+	prefix := node.Name
+	if prefix != "" {
+		if err := a.add(ctx, prefix, node); err != nil {
 			return err
 		}
+	}
 
-		err = idx.Dump(os.Stdout)
-		if err != nil {
+	if node.Subtree != nil {
+		if err := archiveTree(ctx, repo, a, prefix, *node.Subtree); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return a.Close()
 }
 
-func runDump(gopts GlobalOptions, args []string) error {
-	if len(args) != 1 {
-		return errors.Fatal("type not specified")
+func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
+	ctx := gopts.ctx
+
+	if len(args) != 2 {
+		return errors.Fatal("no file and no snapshot ID specified")
+	}
+
+	switch opts.Archive {
+	case "tar", "zip":
+	default:
+		return errors.Fatalf("unknown archive format %q", opts.Archive)
 	}
 
+	snapshotIDString := args[0]
+	pathToPrint := args[1]
+
+	debug.Log("dump file %q from %q", pathToPrint, snapshotIDString)
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -177,35 +318,38 @@ func runDump(gopts GlobalOptions, args []string) error {
 		}
 	}
 
-	err = repo.LoadIndex(context.TODO())
+	err = repo.LoadIndex(ctx)
 	if err != nil {
 		return err
 	}
 
-	tpe := args[0]
+	var id restic.ID
 
-	switch tpe {
-	case "indexes":
-		return dumpIndexes(repo)
-	case "snapshots":
-		return debugPrintSnapshots(repo, os.Stdout)
-	case "packs":
-		return printPacks(repo, os.Stdout)
-	case "all":
-		fmt.Printf("snapshots:\n")
-		err := debugPrintSnapshots(repo, os.Stdout)
+	if snapshotIDString == "latest" {
+		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Host)
 		if err != nil {
-			return err
+			Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Host:%v", err, opts.Paths, opts.Host)
 		}
-
-		fmt.Printf("\nindexes:\n")
-		err = dumpIndexes(repo)
+	} else {
+		id, err = restic.FindSnapshot(repo, snapshotIDString)
 		if err != nil {
-			return err
+			Exitf(1, "invalid id %q: %v", snapshotIDString, err)
 		}
+	}
 
-		return nil
-	default:
-		return errors.Fatalf("no such type %q", tpe)
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		Exitf(2, "loading snapshot %q failed: %v", snapshotIDString, err)
 	}
+
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	node, err := findNode(ctx, repo, *sn.Tree, splitPath(pathToPrint))
+	if err != nil {
+		return errors.Fatalf("cannot dump %q from snapshot %v: %v", pathToPrint, sn.ID().Str(), err)
+	}
+
+	return dumpNode(ctx, repo, node, opts.Archive, gopts.stdout)
 }
@@ -30,6 +30,8 @@ repo. `,
 type FindOptions struct {
 	Oldest          string
 	Newest          string
+	Newer           string
+	Older           string
 	Snapshots       []string
 	CaseInsensitive bool
 	ListLong        bool
@@ -46,6 +48,8 @@ func init() {
 	f := cmdFind.Flags()
 	f.StringVarP(&findOptions.Oldest, "oldest", "O", "", "oldest modification date/time")
 	f.StringVarP(&findOptions.Newest, "newest", "N", "", "newest modification date/time")
+	f.StringVar(&findOptions.Newer, "newer", "", "only consider snapshots taken after this date/time")
+	f.StringVar(&findOptions.Older, "older", "", "only consider snapshots taken before this date/time")
 	f.StringArrayVarP(&findOptions.Snapshots, "snapshot", "s", nil, "snapshot `id` to search in (can be given multiple times)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
@@ -271,6 +275,19 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	var newer, older time.Time
+	if opts.Newer != "" {
+		if newer, err = parseTime(opts.Newer); err != nil {
+			return err
+		}
+	}
+
+	if opts.Older != "" {
+		if older, err = parseTime(opts.Older); err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -298,6 +315,11 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		notfound: restic.NewIDSet(),
 	}
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, opts.Snapshots) {
+		if (!newer.IsZero() && !sn.Time.After(newer)) || (!older.IsZero() && !sn.Time.Before(older)) {
+			debug.Log("skipping snapshot %v, time %v is out of range", sn.ID().Str(), sn.Time)
+			continue
+		}
+
 		if err = f.findInSnapshot(sn); err != nil {
 			return err
 		}
@@ -44,6 +44,8 @@ type ForgetOptions struct {
 	GroupBy string
 	DryRun  bool
 	Prune   bool
+
+	PruneOptions
 }
 
 var forgetOptions ForgetOptions
@@ -71,16 +73,39 @@ func init() {
 	f.StringVarP(&forgetOptions.GroupBy, "group-by", "g", "host,paths", "string for grouping snapshots by host,paths,tags")
 	f.BoolVarP(&forgetOptions.DryRun, "dry-run", "n", false, "do not delete anything, just print what would be done")
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
+	addPruneOptions(f, &forgetOptions.PruneOptions)
 
 	f.SortFlags = false
 }
 
+// ForgetGroup is the JSON representation of the snapshots kept and removed
+// for one group of snapshots.
+type ForgetGroup struct {
+	Tags   []string   `json:"tags"`
+	Host   string     `json:"host"`
+	Paths  []string   `json:"paths"`
+	Keep   []Snapshot `json:"keep"`
+	Remove []Snapshot `json:"remove"`
+}
+
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	if _, err := opts.limits(0); err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if repo.AppendOnly() && !opts.DryRun {
+		return errors.Fatal("forget cannot be run with an append-only key")
+	}
+
+	if repo.ReadOnly() && !opts.DryRun {
+		return errors.Fatal("forget cannot be run with a read-only key")
+	}
+
 	lock, err := lockRepoExclusive(repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -117,13 +142,34 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	removeSnapshots := 0
+	heldSnapshots := 0
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
+
+	// snapshots within the retention period of the object lock cannot be
+	// removed from the storage, so they are kept like held snapshots
+	objectLock := repo.Config().ObjectLock
+	retained := func(sn *restic.Snapshot) (bool, error) {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+		return repo.Retained(ctx, h)
+	}
+
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
 		if len(args) > 0 {
+			isRetained, err := retained(sn)
+			if err != nil {
+				return err
+			}
+
 			// When explicit snapshots args are given, remove them immediately.
-			if !opts.DryRun {
+			if sn.Held() {
+				Warnf("snapshot %v is held (%v), not removing it\n", sn.ID().Str(), strings.Join(sn.Holds, ", "))
+				heldSnapshots++
+			} else if isRetained {
+				Warnf("snapshot %v is within the object lock period of %d days, not removing it\n", sn.ID().Str(), objectLock.Days)
+				heldSnapshots++
+			} else if !opts.DryRun {
 				h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 				if err = repo.Backend().Remove(context.TODO(), h); err != nil {
 					return err
@@ -163,6 +209,9 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 	if len(args) > 0 {
+		if heldSnapshots > 0 {
+			return errors.Fatalf("%d snapshots are held or within the object lock period and have not been removed", heldSnapshots)
+		}
 		return nil
 	}
 
@@ -181,6 +230,8 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		return nil
 	}
 
+	var jsonGroups []*ForgetGroup
+
 	for k, snapshotGroup := range snapshotGroups {
 		var key key
 		if json.Unmarshal([]byte(k), &key) != nil {
@@ -204,15 +255,44 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		}
 		Verbosef(":\n\n")
 
-		keep, remove := restic.ApplyPolicy(snapshotGroup, policy)
+		// snapshots with a hold are always kept and do not count for the policy
+		var held, unheld restic.Snapshots
+		for _, sn := range snapshotGroup {
+			isRetained, err := retained(sn)
+			if err != nil {
+				return err
+			}
 
-		if len(keep) != 0 && !gopts.Quiet {
+			if sn.Held() || isRetained {
+				held = append(held, sn)
+			} else {
+				unheld = append(unheld, sn)
+			}
+		}
+
+		keep, remove := restic.ApplyPolicy(unheld, policy)
+		if len(held) > 0 {
+			keep = append(keep, held...)
+			sort.Sort(keep)
+		}
+
+		if gopts.JSON {
+			jsonGroups = append(jsonGroups, &ForgetGroup{
+				Tags:   key.Tags,
+				Host:   key.Hostname,
+				Paths:  key.Paths,
+				Keep:   asJSONSnapshots(keep),
+				Remove: asJSONSnapshots(remove),
+			})
+		}
+
+		if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
 			Printf("keep %d snapshots:\n", len(keep))
 			PrintSnapshots(globalOptions.stdout, keep, opts.Compact)
 			Printf("\n")
 		}
 
-		if len(remove) != 0 && !gopts.Quiet {
+		if len(remove) != 0 && !gopts.Quiet && !gopts.JSON {
 			Printf("remove %d snapshots:\n", len(remove))
 			PrintSnapshots(globalOptions.stdout, remove, opts.Compact)
 			Printf("\n")
@@ -231,10 +311,19 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if gopts.JSON {
+		if jsonGroups == nil {
+			jsonGroups = []*ForgetGroup{}
+		}
+		if err = json.NewEncoder(gopts.stdout).Encode(jsonGroups); err != nil {
+			return err
+		}
+	}
+
 	if removeSnapshots > 0 && opts.Prune {
 		Verbosef("%d snapshots have been removed, running prune\n", removeSnapshots)
 		if !opts.DryRun {
-			return pruneRepository(gopts, repo)
+			return pruneRepository(opts.PruneOptions, gopts, repo)
 		}
 	}
 
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
 )
@@ -14,23 +20,115 @@ var cmdInit = &cobra.Command{
 	Short: "Initialize a new repository",
 	Long: `
 The "init" command initializes a new repository.
+
+The parameters of the key derivation function (scrypt) are calibrated so that
+deriving the key from the password takes about --kdf-time on this machine,
+using at most --kdf-memory MiB. Alternatively, the parameters can be given
+explicitly with --kdf-n, --kdf-r and --kdf-p.
+
+The IDs of all data in the repository are SHA-256 hashes by default. With
+--hash sha512-256, blake2b-256 or blake3, SHA-512/256, BLAKE2b or BLAKE3 is
+used instead, which is faster on 64 bit CPUs without SHA extensions. The
+algorithm cannot be changed afterwards, and such repositories cannot be opened
+by older versions of restic. "copy" computes the IDs again when copying
+snapshots to a repository with another algorithm.
+
+With --max-repo-size, the maximum size of the repository is stored in the
+config. "backup" and "copy" refuse to upload data which would grow the
+repository beyond that size.
+
+With --object-lock-days, the storage is configured to keep all files for the
+given number of days, so that they cannot be removed or overwritten even with
+the credentials of the repository (currently only supported for S3). "backup"
+and "copy" extend the retention of all data referenced by new snapshots, and
+"forget" keeps all snapshots which are still retained. This changes the
+configuration of the whole bucket: versioning is enabled, which cannot be
+undone, and all objects saved to the bucket afterwards are retained, also
+those outside of the repository. restic asks for confirmation before the
+bucket is changed, use --confirm-object-lock to skip this.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runInit(globalOptions, args)
+		if err := kdfOptions.apply(); err != nil {
+			return err
+		}
+		return runInit(initOptions, globalOptions, args)
 	},
 }
 
+// InitOptions bundles all options for the init command.
+type InitOptions struct {
+	Hash              string
+	ObjectLockDays    uint
+	ObjectLockMode    string
+	ConfirmObjectLock bool
+}
+
+var initOptions InitOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdInit)
+
+	f := cmdInit.Flags()
+	addKDFFlags(f, &kdfOptions)
+	f.StringVar(&initOptions.Hash, "hash", string(restic.HashSHA256), "`algorithm` for computing IDs, one of sha256, sha512-256, blake2b-256 and blake3")
+	f.UintVar(&initOptions.ObjectLockDays, "object-lock-days", 0, "keep all files in the storage for `n` days, so that they cannot be removed or overwritten")
+	f.StringVar(&initOptions.ObjectLockMode, "object-lock-mode", restic.ObjectLockGovernance, "retention `mode` for --object-lock-days, either governance or compliance")
+	f.BoolVar(&initOptions.ConfirmObjectLock, "confirm-object-lock", false, "change the bucket for --object-lock-days without asking for confirmation")
 }
 
-func runInit(gopts GlobalOptions, args []string) error {
+// confirmObjectLock describes how enabling the object lock changes the bucket
+// and asks the user to confirm this. Without a terminal, it must be confirmed
+// with --confirm-object-lock.
+func confirmObjectLock(opts InitOptions) error {
+	if opts.ConfirmObjectLock {
+		return nil
+	}
+
+	if !stdinIsTerminal() {
+		return errors.Fatal("--object-lock-days changes the configuration of the whole bucket, " +
+			"use --confirm-object-lock to confirm this")
+	}
+
+	fmt.Fprintf(os.Stderr, "Enabling the object lock changes the configuration of the whole bucket:\n")
+	fmt.Fprintf(os.Stderr, "  - versioning is enabled, it cannot be disabled again\n")
+	fmt.Fprintf(os.Stderr, "  - all objects saved to the bucket afterwards are kept for %d days in %v mode,\n", opts.ObjectLockDays, opts.ObjectLockMode)
+	fmt.Fprintf(os.Stderr, "    also those which do not belong to the repository\n")
+	if opts.ObjectLockMode == restic.ObjectLockCompliance {
+		fmt.Fprintf(os.Stderr, "  - nobody can remove them before, not even the owner of the account\n")
+	}
+	fmt.Fprintf(os.Stderr, "Enable the object lock? [y/N] ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "ReadString")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.Fatal("object lock not confirmed")
+}
+
+func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	if gopts.Repo == "" {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
-	be, err := create(gopts.Repo, gopts.extended)
+	hash, err := restic.ParseHashAlgorithm(opts.Hash)
+	if err != nil {
+		return err
+	}
+
+	if opts.ObjectLockDays > 0 {
+		err = confirmObjectLock(opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	be, err := create(gopts.Repo, gopts, gopts.extended)
 	if err != nil {
 		return errors.Fatalf("create backend at %s failed: %v\n", gopts.Repo, err)
 	}
@@ -43,6 +141,19 @@ func runInit(gopts GlobalOptions, args []string) error {
 	}
 
 	s := repository.New(be)
+	s.SetHashAlgorithm(hash)
+
+	err = setMaxRepoSize(gopts, s)
+	if err != nil {
+		return err
+	}
+
+	if opts.ObjectLockDays > 0 {
+		err = s.SetObjectLock(restic.ObjectLock{Mode: opts.ObjectLockMode, Days: opts.ObjectLockDays})
+		if err != nil {
+			return err
+		}
+	}
 
 	err = s.Init(context.TODO(), gopts.password)
 	if err != nil {
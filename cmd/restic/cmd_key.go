@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/kms"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
@@ -12,25 +13,86 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [list|add|remove|passwd] [ID]",
+	Use:   "key [list|add|remove|passwd|split|combine] [ID|FILE...]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
+
+The parameters of the key derivation function for new keys ("add" and
+"passwd") can be set with the --kdf-* options, see "restic help init".
+
+With --append-only, "add" creates a key which can only be used to add data to
+the repository: restic refuses to remove or overwrite any files (except for
+locks) when the repository is opened with such a key, so "forget" and "prune"
+cannot be run. Keys added using an append-only key are append-only as well.
+
+With --read-only, "add" creates a key which can only be used to read data from
+the repository: restic refuses to save or remove any files (except for locks)
+when the repository is opened with such a key. Other keys cannot be added or
+changed with a read-only key.
+
+With --write-only, "add" creates a key which does not contain the master key
+but only a public key derived from it. Such a key can be used to run "backup",
+but not to read any data from the repository. It is append-only as well.
+Other keys cannot be added or changed with a write-only key.
+
+With --wrap-kms, "add" creates a key which is not protected by a password, but
+wrapped with a key stored in a key management service: a key in the transit
+engine of HashiCorp Vault ("vault-transit:mount/name", using $VAULT_ADDR and
+$VAULT_TOKEN), in Google Cloud KMS ("gcp-kms:projects/.../cryptoKeys/name") or
+in AWS KMS ("aws-kms:arn:aws:kms:region:account:key/id").
+The repository is then opened with "--kms uri" and access can be revoked
+centrally in the key management service.
+
+"split" creates a key which is not protected by a password, but split into
+--shares shares using Shamir's secret sharing. The shares are printed and
+should be given to different people. Any --threshold of them are needed to
+open the key: "combine FILE..." reads the shares from the files (one share per
+line) and adds a new key with a password using the reconstructed key.
+
+New keys can be given a human-readable description with --label, which is
+shown by "list" together with the user, host and role of each key.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runKey(globalOptions, args)
+		if err := kdfOptions.apply(); err != nil {
+			return err
+		}
+		return runKey(keyOptions, globalOptions, args)
 	},
 }
 
+// KeyOptions collects all options for the key command.
+type KeyOptions struct {
+	AppendOnly bool
+	ReadOnly   bool
+	WriteOnly  bool
+	Label      string
+	WrapKMS    string
+	Shares     int
+	Threshold  int
+}
+
+var keyOptions KeyOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdKey)
+
+	f := cmdKey.Flags()
+	addKDFFlags(f, &kdfOptions)
+	f.BoolVar(&keyOptions.AppendOnly, "append-only", false, "create an append-only key which cannot remove data (for \"add\")")
+	f.BoolVar(&keyOptions.ReadOnly, "read-only", false, "create a read-only key which cannot add or remove data (for \"add\")")
+	f.BoolVar(&keyOptions.WriteOnly, "write-only", false, "create a write-only key which cannot read data (for \"add\")")
+	f.StringVar(&keyOptions.Label, "label", "", "set a human-readable `label` for the new key")
+	f.StringVar(&keyOptions.WrapKMS, "wrap-kms", "", "create a key wrapped with the KMS key `uri` instead of a password (for \"add\")")
+	f.IntVar(&keyOptions.Shares, "shares", 0, "split the key into `n` shares (for \"split\")")
+	f.IntVar(&keyOptions.Threshold, "threshold", 0, "require `k` shares to open the key (for \"split\")")
 }
 
 func listKeys(ctx context.Context, s *repository.Repository) error {
 	tab := NewTable()
-	tab.Header = fmt.Sprintf(" %-10s  %-10s  %-10s  %s", "ID", "User", "Host", "Created")
-	tab.RowFormat = "%s%-10s  %-10s  %-10s  %s"
+	tab.Header = fmt.Sprintf(" %-10s  %-10s  %-10s  %-19s  %-11s  %s", "ID", "User", "Host", "Created", "Role", "Label")
+	tab.RowFormat = "%s%-10s  %-10s  %-10s  %-19s  %-11s  %s"
 
 	for id := range s.List(ctx, restic.KeyFile) {
 		k, err := repository.LoadKey(ctx, s, id.String())
@@ -45,8 +107,12 @@ func listKeys(ctx context.Context, s *repository.Repository) error {
 		} else {
 			current = " "
 		}
+		role := k.Role
+		if role == "" {
+			role = "full"
+		}
 		tab.Rows = append(tab.Rows, []interface{}{current, id.Str(),
-			k.Username, k.Hostname, k.Created.Format(TimeFormat)})
+			k.Username, k.Hostname, k.Created.Format(TimeFormat), role, k.Label})
 	}
 
 	return tab.Write(globalOptions.stdout)
@@ -70,15 +136,60 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 		"enter password again: ")
 }
 
-func addKey(gopts GlobalOptions, repo *repository.Repository) error {
-	pw, err := getNewPassword(gopts)
+// keyMeta returns the role and the label for a new key.
+func keyMeta(opts KeyOptions, repo *repository.Repository) (repository.KeyMeta, error) {
+	meta := repository.KeyMeta{Label: opts.Label}
+
+	roles := 0
+	for _, set := range []bool{opts.AppendOnly, opts.ReadOnly, opts.WriteOnly} {
+		if set {
+			roles++
+		}
+	}
+	if roles > 1 {
+		return meta, errors.Fatal("only one of --append-only, --read-only and --write-only can be given")
+	}
+
+	// keys added with an append-only key must not be able to remove data
+	switch {
+	case opts.WriteOnly:
+		meta.Role = repository.KeyRoleWriteOnly
+	case opts.ReadOnly:
+		meta.Role = repository.KeyRoleReadOnly
+	case opts.AppendOnly || repo.AppendOnly():
+		meta.Role = repository.KeyRoleAppendOnly
+	}
+
+	return meta, nil
+}
+
+func addKey(opts KeyOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	meta, err := keyMeta(opts, repo)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(context.TODO(), repo, pw, repo.Key())
-	if err != nil {
-		return errors.Fatalf("creating new key failed: %v\n", err)
+	var id *repository.Key
+	if opts.WrapKMS != "" {
+		w, err := kms.Open(gopts.ctx, opts.WrapKMS)
+		if err != nil {
+			return err
+		}
+
+		id, err = repository.AddWrappedKey(gopts.ctx, repo, w, repo.Key(), meta)
+		if err != nil {
+			return errors.Fatalf("creating new key failed: %v\n", err)
+		}
+	} else {
+		pw, err := getNewPassword(gopts)
+		if err != nil {
+			return err
+		}
+
+		id, err = repository.AddKey(context.TODO(), repo, pw, repo.Key(), meta)
+		if err != nil {
+			return errors.Fatalf("creating new key failed: %v\n", err)
+		}
 	}
 
 	Verbosef("saved new key as %s\n", id)
@@ -101,13 +212,24 @@ func deleteKey(repo *repository.Repository, name string) error {
 	return nil
 }
 
-func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
+func changePassword(opts KeyOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	// the new key keeps the role and the label of the current key
+	current, err := repository.LoadKey(gopts.ctx, repo, repo.KeyName())
+	if err != nil {
+		return err
+	}
+
+	meta := repository.KeyMeta{Role: current.Role, Label: current.Label}
+	if opts.Label != "" {
+		meta.Label = opts.Label
+	}
+
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(context.TODO(), repo, pw, repo.Key())
+	id, err := repository.AddKey(context.TODO(), repo, pw, repo.Key(), meta)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -123,9 +245,79 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 	return nil
 }
 
-func runKey(gopts GlobalOptions, args []string) error {
-	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
+// splitKey adds a key which is split into shares and prints the shares.
+func splitKey(opts KeyOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	if opts.Threshold < 2 || opts.Threshold > opts.Shares || opts.Shares > 255 {
+		return errors.Fatal("--shares and --threshold must be set, with 2 <= threshold <= shares <= 255")
+	}
+
+	meta, err := keyMeta(KeyOptions{Label: opts.Label}, repo)
+	if err != nil {
+		return err
+	}
+
+	w := &shareWrapper{n: opts.Shares, k: opts.Threshold}
+	key, err := repository.AddWrappedKey(gopts.ctx, repo, w, repo.Key(), meta)
+	if err != nil {
+		return errors.Fatalf("creating new key failed: %v\n", err)
+	}
+
+	Verbosef("saved new key as %s, any %d of the following %d shares are needed to open it:\n\n",
+		key, opts.Threshold, opts.Shares)
+	for i := range w.shares {
+		Printf("%s\n", w.String(i))
+	}
+
+	return nil
+}
+
+func runKey(opts KeyOptions, gopts GlobalOptions, args []string) error {
+	switch {
+	case len(args) < 1:
 		return errors.Fatal("wrong number of arguments")
+	case args[0] == "remove":
+		if len(args) != 2 {
+			return errors.Fatal("wrong number of arguments")
+		}
+	case args[0] == "combine":
+		if len(args) < 2 {
+			return errors.Fatal("combine needs the files containing the shares")
+		}
+	case len(args) != 1:
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	if opts.AppendOnly && args[0] != "add" {
+		return errors.Fatal("--append-only can only be used to add a key")
+	}
+
+	if opts.ReadOnly && args[0] != "add" {
+		return errors.Fatal("--read-only can only be used to add a key")
+	}
+
+	if opts.WriteOnly && args[0] != "add" {
+		return errors.Fatal("--write-only can only be used to add a key")
+	}
+
+	if opts.Label != "" && (args[0] == "list" || args[0] == "remove") {
+		return errors.Fatal("--label can only be used for new keys")
+	}
+
+	if opts.WrapKMS != "" && args[0] != "add" {
+		return errors.Fatal("--wrap-kms can only be used to add a key")
+	}
+
+	if (opts.Shares != 0 || opts.Threshold != 0) && args[0] != "split" {
+		return errors.Fatal("--shares and --threshold can only be used to split a key")
+	}
+
+	// the repository is opened with the key reconstructed from the shares
+	if args[0] == "combine" {
+		w, err := loadShares(args[1:])
+		if err != nil {
+			return err
+		}
+		gopts.keyWrapper = w
 	}
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
@@ -136,6 +328,14 @@ func runKey(gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if repo.WriteOnly() && args[0] != "list" {
+		return errors.Fatal("keys cannot be changed with a write-only key")
+	}
+
+	if repo.ReadOnly() && args[0] != "list" {
+		return errors.Fatal("keys cannot be changed with a read-only key")
+	}
+
 	switch args[0] {
 	case "list":
 		lock, err := lockRepo(repo)
@@ -152,8 +352,28 @@ func runKey(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
-		return addKey(gopts, repo)
+		return addKey(opts, gopts, repo)
+	case "split":
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return splitKey(opts, gopts, repo)
+	case "combine":
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return addKey(KeyOptions{Label: opts.Label}, gopts, repo)
 	case "remove":
+		if repo.AppendOnly() {
+			return errors.Fatal("keys cannot be removed with an append-only key")
+		}
+
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
 		if err != nil {
@@ -167,13 +387,17 @@ func runKey(gopts GlobalOptions, args []string) error {
 
 		return deleteKey(repo, id)
 	case "passwd":
+		if repo.AppendOnly() {
+			return errors.Fatal("the password of an append-only key cannot be changed, add a new key instead")
+		}
+
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
 		if err != nil {
 			return err
 		}
 
-		return changePassword(gopts, repo)
+		return changePassword(opts, gopts, repo)
 	}
 
 	return nil
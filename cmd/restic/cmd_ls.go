@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
-	"path/filepath"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -12,12 +16,22 @@ import (
 )
 
 var cmdLs = &cobra.Command{
-	Use:   "ls [flags] [snapshot-ID ...]",
-	Short: "List files in a snapshot",
+	Use:   "ls [flags] [snapshot-ID ...] [dir...]",
+	Short: "List files in snapshots",
 	Long: `
-The "ls" command allows listing files and directories in a snapshot.
+The "ls" command allows listing files and directories in snapshots. When no
+snapshot ID is given, all snapshots matching the --host, --tag and --path
+filters are listed.
 
-The special snapshot-ID "latest" can be used to list files and directories of the latest snapshot in the repository.
+The special snapshot-ID "latest" can be used to list files and directories of
+the latest snapshot in the repository, the filters select the snapshots it is
+chosen from.
+
+File listings can optionally be filtered by directories. Any positional
+arguments after the snapshot IDs which start with a slash are interpreted as
+absolute directory paths, and only files inside those directories will be
+listed. If the --recursive flag is used, then the filter will allow traversing
+into matching directories' subfolders.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -27,10 +41,11 @@ The special snapshot-ID "latest" can be used to list files and directories of th
 
 // LsOptions collects all options for the ls command.
 type LsOptions struct {
-	ListLong bool
-	Host     string
-	Tags     restic.TagLists
-	Paths    []string
+	ListLong  bool
+	Host      string
+	Tags      restic.TagLists
+	Paths     []string
+	Recursive bool
 }
 
 var lsOptions LsOptions
@@ -40,23 +55,138 @@ func init() {
 
 	flags := cmdLs.Flags()
 	flags.BoolVarP(&lsOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
+	flags.BoolVar(&lsOptions.Recursive, "recursive", false, "include files in subfolders of the listed directories")
+
+	flags.StringVarP(&lsOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID or \"latest\" is given")
+	flags.Var(&lsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID or \"latest\" is given")
+	flags.StringArrayVar(&lsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID or \"latest\" is given")
+}
+
+// lsFilter decides which items in a snapshot are listed.
+type lsFilter struct {
+	dirs      []string
+	recursive bool
+}
+
+// within returns true if item is located in one of the directories. Unless
+// the filter is recursive, only direct children of the directories match.
+func (f lsFilter) within(item string) bool {
+	for _, dir := range f.dirs {
+		var rel string
+		switch {
+		case dir == "/":
+			rel = strings.TrimPrefix(item, "/")
+		case strings.HasPrefix(item, dir+"/"):
+			rel = item[len(dir)+1:]
+		default:
+			continue
+		}
+
+		if rel == "" {
+			continue
+		}
+
+		if f.recursive || !strings.Contains(rel, "/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// approaching returns true if one of the directories is located in dir, so
+// dir needs to be traversed.
+func (f lsFilter) approaching(dir string) bool {
+	for _, d := range f.dirs {
+		if d == dir || dir == "/" || strings.HasPrefix(d, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns true if item should be printed.
+func (f lsFilter) list(item string) bool {
+	return len(f.dirs) == 0 || f.within(item)
+}
+
+// descend returns true if the directory dir needs to be traversed.
+func (f lsFilter) descend(dir string) bool {
+	if len(f.dirs) == 0 || f.approaching(dir) {
+		return true
+	}
+
+	return f.recursive && f.within(dir)
+}
+
+// lsSnapshot is the JSON representation of a snapshot in the output of ls.
+type lsSnapshot struct {
+	*restic.Snapshot
+
+	ID         *restic.ID `json:"id"`
+	ShortID    string     `json:"short_id"`
+	StructType string     `json:"struct_type"` // "snapshot"
+}
+
+// lsNode is the JSON representation of a node in the output of ls.
+type lsNode struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Path       string      `json:"path"`
+	UID        uint32      `json:"uid"`
+	GID        uint32      `json:"gid"`
+	Size       uint64      `json:"size,omitempty"`
+	Mode       os.FileMode `json:"mode,omitempty"`
+	ModTime    time.Time   `json:"mtime"`
+	AccessTime time.Time   `json:"atime"`
+	ChangeTime time.Time   `json:"ctime"`
+	StructType string      `json:"struct_type"` // "node"
+}
+
+// printNodeJSON writes node as a single line of JSON to stdout.
+func printNodeJSON(prefix string, node *restic.Node) error {
+	return json.NewEncoder(globalOptions.stdout).Encode(lsNode{
+		Name:       node.Name,
+		Type:       node.Type,
+		Path:       path.Join(prefix, node.Name),
+		UID:        node.UID,
+		GID:        node.GID,
+		Size:       node.Size,
+		Mode:       node.Mode,
+		ModTime:    node.ModTime,
+		AccessTime: node.AccessTime,
+		ChangeTime: node.ChangeTime,
+		StructType: "node",
+	})
+}
+
+// printNode writes node to stdout, either as text or as JSON.
+func printNode(prefix string, node *restic.Node) error {
+	if globalOptions.JSON {
+		return printNodeJSON(prefix, node)
+	}
 
-	flags.StringVarP(&lsOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
-	flags.Var(&lsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
-	flags.StringArrayVar(&lsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+	Printf("%s\n", formatNode(prefix, node, lsOptions.ListLong))
+	return nil
 }
 
-func printTree(repo *repository.Repository, id *restic.ID, prefix string) error {
+func printTree(repo *repository.Repository, filter lsFilter, id *restic.ID, prefix string) error {
 	tree, err := repo.LoadTree(context.TODO(), *id)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range tree.Nodes {
-		Printf("%s\n", formatNode(prefix, entry, lsOptions.ListLong))
+		item := path.Join(prefix, entry.Name)
+		if filter.list(item) {
+			if err = printNode(prefix, entry); err != nil {
+				return err
+			}
+		}
 
-		if entry.Type == "dir" && entry.Subtree != nil {
-			if err = printTree(repo, entry.Subtree, filepath.Join(prefix, entry.Name)); err != nil {
+		if entry.Type == "dir" && entry.Subtree != nil && filter.descend(item) {
+			if err = printTree(repo, filter, entry.Subtree, item); err != nil {
 				return err
 			}
 		}
@@ -65,11 +195,36 @@ func printTree(repo *repository.Repository, id *restic.ID, prefix string) error
 	return nil
 }
 
+// splitLsArgs splits the arguments of ls into the snapshot IDs and the
+// directories, which are absolute paths following the snapshot IDs.
+func splitLsArgs(args []string) (snapshotIDs []string, dirs []string, err error) {
+	for _, arg := range args {
+		if len(dirs) == 0 && !strings.HasPrefix(arg, "/") {
+			snapshotIDs = append(snapshotIDs, arg)
+			continue
+		}
+
+		if !path.IsAbs(arg) {
+			return nil, nil, errors.Fatalf("path %q is not absolute", arg)
+		}
+		dirs = append(dirs, path.Clean(arg))
+	}
+
+	return snapshotIDs, dirs, nil
+}
+
 func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
-	if len(args) == 0 && opts.Host == "" && len(opts.Tags) == 0 && len(opts.Paths) == 0 {
-		return errors.Fatal("Invalid arguments, either give one or more snapshot IDs or set filters.")
+	snapshotIDs, dirs, err := splitLsArgs(args)
+	if err != nil {
+		return err
 	}
 
+	if len(snapshotIDs) == 0 && opts.Host == "" && len(opts.Tags) == 0 && len(opts.Paths) == 0 {
+		return errors.Fatal("Invalid arguments, either give a snapshot ID or set filters.")
+	}
+
+	filter := lsFilter{dirs: dirs, recursive: opts.Recursive}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -81,10 +236,22 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, snapshotIDs) {
+		if gopts.JSON {
+			err = json.NewEncoder(globalOptions.stdout).Encode(lsSnapshot{
+				Snapshot:   sn,
+				ID:         sn.ID(),
+				ShortID:    sn.ID().Str(),
+				StructType: "snapshot",
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		Verbosef("snapshot %s of %v at %s):\n", sn.ID().Str(), sn.Paths, sn.Time)
 
-		if err = printTree(repo, sn.Tree, string(filepath.Separator)); err != nil {
+		if err = printTree(repo, filter, sn.Tree, "/"); err != nil {
 			return err
 		}
 	}
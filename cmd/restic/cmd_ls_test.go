@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLsFilter(t *testing.T) {
+	var tests = []struct {
+		filter  lsFilter
+		item    string
+		list    bool
+		descend bool
+	}{
+		{lsFilter{}, "/home", true, true},
+		{lsFilter{}, "/home/user/foo", true, true},
+		{lsFilter{dirs: []string{"/home/user"}}, "/home", false, true},
+		{lsFilter{dirs: []string{"/home/user"}}, "/home/user", false, true},
+		{lsFilter{dirs: []string{"/home/user"}}, "/home/user/foo", true, false},
+		{lsFilter{dirs: []string{"/home/user"}}, "/home/user/foo/bar", false, false},
+		{lsFilter{dirs: []string{"/home/user"}}, "/home/username", false, false},
+		{lsFilter{dirs: []string{"/home/user"}}, "/srv", false, false},
+		{lsFilter{dirs: []string{"/home/user"}, recursive: true}, "/home/user/foo", true, true},
+		{lsFilter{dirs: []string{"/home/user"}, recursive: true}, "/home/user/foo/bar", true, true},
+		{lsFilter{dirs: []string{"/home/user"}, recursive: true}, "/srv/foo", false, false},
+		{lsFilter{dirs: []string{"/"}}, "/home", true, false},
+		{lsFilter{dirs: []string{"/"}}, "/home/user", false, false},
+	}
+
+	for _, test := range tests {
+		list := test.filter.list(test.item)
+		if list != test.list {
+			t.Errorf("%v: wrong list result for %v: want %v, got %v",
+				test.filter, test.item, test.list, list)
+		}
+
+		descend := test.filter.descend(test.item)
+		if descend != test.descend {
+			t.Errorf("%v: wrong descend result for %v: want %v, got %v",
+				test.filter, test.item, test.descend, descend)
+		}
+	}
+}
+
+func TestSplitLsArgs(t *testing.T) {
+	var tests = []struct {
+		args        []string
+		snapshotIDs []string
+		dirs        []string
+		err         bool
+	}{
+		{nil, nil, nil, false},
+		{[]string{"latest"}, []string{"latest"}, nil, false},
+		{[]string{"1234abcd", "latest", "/home/user/"}, []string{"1234abcd", "latest"}, []string{"/home/user"}, false},
+		{[]string{"/home", "/srv"}, nil, []string{"/home", "/srv"}, false},
+		{[]string{"latest", "/home", "srv"}, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		snapshotIDs, dirs, err := splitLsArgs(test.args)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected error, got nil", test.args)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.args, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(snapshotIDs, test.snapshotIDs) || !reflect.DeepEqual(dirs, test.dirs) {
+			t.Errorf("%v: wrong result, want %v %v, got %v %v",
+				test.args, test.snapshotIDs, test.dirs, snapshotIDs, dirs)
+		}
+	}
+}
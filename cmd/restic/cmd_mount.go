@@ -6,10 +6,13 @@ package main
 import (
 	"context"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dirstruct"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 
@@ -26,6 +29,22 @@ var cmdMount = &cobra.Command{
 	Long: `
 The "mount" command mounts the repository via fuse to a directory. This is a
 read-only mount.
+
+The directory structure of the snapshots is configured with --path-template,
+which can be given several times. By default, the templates "ids/%i",
+"snapshots/%T", "hosts/%h/%T" and "tags/%t/%T" are used. The following
+placeholders are replaced in the templates:
+
+    %i  short snapshot ID
+    %I  long snapshot ID
+    %h  hostname
+    %u  username
+    %T  time of the snapshot
+    %t  tag, the snapshot is listed once for each of its tags
+    %%  a literal percent sign
+
+Directories containing snapshots named by their time also contain a link
+"latest" to the newest snapshot.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -35,12 +54,15 @@ read-only mount.
 
 // MountOptions collects all options for the mount command.
 type MountOptions struct {
-	OwnerRoot  bool
-	AllowRoot  bool
-	AllowOther bool
-	Host       string
-	Tags       restic.TagLists
-	Paths      []string
+	OwnerRoot     bool
+	AllowRoot     bool
+	AllowOther    bool
+	Host          string
+	Tags          restic.TagLists
+	Paths         []string
+	PathTemplates []string
+	MapUIDs       []string
+	MapGIDs       []string
 }
 
 var mountOptions MountOptions
@@ -52,16 +74,35 @@ func init() {
 	mountFlags.BoolVar(&mountOptions.OwnerRoot, "owner-root", false, "use 'root' as the owner of files and dirs")
 	mountFlags.BoolVar(&mountOptions.AllowRoot, "allow-root", false, "allow root user to access the data in the mounted directory")
 	mountFlags.BoolVar(&mountOptions.AllowOther, "allow-other", false, "allow other users to access the data in the mounted directory")
+	mountFlags.StringArrayVar(&mountOptions.MapUIDs, "map-uid", nil, "present files owned by user ID `from:to` in the snapshots as owned by a different user ID (can be specified multiple times)")
+	mountFlags.StringArrayVar(&mountOptions.MapGIDs, "map-gid", nil, "present files owned by group ID `from:to` in the snapshots as owned by a different group ID (can be specified multiple times)")
 
 	mountFlags.StringVarP(&mountOptions.Host, "host", "H", "", `only consider snapshots for this host`)
 	mountFlags.Var(&mountOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
 	mountFlags.StringArrayVar(&mountOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	mountFlags.StringArrayVar(&mountOptions.PathTemplates, "path-template", nil, "set `template` for the directory structure of the snapshots (can be specified multiple times)")
 }
 
 func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 	debug.Log("start mount")
 	defer debug.Log("finish mount")
 
+	uidMap, err := parseIDMap(opts.MapUIDs)
+	if err != nil {
+		return errors.Fatalf("invalid --map-uid: %v", err)
+	}
+
+	gidMap, err := parseIDMap(opts.MapGIDs)
+	if err != nil {
+		return errors.Fatalf("invalid --map-gid: %v", err)
+	}
+
+	for _, tmpl := range opts.PathTemplates {
+		if err := dirstruct.CheckTemplate(tmpl); err != nil {
+			return errors.Fatalf("%v", err)
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -103,10 +144,13 @@ func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 	}
 
 	cfg := fuse.Config{
-		OwnerIsRoot: opts.OwnerRoot,
-		Host:        opts.Host,
-		Tags:        opts.Tags,
-		Paths:       opts.Paths,
+		OwnerIsRoot:   opts.OwnerRoot,
+		Host:          opts.Host,
+		Tags:          opts.Tags,
+		Paths:         opts.Paths,
+		PathTemplates: opts.PathTemplates,
+		UIDMap:        uidMap,
+		GIDMap:        gidMap,
 	}
 	root, err := fuse.NewRoot(context.TODO(), repo, cfg)
 	if err != nil {
@@ -126,6 +170,31 @@ func mount(opts MountOptions, gopts GlobalOptions, mountpoint string) error {
 	return c.MountError
 }
 
+// parseIDMap parses a list of "from:to" pairs of numeric IDs.
+func parseIDMap(list []string) (map[uint32]uint32, error) {
+	m := make(map[uint32]uint32, len(list))
+	for _, s := range list {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("%q is not of the form from:to", s)
+		}
+
+		from, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid ID in %q: %v", s, err)
+		}
+
+		to, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid ID in %q: %v", s, err)
+		}
+
+		m[uint32(from)] = uint32(to)
+	}
+
+	return m, nil
+}
+
 func umount(mountpoint string) error {
 	return systemFuse.Unmount(mountpoint)
 }
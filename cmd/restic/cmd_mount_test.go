@@ -0,0 +1,25 @@
+// +build !openbsd
+// +build !windows
+
+package main
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseIDMap(t *testing.T) {
+	m, err := parseIDMap([]string{"1000:0", "33:1001"})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[uint32]uint32{1000: 0, 33: 1001}, m)
+
+	m, err = parseIDMap(nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(m))
+
+	for _, s := range []string{"1000", "a:b", "1000:", "-1:0", "1:4294967296"} {
+		_, err = parseIDMap([]string{s})
+		rtest.Assert(t, err != nil, "expected an error for %q", s)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
@@ -11,6 +14,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var cmdPrune = &cobra.Command{
@@ -19,15 +23,83 @@ var cmdPrune = &cobra.Command{
 	Long: `
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
+
+Pack files which contain both needed and unneeded data are rewritten. With
+--max-unused, some unneeded data is tolerated and only the pack files with the
+largest share of unneeded data are rewritten, --max-repack-size limits the
+amount of data rewritten in a single run.
+
+With --grace-period, prune does not need an exclusive lock and can run while
+backups are in progress. Pack files which are not needed any more are only
+marked for deletion and removed by a later run of prune once they have been
+marked for longer than the grace period. The grace period must be longer than
+the longest running backup.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	MaxUnused     string
+	MaxRepackSize string
+	GracePeriod   time.Duration
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	addPruneOptions(cmdPrune.Flags(), &pruneOptions)
+}
+
+func addPruneOptions(f *pflag.FlagSet, opts *PruneOptions) {
+	f.StringVar(&opts.MaxUnused, "max-unused", "0%", "tolerate `limit` of unused data, as a percentage of the repository size (e.g. 10%), a size (e.g. 1G) or 'unlimited'")
+	f.StringVar(&opts.MaxRepackSize, "max-repack-size", "", "rewrite at most `size` of pack files (e.g. 500M, default: unlimited)")
+	f.DurationVar(&opts.GracePeriod, "grace-period", 0, "only mark unneeded pack files and delete them when they have been marked for longer than `duration`, allows backups to run concurrently")
+}
+
+// pruneLimits are the limits for repacking computed from the PruneOptions, -1
+// means unlimited.
+type pruneLimits struct {
+	maxUnused     int64
+	maxRepackSize int64
+}
+
+// limits parses the options, percentages are relative to the size of the
+// repository repoSize.
+func (opts PruneOptions) limits(repoSize int64) (pruneLimits, error) {
+	l := pruneLimits{maxUnused: 0, maxRepackSize: -1}
+
+	switch {
+	case opts.MaxUnused == "":
+	case opts.MaxUnused == "unlimited":
+		l.maxUnused = -1
+	case strings.HasSuffix(opts.MaxUnused, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(opts.MaxUnused, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return l, errors.Fatalf("invalid value for --max-unused: %q", opts.MaxUnused)
+		}
+		l.maxUnused = int64(p / 100 * float64(repoSize))
+	default:
+		size, err := parseSizeStr(opts.MaxUnused)
+		if err != nil {
+			return l, errors.Fatalf("invalid value for --max-unused: %v", err)
+		}
+		l.maxUnused = size
+	}
+
+	if opts.MaxRepackSize != "" {
+		size, err := parseSizeStr(opts.MaxRepackSize)
+		if err != nil {
+			return l, errors.Fatalf("invalid value for --max-repack-size: %v", err)
+		}
+		l.maxRepackSize = size
+	}
+
+	return l, nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -70,19 +142,123 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 	return p
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	if _, err := opts.limits(0); err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(repo)
+	if repo.AppendOnly() {
+		return errors.Fatal("prune cannot be run with an append-only key")
+	}
+
+	if repo.ReadOnly() {
+		return errors.Fatal("prune cannot be run with a read-only key")
+	}
+
+	// with a grace period, packs are not deleted while they may still be
+	// referenced by a backup which is running concurrently
+	lock, err := lockRepository(repo, opts.GracePeriod <= 0)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
 	}
 
-	return pruneRepository(gopts, repo)
+	return pruneRepository(opts, gopts, repo)
+}
+
+// minSmallPacks is the number of packs smaller than half the target pack size
+// that prune needs to find before they are repacked.
+const minSmallPacks = 10
+
+// repackCandidate is a pack which may be rewritten by prune.
+type repackCandidate struct {
+	id     restic.ID
+	size   int64
+	unused int
+
+	// mustRepack is set for packs which are rewritten regardless of the
+	// unused data they contain: packs with tree and data blobs, small packs
+	// and packs with duplicate blobs.
+	mustRepack bool
+}
+
+// limitRepack removes packs from rewritePacks, so that the packs which are
+// not rewritten contain at most limits.maxUnused bytes of unused data and
+// at most limits.maxRepackSize bytes are rewritten. Packs with the largest
+// share of unused data are rewritten first. The blobs in packs which are not
+// rewritten are removed from usedBlobs, so that they are not copied by
+// Repack. The amount of unused data which is kept is returned.
+func limitRepack(limits pruneLimits, packs map[restic.ID]index.Pack, rewritePacks restic.IDSet, usedBlobs restic.BlobSet) (keptUnused int) {
+	var list []repackCandidate
+	totalUnused := 0
+	for id := range rewritePacks {
+		p := packs[id]
+		c := repackCandidate{id: id, size: p.Size}
+		for _, blob := range p.Entries {
+			if !usedBlobs.Has(restic.BlobHandle{ID: blob.ID, Type: blob.Type}) {
+				c.unused += int(blob.Length)
+			}
+		}
+		c.mustRepack = c.unused == 0 || mixedBlobs(p.Entries)
+
+		totalUnused += c.unused
+		list = append(list, c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.mustRepack != b.mustRepack {
+			return a.mustRepack
+		}
+		return float64(a.unused)/float64(a.size) > float64(b.unused)/float64(b.size)
+	})
+
+	remainingUnused := totalUnused
+	var repackSize int64
+	for _, c := range list {
+		repack := c.mustRepack || (limits.maxUnused >= 0 && int64(remainingUnused) > limits.maxUnused)
+		if repack && limits.maxRepackSize >= 0 && repackSize+c.size > limits.maxRepackSize {
+			repack = false
+		}
+
+		if repack {
+			repackSize += c.size
+			remainingUnused -= c.unused
+			continue
+		}
+
+		debug.Log("not repacking pack %v, %d of %d bytes unused", c.id.Str(), c.unused, c.size)
+		rewritePacks.Delete(c.id)
+		keptUnused += c.unused
+		for _, blob := range packs[c.id].Entries {
+			usedBlobs.Delete(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
+
+	return keptUnused
+}
+
+// countKeptPacks returns the number of packs with unused data which are
+// neither removed nor rewritten.
+func countKeptPacks(packs map[restic.ID]index.Pack, removePacks, rewritePacks restic.IDSet, usedBlobs restic.BlobSet) (n int) {
+	for id, p := range packs {
+		if removePacks.Has(id) || rewritePacks.Has(id) {
+			continue
+		}
+
+		for _, blob := range p.Entries {
+			if !usedBlobs.Has(restic.BlobHandle{ID: blob.ID, Type: blob.Type}) {
+				n++
+				break
+			}
+		}
+	}
+	return n
 }
 
 func mixedBlobs(list []restic.Blob) bool {
@@ -104,19 +280,76 @@ func mixedBlobs(list []restic.Blob) bool {
 	return false
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+// findUsedBlobs returns the blobs referenced by all snapshots in the repository.
+func findUsedBlobs(gopts GlobalOptions, repo restic.Repository) (restic.BlobSet, error) {
+	ctx := gopts.ctx
+
+	Verbosef("load all snapshots\n")
+
+	// find referenced blobs
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	Verbosef("find data that is still in use for %d snapshots\n", len(snapshots))
+
+	usedBlobs := restic.NewBlobSet()
+	seenBlobs := restic.NewBlobSet()
+
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(snapshots)), "snapshots")
+	bar.Start()
+	for _, sn := range snapshots {
+		debug.Log("process snapshot %v", sn.ID().Str())
+
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
+		if err != nil {
+			if repo.Backend().IsNotExist(err) {
+				return nil, errors.Fatal("unable to load a tree from the repo: " + err.Error())
+			}
+
+			return nil, err
+		}
+
+		debug.Log("processed snapshot %v", sn.ID().Str())
+		bar.Report(restic.Stat{Blobs: 1})
+	}
+	bar.Done()
+
+	return usedBlobs, nil
+}
+
+func pruneRepository(opts PruneOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	if lock := repo.Config().ObjectLock; lock != nil {
+		Verbosef("the repository uses object lock, removed files are kept by the storage until %d days after they were saved\n", lock.Days)
+	}
+
+	// prune holds an exclusive lock, so no other client is uploading pack
+	// files and all incomplete uploads have been left behind by failed or
+	// interrupted backups
+	aborted, err := restic.AbortUploads(gopts.ctx, repo.Backend(), restic.DataFile, time.Now())
+	if err != nil {
+		return err
+	}
+	if aborted > 0 {
+		Verbosef("aborted %d incomplete uploads of pack files\n", aborted)
+	}
+
+	if opts.GracePeriod > 0 {
+		return pruneRepositoryGrace(opts, gopts, repo)
+	}
+
 	ctx := gopts.ctx
 
-	err := repo.LoadIndex(ctx)
+	err = repo.LoadIndex(ctx)
 	if err != nil {
 		return err
 	}
 
 	var stats struct {
-		blobs     int
-		packs     int
-		snapshots int
-		bytes     int64
+		blobs int
+		packs int
+		bytes int64
 	}
 
 	Verbosef("counting files in repo\n")
@@ -126,7 +359,7 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 
 	Verbosef("building new index for repo\n")
 
-	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
+	bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(stats.packs), "packs")
 	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
 	if err != nil {
 		return err
@@ -164,40 +397,11 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 
 	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
 		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
-
-	// find referenced blobs
-	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	usedBlobs, err := findUsedBlobs(gopts, repo)
 	if err != nil {
 		return err
 	}
 
-	stats.snapshots = len(snapshots)
-
-	Verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
-
-	usedBlobs := restic.NewBlobSet()
-	seenBlobs := restic.NewBlobSet()
-
-	bar = newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
-	bar.Start()
-	for _, sn := range snapshots {
-		debug.Log("process snapshot %v", sn.ID().Str())
-
-		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, usedBlobs, seenBlobs)
-		if err != nil {
-			if repo.Backend().IsNotExist(err) {
-				return errors.Fatal("unable to load a tree from the repo: " + err.Error())
-			}
-
-			return err
-		}
-
-		debug.Log("processed snapshot %v", sn.ID().Str())
-		bar.Report(restic.Stat{Blobs: 1})
-	}
-	bar.Done()
-
 	if len(usedBlobs) > stats.blobs {
 		return errors.Fatalf("number of used blobs is larger than number of available blobs!\n" +
 			"Please report this error (along with the output of the 'prune' run) at\n" +
@@ -264,12 +468,43 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		rewritePacks.Delete(packID)
 	}
 
+	// packs which are much smaller than the target size are rewritten into
+	// larger ones, so that the number of packs does not grow with every
+	// backup, but only if there are enough of them
+	smallPacks := restic.NewIDSet()
+	for packID, p := range idx.Packs {
+		if removePacks.Has(packID) || rewritePacks.Has(packID) {
+			continue
+		}
+
+		if uint64(p.Size) < uint64(repo.PackSize())/2 {
+			smallPacks.Insert(packID)
+		}
+	}
+
+	if len(smallPacks) >= minSmallPacks {
+		Verbosef("will repack %d small packs\n", len(smallPacks))
+		rewritePacks.Merge(smallPacks)
+	}
+
+	limits, err := opts.limits(stats.bytes)
+	if err != nil {
+		return err
+	}
+
+	if limits.maxUnused != 0 || limits.maxRepackSize >= 0 {
+		keptUnused := limitRepack(limits, idx.Packs, rewritePacks, usedBlobs)
+		Verbosef("keeping %s of unused data in %d packs which are not rewritten\n",
+			formatBytes(uint64(keptUnused)), countKeptPacks(idx.Packs, removePacks, rewritePacks, usedBlobs))
+		removeBytes -= keptUnused
+	}
+
 	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
 		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
 
 	var obsoletePacks restic.IDSet
 	if len(rewritePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
+		bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(rewritePacks)), "packs rewritten")
 		bar.Start()
 		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
 		if err != nil {
@@ -285,7 +520,7 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 	}
 
 	if len(removePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
+		bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(removePacks)), "packs deleted")
 		bar.Start()
 		for packID := range removePacks {
 			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
@@ -298,6 +533,19 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		bar.Done()
 	}
 
+	// the session keys of clients using write-only keys are saved to a
+	// single file, so that the repository can be opened without loading a
+	// file for every backup made with a write-only key
+	if err = repo.FoldSessionKeys(ctx); err != nil {
+		return err
+	}
+
+	setNotificationStats(map[string]interface{}{
+		"packs_removed":   len(removePacks),
+		"packs_rewritten": len(rewritePacks),
+		"bytes_freed":     removeBytes,
+	})
+
 	Verbosef("done\n")
 	return nil
 }
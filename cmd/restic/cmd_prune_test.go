@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestPruneLimits(t *testing.T) {
+	var tests = []struct {
+		opts   PruneOptions
+		limits pruneLimits
+		err    bool
+	}{
+		{PruneOptions{}, pruneLimits{0, -1}, false},
+		{PruneOptions{MaxUnused: "10%"}, pruneLimits{100, -1}, false},
+		{PruneOptions{MaxUnused: "unlimited", MaxRepackSize: "1k"}, pruneLimits{-1, 1024}, false},
+		{PruneOptions{MaxUnused: "2M"}, pruneLimits{2 << 20, -1}, false},
+		{PruneOptions{MaxUnused: "110%"}, pruneLimits{}, true},
+		{PruneOptions{MaxUnused: "foo"}, pruneLimits{}, true},
+		{PruneOptions{MaxRepackSize: "-1"}, pruneLimits{}, true},
+	}
+
+	for _, test := range tests {
+		limits, err := test.opts.limits(1000)
+		if test.err {
+			rtest.Assert(t, err != nil, "expected an error for %v", test.opts)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.limits, limits)
+	}
+}
+
+// testPack returns a pack with n blobs of 100 bytes each, the first unused
+// of them are not in usedBlobs.
+func testPack(n, unused int, usedBlobs restic.BlobSet) index.Pack {
+	p := index.Pack{ID: restic.NewRandomID(), Size: int64(n * 100)}
+	for i := 0; i < n; i++ {
+		blob := restic.Blob{ID: restic.NewRandomID(), Type: restic.DataBlob, Length: 100}
+		if i >= unused {
+			usedBlobs.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+		p.Entries = append(p.Entries, blob)
+	}
+	return p
+}
+
+func TestLimitRepack(t *testing.T) {
+	usedBlobs := restic.NewBlobSet()
+	packs := make(map[restic.ID]index.Pack)
+	rewritePacks := restic.NewIDSet()
+
+	// packs with 10 blobs, 1 to 4 of them are unused
+	var ids restic.IDs
+	for i := 1; i <= 4; i++ {
+		p := testPack(10, i, usedBlobs)
+		packs[p.ID] = p
+		rewritePacks.Insert(p.ID)
+		ids = append(ids, p.ID)
+	}
+
+	// tolerate 300 bytes of unused data: the packs with 4 and 3 unused blobs
+	// are rewritten
+	kept := limitRepack(pruneLimits{maxUnused: 300, maxRepackSize: -1}, packs, rewritePacks, usedBlobs)
+	rtest.Equals(t, 300, kept)
+	rtest.Equals(t, restic.NewIDSet(ids[2], ids[3]), rewritePacks)
+
+	// the used blobs of the packs which are kept must not be copied
+	for _, blob := range packs[ids[0]].Entries {
+		rtest.Assert(t, !usedBlobs.Has(restic.BlobHandle{ID: blob.ID, Type: blob.Type}),
+			"blob %v of kept pack is still in usedBlobs", blob.ID.Str())
+	}
+
+	// rewrite at most 1000 bytes
+	kept = limitRepack(pruneLimits{maxUnused: 0, maxRepackSize: 1000}, packs, rewritePacks, usedBlobs)
+	rtest.Equals(t, 300, kept)
+	rtest.Equals(t, restic.NewIDSet(ids[3]), rewritePacks)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdRecover = &cobra.Command{
+	Use:   "recover [flags]",
+	Short: "Recover data from the repository",
+	Long: `
+The "recover" command builds a new snapshot from all directories it can find in
+the raw data of the repository which are not referenced in an existing
+snapshot. It can be used if, for example, a snapshot has been removed by
+accident with "forget" or a backup was interrupted before the snapshot was
+saved.
+
+Each of the directories is contained in the new snapshot in a directory named
+after the ID of its tree, the snapshot has the path "/recover".
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRecover(globalOptions)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdRecover)
+}
+
+func runRecover(gopts GlobalOptions) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockRepo(repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	Verbosef("load index files\n")
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	// trees maps a tree ID to whether or not it is referenced by a different
+	// tree. If it is not referenced, we have a root tree.
+	trees := make(map[restic.ID]bool)
+
+	for blob := range repo.Index().Each(ctx) {
+		if blob.Type != restic.TreeBlob {
+			continue
+		}
+		trees[blob.ID] = false
+	}
+
+	Verbosef("load %d trees\n", len(trees))
+	for id := range trees {
+		tree, err := repo.LoadTree(ctx, id)
+		if err != nil {
+			Warnf("unable to load tree %v: %v\n", id.Str(), err)
+			continue
+		}
+
+		for _, node := range tree.Nodes {
+			if node.Type != "dir" || node.Subtree == nil {
+				continue
+			}
+
+			if _, ok := trees[*node.Subtree]; ok {
+				trees[*node.Subtree] = true
+			}
+		}
+	}
+
+	Verbosef("load snapshots\n")
+	for sn := range FindFilteredSnapshots(ctx, repo, "", nil, nil, nil) {
+		if sn.Tree == nil {
+			continue
+		}
+
+		if _, ok := trees[*sn.Tree]; ok {
+			trees[*sn.Tree] = true
+		}
+	}
+
+	roots := restic.NewIDSet()
+	for id, referenced := range trees {
+		if !referenced {
+			roots.Insert(id)
+		}
+	}
+
+	Verbosef("found %d unreferenced roots\n", len(roots))
+
+	if len(roots) == 0 {
+		Verbosef("no snapshot to write.\n")
+		return nil
+	}
+
+	now := time.Now()
+	tree := restic.NewTree()
+	for _, id := range roots.List() {
+		subtree := id
+		err := tree.Insert(&restic.Node{
+			Type:       "dir",
+			Name:       id.Str(),
+			Mode:       os.ModeDir | 0755,
+			ModTime:    now,
+			AccessTime: now,
+			ChangeTime: now,
+			Subtree:    &subtree,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	treeID, err := repo.SaveTree(ctx, tree)
+	if err != nil {
+		return errors.Fatalf("unable to save new tree to the repo: %v", err)
+	}
+
+	if err = repo.Flush(); err != nil {
+		return errors.Fatalf("unable to save blobs to the repo: %v", err)
+	}
+
+	if err = repo.SaveIndex(ctx); err != nil {
+		return errors.Fatalf("unable to save new index to the repo: %v", err)
+	}
+
+	sn, err := restic.NewSnapshot([]string{"/recover"}, []string{}, hostname, now)
+	if err != nil {
+		return errors.Fatalf("unable to save snapshot: %v", err)
+	}
+
+	sn.Tree = &treeID
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return errors.Fatalf("unable to save snapshot: %v", err)
+	}
+
+	Printf("saved new snapshot %v\n", id.Str())
+
+	return nil
+}
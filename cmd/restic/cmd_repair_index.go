@@ -9,12 +9,14 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var cmdRebuildIndex = &cobra.Command{
-	Use:   "rebuild-index [flags]",
-	Short: "Build a new index file",
+var cmdRepairIndex = &cobra.Command{
+	Use:   "index [flags]",
+	Short: "Build a new index",
 	Long: `
-The "rebuild-index" command creates a new index based on the pack files in the
-repository.
+The "repair index" command creates a new index based on the pack files in the
+repository. All pack files are read (only their headers are downloaded) and
+the existing index files are replaced. This recovers from lost or damaged
+index files, e.g. after an interrupted prune.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -22,7 +24,18 @@ repository.
 	},
 }
 
+var cmdRebuildIndex = &cobra.Command{
+	Use:               "rebuild-index [flags]",
+	Short:             cmdRepairIndex.Short,
+	Long:              cmdRepairIndex.Long,
+	Deprecated:        `use "repair index" instead`,
+	DisableAutoGenTag: true,
+	RunE:              cmdRepairIndex.RunE,
+}
+
 func init() {
+	cmdRepair.AddCommand(cmdRepairIndex)
+	// add alias for old name
 	cmdRoot.AddCommand(cmdRebuildIndex)
 }
 
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
@@ -27,12 +32,23 @@ repository.
 
 // RestoreOptions collects all options for the restore command.
 type RestoreOptions struct {
-	Exclude []string
-	Include []string
-	Target  string
-	Host    string
-	Paths   []string
-	Tags    restic.TagLists
+	Exclude            []string
+	InsensitiveExclude []string
+	Include            []string
+	InsensitiveInclude []string
+	Target             string
+	Host               string
+	Paths              []string
+	Tags               restic.TagLists
+	NoXattrs           bool
+	Sparse             bool
+	Verify             bool
+	Delta              bool
+	Overwrite          string
+	Delete             bool
+	NoOwner            bool
+	NoPerms            bool
+	WaitThaw           bool
 }
 
 var restoreOptions RestoreOptions
@@ -42,14 +58,82 @@ func init() {
 
 	flags := cmdRestore.Flags()
 	flags.StringArrayVarP(&restoreOptions.Exclude, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.InsensitiveExclude, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
 	flags.StringArrayVarP(&restoreOptions.Include, "include", "i", nil, "include a `pattern`, exclude everything else (can be specified multiple times)")
+	flags.StringArrayVar(&restoreOptions.InsensitiveInclude, "iinclude", nil, "same as --include `pattern` but ignores the casing of filenames")
 	flags.StringVarP(&restoreOptions.Target, "target", "t", "", "directory to extract data to")
+	flags.BoolVar(&restoreOptions.NoXattrs, "no-xattrs", false, "do not restore extended attributes")
+	flags.StringVar(&restoreOptions.Overwrite, "overwrite", "always", "overwrite existing files: `mode` is one of always, if-changed, if-newer or never")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target which are not in the snapshot")
+	flags.BoolVar(&restoreOptions.Delta, "delta", false, "only download and write the parts of existing files which differ from the snapshot")
+	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify the content of the restored files")
+	flags.BoolVar(&restoreOptions.NoOwner, "no-owner", false, "do not restore the owner and group of files")
+	flags.BoolVar(&restoreOptions.NoPerms, "no-perms", false, "do not restore the permissions of files")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse files, blocks of zeroes are not written")
+	flags.BoolVar(&restoreOptions.WaitThaw, "wait-thaw", false, "wait until archived pack files have been restored by the backend instead of exiting")
 
 	flags.StringVarP(&restoreOptions.Host, "host", "H", "", `only consider snapshots for this host when the snapshot ID is "latest"`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 }
 
+func newRestoreProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
+	if gopts.Quiet || gopts.JSON {
+		return nil
+	}
+
+	restoreProgress := restic.NewProgress()
+
+	var bps, eta uint64
+	itemsTodo := todo.Files + todo.Dirs
+
+	restoreProgress.OnUpdate = func(s restic.Stat, d time.Duration, ticker bool) {
+		if IsProcessBackground() {
+			return
+		}
+
+		sec := uint64(d / time.Second)
+		if todo.Bytes > 0 && sec > 0 && ticker {
+			bps = s.Bytes / sec
+			if s.Bytes >= todo.Bytes {
+				eta = 0
+			} else if bps > 0 {
+				eta = (todo.Bytes - s.Bytes) / bps
+			}
+		}
+
+		itemsDone := s.Files + s.Dirs
+
+		status1 := fmt.Sprintf("[%s] %s  %s/s  %s / %s  %d / %d items  %d errors  ",
+			formatDuration(d),
+			formatPercent(s.Bytes, todo.Bytes),
+			formatBytes(bps),
+			formatBytes(s.Bytes), formatBytes(todo.Bytes),
+			itemsDone, itemsTodo,
+			s.Errors)
+		status2 := fmt.Sprintf("ETA %s ", formatSeconds(eta))
+
+		if w := stdoutTerminalWidth(); w > 0 {
+			maxlen := w - len(status2) - 1
+
+			if maxlen < 4 {
+				status1 = ""
+			} else if len(status1) > maxlen {
+				status1 = status1[:maxlen-4]
+				status1 += "... "
+			}
+		}
+
+		PrintProgress("%s%s", status1, status2)
+	}
+
+	restoreProgress.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
+		fmt.Printf("\nduration: %s, %s\n", formatDuration(d), formatRate(s.Bytes, d))
+	}
+
+	return restoreProgress
+}
+
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	ctx := gopts.ctx
 
@@ -61,10 +145,26 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("please specify a directory to restore to (--target)")
 	}
 
-	if len(opts.Exclude) > 0 && len(opts.Include) > 0 {
+	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
+	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
+
+	if hasExcludes && hasIncludes {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	overwrite, err := restic.ParseOverwriteBehavior(opts.Overwrite)
+	if err != nil {
+		return errors.Fatalf("%v", err)
+	}
+
+	for i, str := range opts.InsensitiveExclude {
+		opts.InsensitiveExclude[i] = strings.ToLower(str)
+	}
+
+	for i, str := range opts.InsensitiveInclude {
+		opts.InsensitiveInclude[i] = strings.ToLower(str)
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -105,10 +205,21 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	if err != nil {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
+	res.SkipExtendedAttributes = opts.NoXattrs
+	res.Sparse = opts.Sparse
+	res.Delta = opts.Delta
+	res.Overwrite = overwrite
+	res.Delete = opts.Delete
+	res.NoOwner = opts.NoOwner
+	res.NoPerms = opts.NoPerms
+	res.PreparePacks = func(ctx context.Context, packs restic.IDSet) error {
+		return thawPacks(ctx, repo, packs, opts.WaitThaw)
+	}
 
 	totalErrors := 0
 	res.Error = func(dir string, node *restic.Node, err error) error {
 		Warnf("ignoring error for %s: %s\n", dir, err)
+		logEvent("file_error", logFields{"path": dir, "error": err})
 		totalErrors++
 		return nil
 	}
@@ -119,11 +230,16 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for exclude pattern: %v", err)
 		}
 
+		matchedInsensitive, _, err := filter.List(opts.InsensitiveExclude, strings.ToLower(item))
+		if err != nil {
+			Warnf("error for iexclude pattern: %v", err)
+		}
+
 		// An exclude filter is basically a 'wildcard but foo',
 		// so even if a childMayMatch, other children of a dir may not,
 		// therefore childMayMatch does not matter, but we should not go down
 		// unless the dir is selected for restore
-		selectedForRestore = !matched
+		selectedForRestore = !matched && !matchedInsensitive
 		childMayBeSelected = selectedForRestore && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
@@ -135,21 +251,43 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 			Warnf("error for include pattern: %v", err)
 		}
 
-		selectedForRestore = matched
-		childMayBeSelected = childMayMatch && node.Type == "dir"
+		matchedInsensitive, childMayMatchInsensitive, err := filter.List(opts.InsensitiveInclude, strings.ToLower(item))
+		if err != nil {
+			Warnf("error for iinclude pattern: %v", err)
+		}
+
+		selectedForRestore = matched || matchedInsensitive
+		childMayBeSelected = (childMayMatch || childMayMatchInsensitive) && node.Type == "dir"
 
 		return selectedForRestore, childMayBeSelected
 	}
 
-	if len(opts.Exclude) > 0 {
+	if hasExcludes {
 		res.SelectFilter = selectExcludeFilter
-	} else if len(opts.Include) > 0 {
+	} else if hasIncludes {
 		res.SelectFilter = selectIncludeFilter
 	}
 
+	// the progress is only displayed on a terminal, don't scan the snapshot
+	// otherwise
+	if !gopts.Quiet && !gopts.JSON && stdoutIsTerminal() {
+		todo, err := res.Scan(ctx, opts.Target)
+		if err != nil {
+			return err
+		}
+		res.Progress = newRestoreProgress(gopts, todo)
+	}
+
 	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
 
 	err = res.RestoreTo(ctx, opts.Target)
+	if err == nil && opts.Verify {
+		Verbosef("verifying files in %s\n", opts.Target)
+		var count int
+		count, err = res.VerifyFiles(ctx)
+		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
+	}
+
 	if totalErrors > 0 {
 		Printf("There were %d errors\n", totalErrors)
 	}
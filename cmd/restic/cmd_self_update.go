@@ -0,0 +1,89 @@
+// +build selfupdate
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/selfupdate"
+	"github.com/spf13/cobra"
+)
+
+var cmdSelfUpdate = &cobra.Command{
+	Use:   "self-update [flags]",
+	Short: "Update the restic binary",
+	Long: `
+The "self-update" command downloads the latest stable release of restic from
+GitHub and replaces the currently running binary. The SHA256 checksum of the
+download is verified against the SHA256SUMS file of the release, and the
+signature of SHA256SUMS is verified with the restic release key. Only the key
+with the fingerprint built into restic is accepted. A different armored PGP
+public key can be given with --signing-key.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSelfUpdate(selfUpdateOptions, globalOptions, args)
+	},
+}
+
+// SelfUpdateOptions collects all options for the self-update command.
+type SelfUpdateOptions struct {
+	Output     string
+	SigningKey string
+}
+
+var selfUpdateOptions SelfUpdateOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdSelfUpdate)
+
+	flags := cmdSelfUpdate.Flags()
+	flags.StringVar(&selfUpdateOptions.Output, "output", os.Args[0], "save the downloaded file as `filename`")
+	flags.StringVar(&selfUpdateOptions.SigningKey, "signing-key", "", "verify the release with the armored PGP public key in `file` instead of the release key")
+}
+
+func runSelfUpdate(opts SelfUpdateOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("self-update has no arguments")
+	}
+
+	file, err := filepath.Abs(opts.Output)
+	if err != nil {
+		return errors.Wrap(err, "unable to find executable")
+	}
+
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return errors.Fatalf("unable to find executable %v: %v", file, err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("output file %v is not a normal file, use --output to specify a different file", file)
+	}
+
+	var keyring io.Reader
+	if opts.SigningKey != "" {
+		f, err := os.Open(opts.SigningKey)
+		if err != nil {
+			return errors.Fatalf("unable to open signing key: %v", err)
+		}
+		defer f.Close()
+		keyring = f
+	}
+
+	Verbosef("writing restic to %v\n", file)
+
+	v, err := selfupdate.DownloadLatestStableRelease(gopts.ctx, file, version, keyring, Verbosef)
+	if err != nil {
+		return errors.Fatalf("unable to update restic: %v", err)
+	}
+
+	if v != version {
+		Printf("successfully updated restic to version %v\n", v)
+	}
+
+	return nil
+}
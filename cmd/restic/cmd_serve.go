@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdServe = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the repository",
+	Long: `
+The "serve" command groups subcommands which make the snapshots in the
+repository available to other programs.
+`,
+	DisableAutoGenTag: true,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdServe)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dirstruct"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/serve"
+)
+
+var cmdServeWebDAV = &cobra.Command{
+	Use:   "webdav [flags]",
+	Short: "Serve the repository via WebDAV",
+	Long: `
+The "serve webdav" command serves the repository via WebDAV, so that the
+snapshots can be browsed with a file manager or mounted as a network drive on
+systems where "mount" is not available. The server is read-only and does not
+use authentication or TLS, by default it only listens on localhost.
+
+The snapshots are presented in the same directory structure as with "mount",
+which can be configured with --path-template. Only files and directories are
+served, symlinks and special files are not listed.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServeWebDAV(serveWebDAVOptions, globalOptions, args)
+	},
+}
+
+// ServeWebDAVOptions collects all options for the serve webdav command.
+type ServeWebDAVOptions struct {
+	Listen        string
+	Host          string
+	Tags          restic.TagLists
+	Paths         []string
+	PathTemplates []string
+}
+
+var serveWebDAVOptions ServeWebDAVOptions
+
+func init() {
+	cmdServe.AddCommand(cmdServeWebDAV)
+
+	flags := cmdServeWebDAV.Flags()
+	flags.StringVarP(&serveWebDAVOptions.Listen, "listen", "l", "localhost:3080", "listen on this `address`")
+	flags.StringVarP(&serveWebDAVOptions.Host, "host", "H", "", `only consider snapshots for this host`)
+	flags.Var(&serveWebDAVOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
+	flags.StringArrayVar(&serveWebDAVOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	flags.StringArrayVar(&serveWebDAVOptions.PathTemplates, "path-template", nil, "set `template` for the directory structure of the snapshots (can be specified multiple times)")
+}
+
+func runServeWebDAV(opts ServeWebDAVOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("serve webdav has no arguments")
+	}
+
+	for _, tmpl := range opts.PathTemplates {
+		if err := dirstruct.CheckTemplate(tmpl); err != nil {
+			return errors.Fatalf("%v", err)
+		}
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	err = repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg := serve.Config{
+		Host:          opts.Host,
+		Tags:          opts.Tags,
+		Paths:         opts.Paths,
+		PathTemplates: opts.PathTemplates,
+	}
+	h, err := serve.NewWebDAV(gopts.ctx, repo, cfg)
+	if err != nil {
+		return err
+	}
+
+	Printf("Now serving the repository at http://%s\n", opts.Listen)
+	Printf("When finished, quit with Ctrl-c here.\n")
+
+	debug.Log("serving webdav at %v", opts.Listen)
+	return http.ListenAndServe(opts.Listen, h)
+}
@@ -176,10 +176,9 @@ type Snapshot struct {
 	ShortID string     `json:"short_id"`
 }
 
-// printSnapshotsJSON writes the JSON representation of list to stdout.
-func printSnapshotsJSON(stdout io.Writer, list restic.Snapshots) error {
-
-	var snapshots []Snapshot
+// asJSONSnapshots returns the snapshots in list with their IDs included.
+func asJSONSnapshots(list restic.Snapshots) []Snapshot {
+	snapshots := make([]Snapshot, 0, len(list))
 
 	for _, sn := range list {
 
@@ -191,5 +190,10 @@ func printSnapshotsJSON(stdout io.Writer, list restic.Snapshots) error {
 		snapshots = append(snapshots, k)
 	}
 
-	return json.NewEncoder(stdout).Encode(snapshots)
+	return snapshots
+}
+
+// printSnapshotsJSON writes the JSON representation of list to stdout.
+func printSnapshotsJSON(stdout io.Writer, list restic.Snapshots) error {
+	return json.NewEncoder(stdout).Encode(asJSONSnapshots(list))
 }
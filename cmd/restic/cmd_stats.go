@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/spf13/cobra"
+)
+
+var cmdStats = &cobra.Command{
+	Use:   "stats [flags] [snapshot-ID ...]",
+	Short: "Scan the repository and show basic statistics",
+	Long: `
+The "stats" command walks one or multiple snapshots in a repository and
+accumulates statistics about the data stored therein. It reports on the
+number of unique files and their sizes, according to one of the counting
+modes as given by the --mode flag.
+
+If no snapshot is specified, all snapshots will be considered. Some modes
+make more sense over just a single snapshot, while others are useful across
+all snapshots, depending on what you are trying to calculate.
+
+The modes are:
+
+* restore-size: (default) Counts the size of the restored files.
+* files-by-contents: Counts total size of files, where a file is
+  considered unique if it has unique contents.
+* raw-data: Counts the size of blobs in the repository, regardless of how
+  many files reference them.
+* blobs-per-file: A combination of files-by-contents and raw-data.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStats(statsOptions, globalOptions, args)
+	},
+}
+
+// StatsOptions collects all options for the stats command.
+type StatsOptions struct {
+	// the mode of counting to perform
+	Mode string
+
+	// filter snapshots by, if given by user
+	Host  string
+	Tags  restic.TagLists
+	Paths []string
+}
+
+var statsOptions StatsOptions
+
+// the available counting modes
+const (
+	countModeRestoreSize           = "restore-size"
+	countModeUniqueFilesByContents = "files-by-contents"
+	countModeBlobsPerFile          = "blobs-per-file"
+	countModeRawData               = "raw-data"
+)
+
+func init() {
+	cmdRoot.AddCommand(cmdStats)
+
+	f := cmdStats.Flags()
+	f.StringVar(&statsOptions.Mode, "mode", countModeRestoreSize, "counting mode: restore-size (default), files-by-contents, blobs-per-file, or raw-data")
+	f.StringVarP(&statsOptions.Host, "host", "H", "", "only consider snapshots with the given `host`")
+	f.Var(&statsOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
+	f.StringArrayVar(&statsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
+}
+
+// statsContainer holds the state while collecting statistics.
+type statsContainer struct {
+	TotalSize      uint64 `json:"total_size"`
+	TotalFileCount uint64 `json:"total_file_count"`
+	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
+
+	repo *repository.Repository
+	mode string
+
+	// blobs is used to count individual unique blobs,
+	// independent of references to files
+	blobs restic.BlobSet
+
+	// fileBlobs maps a file name (path) to the set of
+	// blobs that have been seen as a part of the file
+	fileBlobs map[string]restic.IDSet
+
+	// inodes is used to count the restore size of hard
+	// links only once
+	inodes map[[2]uint64]struct{}
+
+	// uniqueFiles maps the content of a file to the
+	// size of the file, for files-by-contents
+	uniqueFiles map[string]uint64
+}
+
+func newStatsContainer(repo *repository.Repository, mode string) *statsContainer {
+	return &statsContainer{
+		repo:        repo,
+		mode:        mode,
+		blobs:       restic.NewBlobSet(),
+		fileBlobs:   make(map[string]restic.IDSet),
+		inodes:      make(map[[2]uint64]struct{}),
+		uniqueFiles: make(map[string]uint64),
+	}
+}
+
+// contentKey returns a string which identifies the content of node.
+func contentKey(node *restic.Node) string {
+	key := make([]byte, 0, len(node.Content)*len(restic.ID{}))
+	for _, id := range node.Content {
+		key = append(key, id[:]...)
+	}
+	return string(key)
+}
+
+// walkTree updates the statistics for all nodes within the tree id.
+func (s *statsContainer) walkTree(ctx context.Context, prefix string, id restic.ID) error {
+	if s.mode == countModeRawData {
+		// all blobs referenced by a tree have been counted already when the
+		// tree was seen before
+		h := restic.BlobHandle{ID: id, Type: restic.TreeBlob}
+		if s.blobs.Has(h) {
+			return nil
+		}
+		s.blobs.Insert(h)
+	}
+
+	tree, err := s.repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.Nodes {
+		item := path.Join(prefix, node.Name)
+
+		switch s.mode {
+		case countModeUniqueFilesByContents:
+			if node.Type == "file" {
+				key := contentKey(node)
+				if _, ok := s.uniqueFiles[key]; !ok {
+					s.uniqueFiles[key] = node.Size
+				}
+			}
+
+		case countModeBlobsPerFile:
+			if node.Type == "file" {
+				// only consider files with contents not seen before
+				key := contentKey(node)
+				if _, ok := s.uniqueFiles[key]; ok {
+					break
+				}
+				s.uniqueFiles[key] = node.Size
+
+				// a file is unique by both contents and path, each blob is
+				// counted once per file
+				if _, ok := s.fileBlobs[item]; !ok {
+					s.fileBlobs[item] = restic.NewIDSet()
+					s.TotalFileCount++
+				}
+
+				for _, blobID := range node.Content {
+					if s.fileBlobs[item].Has(blobID) {
+						continue
+					}
+					s.fileBlobs[item].Insert(blobID)
+
+					if err := s.addBlobSize(restic.BlobHandle{ID: blobID, Type: restic.DataBlob}); err != nil {
+						return err
+					}
+				}
+			}
+
+		case countModeRawData:
+			if node.Type == "file" {
+				for _, blobID := range node.Content {
+					h := restic.BlobHandle{ID: blobID, Type: restic.DataBlob}
+					if s.blobs.Has(h) {
+						continue
+					}
+					s.blobs.Insert(h)
+
+					if err := s.addBlobSize(h); err != nil {
+						return err
+					}
+				}
+			}
+
+		case countModeRestoreSize:
+			s.TotalFileCount++
+
+			// hard links are only restored once
+			if node.Links > 1 && node.Type == "file" {
+				inode := [2]uint64{node.Inode, node.DeviceID}
+				if _, ok := s.inodes[inode]; ok {
+					break
+				}
+				s.inodes[inode] = struct{}{}
+			}
+
+			s.TotalSize += node.Size
+		}
+
+		if node.Type == "dir" && node.Subtree != nil {
+			if err := s.walkTree(ctx, item, *node.Subtree); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addBlobSize adds the size of the blob h to the statistics.
+func (s *statsContainer) addBlobSize(h restic.BlobHandle) error {
+	size, err := s.repo.LookupBlobSize(h.ID, h.Type)
+	if err != nil {
+		return errors.Errorf("blob %v not found in the index: %v", h, err)
+	}
+
+	s.TotalSize += uint64(size)
+	s.TotalBlobCount++
+	return nil
+}
+
+// finish computes the final numbers after all snapshots have been walked.
+func (s *statsContainer) finish() error {
+	switch s.mode {
+	case countModeUniqueFilesByContents:
+		for _, size := range s.uniqueFiles {
+			s.TotalSize += size
+		}
+		s.TotalFileCount = uint64(len(s.uniqueFiles))
+
+	case countModeRawData:
+		// add the sizes of the tree blobs, the data blobs have already been
+		// counted while walking the trees
+		for h := range s.blobs {
+			if h.Type != restic.TreeBlob {
+				continue
+			}
+			if err := s.addBlobSize(h); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func runStats(opts StatsOptions, gopts GlobalOptions, args []string) error {
+	switch opts.Mode {
+	case countModeRestoreSize, countModeUniqueFilesByContents, countModeBlobsPerFile, countModeRawData:
+	default:
+		return errors.Fatalf("unknown counting mode: %s (use the -h flag to get a list of supported modes)", opts.Mode)
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !gopts.JSON {
+		Printf("scanning...\n")
+	}
+
+	stats := newStatsContainer(repo, opts.Mode)
+
+	var snapshots int
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
+		if sn.Tree == nil {
+			return errors.Errorf("snapshot %s has nil tree", sn.ID().Str())
+		}
+
+		if err = stats.walkTree(ctx, "/", *sn.Tree); err != nil {
+			return errors.Errorf("walking tree %s: %v", *sn.Tree, err)
+		}
+		snapshots++
+	}
+
+	if err = stats.finish(); err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		err = json.NewEncoder(gopts.stdout).Encode(stats)
+		if err != nil {
+			return errors.Errorf("encoding output: %v", err)
+		}
+		return nil
+	}
+
+	Printf("Stats for %d snapshots in %s mode:\n", snapshots, opts.Mode)
+	if stats.TotalBlobCount > 0 {
+		Printf("  Total Blob Count:   %d\n", stats.TotalBlobCount)
+	}
+	if stats.TotalFileCount > 0 {
+		Printf("  Total File Count:   %d\n", stats.TotalFileCount)
+	}
+	Printf("        Total Size:   %-5s\n", formatBytes(stats.TotalSize))
+
+	return nil
+}
@@ -21,6 +21,10 @@ You can either set/replace the entire set of tags on a snapshot, or
 add tags to/remove tags from the existing set.
 
 When no snapshot-ID is given, all snapshots matching the host, tag and path filter criteria are modified.
+
+Snapshots can be protected with --hold, e.g. for a legal hold. The "forget"
+command never removes a snapshot with a hold, regardless of the policy. The
+hold is removed again with --release.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -36,6 +40,8 @@ type TagOptions struct {
 	SetTags    []string
 	AddTags    []string
 	RemoveTags []string
+	Hold       []string
+	Release    []string
 }
 
 var tagOptions TagOptions
@@ -47,15 +53,24 @@ func init() {
 	tagFlags.StringSliceVar(&tagOptions.SetTags, "set", nil, "`tag` which will replace the existing tags (can be given multiple times)")
 	tagFlags.StringSliceVar(&tagOptions.AddTags, "add", nil, "`tag` which will be added to the existing tags (can be given multiple times)")
 	tagFlags.StringSliceVar(&tagOptions.RemoveTags, "remove", nil, "`tag` which will be removed from the existing tags (can be given multiple times)")
+	tagFlags.StringSliceVar(&tagOptions.Hold, "hold", nil, "protect the snapshots from being removed with hold `name` (can be given multiple times)")
+	tagFlags.StringSliceVar(&tagOptions.Release, "release", nil, "remove the hold `name` from the snapshots (can be given multiple times)")
 
 	tagFlags.StringVarP(&tagOptions.Host, "host", "H", "", "only consider snapshots for this `host`, when no snapshot ID is given")
 	tagFlags.Var(&tagOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
 }
 
-func changeTags(repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+func changeTags(repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags, hold, release []string) (bool, error) {
 	var changed bool
 
+	if sn.AddHolds(hold) {
+		changed = true
+	}
+	if sn.RemoveHolds(release) {
+		changed = true
+	}
+
 	if len(setTags) != 0 {
 		// Setting the tag to an empty string really means no tags.
 		if len(setTags) == 1 && setTags[0] == "" {
@@ -64,7 +79,9 @@ func changeTags(repo *repository.Repository, sn *restic.Snapshot, setTags, addTa
 		sn.Tags = setTags
 		changed = true
 	} else {
-		changed = sn.AddTags(addTags)
+		if sn.AddTags(addTags) {
+			changed = true
+		}
 		if sn.RemoveTags(removeTags) {
 			changed = true
 		}
@@ -100,7 +117,8 @@ func changeTags(repo *repository.Repository, sn *restic.Snapshot, setTags, addTa
 }
 
 func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
-	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 {
+	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 &&
+		len(opts.Hold) == 0 && len(opts.Release) == 0 {
 		return errors.Fatal("nothing to do!")
 	}
 	if len(opts.SetTags) != 0 && (len(opts.AddTags) != 0 || len(opts.RemoveTags) != 0) {
@@ -125,7 +143,7 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Host, opts.Tags, opts.Paths, args) {
-		changed, err := changeTags(repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
+		changed, err := changeTags(repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags, opts.Hold, opts.Release)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
 			continue
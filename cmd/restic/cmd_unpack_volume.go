@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/tape"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdUnpackVolume = &cobra.Command{
+	Use:   "unpack-volume [flags] --target dir volume [volume...]",
+	Short: "Unpack volume files written by the tape backend",
+	Long: `
+The "unpack-volume" command reads volume files written by the tape backend
+sequentially and stores the files of the repository in the directory given
+with --target, which can then be used as a local repository. The volumes are
+read front to back without seeking, so they can be read directly from a tape
+drive. Use "-" to read a volume from stdin.
+
+The volumes must be given in the order in which they were written, so that
+files removed in a later volume are removed from the target as well. Files
+which already exist in the target are skipped, so an interrupted run can be
+continued.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUnpackVolume(unpackVolumeOptions, globalOptions, args)
+	},
+}
+
+// UnpackVolumeOptions bundles all options for the unpack-volume command.
+type UnpackVolumeOptions struct {
+	Target string
+}
+
+var unpackVolumeOptions UnpackVolumeOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdUnpackVolume)
+
+	f := cmdUnpackVolume.Flags()
+	f.StringVarP(&unpackVolumeOptions.Target, "target", "t", "", "directory to unpack the repository to (required)")
+}
+
+// openUnpackTarget opens the local repository in dir, it is created if it
+// does not contain a config file yet.
+func openUnpackTarget(dir string) (restic.Backend, error) {
+	cfg := local.Config{Path: dir}
+
+	if _, err := fs.Stat(filepath.Join(dir, "config")); err == nil {
+		return local.Open(cfg)
+	}
+
+	return local.Create(cfg)
+}
+
+func runUnpackVolume(opts UnpackVolumeOptions, gopts GlobalOptions, args []string) error {
+	if opts.Target == "" {
+		return errors.Fatal("please specify a directory to unpack to (--target)")
+	}
+
+	if len(args) == 0 {
+		return errors.Fatal("no volume files given")
+	}
+
+	be, err := openUnpackTarget(opts.Target)
+	if err != nil {
+		return errors.Fatalf("unable to open target %v: %v", opts.Target, err)
+	}
+
+	var total tape.UnpackStats
+	for _, name := range args {
+		var rd io.ReadCloser = os.Stdin
+		if name != "-" {
+			rd, err = fs.Open(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		Verbosef("unpacking %v\n", name)
+		stats, err := tape.Unpack(gopts.ctx, rd, be)
+		_ = rd.Close()
+		if err != nil {
+			return errors.Fatalf("unpacking %v failed: %v", name, err)
+		}
+
+		Verbosef("  %d files saved, %d skipped, %d removed\n", stats.Saved, stats.Skipped, stats.Removed)
+		total.Saved += stats.Saved
+		total.Skipped += stats.Skipped
+		total.Removed += stats.Removed
+	}
+
+	Printf("unpacked %d volumes to %v: %d files saved, %d skipped, %d removed\n",
+		len(args), opts.Target, total.Saved, total.Skipped, total.Removed)
+	return nil
+}
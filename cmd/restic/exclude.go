@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/restic/restic/internal/debug"
@@ -38,6 +40,75 @@ func rejectByPattern(patterns []string) RejectFunc {
 	}
 }
 
+// rejectByInsensitivePattern is like rejectByPattern, but the patterns are
+// matched case insensitively.
+func rejectByInsensitivePattern(patterns []string) RejectFunc {
+	lowerPatterns := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		lowerPatterns = append(lowerPatterns, strings.ToLower(pattern))
+	}
+
+	reject := rejectByPattern(lowerPatterns)
+	return func(item string, fi os.FileInfo) bool {
+		return reject(strings.ToLower(item), fi)
+	}
+}
+
+// listInclude matches item against the patterns, and case insensitively
+// against the insensitivePatterns, which must be lower case.
+func listInclude(patterns, insensitivePatterns []string, item string) (matched bool, childMayMatch bool) {
+	matched, childMayMatch, err := filter.List(patterns, item)
+	if err != nil {
+		Warnf("error for include pattern: %v", err)
+	}
+
+	if len(insensitivePatterns) > 0 {
+		imatched, ichildMayMatch, err := filter.List(insensitivePatterns, strings.ToLower(item))
+		if err != nil {
+			Warnf("error for include pattern: %v", err)
+		}
+
+		matched = matched || imatched
+		childMayMatch = childMayMatch || ichildMayMatch
+	}
+
+	return matched, childMayMatch
+}
+
+// rejectByInclude returns a RejectFunc which rejects files that do not match
+// any of the include patterns or the case insensitive include patterns. Files
+// in a directory matching a pattern are included, and directories are kept as
+// long as a child may still match one of the patterns.
+func rejectByInclude(patterns, insensitivePatterns []string) RejectFunc {
+	lowerPatterns := make([]string, 0, len(insensitivePatterns))
+	for _, pattern := range insensitivePatterns {
+		lowerPatterns = append(lowerPatterns, strings.ToLower(pattern))
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		for p := item; ; p = filepath.Dir(p) {
+			matched, _ := listInclude(patterns, lowerPatterns, p)
+			if matched {
+				return false
+			}
+
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+
+		if fi != nil && fi.IsDir() {
+			_, childMayMatch := listInclude(patterns, lowerPatterns, item)
+			if childMayMatch {
+				return false
+			}
+		}
+
+		debug.Log("path %q excluded, it does not match any include pattern", item)
+		return true
+	}
+}
+
 // rejectIfPresent returns a RejectFunc which itself returns whether a path
 // should be excluded. The RejectFunc considers a file to be excluded when
 // it resides in a directory with an exclusion file, that is specified by
@@ -179,6 +250,61 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 	}, nil
 }
 
+// rejectBySize returns a RejectFunc that rejects files which are larger than
+// maxSize bytes. Directories are never rejected.
+func rejectBySize(maxSize int64) RejectFunc {
+	return func(item string, fi os.FileInfo) bool {
+		if fi == nil || !fi.Mode().IsRegular() {
+			return false
+		}
+
+		if fi.Size() > maxSize {
+			debug.Log("file %s is oversize: %d", item, fi.Size())
+			return true
+		}
+
+		return false
+	}
+}
+
+// parseSizeStr parses a size like "500M" and returns the number of bytes.
+// The optional suffixes k, m, g and t (case insensitive) denote kibibytes,
+// mebibytes, gibibytes and tebibytes, a number without suffix is in bytes.
+func parseSizeStr(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, errors.New("expected size, got empty string")
+	}
+
+	numStr := sizeStr[:len(sizeStr)-1]
+	var unit int64 = 1
+
+	switch sizeStr[len(sizeStr)-1] {
+	case 'b', 'B':
+		// use initialized values, do nothing here
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	case 't', 'T':
+		unit = 1 << 40
+	default:
+		numStr = sizeStr
+	}
+
+	value, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil || value < 0 {
+		return 0, errors.Errorf("invalid size %q", sizeStr)
+	}
+
+	if value > math.MaxInt64/unit {
+		return 0, errors.Errorf("size %q is too large", sizeStr)
+	}
+
+	return value * unit, nil
+}
+
 // rejectResticCache returns a RejectFunc that rejects the restic cache
 // directory (if set).
 func rejectResticCache(repo *repository.Repository) (RejectFunc, error) {
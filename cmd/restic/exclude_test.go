@@ -2,6 +2,7 @@ package main
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -35,6 +36,33 @@ func TestRejectByPattern(t *testing.T) {
 	}
 }
 
+func TestRejectByInsensitivePattern(t *testing.T) {
+	var tests = []struct {
+		filename string
+		reject   bool
+	}{
+		{filename: "/home/user/foo.GO", reject: true},
+		{filename: "/home/user/foo.c", reject: false},
+		{filename: "/home/user/foobar", reject: false},
+		{filename: "/home/user/FOObar/x", reject: true},
+		{filename: "/home/user/README", reject: false},
+		{filename: "/home/user/readme.md", reject: true},
+	}
+
+	patterns := []string{"*.go", "README.md", "/home/user/foobar/*"}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			reject := rejectByInsensitivePattern(patterns)
+			res := reject(tc.filename, nil)
+			if res != tc.reject {
+				t.Fatalf("wrong result for filename %v: want %v, got %v",
+					tc.filename, tc.reject, res)
+			}
+		})
+	}
+}
+
 func TestIsExcludedByFile(t *testing.T) {
 	const (
 		tagFilename = "CACHEDIR.TAG"
@@ -82,3 +110,136 @@ func TestIsExcludedByFile(t *testing.T) {
 		})
 	}
 }
+
+type dirInfo struct {
+	os.FileInfo
+	dir bool
+}
+
+func (fi dirInfo) IsDir() bool {
+	return fi.dir
+}
+
+func TestRejectByInclude(t *testing.T) {
+	var tests = []struct {
+		filename string
+		dir      bool
+		reject   bool
+	}{
+		{filename: "/home/user/foo.go", reject: false},
+		{filename: "/home/user/foo.c", reject: true},
+		{filename: "/home/user/src", dir: true, reject: false},
+		{filename: "/home/user/src/main.c", reject: false},
+		{filename: "/home/user/src/sub", dir: true, reject: false},
+		{filename: "/home/user/src/sub/x", reject: false},
+		{filename: "/home/user/work", dir: true, reject: true},
+		{filename: "/home/user/work/x.go", reject: true},
+		{filename: "/srv", dir: true, reject: false},
+		{filename: "/srv/data", dir: true, reject: false},
+		{filename: "/srv/data/db", reject: false},
+		{filename: "/srv/www", dir: true, reject: true},
+	}
+
+	patterns := []string{"/home/*/*.go", "/home/user/src", "/srv/data"}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			reject := rejectByInclude(patterns, nil)
+			res := reject(tc.filename, dirInfo{dir: tc.dir})
+			if res != tc.reject {
+				t.Fatalf("wrong result for filename %v: want %v, got %v",
+					tc.filename, tc.reject, res)
+			}
+		})
+	}
+}
+
+func TestRejectByInsensitiveInclude(t *testing.T) {
+	var tests = []struct {
+		filename string
+		dir      bool
+		reject   bool
+	}{
+		{filename: "/home/user/foo.go", reject: false},
+		{filename: "/home/user/FOO.GO", reject: true},
+		{filename: "/home/user/Photo.JPG", reject: false},
+		{filename: "/home/user/photo.txt", reject: true},
+		{filename: "/home/User/Docs", dir: true, reject: false},
+		{filename: "/home/User/Docs/x", reject: false},
+	}
+
+	patterns := []string{"/home/*/*.go"}
+	insensitivePatterns := []string{"*.jpg", "/home/user/DOCS"}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			reject := rejectByInclude(patterns, insensitivePatterns)
+			res := reject(tc.filename, dirInfo{dir: tc.dir})
+			if res != tc.reject {
+				t.Fatalf("wrong result for filename %v: want %v, got %v",
+					tc.filename, tc.reject, res)
+			}
+		})
+	}
+}
+
+func TestParseSizeStr(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected int64
+	}{
+		{"1024", 1024},
+		{"1024b", 1024},
+		{"1024B", 1024},
+		{"1k", 1024},
+		{"100k", 102400},
+		{"100K", 102400},
+		{"10m", 10485760},
+		{"100m", 104857600},
+		{"20G", 21474836480},
+		{"10g", 10737418240},
+		{"2t", 2199023255552},
+		{"2T", 2199023255552},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			actual, err := parseSizeStr(tt.input)
+			test.OK(t, err)
+			test.Equals(t, tt.expected, actual)
+		})
+	}
+
+	for _, input := range []string{"", "k", "-1", "1.5G", "10x", "9999999999999999999", "10000000000T"} {
+		_, err := parseSizeStr(input)
+		test.Assert(t, err != nil, "no error returned for invalid size %q", input)
+	}
+}
+
+func TestRejectBySize(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := map[string]int{
+		"small": 100,
+		"exact": 1024,
+		"large": 1025,
+	}
+
+	for name, size := range files {
+		test.OK(t, ioutil.WriteFile(filepath.Join(tempDir, name), make([]byte, size), 0644))
+	}
+
+	reject := rejectBySize(1024)
+	for name, size := range files {
+		fi, err := os.Lstat(filepath.Join(tempDir, name))
+		test.OK(t, err)
+
+		res := reject(filepath.Join(tempDir, name), fi)
+		test.Equals(t, size > 1024, res)
+	}
+
+	fi, err := os.Lstat(tempDir)
+	test.OK(t, err)
+	test.Assert(t, !reject(tempDir, fi), "directory was rejected")
+}
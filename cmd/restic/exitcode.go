@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Exit codes of restic, they are documented in the manual and must not be
+// changed.
+const (
+	exitCodeOK         = 0
+	exitCodeError      = 1  // any error not covered by the codes below
+	exitCodeIncomplete = 3  // backup: some source files could not be read
+	exitCodeNoRepo     = 10 // the repository could not be opened
+	exitCodeLocked     = 11 // the repository is locked by another process
+	exitCodeNoKey      = 12 // wrong password or no key found
+	exitCodeCorrupt    = 13 // check: the repository contains errors
+
+	exitCodeInterrupted = 130 // restic was interrupted with SIGINT (Ctrl-C)
+)
+
+// exitError is an error which causes restic to exit with a specific code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying error, so that errors.Cause and errors.IsFatal
+// work as for err.
+func (e *exitError) Cause() error {
+	return e.err
+}
+
+// withExitCode returns an error which causes restic to exit with code.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitCodeOK
+	}
+
+	for e := err; e != nil; {
+		if ee, ok := e.(*exitError); ok {
+			return ee.code
+		}
+
+		c, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+
+	switch cause := errors.Cause(err); {
+	case restic.IsAlreadyLocked(cause):
+		return exitCodeLocked
+	case cause == errors.Cause(repository.ErrNoKeyFound):
+		return exitCodeNoKey
+	}
+
+	return exitCodeError
+}
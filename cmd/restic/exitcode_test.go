@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+func TestExitCode(t *testing.T) {
+	var tests = []struct {
+		err  error
+		code int
+	}{
+		{nil, exitCodeOK},
+		{errors.New("foo"), exitCodeError},
+		{errors.Fatal("foo"), exitCodeError},
+		{withExitCode(exitCodeIncomplete, errors.Fatal("foo")), exitCodeIncomplete},
+		{errors.Wrap(withExitCode(exitCodeNoRepo, errors.Fatalf("foo")), "bar"), exitCodeNoRepo},
+		{restic.ErrAlreadyLocked{}, exitCodeLocked},
+		{errors.Wrap(restic.ErrAlreadyLocked{}, "Lock"), exitCodeLocked},
+		{repository.ErrNoKeyFound, exitCodeNoKey},
+	}
+
+	for _, test := range tests {
+		code := exitCode(test.err)
+		if code != test.code {
+			t.Errorf("exitCode(%v) = %d, want %d", test.err, code, test.code)
+		}
+	}
+}
+
+func TestExitErrorFatal(t *testing.T) {
+	err := withExitCode(exitCodeCorrupt, errors.Fatal("repository contains errors"))
+	if !errors.IsFatal(errors.Cause(err)) {
+		t.Errorf("error %v is not fatal", err)
+	}
+
+	if err.Error() != "Fatal: repository contains errors" {
+		t.Errorf("wrong message %q", err.Error())
+	}
+}
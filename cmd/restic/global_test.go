@@ -0,0 +1,49 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/restic/restic/internal/options"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestResolvePasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("echo is not available as a program on Windows")
+	}
+
+	opts := GlobalOptions{PasswordCommand: "echo 'secret password'"}
+	pwd, err := resolvePassword(opts, "RESTIC_PASSWORD_TEST_UNSET")
+	rtest.OK(t, err)
+	rtest.Equals(t, "secret password", pwd)
+
+	opts.PasswordCommand = "false"
+	_, err = resolvePassword(opts, "RESTIC_PASSWORD_TEST_UNSET")
+	rtest.Assert(t, err != nil, "expected an error for a failing password command")
+
+	opts.PasswordCommand = "echo foo"
+	opts.PasswordFile = "/does/not/exist"
+	_, err = resolvePassword(opts, "RESTIC_PASSWORD_TEST_UNSET")
+	rtest.Assert(t, err != nil, "expected an error when both password file and command are set")
+
+	opts.PasswordFile = ""
+	opts.PasswordKeychain = true
+	_, err = resolvePassword(opts, "RESTIC_PASSWORD_TEST_UNSET")
+	rtest.Assert(t, err != nil, "expected an error when both password keychain and command are set")
+}
+
+func TestApplyPackUploaders(t *testing.T) {
+	opts := applyPackUploaders("s3", 12, options.Options{"s3.region": "foo"})
+	rtest.Equals(t, options.Options{"s3.region": "foo", "s3.connections": "12"}, opts)
+
+	opts = applyPackUploaders("s3", 12, options.Options{"s3.connections": "3"})
+	rtest.Equals(t, options.Options{"s3.connections": "3"}, opts)
+
+	// the local backend has no connection limit
+	opts = applyPackUploaders("local", 12, options.Options{})
+	rtest.Equals(t, options.Options{}, opts)
+
+	opts = applyPackUploaders("s3", 0, options.Options{})
+	rtest.Equals(t, options.Options{}, opts)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// hookCommand is the name of the command for which the pre hook has been
+// run, the post hook is only run when it is set.
+var hookCommand string
+
+// runHook runs the shell command for the hook with the environment of restic
+// and the additional variables in env.
+func runHook(kind, command string, env []string) error {
+	debug.Log("run %v hook %q, env %v", kind, command, env)
+
+	name, args, err := backend.SplitShellArgs(command)
+	if err != nil {
+		return errors.Fatalf("invalid %s hook: %v", kind, err)
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = globalOptions.stdout
+	cmd.Stderr = globalOptions.stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Fatalf("%s hook %q failed: %v", kind, command, err)
+	}
+
+	return nil
+}
+
+// runPreHook runs the hook given with --pre-hook before the command is run,
+// in particular before the repository is locked. The name of the command is
+// available in $RESTIC_COMMAND.
+func runPreHook(gopts GlobalOptions, command string) error {
+	hookCommand = command
+	if gopts.PreHook == "" {
+		return nil
+	}
+
+	logEvent("pre_hook", logFields{"hook": gopts.PreHook})
+	return runHook("pre", gopts.PreHook, []string{
+		"RESTIC_COMMAND=" + command,
+	})
+}
+
+// runPostHook runs the hook given with --post-hook after the command has
+// finished, also when it failed. In addition to $RESTIC_COMMAND, the status
+// ("success" or "failure") is available in $RESTIC_STATUS and the error in
+// $RESTIC_ERROR.
+func runPostHook(gopts GlobalOptions, cmdErr error) error {
+	if gopts.PostHook == "" || hookCommand == "" {
+		return nil
+	}
+
+	status, msg := "success", ""
+	if cmdErr != nil {
+		status, msg = "failure", cmdErr.Error()
+	}
+
+	logEvent("post_hook", logFields{"hook": gopts.PostHook, "status": status})
+	return runHook("post", gopts.PostHook, []string{
+		"RESTIC_COMMAND=" + hookCommand,
+		"RESTIC_STATUS=" + status,
+		"RESTIC_ERROR=" + msg,
+	})
+}
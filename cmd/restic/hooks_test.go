@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with sh in this test")
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	defer func() {
+		hookCommand = ""
+	}()
+
+	output := filepath.Join(tempdir, "output")
+	gopts := GlobalOptions{
+		PreHook:  "sh -c 'echo pre $RESTIC_COMMAND > " + output + "'",
+		PostHook: "sh -c 'echo post $RESTIC_COMMAND $RESTIC_STATUS $RESTIC_ERROR >> " + output + "'",
+	}
+
+	rtest.OK(t, runPreHook(gopts, "backup"))
+	rtest.OK(t, runPostHook(gopts, errors.New("failed")))
+
+	buf, err := ioutil.ReadFile(output)
+	rtest.OK(t, err)
+	rtest.Equals(t, "pre backup\npost backup failure failed\n", string(buf))
+
+	gopts.PreHook = "sh -c 'exit 1'"
+	rtest.Assert(t, runPreHook(gopts, "backup") != nil, "expected an error for a failing pre hook")
+}
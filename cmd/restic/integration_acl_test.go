@@ -0,0 +1,86 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// POSIX ACLs are stored by Linux in the extended attributes
+// system.posix_acl_access and system.posix_acl_default.
+const (
+	aclAccess  = "system.posix_acl_access"
+	aclDefault = "system.posix_acl_default"
+
+	aclVersion  = 2
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclMask     = 0x10
+	aclOther    = 0x20
+	aclUndefID  = 0xffffffff
+)
+
+// buildACL returns the binary representation of an ACL which grants the user
+// uid read access in addition to the permissions of the owner.
+func buildACL(uid uint32) []byte {
+	entries := []struct {
+		Tag  uint16
+		Perm uint16
+		ID   uint32
+	}{
+		{aclUserObj, 6, aclUndefID},
+		{aclUser, 4, uid},
+		{aclGroupObj, 4, aclUndefID},
+		{aclMask, 4, aclUndefID},
+		{aclOther, 0, aclUndefID},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(aclVersion))
+	_ = binary.Write(buf, binary.LittleEndian, entries)
+	return buf.Bytes()
+}
+
+func TestRestorePOSIXACL(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dir := filepath.Join(env.testdata, "shared")
+	file := filepath.Join(dir, "file")
+	rtest.OK(t, os.MkdirAll(dir, 0755))
+	rtest.OK(t, appendRandomData(file, 200))
+
+	acl := buildACL(uint32(os.Getuid()))
+	if err := restic.Setxattr(file, aclAccess, acl); err != nil {
+		t.Skipf("unable to set ACL: %v", err)
+	}
+	if v, err := restic.Getxattr(file, aclAccess); err != nil || v == nil {
+		t.Skip("POSIX ACLs are not supported")
+	}
+	rtest.OK(t, restic.Setxattr(dir, aclDefault, acl))
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	target := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, target, snapshotID)
+
+	v, err := restic.Getxattr(filepath.Join(target, "testdata", "shared", "file"), aclAccess)
+	rtest.OK(t, err)
+	rtest.Equals(t, acl, v)
+
+	v, err = restic.Getxattr(filepath.Join(target, "testdata", "shared"), aclDefault)
+	rtest.OK(t, err)
+	rtest.Equals(t, acl, v)
+}
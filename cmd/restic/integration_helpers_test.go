@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/repository"
@@ -220,3 +227,75 @@ func withTestEnvironment(t testing.TB) (env *testEnvironment, cleanup func()) {
 
 	return env, cleanup
 }
+
+// withAppendOnlyRESTServer serves the local repository in dir via the REST
+// protocol like the REST server started with --append-only: files cannot be
+// overwritten and only lock files can be removed. It returns the location of
+// the repository.
+func withAppendOnlyRESTServer(t testing.TB, dir string) (repo string, cleanup func()) {
+	filename := func(urlPath string) string {
+		parts := strings.Split(strings.Trim(path.Clean(urlPath), "/"), "/")
+		if len(parts) == 2 && parts[0] == "data" && len(parts[1]) > 2 {
+			parts = []string{"data", parts[1][:2], parts[1]}
+		}
+		return filepath.Join(append([]string{dir}, parts...)...)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filename(r.URL.Path)
+
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/"):
+			names := []string{}
+			err := filepath.Walk(name, func(p string, fi os.FileInfo, err error) error {
+				if err == nil && fi.Mode().IsRegular() {
+					names = append(names, fi.Name())
+				}
+				return nil
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(names)
+
+		case r.Method == "GET" || r.Method == "HEAD":
+			f, err := os.Open(name)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			defer f.Close()
+			http.ServeContent(w, r, "", time.Time{}, f)
+
+		case r.Method == "POST":
+			_ = os.MkdirAll(filepath.Dir(name), 0700)
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, err = io.Copy(f, r.Body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+		case r.Method == "DELETE":
+			if !strings.HasPrefix(r.URL.Path, "/locks/") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if err := os.Remove(name); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	return "rest:" + srv.URL + "/", srv.Close
+}
@@ -0,0 +1,62 @@
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestoreSparse(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rnd := rand.New(rand.NewSource(23))
+	randomData := func(n int) []byte {
+		buf := make([]byte, n)
+		_, _ = rnd.Read(buf)
+		return buf
+	}
+
+	// the file ends with a large block of zeroes
+	var data []byte
+	data = append(data, randomData(1<<20)...)
+	data = append(data, make([]byte, 32<<20)...)
+	data = append(data, randomData(1<<20)...)
+	data = append(data, make([]byte, 32<<20)...)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "sparse"), data, 0644))
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	target := filepath.Join(env.base, "restore")
+	opts := RestoreOptions{
+		Target: target,
+		Sparse: true,
+	}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotID.String()}))
+
+	filename := filepath.Join(target, "testdata", "sparse")
+	restored, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, restored), "restored sparse file has different content")
+
+	fi, err := os.Stat(filename)
+	rtest.OK(t, err)
+
+	allocated := fi.Sys().(*syscall.Stat_t).Blocks * 512
+	t.Logf("size %d, allocated %d bytes", fi.Size(), allocated)
+	rtest.Assert(t, allocated < fi.Size()/2,
+		"restored file is not sparse, %d bytes allocated for %d bytes of data", allocated, fi.Size())
+}
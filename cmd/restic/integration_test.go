@@ -1,17 +1,22 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	mrand "math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"syscall"
 	"testing"
@@ -46,7 +51,7 @@ func testRunInit(t testing.TB, opts GlobalOptions) {
 	repository.TestUseLowSecurityKDFParameters(t)
 	restic.TestSetLockTimeout(t, 0)
 
-	rtest.OK(t, runInit(opts, nil))
+	rtest.OK(t, runInit(InitOptions{}, opts, nil))
 	t.Logf("repository initialized at %v", opts.Repo)
 }
 
@@ -131,6 +136,21 @@ func testRunRebuildIndex(t testing.TB, gopts GlobalOptions) {
 	rtest.OK(t, runRebuildIndex(gopts))
 }
 
+func testRunLsRecursive(t testing.TB, gopts GlobalOptions, snapshotID string) []string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	quiet := globalOptions.Quiet
+	globalOptions.Quiet = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.Quiet = quiet
+	}()
+
+	rtest.OK(t, runLs(LsOptions{Recursive: true}, gopts, []string{snapshotID}))
+
+	return strings.Split(string(buf.Bytes()), "\n")
+}
+
 func testRunLs(t testing.TB, gopts GlobalOptions, snapshotID string) []string {
 	buf := bytes.NewBuffer(nil)
 	globalOptions.stdout = buf
@@ -196,7 +216,70 @@ func testRunForget(t testing.TB, gopts GlobalOptions, args ...string) {
 }
 
 func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+	rtest.OK(t, runPrune(PruneOptions{}, gopts))
+}
+
+func TestBackupHashAlgorithms(t *testing.T) {
+	for _, hash := range []restic.HashAlgorithm{restic.HashSHA512_256, restic.HashBLAKE2b, restic.HashBLAKE3} {
+		t.Run(string(hash), func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+
+			datafile := filepath.Join("testdata", "backup-data.tar.gz")
+			repository.TestUseLowSecurityKDFParameters(t)
+			restic.TestSetLockTimeout(t, 0)
+			rtest.OK(t, runInit(InitOptions{Hash: string(hash)}, env.gopts, nil))
+
+			rtest.SetupTarTestFixture(t, env.testdata, datafile)
+			testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+			// the algorithm is read from the config when the repository is opened
+			repo, err := OpenRepository(env.gopts)
+			rtest.OK(t, err)
+			rtest.Equals(t, hash, repo.Config().Hash)
+
+			testRunCheck(t, env.gopts)
+
+			snapshotIDs := testRunList(t, "snapshots", env.gopts)
+			rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+			restoredir := filepath.Join(env.base, "restore")
+			testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+			rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+				"directories are not equal")
+		})
+	}
+}
+
+func TestBackupMaxRepoSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	gopts := env.gopts
+	gopts.MaxRepoSize = "1K"
+	testRunInit(t, gopts)
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// the quota is exceeded by the first pack file of the backup
+	err := runBackup(BackupOptions{}, env.gopts, []string{env.testdata})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "quota exceeded"),
+		"backup exceeding the quota did not fail, err %v", err)
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 0,
+		"snapshot saved although the quota was exceeded")
+
+	// the size stored in the config can be overridden
+	gopts.MaxRepoSize = "1G"
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, gopts)
+
+	err = runBackup(BackupOptions{}, env.gopts, []string{env.testdata})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "quota exceeded"),
+		"backup exceeding the quota did not fail, err %v", err)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
 }
 
 func TestBackup(t *testing.T) {
@@ -427,7 +510,11 @@ func TestBackupChangedFile(t *testing.T) {
 
 	opts := BackupOptions{}
 
-	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	// the snapshot is saved, but the changed file is reported as an error
+	err = runBackup(opts, env.gopts, []string{env.testdata})
+	rtest.Assert(t, exitCode(err) == exitCodeIncomplete,
+		"expected exit code %d for changed file, got %d (%v)", exitCodeIncomplete, exitCode(err), err)
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 1, "snapshot has not been saved")
 	testRunCheck(t, env.gopts)
 
 	rtest.Assert(t, ranHook, "hook did not run")
@@ -528,6 +615,103 @@ var backupExcludeFilenames = []string{
 	"work/source/test.c",
 }
 
+func TestBackupReadConcurrency(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for i := 0; i < 5; i++ {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, fmt.Sprintf("file%d", i)), 200000))
+	}
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{ReadConcurrency: 1}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, filepath.Base(env.testdata))),
+		"directories are not equal")
+}
+
+func TestBackupDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for _, name := range []string{"file1", "file2"} {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, name), 2000))
+	}
+
+	runDryRun := func() string {
+		buf := bytes.NewBuffer(nil)
+		globalOptions.stdout = buf
+		defer func() {
+			globalOptions.stdout = os.Stdout
+		}()
+
+		testRunBackup(t, []string{env.testdata}, BackupOptions{DryRun: true}, env.gopts)
+		return buf.String()
+	}
+
+	out := runDryRun()
+	rtest.Assert(t, strings.Contains(out, "would add new      "+filepath.Join(env.testdata, "file1")),
+		"new file missing in output: %q", out)
+	rtest.Assert(t, strings.Contains(out, "would add 2 new and 0 modified files"),
+		"summary missing in output: %q", out)
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 0,
+		"dry run saved a snapshot")
+	rtest.Assert(t, len(testRunList(t, "packs", env.gopts)) == 0,
+		"dry run saved data")
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file2"), 2000))
+
+	out = runDryRun()
+	rtest.Assert(t, strings.Contains(out, "would add modified "+filepath.Join(env.testdata, "file2")),
+		"modified file missing in output: %q", out)
+	rtest.Assert(t, !strings.Contains(out, "file1"),
+		"unmodified file in output: %q", out)
+	rtest.Assert(t, strings.Contains(out, "would add 0 new and 1 modified files"),
+		"summary missing in output: %q", out)
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 1,
+		"dry run saved a snapshot")
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupFilesFromRaw(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	names := []string{"file with spaces ", "file\nwith newline", "unlisted"}
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for _, name := range names {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, name), 100))
+	}
+
+	// only save the first two files
+	list := filepath.Join(env.base, "files")
+	data := filepath.Join(env.testdata, names[0]) + "\x00" + filepath.Join(env.testdata, names[1]) + "\x00"
+	rtest.OK(t, ioutil.WriteFile(list, []byte(data), 0644))
+
+	testRunBackup(t, nil, BackupOptions{FilesFromRaw: list}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	// names may contain newlines, so search the complete output
+	out := strings.Join(testRunLsRecursive(t, env.gopts, snapshotIDs[0].String()), "\n")
+	for i, name := range names {
+		found := strings.Contains(out, string(filepath.Separator)+name+"\n")
+		rtest.Assert(t, found == (i < 2), "file %q: found %v in snapshot, output %q", name, found, out)
+	}
+}
+
 func TestBackupExclude(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -741,6 +925,35 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func TestForgetHold(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunTag(t, TagOptions{Hold: []string{"legal"}}, env.gopts)
+	held := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(held) == 1, "expected one snapshot, got %v", held)
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	err := runForget(ForgetOptions{}, env.gopts, []string{held[0].String()})
+	rtest.Assert(t, err != nil, "removing a held snapshot did not return an error")
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 2, "held snapshot was removed")
+
+	rtest.OK(t, runForget(ForgetOptions{Last: 1}, env.gopts, nil))
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 2, "held snapshot was removed by policy")
+
+	testRunTag(t, TagOptions{Release: []string{"legal"}}, env.gopts)
+	rtest.OK(t, runForget(ForgetOptions{Last: 1}, env.gopts, nil))
+	rtest.Assert(t, len(testRunList(t, "snapshots", env.gopts)) == 1, "released snapshot was not removed")
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -749,7 +962,7 @@ func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 		globalOptions.stdout = os.Stdout
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"list"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"list"}))
 
 	scanner := bufio.NewScanner(buf)
 	exp := regexp.MustCompile(`^ ([a-f0-9]+) `)
@@ -770,7 +983,7 @@ func testRunKeyAddNewKey(t testing.TB, newPassword string, gopts GlobalOptions)
 		testKeyNewPassword = ""
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"add"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"add"}))
 }
 
 func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
@@ -779,13 +992,13 @@ func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
 		testKeyNewPassword = ""
 	}()
 
-	rtest.OK(t, runKey(gopts, []string{"passwd"}))
+	rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"passwd"}))
 }
 
 func testRunKeyRemove(t testing.TB, gopts GlobalOptions, IDs []string) {
 	t.Logf("remove %d keys: %q\n", len(IDs), IDs)
 	for _, id := range IDs {
-		rtest.OK(t, runKey(gopts, []string{"remove", id}))
+		rtest.OK(t, runKey(KeyOptions{}, gopts, []string{"remove", id}))
 	}
 }
 
@@ -813,10 +1026,280 @@ func TestKeyAddRemove(t *testing.T) {
 
 	env.gopts.password = passwordList[len(passwordList)-1]
 	t.Logf("testing access with last password %q\n", env.gopts.password)
-	rtest.OK(t, runKey(env.gopts, []string{"list"}))
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"list"}))
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	testKeyNewPassword = "append-only"
+	rtest.OK(t, runKey(KeyOptions{AppendOnly: true}, env.gopts, []string{"add"}))
+	testKeyNewPassword = ""
+
+	gopts := env.gopts
+	gopts.password = "append-only"
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	// the key cannot be used if the storage allows removing files
+	err := runBackup(opts, gopts, []string{env.testdata})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "append-only"),
+		"backup with an append-only key to a local repository succeeded, err %v", err)
+
+	repo, cleanupServer := withAppendOnlyRESTServer(t, env.repo)
+	defer cleanupServer()
+	gopts.Repo = repo
+
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+	snapshotIDs := testRunList(t, "snapshots", gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2,
+		"expected two snapshots, got %v", snapshotIDs)
+
+	rtest.Assert(t, runForget(ForgetOptions{Last: 1}, gopts, nil) != nil,
+		"forget with an append-only key succeeded")
+	rtest.Assert(t, runPrune(PruneOptions{}, gopts) != nil,
+		"prune with an append-only key succeeded")
+	rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"passwd"}) != nil,
+		"passwd with an append-only key succeeded")
+	for _, id := range testRunKeyListOtherIDs(t, gopts) {
+		rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"remove", id}) != nil,
+			"removing a key with an append-only key succeeded")
+	}
+
+	testRunCheck(t, gopts)
+
+	// the regular key can still remove data
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyWriteOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+
+	testKeyNewPassword = "write-only"
+	rtest.OK(t, runKey(KeyOptions{WriteOnly: true}, env.gopts, []string{"add"}))
+	testKeyNewPassword = ""
+
+	gopts := env.gopts
+	gopts.password = "write-only"
+
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+	snapshotIDs := testRunList(t, "snapshots", gopts)
+	rtest.Assert(t, len(snapshotIDs) == 3,
+		"expected three snapshots, got %v", snapshotIDs)
+
+	rtest.Assert(t, runCheck(CheckOptions{}, gopts, nil) != nil,
+		"check with a write-only key succeeded")
+	rtest.Assert(t, runForget(ForgetOptions{Last: 1}, gopts, nil) != nil,
+		"forget with a write-only key succeeded")
+	rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"add"}) != nil,
+		"adding a key with a write-only key succeeded")
+	rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"passwd"}) != nil,
+		"passwd with a write-only key succeeded")
+
+	// the data saved with the write-only key can be read with the regular key
+	testRunCheck(t, env.gopts)
+	for i, id := range snapshotIDs {
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env.gopts, restoredir, id)
+		rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+			"directories are not equal")
+	}
+
+	// every time the repository is opened with the write-only key, a session
+	// key is saved
+	sessionFiles := func() (n int) {
+		rtest.OK(t, filepath.Walk(filepath.Join(env.repo, "sessions"), func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				n++
+			}
+			return err
+		}))
+		return n
+	}
+	n := sessionFiles()
+	rtest.Assert(t, n > 3, "expected a session key for every backup, found %d", n)
+
+	testRunForget(t, env.gopts, snapshotIDs[0].String(), snapshotIDs[1].String())
+	testRunPrune(t, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// prune saves the session keys to a single file
+	rtest.Equals(t, 1, sessionFiles())
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+	rtest.Equals(t, 2, sessionFiles())
+
+	testRunCheck(t, env.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	for i, id := range snapshotIDs {
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore-pruned%d", i))
+		testRunRestore(t, env.gopts, restoredir, id)
+		rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+			"directories are not equal")
+	}
+}
+
+func TestKeyWriteOnlyLocks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testKeyNewPassword = "write-only"
+	rtest.OK(t, runKey(KeyOptions{WriteOnly: true}, env.gopts, []string{"add"}))
+	testKeyNewPassword = ""
+
+	gopts := env.gopts
+	gopts.password = "write-only"
+
+	// the lock is encrypted with the master key, because the repository has
+	// not been used with the write-only key yet
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	lock, err := lockRepoExclusive(repo)
+	rtest.OK(t, err)
+
+	err = runBackup(opts, gopts, []string{env.testdata})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "unable to decrypt lock"),
+		"backup with a write-only key ignored a lock it cannot decrypt, err %v", err)
+	unlockRepo(lock)
+
+	// now the lock is shared with clients using write-only keys
+	repo, err = OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	lock, err = lockRepoExclusive(repo)
+	rtest.OK(t, err)
+
+	err = runBackup(opts, gopts, []string{env.testdata})
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"backup with a write-only key ignored an exclusive lock, err %v", err)
+	unlockRepo(lock)
+
+	// the lock of the write-only key can be read with the regular key
+	woRepo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	lock, err = lockRepo(woRepo)
+	rtest.OK(t, err)
+
+	repo, err = OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	_, err = lockRepoExclusive(repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"exclusive lock ignored the lock of the write-only key, err %v", err)
+	unlockRepo(lock)
+
+	testRunBackup(t, []string{env.testdata}, opts, gopts)
+}
+
+func TestKeyReadOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+
+	testKeyNewPassword = "read-only"
+	rtest.OK(t, runKey(KeyOptions{ReadOnly: true, Label: "auditor"}, env.gopts, []string{"add"}))
+	testKeyNewPassword = ""
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"list"}))
+	globalOptions.stdout = os.Stdout
+	rtest.Assert(t, regexp.MustCompile(`read-only\s+auditor`).MatchString(buf.String()),
+		"role and label not listed:\n%s", buf.String())
+
+	gopts := env.gopts
+	gopts.password = "read-only"
+
+	snapshotIDs := testRunList(t, "snapshots", gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1,
+		"expected one snapshot, got %v", snapshotIDs)
+
+	testRunCheck(t, gopts)
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, gopts, restoredir, snapshotIDs[0])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+
+	rtest.Assert(t, runBackup(opts, gopts, []string{env.testdata}) != nil,
+		"backup with a read-only key succeeded")
+	rtest.Assert(t, runForget(ForgetOptions{Last: 1}, gopts, nil) != nil,
+		"forget with a read-only key succeeded")
+	rtest.Assert(t, runPrune(PruneOptions{}, gopts) != nil,
+		"prune with a read-only key succeeded")
+	rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"add"}) != nil,
+		"adding a key with a read-only key succeeded")
+	rtest.Assert(t, runKey(KeyOptions{}, gopts, []string{"passwd"}) != nil,
+		"passwd with a read-only key succeeded")
+
 	testRunCheck(t, env.gopts)
 }
 
+func TestKeySplit(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	err := runKey(KeyOptions{Shares: 5, Threshold: 3}, env.gopts, []string{"split"})
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+
+	var files []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if !strings.HasPrefix(line, sharePrefix) {
+			continue
+		}
+		filename := filepath.Join(env.base, fmt.Sprintf("share%d", len(files)))
+		rtest.OK(t, ioutil.WriteFile(filename, []byte(line+"\n"), 0600))
+		files = append(files, filename)
+	}
+	rtest.Equals(t, 5, len(files))
+
+	testKeyNewPassword = "combined"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	rtest.Assert(t, runKey(KeyOptions{}, env.gopts, []string{"combine", files[0], files[3]}) != nil,
+		"combining two shares succeeded")
+	rtest.OK(t, runKey(KeyOptions{}, env.gopts, []string{"combine", files[4], files[1], files[2]}))
+
+	gopts := env.gopts
+	gopts.password = "combined"
+	testRunCheck(t, gopts)
+}
+
 func testFileSize(filename string, size int64) error {
 	fi, err := os.Stat(filename)
 	if err != nil {
@@ -905,31 +1388,254 @@ func TestRestore(t *testing.T) {
 		"directories are not equal")
 }
 
-func TestRestoreLatest(t *testing.T) {
+func TestRestoreDuplicateContent(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
 	testRunInit(t, env.gopts)
 
-	p := filepath.Join(env.testdata, "testfile.c")
-	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
-	rtest.OK(t, appendRandomData(p, 100))
+	// the files share most of their blobs, and the second file contains the
+	// same data twice
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	data := make([]byte, 6<<20)
+	_, err := io.ReadFull(rand.Reader, data)
+	rtest.OK(t, err)
 
-	opts := BackupOptions{}
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file1"), data, 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file2"), append(data, data...), 0644))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "empty"), nil, 0644))
 
-	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
 	testRunCheck(t, env.gopts)
 
-	os.Remove(p)
-	rtest.OK(t, appendRandomData(p, 101))
-	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
-	testRunCheck(t, env.gopts)
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, filepath.Base(env.testdata))),
+		"directories are not equal")
+}
 
-	// Restore latest without any filters
-	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore0"), nil, "")
-	rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", "testfile.c"), int64(101)))
+func TestRestoreDelta(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
 
-	// Setup test files in different directories backed up in different snapshots
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	for _, name := range []string{"file1", "file2", "file3"} {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, name), 3<<20))
+	}
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	target := filepath.Join(restoredir, filepath.Base(env.testdata))
+
+	// modify the restored files: change data in the middle of the first one,
+	// make the second one larger and the third one smaller
+	f, err := os.OpenFile(filepath.Join(target, "file1"), os.O_WRONLY, 0)
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte("modified"), 1<<20)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	rtest.OK(t, appendRandomData(filepath.Join(target, "file2"), 1000))
+	rtest.OK(t, os.Truncate(filepath.Join(target, "file3"), 1<<20))
+
+	opts := RestoreOptions{Target: restoredir, Delta: true, Verify: true}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+	rtest.Assert(t, directoriesEqualContents(env.testdata, target),
+		"directories are not equal")
+}
+
+func TestRestoreOverwrite(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "file"), []byte("snapshot data"), 0644))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	filename := filepath.Join(restoredir, filepath.Base(env.testdata), "file")
+
+	var tests = []struct {
+		mode     string
+		modTime  time.Time
+		expected string
+	}{
+		{"never", time.Now().Add(-time.Hour), "local data"},
+		{"if-newer", time.Now().Add(time.Hour), "local data"},
+		{"if-newer", time.Unix(0, 0), "snapshot data"},
+		{"if-changed", time.Now().Add(time.Hour), "snapshot data"},
+		{"always", time.Now().Add(time.Hour), "snapshot data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+			rtest.OK(t, ioutil.WriteFile(filename, []byte("local data"), 0644))
+			rtest.OK(t, os.Chtimes(filename, test.modTime, test.modTime))
+
+			opts := RestoreOptions{Target: restoredir, Overwrite: test.mode}
+			rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+			buf, err := ioutil.ReadFile(filename)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.expected, string(buf))
+		})
+	}
+
+	// a file which has not been modified is kept with if-changed
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	opts := RestoreOptions{Target: restoredir, Overwrite: "if-changed"}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+	opts.Overwrite = "sometimes"
+	rtest.Assert(t, runRestore(opts, env.gopts, []string{"latest"}) != nil,
+		"expected an error for an invalid overwrite mode")
+}
+
+func TestRestoreDelete(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, "subdir"), 0755))
+	for _, name := range []string{"file1", "subdir/file2"} {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, name), 1000))
+	}
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, "")
+	target := filepath.Join(restoredir, filepath.Base(env.testdata))
+
+	extra := []string{"extra1", "subdir/extra2", "extradir/extra3"}
+	rtest.OK(t, os.MkdirAll(filepath.Join(target, "extradir"), 0755))
+	for _, name := range extra {
+		rtest.OK(t, appendRandomData(filepath.Join(target, name), 100))
+	}
+
+	// only extraneous files within the included paths are removed
+	opts := RestoreOptions{
+		Target:  restoredir,
+		Include: []string{filepath.Join("/", filepath.Base(env.testdata), "subdir")},
+		Delete:  true,
+	}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+	_, err := os.Stat(filepath.Join(target, "subdir", "extra2"))
+	rtest.Assert(t, os.IsNotExist(err), "extraneous file in included dir was not removed")
+	_, err = os.Stat(filepath.Join(target, "extra1"))
+	rtest.OK(t, err)
+
+	opts = RestoreOptions{Target: restoredir, Delete: true}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+	rtest.Assert(t, directoriesEqualContents(env.testdata, target),
+		"directories are not equal")
+	_, err = os.Stat(filepath.Join(target, "extradir"))
+	rtest.Assert(t, os.IsNotExist(err), "extraneous dir was not removed")
+}
+
+func TestRestoreNoPerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not restored on Windows")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	filename := filepath.Join(env.testdata, "readonly")
+	rtest.OK(t, appendRandomData(filename, 1000))
+	rtest.OK(t, os.Chmod(filename, 0400))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	opts := RestoreOptions{Target: restoredir, NoOwner: true, NoPerms: true}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{"latest"}))
+
+	fi, err := os.Stat(filepath.Join(restoredir, filepath.Base(env.testdata), "readonly"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.Mode().Perm()&0200 != 0,
+		"file mode %v from the snapshot was restored", fi.Mode())
+}
+
+func TestRestoreProgress(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	var size uint64
+	for i := 0; i < 5; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("foo/bar/testfile%v", i))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		n := uint(mrand.Intn(1 << 20))
+		rtest.OK(t, appendRandomData(p, n))
+		size += uint64(n)
+	}
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	res, err := restic.NewRestorer(repo, snapshotIDs[0])
+	rtest.OK(t, err)
+
+	restoredir := filepath.Join(env.base, "restore")
+	todo, err := res.Scan(context.TODO(), restoredir)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint64(5), todo.Files)
+	rtest.Equals(t, size, todo.Bytes)
+
+	var done restic.Stat
+	res.Progress = restic.NewProgress()
+	res.Progress.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
+		done = s
+	}
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), restoredir))
+	rtest.Equals(t, todo, done)
+}
+
+func TestRestoreLatest(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	p := filepath.Join(env.testdata, "testfile.c")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+	rtest.OK(t, appendRandomData(p, 100))
+
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	os.Remove(p)
+	rtest.OK(t, appendRandomData(p, 101))
+	testRunBackup(t, []string{env.testdata}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// Restore latest without any filters
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore0"), nil, "")
+	rtest.OK(t, testFileSize(filepath.Join(env.base, "restore0", "testdata", "testfile.c"), int64(101)))
+
+	// Setup test files in different directories backed up in different snapshots
 	p1 := filepath.Join(env.testdata, "p1/testfile.c")
 	rtest.OK(t, os.MkdirAll(filepath.Dir(p1), 0755))
 	rtest.OK(t, appendRandomData(p1, 102))
@@ -1040,6 +1746,47 @@ func TestRestoreNoMetadataOnIgnoredIntermediateDirs(t *testing.T) {
 		"meta data of intermediate directory hasn't been restore")
 }
 
+func TestRestoreExtendedAttributes(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	p := filepath.Join(env.testdata, "file")
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, appendRandomData(p, 200))
+
+	rtest.OK(t, restic.Setxattr(p, "user.restic", []byte("test value")))
+	if v, err := restic.Getxattr(p, "user.restic"); err != nil || v == nil {
+		t.Skip("extended attributes are not supported")
+	}
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore0"), snapshotID)
+	v, err := restic.Getxattr(filepath.Join(env.base, "restore0", "testdata", "file"), "user.restic")
+	rtest.OK(t, err)
+	rtest.Equals(t, "test value", string(v))
+
+	opts := RestoreOptions{
+		Target:   filepath.Join(env.base, "restore1"),
+		NoXattrs: true,
+	}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotID.String()}))
+	v, err = restic.Getxattr(filepath.Join(env.base, "restore1", "testdata", "file"), "user.restic")
+	rtest.Assert(t, err != nil || v == nil, "extended attribute restored with --no-xattrs")
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{NoXattrs: true}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+
+	testRunRestoreLatest(t, env.gopts, filepath.Join(env.base, "restore2"), nil, "")
+	v, err = restic.Getxattr(filepath.Join(env.base, "restore2", "testdata", "file"), "user.restic")
+	rtest.Assert(t, err != nil || v == nil, "extended attribute saved with --no-xattrs")
+}
+
 func TestFind(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1065,6 +1812,375 @@ func TestFind(t *testing.T) {
 	rtest.Assert(t, len(lines) == 4, "expected three files found in repo (%v)", datafile)
 }
 
+func testRunDiff(t testing.TB, gopts GlobalOptions, id1, id2 string) string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runDiff(DiffOptions{}, gopts, []string{id1, id2}))
+
+	return buf.String()
+}
+
+func TestDiff(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(filepath.Join(datadir, "subdir"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "modified"), 1024))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "removed"), 1024))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "subdir", "unchanged"), 1024))
+
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	firstID := snapshotIDs[0]
+
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "modified"), 1024))
+	rtest.OK(t, os.Remove(filepath.Join(datadir, "removed")))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "added"), 1024))
+
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	var secondID restic.ID
+	for _, id := range testRunList(t, "snapshots", env.gopts) {
+		if !id.Equal(firstID) {
+			secondID = id
+		}
+	}
+
+	out := testRunDiff(t, env.gopts, firstID.String(), secondID.String())
+
+	for _, line := range []string{
+		"+    /testdata/added",
+		"-    /testdata/removed",
+		"M    /testdata/modified",
+	} {
+		rtest.Assert(t, strings.Contains(out, line+"\n"), "line %q not found in output:\n%v", line, out)
+	}
+
+	rtest.Assert(t, !strings.Contains(out, "unchanged"), "unchanged file listed in output:\n%v", out)
+}
+
+func testRunStats(t testing.TB, gopts GlobalOptions, mode string) statsContainer {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	rtest.OK(t, runStats(StatsOptions{Mode: mode}, gopts, nil))
+
+	var stats statsContainer
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &stats))
+	return stats
+}
+
+func TestStats(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(datadir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "file1"), 4096))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "file2"), 2048))
+
+	// back up the same data twice, the second file is a copy of the first
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	buf, err := ioutil.ReadFile(filepath.Join(datadir, "file1"))
+	rtest.OK(t, err)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "file3"), buf, 0644))
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+
+	stats := testRunStats(t, env.gopts, countModeRestoreSize)
+	rtest.Equals(t, uint64(4096+2048+4096+2048+4096), stats.TotalSize)
+
+	stats = testRunStats(t, env.gopts, countModeUniqueFilesByContents)
+	rtest.Equals(t, uint64(2), stats.TotalFileCount)
+	rtest.Equals(t, uint64(4096+2048), stats.TotalSize)
+
+	stats = testRunStats(t, env.gopts, countModeRawData)
+	rtest.Assert(t, stats.TotalBlobCount >= 2, "expected at least two blobs, got %d", stats.TotalBlobCount)
+	rtest.Assert(t, stats.TotalSize >= 4096+2048, "raw data is smaller than the unique data: %d", stats.TotalSize)
+}
+
+func TestRecover(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(filepath.Join(datadir, "subdir"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "subdir", "file"), 4096))
+
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	// nothing to recover while all trees are referenced
+	rtest.OK(t, runRecover(env.gopts))
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	testRunForget(t, env.gopts, snapshotIDs[0].String())
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+
+	rtest.OK(t, runRecover(env.gopts))
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one recovered snapshot, got %v", snapshotIDs)
+
+	found := false
+	for _, line := range testRunLsRecursive(t, env.gopts, snapshotIDs[0].String()) {
+		if strings.HasSuffix(line, "/testdata/subdir/file") {
+			found = true
+		}
+	}
+	rtest.Assert(t, found, "file not found in the recovered snapshot")
+
+	testRunCheck(t, env.gopts)
+}
+
+func testRunDump(t testing.TB, gopts GlobalOptions, opts DumpOptions, snapshotID, file string) []byte {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+
+	rtest.OK(t, runDump(opts, gopts, []string{snapshotID, file}))
+
+	return buf.Bytes()
+}
+
+func TestDump(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(filepath.Join(datadir, "subdir"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "file1"), 4096))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "subdir", "file2"), 1<<20))
+
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	// a single file is written as is
+	want, err := ioutil.ReadFile(filepath.Join(datadir, "subdir", "file2"))
+	rtest.OK(t, err)
+	data := testRunDump(t, env.gopts, DumpOptions{Archive: "tar"}, "latest", "/testdata/subdir/file2")
+	rtest.Assert(t, bytes.Equal(want, data), "dumped file differs from the original")
+
+	// directories are written as an archive
+	files := make(map[string]int64)
+	data = testRunDump(t, env.gopts, DumpOptions{Archive: "tar"}, snapshotIDs[0].String(), "/testdata")
+	rd := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		files[hdr.Name] = hdr.Size
+	}
+	rtest.Equals(t, map[string]int64{
+		"testdata/":             0,
+		"testdata/file1":        4096,
+		"testdata/subdir/":      0,
+		"testdata/subdir/file2": 1 << 20,
+	}, files)
+
+	files = make(map[string]int64)
+	data = testRunDump(t, env.gopts, DumpOptions{Archive: "zip"}, "latest", "/testdata/subdir")
+	zrd, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	rtest.OK(t, err)
+	for _, f := range zrd.File {
+		files[f.Name] = int64(f.UncompressedSize64)
+	}
+	rtest.Equals(t, map[string]int64{
+		"subdir/":      0,
+		"subdir/file2": 1 << 20,
+	}, files)
+}
+
+func TestJSONOutput(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	rtest.OK(t, os.MkdirAll(datadir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "file1"), 4096))
+
+	buf := bytes.NewBuffer(nil)
+	env.gopts.JSON = true
+	env.gopts.stdout = buf
+	globalOptions.JSON = true
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.JSON = false
+		globalOptions.stdout = os.Stdout
+	}()
+
+	// backup prints a single summary
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	var summary backupSummary
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &summary))
+	rtest.Equals(t, "summary", summary.MessageType)
+	rtest.Equals(t, uint64(1), summary.FilesProcessed)
+	rtest.Equals(t, uint64(4096), summary.BytesProcessed)
+	id1 := summary.SnapshotID
+
+	// ls prints one line for the snapshot, then one for each node
+	buf.Reset()
+	rtest.OK(t, runLs(LsOptions{Recursive: true}, env.gopts, []string{id1}))
+	dec := json.NewDecoder(buf)
+	var sn lsSnapshot
+	rtest.OK(t, dec.Decode(&sn))
+	rtest.Equals(t, "snapshot", sn.StructType)
+	rtest.Equals(t, id1, sn.ID.String())
+
+	var nodes []lsNode
+	for dec.More() {
+		var node lsNode
+		rtest.OK(t, dec.Decode(&node))
+		rtest.Equals(t, "node", node.StructType)
+		nodes = append(nodes, node)
+	}
+	last := nodes[len(nodes)-1]
+	rtest.Equals(t, "file1", last.Name)
+	rtest.Equals(t, "file", last.Type)
+	rtest.Equals(t, uint64(4096), last.Size)
+
+	// diff prints the changes, then the statistics
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "file2"), 1024))
+	buf.Reset()
+	testRunBackup(t, []string{datadir}, BackupOptions{}, env.gopts)
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &summary))
+	id2 := summary.SnapshotID
+
+	buf.Reset()
+	rtest.OK(t, runDiff(DiffOptions{}, env.gopts, []string{id1, id2}))
+	dec = json.NewDecoder(buf)
+	var change struct {
+		MessageType string `json:"message_type"`
+		Path        string `json:"path"`
+		Modifier    string `json:"modifier"`
+	}
+	rtest.OK(t, dec.Decode(&change))
+	rtest.Equals(t, "change", change.MessageType)
+	rtest.Equals(t, "+", change.Modifier)
+	rtest.Assert(t, strings.HasSuffix(change.Path, "/testdata/file2"), "unexpected path %v", change.Path)
+
+	var stats DiffStats
+	rtest.OK(t, dec.Decode(&stats))
+	rtest.Equals(t, "statistics", stats.MessageType)
+	rtest.Equals(t, 1, stats.Added.Files)
+	rtest.Equals(t, 0, stats.Removed.Files)
+
+	// check prints a summary
+	buf.Reset()
+	rtest.OK(t, runCheck(CheckOptions{}, env.gopts, nil))
+	var checkSum checkSummary
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &checkSum))
+	rtest.Equals(t, "summary", checkSum.MessageType)
+	rtest.Equals(t, 0, checkSum.NumErrors)
+
+	// forget prints the groups of snapshots
+	buf.Reset()
+	rtest.OK(t, runForget(ForgetOptions{Last: 1, GroupBy: "host,paths"}, env.gopts, nil))
+	var groups []ForgetGroup
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &groups))
+	rtest.Equals(t, 1, len(groups))
+	rtest.Equals(t, 1, len(groups[0].Keep))
+	rtest.Equals(t, 1, len(groups[0].Remove))
+	rtest.Equals(t, id2, groups[0].Keep[0].ID.String())
+}
+
+func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions, passwordFile string) {
+	opts := CopyOptions{
+		Repo2:         dstGopts.Repo,
+		PasswordFile2: passwordFile,
+	}
+
+	rtest.OK(t, runCopy(opts, srcGopts, nil))
+}
+
+func TestCopy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	dstGopts := env.gopts
+	dstGopts.Repo = filepath.Join(env.base, "repo2")
+	testRunInit(t, dstGopts)
+
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, ioutil.WriteFile(passwordFile, []byte(rtest.TestPassword), 0600))
+
+	for i := 0; i < 5; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("foo/bar/testfile%v", i))
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, uint(mrand.Intn(5<<20))))
+	}
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "foo", "new"), 1<<20))
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	testRunCopy(t, env.gopts, dstGopts, passwordFile)
+	testRunCheck(t, dstGopts)
+
+	snapshotIDs := testRunList(t, "snapshots", dstGopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots in the destination, got %v", snapshotIDs)
+
+	// copying again must not create duplicate snapshots
+	testRunCopy(t, env.gopts, dstGopts, passwordFile)
+	snapshotIDs = testRunList(t, "snapshots", dstGopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots in the destination, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, dstGopts, restoredir, nil, "")
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, filepath.Base(env.testdata))),
+		"directories are not equal")
+}
+
+func TestCopyHashAlgorithm(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	rtest.OK(t, runInit(InitOptions{Hash: string(restic.HashBLAKE3)}, env.gopts, nil))
+
+	dstGopts := env.gopts
+	dstGopts.Repo = filepath.Join(env.base, "repo2")
+	testRunInit(t, dstGopts)
+
+	passwordFile := filepath.Join(env.base, "password2")
+	rtest.OK(t, ioutil.WriteFile(passwordFile, []byte(rtest.TestPassword), 0600))
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	// the IDs of all blobs are computed again for the destination
+	testRunCopy(t, env.gopts, dstGopts, passwordFile)
+	testRunCheck(t, dstGopts)
+
+	testRunCopy(t, env.gopts, dstGopts, passwordFile)
+	snapshotIDs := testRunList(t, "snapshots", dstGopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot in the destination, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, dstGopts, restoredir, snapshotIDs[0])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+}
+
 type testMatch struct {
 	Path        string    `json:"path,omitempty"`
 	Permissions string    `json:"permissions,omitempty"`
@@ -1111,6 +2227,46 @@ func TestFindJSON(t *testing.T) {
 	rtest.Assert(t, matches[0].Hits == 3, "expected hits to show 3 matches (%v)", datafile)
 }
 
+func TestFindSnapshotTime(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	testRunBackup(t, []string{env.testdata}, BackupOptions{TimeStamp: "2017-01-01 10:00:00"}, env.gopts)
+	testRunBackup(t, []string{env.testdata}, BackupOptions{TimeStamp: "2018-01-01 10:00:00"}, env.gopts)
+
+	find := func(opts FindOptions) []testMatches {
+		buf := bytes.NewBuffer(nil)
+		globalOptions.stdout = buf
+		globalOptions.JSON = true
+		defer func() {
+			globalOptions.stdout = os.Stdout
+			globalOptions.JSON = false
+		}()
+
+		rtest.OK(t, runFind(opts, env.gopts, []string{"testfile"}))
+
+		matches := []testMatches{}
+		rtest.OK(t, json.Unmarshal(buf.Bytes(), &matches))
+		return matches
+	}
+
+	matches := find(FindOptions{})
+	rtest.Assert(t, len(matches) == 2, "expected matches in two snapshots, got %d", len(matches))
+
+	matches = find(FindOptions{Newer: "2017-06-01"})
+	rtest.Assert(t, len(matches) == 1, "expected matches in one snapshot, got %d", len(matches))
+
+	matches = find(FindOptions{Older: "2017-06-01"})
+	rtest.Assert(t, len(matches) == 1, "expected matches in one snapshot, got %d", len(matches))
+
+	matches = find(FindOptions{Newer: "2017-06-01", Older: "2017-12-01"})
+	rtest.Assert(t, len(matches) == 0, "expected no matches, got %d", len(matches))
+}
+
 func TestRebuildIndex(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1127,8 +2283,8 @@ func TestRebuildIndex(t *testing.T) {
 		t.Fatalf("expected no error from checker for test repository, got %v", err)
 	}
 
-	if !strings.Contains(out, "restic rebuild-index") {
-		t.Fatalf("did not find hint for rebuild-index command")
+	if !strings.Contains(out, "restic repair index") {
+		t.Fatalf("did not find hint for repair index command")
 	}
 
 	testRunRebuildIndex(t, env.gopts)
@@ -1175,6 +2331,54 @@ func TestCheckRestoreNoLock(t *testing.T) {
 	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshotIDs[0])
 }
 
+func TestStaleLockRemovedAutomatically(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+
+	hostname, err := os.Hostname()
+	rtest.OK(t, err)
+
+	// a lock of a process which does not exist any more
+	staleLock := restic.Lock{
+		Time:      time.Now(),
+		Exclusive: true,
+		Hostname:  hostname,
+		PID:       math.MaxInt32,
+	}
+	_, err = repo.SaveJSONUnpacked(context.TODO(), restic.LockFile, staleLock)
+	rtest.OK(t, err)
+
+	listLocks := func() (ids restic.IDs) {
+		for id := range repo.List(context.TODO(), restic.LockFile) {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	testRunCheck(t, env.gopts)
+	rtest.Equals(t, 0, len(listLocks()))
+
+	// a lock held by a process on a different host is not removed
+	otherLock := restic.Lock{
+		Time:      time.Now(),
+		Exclusive: true,
+		Hostname:  hostname + "-other",
+		PID:       os.Getpid(),
+	}
+	otherLockID, err := repo.SaveJSONUnpacked(context.TODO(), restic.LockFile, otherLock)
+	rtest.OK(t, err)
+
+	_, err = testRunCheckOutput(env.gopts)
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "expected locked error, got %v", err)
+	locks := listLocks()
+	rtest.Assert(t, len(locks) == 1 && locks[0].Equal(otherLockID), "lock of other host was removed: %v", locks)
+}
+
 func TestPrune(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1210,6 +2414,104 @@ func TestPrune(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestPruneGracePeriod(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0", "2")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(firstSnapshot) == 1,
+		"expected one snapshot, got %v", firstSnapshot)
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0", "3")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	packs := testRunList(t, "packs", env.gopts)
+
+	// packs are only marked for deletion
+	rtest.OK(t, runPrune(PruneOptions{GracePeriod: time.Hour}, env.gopts))
+	testRunCheck(t, env.gopts)
+
+	marked := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(marked) == len(packs),
+		"expected %d packs after marking, got %d", len(packs), len(marked))
+
+	// the packs have been marked for longer than the grace period now
+	rtest.OK(t, runPrune(PruneOptions{GracePeriod: time.Nanosecond}, env.gopts))
+	testRunCheck(t, env.gopts)
+
+	remaining := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(remaining) < len(packs),
+		"expected fewer than %d packs after deletion, got %d", len(packs), len(remaining))
+
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), testRunList(t, "snapshots", env.gopts)[0])
+}
+
+func TestPruneGracePeriodConcurrentBackup(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0", "2")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(firstSnapshot) == 1,
+		"expected one snapshot, got %v", firstSnapshot)
+
+	snapshotFile := filepath.Join(env.repo, "snapshots", firstSnapshot[0].String())
+	buf, err := ioutil.ReadFile(snapshotFile)
+	rtest.OK(t, err)
+
+	testRunBackup(t, []string{filepath.Join(env.testdata, "0", "0", "3")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	rtest.OK(t, runPrune(PruneOptions{GracePeriod: time.Hour}, env.gopts))
+
+	// a backup which ran at the same time as prune saves a snapshot which
+	// references blobs in the packs marked for deletion, they are still
+	// present
+	rtest.OK(t, ioutil.WriteFile(snapshotFile, buf, 0600))
+	testRunCheck(t, env.gopts)
+
+	// the snapshot can be restored before the next prune
+	restoredir := filepath.Join(env.base, "restore-marked")
+	testRunRestore(t, env.gopts, restoredir, firstSnapshot[0])
+	rtest.Assert(t, directoriesEqualContents(filepath.Join(env.testdata, "0", "0", "2"),
+		filepath.Join(restoredir, "2")),
+		"restored directory does not match the backup")
+
+	// the packs are added to the index again instead of being deleted
+	rtest.OK(t, runPrune(PruneOptions{GracePeriod: time.Nanosecond}, env.gopts))
+	testRunCheck(t, env.gopts)
+
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), firstSnapshot[0])
+}
+
 func TestHardLink(t *testing.T) {
 	// this test assumes a test set with a single directory containing hard linked files
 	env, cleanup := withTestEnvironment(t)
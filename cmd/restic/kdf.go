@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+
+	"github.com/spf13/pflag"
+)
+
+// KDFOptions collects the options for the key derivation function which is
+// used when new keys are added to a repository.
+type KDFOptions struct {
+	Timeout time.Duration
+	Memory  int
+	N, R, P int
+}
+
+var kdfOptions KDFOptions
+
+// addKDFFlags adds the flags for the KDF parameters to f.
+func addKDFFlags(f *pflag.FlagSet, opts *KDFOptions) {
+	f.DurationVar(&opts.Timeout, "kdf-time", repository.KDFTimeout, "calibrate the KDF to take about this `duration` on this machine")
+	f.IntVar(&opts.Memory, "kdf-memory", repository.KDFMemory, "maximum memory in `MiB` the KDF may use during calibration")
+	f.IntVar(&opts.N, "kdf-n", 0, "use the scrypt parameter `N` instead of calibrating (requires --kdf-r and --kdf-p)")
+	f.IntVar(&opts.R, "kdf-r", 0, "use the scrypt parameter `r` instead of calibrating (requires --kdf-n and --kdf-p)")
+	f.IntVar(&opts.P, "kdf-p", 0, "use the scrypt parameter `p` instead of calibrating (requires --kdf-n and --kdf-r)")
+}
+
+// apply configures the repository package to use the KDF parameters.
+func (opts KDFOptions) apply() error {
+	if opts.N == 0 && opts.R == 0 && opts.P == 0 {
+		if opts.Timeout <= 0 {
+			return errors.Fatal("--kdf-time must be positive")
+		}
+
+		if opts.Memory <= 0 {
+			return errors.Fatal("--kdf-memory must be positive")
+		}
+
+		repository.KDFTimeout = opts.Timeout
+		repository.KDFMemory = opts.Memory
+		return nil
+	}
+
+	params := crypto.KDFParams{N: opts.N, R: opts.R, P: opts.P}
+	if err := params.Check(); err != nil {
+		return errors.Fatalf("invalid KDF parameters: %v", err)
+	}
+
+	debug.Log("using KDF parameters %v", params)
+	repository.KDFParams = &params
+	return nil
+}
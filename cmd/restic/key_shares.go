@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/shamir"
+)
+
+// sharePrefix is the prefix of the textual representation of a key share.
+const sharePrefix = "restic-share:"
+
+// shareWrapper implements repository.KeyWrapper with Shamir's secret sharing:
+// Wrap splits the user key into shares and does not store anything in the
+// key file, Unwrap combines the shares again.
+type shareWrapper struct {
+	n, k   int
+	shares [][]byte
+}
+
+func (w *shareWrapper) Name() string {
+	return fmt.Sprintf("shamir:%d-of-%d", w.k, w.n)
+}
+
+func (w *shareWrapper) Wrap(ctx context.Context, plaintext []byte) (string, error) {
+	shares, err := shamir.Split(plaintext, w.n, w.k)
+	if err != nil {
+		return "", err
+	}
+
+	w.shares = shares
+	return "", nil
+}
+
+func (w *shareWrapper) Unwrap(ctx context.Context, ciphertext string) ([]byte, error) {
+	if len(w.shares) < w.k {
+		return nil, errors.Fatalf("%d shares are needed, but only %d were given", w.k, len(w.shares))
+	}
+
+	return shamir.Combine(w.shares)
+}
+
+// String returns the textual representation of the i-th share.
+func (w *shareWrapper) String(i int) string {
+	return fmt.Sprintf("%s%d-of-%d:%s", sharePrefix, w.k, w.n, hex.EncodeToString(w.shares[i]))
+}
+
+// parseShare adds the share in the textual representation s.
+func (w *shareWrapper) parseShare(s string) error {
+	if !strings.HasPrefix(s, sharePrefix) {
+		return errors.Errorf("invalid share %q", s)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, sharePrefix), ":", 2)
+	if len(parts) != 2 {
+		return errors.Errorf("invalid share %q", s)
+	}
+
+	var n, k int
+	if _, err := fmt.Sscanf(parts[0], "%d-of-%d", &k, &n); err != nil {
+		return errors.Errorf("invalid share %q", s)
+	}
+
+	if w.n == 0 {
+		w.n, w.k = n, k
+	} else if n != w.n || k != w.k {
+		return errors.Fatalf("shares of different splits (%d-of-%d and %d-of-%d) cannot be combined", w.k, w.n, k, n)
+	}
+
+	share, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return errors.Errorf("invalid share %q: %v", s, err)
+	}
+
+	w.shares = append(w.shares, share)
+	return nil
+}
+
+// loadShares reads the shares from the files, each line of a file may
+// contain a share.
+func loadShares(filenames []string) (*shareWrapper, error) {
+	w := &shareWrapper{}
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, errors.Fatalf("unable to read share: %v", err)
+		}
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+
+			if err := w.parseShare(line); err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+		}
+
+		err = sc.Err()
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Scan")
+		}
+	}
+
+	if len(w.shares) == 0 {
+		return nil, errors.Fatal("no shares found")
+	}
+
+	return w, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// keychainService is the name of the service the repository passwords are
+// stored for in the keychain of the operating system. The repository location
+// is used as the account name.
+const keychainService = "restic"
+
+// readPasswordKeychain returns the password stored in the keychain for the
+// repository, or an empty string if no password has been stored yet.
+func readPasswordKeychain(repo string) (string, error) {
+	pw, err := keychainGet(repo)
+	if err != nil {
+		return "", err
+	}
+
+	debug.Log("password found in keychain: %v", pw != "")
+	return pw, nil
+}
+
+// storePasswordKeychain saves the password in the keychain unless it is
+// already stored there. Errors are only reported as a warning, the repository
+// has already been opened successfully.
+func storePasswordKeychain(repo, password string) {
+	stored, err := keychainGet(repo)
+	if err == nil && stored == password {
+		return
+	}
+
+	err = keychainSet(repo, password)
+	if err != nil {
+		Warnf("unable to store password in keychain: %v\n", err)
+		return
+	}
+
+	Verbosef("stored password in keychain\n")
+}
+
+// keychainCommandError returns an error for a failed keychain command which
+// includes the message it printed.
+func keychainCommandError(command string, err error, output []byte) error {
+	msg := strings.TrimSpace(string(output))
+	if msg == "" {
+		return errors.Errorf("%s failed: %v", command, err)
+	}
+	return errors.Errorf("%s failed: %v: %s", command, err, msg)
+}
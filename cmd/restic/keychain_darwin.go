@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// On macOS, the password is stored in the login keychain using the security
+// command.
+
+// securityItemNotFound is the exit code of security if no item was found.
+const securityItemNotFound = 44
+
+func keychainGet(repo string) (string, error) {
+	cmd := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", repo, "-w")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == securityItemNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", keychainCommandError("security find-generic-password", err, stderr.Bytes())
+	}
+
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// securityQuote quotes s for the interactive mode of security.
+func securityQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func keychainSet(repo, password string) error {
+	// the command is passed on stdin so that the password does not show up in
+	// the list of processes
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		securityQuote(keychainService), securityQuote(repo), securityQuote(password)))
+	output, err := cmd.CombinedOutput()
+	if err == nil && len(bytes.TrimSpace(output)) > 0 {
+		// security -i does not report errors in the exit status
+		err = errors.New("unexpected output")
+	}
+	if err != nil {
+		return keychainCommandError("security add-generic-password", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// On other systems, the password is stored using the Secret Service API (e.g.
+// GNOME Keyring or KWallet) with the secret-tool command from libsecret.
+
+func keychainGet(repo string) (string, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", keychainService, "repository", repo)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+
+	// secret-tool exits with status 1 and does not print anything if no
+	// password was found
+	if _, ok := err.(*exec.ExitError); ok && len(output) == 0 && stderr.Len() == 0 {
+		return "", nil
+	}
+	if err != nil {
+		return "", keychainCommandError("secret-tool lookup", err, stderr.Bytes())
+	}
+
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+func keychainSet(repo, password string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "restic repository "+repo,
+		"service", keychainService, "repository", repo)
+	cmd.Stdin = strings.NewReader(password)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return keychainCommandError("secret-tool store", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// On Windows, the password is stored as a generic credential in the
+// Credential Manager.
+
+var (
+	modadvapi32    = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = modadvapi32.NewProc("CredReadW")
+	procCredWriteW = modadvapi32.NewProc("CredWriteW")
+	procCredFree   = modadvapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = 1168
+)
+
+// credential is the CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func keychainTarget(repo string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(keychainService + ":" + repo)
+}
+
+func keychainGet(repo string) (string, error) {
+	target, err := keychainTarget(repo)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+			return "", nil
+		}
+		return "", errors.Errorf("CredRead failed: %v", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+	return string(blob), nil
+}
+
+func keychainSet(repo, password string) error {
+	target, err := keychainTarget(repo)
+	if err != nil {
+		return err
+	}
+
+	user, err := syscall.UTF16PtrFromString(repo)
+	if err != nil {
+		return err
+	}
+
+	if len(password) == 0 {
+		return errors.New("empty password")
+	}
+
+	blob := []byte(password)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return errors.Errorf("CredWrite failed: %v", err)
+	}
+
+	return nil
+}
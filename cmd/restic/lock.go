@@ -34,10 +34,25 @@ func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock,
 	}
 
 	lock, err := lockFn(context.TODO(), repo)
+	if restic.IsAlreadyLocked(err) {
+		// the other lock may belong to a process which is not running any
+		// more, remove stale locks and try again
+		debug.Log("repository is locked, removing stale locks: %v", err)
+		if rerr := restic.RemoveStaleLocks(context.TODO(), repo); rerr != nil {
+			Warnf("unable to remove stale locks: %v\n", rerr)
+			return nil, err
+		}
+		logEvent("lock_stale_removed", nil)
+
+		lock, err = lockFn(context.TODO(), repo)
+	}
+
 	if err != nil {
+		logEvent("lock_failed", logFields{"exclusive": exclusive, "error": err})
 		return nil, err
 	}
 	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
+	logEvent("lock_created", logFields{"exclusive": exclusive})
 
 	globalLocks.Lock()
 	if globalLocks.cancelRefresh == nil {
@@ -79,6 +94,7 @@ func refreshLocks(wg *sync.WaitGroup, done <-chan struct{}) {
 				err := lock.Refresh(context.TODO())
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "unable to refresh lock: %v\n", err)
+					logEvent("lock_refresh_failed", logFields{"error": err})
 				}
 			}
 			globalLocks.Unlock()
@@ -93,8 +109,10 @@ func unlockRepo(lock *restic.Lock) error {
 	debug.Log("unlocking repository with lock %p", lock)
 	if err := lock.Unlock(); err != nil {
 		debug.Log("error while unlocking: %v", err)
+		logEvent("lock_remove_failed", logFields{"error": err})
 		return err
 	}
+	logEvent("lock_removed", nil)
 
 	for i := 0; i < len(globalLocks.locks); i++ {
 		if lock == globalLocks.locks[i] {
@@ -114,9 +132,11 @@ func unlockAll() error {
 	for _, lock := range globalLocks.locks {
 		if err := lock.Unlock(); err != nil {
 			debug.Log("error while unlocking: %v", err)
+			logEvent("lock_remove_failed", logFields{"error": err})
 			return err
 		}
 		debug.Log("successfully removed lock")
+		logEvent("lock_removed", nil)
 	}
 
 	return nil
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// logFields holds additional information for an event in the log file.
+type logFields map[string]interface{}
+
+var logFile struct {
+	f      *os.File
+	format string
+	sync.Mutex
+}
+
+// openLogFile opens the log file given with --log-file, new events are
+// appended to it.
+func openLogFile(gopts GlobalOptions) error {
+	if gopts.LogFile == "" {
+		return nil
+	}
+
+	switch gopts.LogFormat {
+	case "text", "json":
+	default:
+		return errors.Fatalf("invalid log format %q, must be one of text, json", gopts.LogFormat)
+	}
+
+	f, err := os.OpenFile(gopts.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Fatalf("unable to open log file: %v", err)
+	}
+
+	logFile.Lock()
+	logFile.f = f
+	logFile.format = gopts.LogFormat
+	logFile.Unlock()
+
+	AddCleanupHandler(closeLogFile)
+	return nil
+}
+
+func closeLogFile() error {
+	logFile.Lock()
+	defer logFile.Unlock()
+
+	if logFile.f == nil {
+		return nil
+	}
+
+	err := logFile.f.Close()
+	logFile.f = nil
+	return err
+}
+
+// logEvent writes the event with the fields to the log file, if one has been
+// configured. Events are logged regardless of --quiet and --verbose.
+func logEvent(event string, fields logFields) {
+	logFile.Lock()
+	defer logFile.Unlock()
+
+	if logFile.f == nil {
+		return
+	}
+
+	buf, err := formatLogEvent(logFile.format, time.Now(), event, fields)
+	if err != nil {
+		Warnf("unable to format log event: %v\n", err)
+		return
+	}
+
+	if _, err := logFile.f.Write(buf); err != nil {
+		Warnf("unable to write to log file: %v\n", err)
+	}
+}
+
+// logValue returns the representation of v in the log file.
+func logValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// formatLogEvent returns a single line describing the event in the given
+// format, "text" or "json".
+func formatLogEvent(format string, t time.Time, event string, fields logFields) ([]byte, error) {
+	timestamp := t.Format("2006-01-02T15:04:05.000Z07:00")
+
+	if format == "json" {
+		m := make(map[string]interface{}, len(fields)+2)
+		for k, v := range fields {
+			m[k] = logValue(v)
+		}
+		m["time"] = timestamp
+		m["event"] = event
+
+		buf, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '\n'), nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString(timestamp)
+	buf.WriteByte(' ')
+	buf.WriteString(event)
+	for _, k := range keys {
+		s := fmt.Sprint(logValue(fields[k]))
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(&buf, " %s=%s", k, s)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestFormatLogEvent(t *testing.T) {
+	tm := time.Date(2018, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	fields := logFields{
+		"path":  "/home/user/file name",
+		"error": errors.New("permission denied"),
+		"delay": 1500 * time.Millisecond,
+		"try":   2,
+		"empty": "",
+	}
+
+	buf, err := formatLogEvent("text", tm, "file_error", fields)
+	rtest.OK(t, err)
+	rtest.Equals(t, `2018-01-02T03:04:05.006Z file_error delay=1.5s empty="" error="permission denied" path="/home/user/file name" try=2`+"\n", string(buf))
+
+	buf, err = formatLogEvent("json", tm, "file_error", fields)
+	rtest.OK(t, err)
+
+	var m map[string]interface{}
+	rtest.OK(t, json.Unmarshal(buf, &m))
+	rtest.Equals(t, map[string]interface{}{
+		"time":  "2018-01-02T03:04:05.006Z",
+		"event": "file_error",
+		"path":  "/home/user/file name",
+		"error": "permission denied",
+		"delay": "1.5s",
+		"try":   float64(2),
+		"empty": "",
+	}, m)
+
+	buf, err = formatLogEvent("text", tm, "finish", nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, "2018-01-02T03:04:05.006Z finish\n", string(buf))
+}
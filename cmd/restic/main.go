@@ -29,7 +29,19 @@ directories in an encrypted repository stored on different backends.
 	SilenceUsage:      true,
 	DisableAutoGenTag: true,
 
-	PersistentPreRunE: func(*cobra.Command, []string) error {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if globalOptions.Profile != "" {
+			profile, err := loadProfile(globalOptions.Config, globalOptions.Profile)
+			if err != nil {
+				return err
+			}
+
+			globalOptions.profilePaths, err = applyProfile(cmd, profile)
+			if err != nil {
+				return err
+			}
+		}
+
 		// parse extended options
 		opts, err := options.Parse(globalOptions.Options)
 		if err != nil {
@@ -37,6 +49,12 @@ directories in an encrypted repository stored on different backends.
 		}
 		globalOptions.extended = opts
 
+		if err := openLogFile(globalOptions); err != nil {
+			return err
+		}
+		logEvent("start", logFields{"command": cmd.CommandPath(), "version": version})
+		startNotification(cmd.Name())
+
 		pwd, err := resolvePassword(globalOptions, "RESTIC_PASSWORD")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Resolving password failed: %v\n", err)
@@ -44,13 +62,25 @@ directories in an encrypted repository stored on different backends.
 		}
 		globalOptions.password = pwd
 
+		if globalOptions.MetricsListen != "" {
+			if err := serveMetrics(globalOptions.MetricsListen); err != nil {
+				return err
+			}
+		}
+
+		if globalOptions.TraceExport != "" {
+			if err := startTracing(&globalOptions, cmd.CommandPath()); err != nil {
+				return err
+			}
+		}
+
 		// run the debug functions for all subcommands (if build tag "debug" is
 		// enabled)
 		if err := runDebug(); err != nil {
 			return err
 		}
 
-		return nil
+		return runPreHook(globalOptions, cmd.Name())
 	},
 }
 
@@ -68,6 +98,18 @@ func main() {
 		version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	err := cmdRoot.Execute()
 
+	// a failed post hook is reported like an error of the command, unless
+	// the command failed already
+	if herr := runPostHook(globalOptions, err); herr != nil {
+		if err == nil {
+			err = herr
+		} else {
+			Warnf("%v\n", herr)
+		}
+	}
+
+	sendNotifications(globalOptions, err)
+
 	switch {
 	case restic.IsAlreadyLocked(errors.Cause(err)):
 		fmt.Fprintf(os.Stderr, "%v\nthe `unlock` command can be used to remove stale locks\n", err)
@@ -85,10 +127,12 @@ func main() {
 		}
 	}
 
-	var exitCode int
+	code := exitCode(err)
 	if err != nil {
-		exitCode = 1
+		logEvent("finish", logFields{"error": err, "exit_code": code})
+	} else {
+		logEvent("finish", nil)
 	}
 
-	Exit(exitCode)
+	Exit(code)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// notifyCommands are the commands for which notifications are sent.
+var notifyCommands = map[string]bool{
+	"backup": true,
+	"prune":  true,
+	"check":  true,
+}
+
+// maxNotificationErrors is the maximum number of errors included in a
+// notification.
+const maxNotificationErrors = 100
+
+// notification collects the result of the current command.
+var notification struct {
+	command string
+	start   time.Time
+	stats   interface{}
+	errors  []string
+	sync.Mutex
+}
+
+// notificationSummary is sent as JSON to the URL given with --notify-url.
+type notificationSummary struct {
+	Command  string      `json:"command"`
+	Status   string      `json:"status"` // "success" or "failure"
+	Error    string      `json:"error,omitempty"`
+	Hostname string      `json:"hostname"`
+	Start    time.Time   `json:"start"`
+	End      time.Time   `json:"end"`
+	Duration float64     `json:"duration"` // in seconds
+	Stats    interface{} `json:"stats,omitempty"`
+	Errors   []string    `json:"errors,omitempty"`
+}
+
+// startNotification records that the command has been started.
+func startNotification(command string) {
+	notification.Lock()
+	defer notification.Unlock()
+
+	notification.command = command
+	notification.start = time.Now()
+}
+
+// setNotificationStats sets the statistics of the current command, they are
+// encoded as JSON in the notification.
+func setNotificationStats(stats interface{}) {
+	notification.Lock()
+	defer notification.Unlock()
+
+	notification.stats = stats
+}
+
+// notifyError records an error for the item, which is included in the
+// notification.
+func notifyError(item string, err error) {
+	notification.Lock()
+	defer notification.Unlock()
+
+	if len(notification.errors) < maxNotificationErrors {
+		notification.errors = append(notification.errors, fmt.Sprintf("%v: %v", item, err))
+	}
+}
+
+// newNotificationSummary returns the summary for the current command, which
+// returned err.
+func newNotificationSummary(err error) notificationSummary {
+	notification.Lock()
+	defer notification.Unlock()
+
+	hostname, _ := os.Hostname()
+	end := time.Now()
+
+	summary := notificationSummary{
+		Command:  notification.command,
+		Status:   "success",
+		Hostname: hostname,
+		Start:    notification.start,
+		End:      end,
+		Duration: end.Sub(notification.start).Seconds(),
+		Stats:    notification.stats,
+		Errors:   notification.errors,
+	}
+
+	if err != nil {
+		summary.Status = "failure"
+		summary.Error = err.Error()
+	}
+
+	return summary
+}
+
+// sendNotifications sends the notifications configured in gopts for the
+// command which returned err. Errors are only printed.
+func sendNotifications(gopts GlobalOptions, err error) {
+	if gopts.NotifyURL == "" && gopts.NotifyEmail == "" {
+		return
+	}
+
+	notification.Lock()
+	command := notification.command
+	notification.Unlock()
+
+	if !notifyCommands[command] {
+		return
+	}
+
+	summary := newNotificationSummary(err)
+
+	if gopts.NotifyURL != "" {
+		if err := postNotification(gopts.NotifyURL, summary); err != nil {
+			Warnf("unable to send notification to %v: %v\n", gopts.NotifyURL, err)
+		}
+	}
+
+	if gopts.NotifyEmail != "" {
+		if err := mailNotification(gopts, summary); err != nil {
+			Warnf("unable to send notification to %v: %v\n", gopts.NotifyEmail, err)
+		}
+	}
+}
+
+// postNotification sends the summary as JSON to url.
+func postNotification(url string, summary notificationSummary) error {
+	buf, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return errors.Errorf("server returned status %v", res.Status)
+	}
+
+	return nil
+}
+
+// formatNotificationMail returns the mail for the summary.
+func formatNotificationMail(from, to string, summary notificationSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: restic %s on %s: %s\r\n", summary.Command, summary.Hostname, summary.Status)
+	fmt.Fprintf(&buf, "Date: %s\r\n", summary.End.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+
+	fmt.Fprintf(&buf, "Command:  %s\r\n", summary.Command)
+	fmt.Fprintf(&buf, "Status:   %s\r\n", summary.Status)
+	if summary.Error != "" {
+		fmt.Fprintf(&buf, "Error:    %s\r\n", summary.Error)
+	}
+	fmt.Fprintf(&buf, "Started:  %s\r\n", summary.Start.Format(TimeFormat))
+	fmt.Fprintf(&buf, "Finished: %s\r\n", summary.End.Format(TimeFormat))
+	fmt.Fprintf(&buf, "Duration: %s\r\n", formatSeconds(uint64(summary.Duration)))
+
+	if summary.Stats != nil {
+		stats, err := json.MarshalIndent(summary.Stats, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "\r\nStatistics:\r\n%s\r\n", bytes.Replace(stats, []byte("\n"), []byte("\r\n"), -1))
+	}
+
+	if len(summary.Errors) > 0 {
+		fmt.Fprintf(&buf, "\r\nErrors:\r\n")
+		for _, e := range summary.Errors {
+			fmt.Fprintf(&buf, "  %s\r\n", e)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mailNotification sends the summary by mail via the SMTP server configured
+// in gopts. When $RESTIC_SMTP_USERNAME is set, the server must support
+// authentication.
+func mailNotification(gopts GlobalOptions, summary notificationSummary) error {
+	from := gopts.NotifyFrom
+	if from == "" {
+		from = "restic@" + summary.Hostname
+	}
+
+	msg, err := formatNotificationMail(from, gopts.NotifyEmail, summary)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("RESTIC_SMTP_USERNAME"); user != "" {
+		host, _, err := net.SplitHostPort(gopts.NotifySMTP)
+		if err != nil {
+			return errors.Wrap(err, "SplitHostPort")
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("RESTIC_SMTP_PASSWORD"), host)
+	}
+
+	return smtp.SendMail(gopts.NotifySMTP, auth, from, []string{gopts.NotifyEmail}, msg)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func testNotificationSummary() notificationSummary {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	return notificationSummary{
+		Command:  "backup",
+		Status:   "failure",
+		Error:    "something went wrong",
+		Hostname: "foo",
+		Start:    start,
+		End:      start.Add(90 * time.Second),
+		Duration: 90,
+		Stats:    map[string]interface{}{"files_new": 23},
+		Errors:   []string{"/home/user/file: permission denied"},
+	}
+}
+
+func TestPostNotification(t *testing.T) {
+	var received notificationSummary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rtest.Equals(t, "POST", req.Method)
+		rtest.Equals(t, "application/json", req.Header.Get("Content-Type"))
+
+		buf, err := ioutil.ReadAll(req.Body)
+		rtest.OK(t, err)
+		rtest.OK(t, json.Unmarshal(buf, &received))
+	}))
+	defer srv.Close()
+
+	summary := testNotificationSummary()
+	rtest.OK(t, postNotification(srv.URL, summary))
+
+	rtest.Equals(t, summary.Command, received.Command)
+	rtest.Equals(t, summary.Status, received.Status)
+	rtest.Equals(t, summary.Error, received.Error)
+	rtest.Equals(t, summary.Errors, received.Errors)
+	rtest.Equals(t, map[string]interface{}{"files_new": float64(23)}, received.Stats)
+
+	// errors returned by the server are reported
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	rtest.Assert(t, postNotification(srv.URL, summary) != nil, "expected an error for status 500")
+}
+
+func TestFormatNotificationMail(t *testing.T) {
+	msg, err := formatNotificationMail("restic@foo", "admin@example.com", testNotificationSummary())
+	rtest.OK(t, err)
+
+	for _, s := range []string{
+		"To: admin@example.com\r\n",
+		"Subject: restic backup on foo: failure\r\n",
+		"Error:    something went wrong\r\n",
+		"Duration: 1:30\r\n",
+		"\"files_new\": 23",
+		"  /home/user/file: permission denied\r\n",
+	} {
+		rtest.Assert(t, strings.Contains(string(msg), s), "mail does not contain %q:\n%s", s, msg)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// profileConfig is the content of the configuration file. Each profile maps
+// the names of global options to their values, and the names of commands to
+// the options for that command. For the backup command, the key "paths"
+// lists the files and directories to back up.
+type profileConfig struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// defaultConfigFile returns the location of the configuration file which is
+// used when --config is not set.
+func defaultConfigFile() string {
+	if runtime.GOOS == "windows" {
+		if appdata := os.Getenv("APPDATA"); appdata != "" {
+			return filepath.Join(appdata, "restic", "config.yaml")
+		}
+		return ""
+	}
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "restic", "config.yaml")
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "restic", "config.yaml")
+	}
+
+	return ""
+}
+
+// loadProfile returns the profile name from the configuration file.
+func loadProfile(filename, name string) (map[string]interface{}, error) {
+	if filename == "" {
+		filename = defaultConfigFile()
+	}
+
+	if filename == "" {
+		return nil, errors.Fatal("unable to locate the configuration file, please specify it with --config")
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read configuration file: %v", err)
+	}
+
+	var cfg profileConfig
+	if err := yaml.Unmarshal(buf, &cfg); err != nil {
+		return nil, errors.Fatalf("unable to parse configuration file %v: %v", filename, err)
+	}
+
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, errors.Fatalf("profile %q not found in %v", name, filename)
+	}
+
+	debug.Log("loaded profile %v from %v", name, filename)
+	return profile, nil
+}
+
+// commandPath returns the path of cmd without the name of the program, e.g.
+// "repair index".
+func commandPath(cmd *cobra.Command) string {
+	path := cmd.CommandPath()
+	if i := strings.Index(path, " "); i >= 0 {
+		return path[i+1:]
+	}
+	return ""
+}
+
+// applyProfile sets the options of the profile for the command cmd. Options
+// given on the command line take precedence, options in the section for the
+// command override global options of the profile. The paths for the backup
+// command are returned.
+func applyProfile(cmd *cobra.Command, profile map[string]interface{}) (paths []string, err error) {
+	global := make(map[string]interface{})
+	local := make(map[string]interface{})
+
+	command := commandPath(cmd)
+	for key, value := range profile {
+		section, ok := value.(map[interface{}]interface{})
+		if !ok {
+			global[key] = value
+			continue
+		}
+
+		if key != command {
+			continue
+		}
+
+		for k, v := range section {
+			local[fmt.Sprint(k)] = v
+		}
+	}
+
+	if command == "backup" {
+		if v, ok := local["paths"]; ok {
+			paths, err = profileStrings("paths", v)
+			if err != nil {
+				return nil, err
+			}
+			delete(local, "paths")
+		}
+	}
+
+	for name, value := range local {
+		if cmd.Flags().Lookup(name) == nil {
+			continue
+		}
+
+		if err := setProfileFlag(cmd.Flags(), name, value); err != nil {
+			return nil, errors.Fatalf("%v: %v", command, err)
+		}
+		delete(local, name)
+	}
+
+	// the remaining options of the command section are global options
+	for name, value := range local {
+		global[name] = value
+	}
+
+	var names []string
+	for name := range global {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := setProfileFlag(cmd.Root().PersistentFlags(), name, global[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// profileStrings converts a single value or a list to a list of strings.
+func profileStrings(name string, value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(map[interface{}]interface{}); ok {
+				return nil, errors.Fatalf("invalid value for option %q in profile", name)
+			}
+			list = append(list, fmt.Sprint(item))
+		}
+		return list, nil
+	case map[interface{}]interface{}:
+		return nil, errors.Fatalf("invalid value for option %q in profile", name)
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+// setProfileFlag sets the flag name to the value from the profile, unless it
+// has been set on the command line. For lists, the flag is set once for each
+// item, so that options which can be specified multiple times work.
+func setProfileFlag(flags *pflag.FlagSet, name string, value interface{}) error {
+	f := flags.Lookup(name)
+	if f == nil {
+		return errors.Fatalf("unknown option %q in profile", name)
+	}
+
+	if f.Changed {
+		debug.Log("option %v given on the command line, ignoring profile", name)
+		return nil
+	}
+
+	values, err := profileStrings(name, value)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if err := flags.Set(name, v); err != nil {
+			return errors.Fatalf("invalid value %q for option %q in profile: %v", v, name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+const testProfileConfig = `
+profiles:
+  home:
+    repo: /srv/restic-repo
+    quiet: true
+    backup:
+      paths:
+        - /home
+        - /etc
+      exclude: ["*.tmp", "/home/*/.cache"]
+      quiet: false
+    forget:
+      keep-daily: 7
+`
+
+// testProfileCommand applies the profile to the command "backup" of a
+// command tree similar to restic's, run with args.
+func testProfileCommand(t testing.TB, profile map[string]interface{}, args ...string) (repo string, quiet bool, excludes []string, paths []string) {
+	root := &cobra.Command{Use: "restic"}
+	root.PersistentFlags().StringVarP(&repo, "repo", "r", "", "")
+	root.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "")
+
+	backup := &cobra.Command{
+		Use: "backup",
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			paths, err = applyProfile(cmd, profile)
+			return err
+		},
+	}
+	backup.Flags().StringArrayVarP(&excludes, "exclude", "e", nil, "")
+	root.AddCommand(backup)
+
+	root.SetArgs(append([]string{"backup"}, args...))
+	rtest.OK(t, root.Execute())
+	return repo, quiet, excludes, paths
+}
+
+func TestProfile(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "config.yaml")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte(testProfileConfig), 0600))
+
+	profile, err := loadProfile(filename, "home")
+	rtest.OK(t, err)
+
+	repo, quiet, excludes, paths := testProfileCommand(t, profile)
+	rtest.Equals(t, "/srv/restic-repo", repo)
+	rtest.Equals(t, false, quiet)
+	rtest.Equals(t, []string{"*.tmp", "/home/*/.cache"}, excludes)
+	rtest.Equals(t, []string{"/home", "/etc"}, paths)
+
+	// options on the command line take precedence
+	repo, _, excludes, _ = testProfileCommand(t, profile, "-r", "/other", "--exclude", "foo")
+	rtest.Equals(t, "/other", repo)
+	rtest.Equals(t, []string{"foo"}, excludes)
+
+	_, err = loadProfile(filename, "missing")
+	rtest.Assert(t, err != nil, "expected an error for a missing profile")
+}
+
+func TestProfileUnknownOption(t *testing.T) {
+	root := &cobra.Command{Use: "restic"}
+	cmd := &cobra.Command{
+		Use: "backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := applyProfile(cmd, map[string]interface{}{"foo": "bar"})
+			return err
+		},
+	}
+	root.AddCommand(cmd)
+	root.SetArgs([]string{"backup"})
+	root.SilenceErrors = true
+	root.SilenceUsage = true
+
+	rtest.Assert(t, root.Execute() != nil, "expected an error for an unknown option")
+}
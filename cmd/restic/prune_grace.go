@@ -0,0 +1,258 @@
+package main
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// pruneRepositoryGrace removes unneeded data from the repository without
+// requiring an exclusive lock, so that backups can run at the same time.
+//
+// Packs which are not needed any more are not deleted right away, but removed
+// from the index and recorded as obsolete in the new index together with the
+// time and their list of blobs. A backup which is running at that time may
+// still reference blobs in these packs. Such packs are added to the index
+// again by the next run, and only packs which have been obsolete for longer
+// than the grace period are deleted. The grace period must therefore be
+// longer than the longest running backup.
+//
+// Only packs listed in the index are considered, pack files which are not
+// referenced by any index (e.g. uploaded by a backup which is still running)
+// are left alone.
+func pruneRepositoryGrace(opts PruneOptions, gopts GlobalOptions, repo restic.Repository) error {
+	ctx := gopts.ctx
+	now := time.Now()
+
+	err := repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	mi, ok := repo.Index().(*repository.MasterIndex)
+	if !ok {
+		return errors.Fatal("prune with --grace-period is not supported for this repository")
+	}
+
+	// the index files which have been loaded are replaced by a new index at
+	// the end, index files saved in the meantime by backups are kept
+	var supersedes restic.IDs
+	live := make(map[restic.ID][]restic.Blob)
+	obsolete := make(map[restic.ID]repository.ObsoletePack)
+	for _, idx := range mi.All() {
+		// the index of blobs in obsolete packs, which LoadIndex has added,
+		// has not been loaded from a file
+		id, err := idx.ID()
+		if err != nil || id.IsNull() {
+			continue
+		}
+		supersedes = append(supersedes, id)
+
+		for pb := range idx.Each(ctx) {
+			live[pb.PackID] = append(live[pb.PackID], pb.Blob)
+		}
+
+		for _, op := range idx.Obsolete() {
+			if prev, ok := obsolete[op.ID]; !ok || op.Time.Before(prev.Time) {
+				obsolete[op.ID] = op
+			}
+		}
+	}
+
+	for id := range obsolete {
+		if _, ok := live[id]; ok {
+			debug.Log("obsolete pack %v is contained in the index", id.Str())
+			delete(obsolete, id)
+		}
+	}
+
+	Verbosef("repository contains %d packs in the index, %d packs are waiting to be deleted\n",
+		len(live), len(obsolete))
+
+	// trees in obsolete packs may be referenced by new snapshots, LoadIndex
+	// has made them available for finding the used blobs
+	usedBlobs, err := findUsedBlobs(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	liveBlobs := restic.NewBlobSet()
+	for _, blobs := range live {
+		for _, blob := range blobs {
+			liveBlobs.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
+
+	// obsolete packs which contain blobs needed by a snapshot are added to
+	// the index again
+	resurrected := 0
+	for id, op := range obsolete {
+		for _, blob := range op.Blobs {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if !usedBlobs.Has(h) || liveBlobs.Has(h) {
+				continue
+			}
+
+			debug.Log("obsolete pack %v contains used blob %v", id.Str(), h)
+			live[id] = op.Blobs
+			for _, blob := range op.Blobs {
+				liveBlobs.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+			}
+			delete(obsolete, id)
+			resurrected++
+			break
+		}
+	}
+
+	if resurrected > 0 {
+		Verbosef("%d packs waiting to be deleted are used by new snapshots, adding them to the index again\n", resurrected)
+	}
+
+	for h := range usedBlobs {
+		if !liveBlobs.Has(h) {
+			return errors.Fatalf("blob %v is used, but not contained in any pack, please run `restic check`", h)
+		}
+	}
+
+	// find packs which are not needed any more and packs which need to be
+	// rewritten
+	removePacks := restic.NewIDSet()
+	rewritePacks := restic.NewIDSet()
+	packs := make(map[restic.ID]index.Pack)
+	for id, blobs := range live {
+		var used, unused int
+		var size int64
+		for _, blob := range blobs {
+			size += int64(blob.Length)
+			if usedBlobs.Has(restic.BlobHandle{ID: blob.ID, Type: blob.Type}) {
+				used++
+			} else {
+				unused++
+			}
+		}
+
+		switch {
+		case used == 0:
+			removePacks.Insert(id)
+		case unused > 0:
+			rewritePacks.Insert(id)
+			packs[id] = index.Pack{ID: id, Size: size, Entries: blobs}
+		}
+	}
+
+	limits, err := opts.limits(0)
+	if err != nil {
+		return err
+	}
+
+	if limits.maxUnused != 0 || limits.maxRepackSize >= 0 {
+		var total int64
+		for _, blobs := range live {
+			for _, blob := range blobs {
+				total += int64(blob.Length)
+			}
+		}
+
+		limits, err = opts.limits(total)
+		if err != nil {
+			return err
+		}
+
+		limitRepack(limits, packs, rewritePacks, usedBlobs)
+	}
+
+	if len(rewritePacks) > 0 {
+		Verbosef("rewriting %d packs\n", len(rewritePacks))
+
+		bar := newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(rewritePacks)), "packs rewritten")
+		bar.Start()
+		_, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+		if err != nil {
+			return err
+		}
+		bar.Done()
+
+		// the index for the new packs is saved in a separate file
+		if err := repo.SaveIndex(ctx); err != nil {
+			return err
+		}
+	}
+
+	// the packs are removed from the index and deleted by a later run
+	marked := restic.NewIDSet()
+	marked.Merge(removePacks)
+	marked.Merge(rewritePacks)
+	for id := range marked {
+		obsolete[id] = repository.ObsoletePack{ID: id, Time: now, Blobs: live[id]}
+		delete(live, id)
+	}
+
+	deletePacks := restic.NewIDSet()
+	for id, op := range obsolete {
+		if now.Sub(op.Time) >= opts.GracePeriod {
+			deletePacks.Insert(id)
+			delete(obsolete, id)
+		}
+	}
+
+	Verbosef("marked %d packs for deletion, %d packs have been marked for longer than %v and are deleted\n",
+		len(marked), len(deletePacks), opts.GracePeriod)
+
+	if len(marked)+len(deletePacks)+resurrected == 0 {
+		Verbosef("nothing to do\n")
+		return nil
+	}
+
+	newIdx := repository.NewIndex()
+	for id, blobs := range live {
+		for _, blob := range blobs {
+			newIdx.Store(restic.PackedBlob{Blob: blob, PackID: id})
+		}
+	}
+
+	var list []repository.ObsoletePack
+	for _, op := range obsolete {
+		list = append(list, op)
+	}
+
+	if err := newIdx.SetObsolete(list); err != nil {
+		return err
+	}
+
+	if err := newIdx.AddToSupersedes(supersedes...); err != nil {
+		return err
+	}
+
+	id, err := repository.SaveIndex(ctx, repo, newIdx)
+	if err != nil {
+		return err
+	}
+	Verbosef("saved new index as %v\n", id.Str())
+
+	for _, id := range supersedes {
+		h := restic.Handle{Type: restic.IndexFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("error removing old index %v: %v\n", id.Str(), err)
+		}
+	}
+
+	for id := range deletePacks {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("unable to remove file %v from the repository\n", id.Str())
+		}
+	}
+
+	setNotificationStats(map[string]interface{}{
+		"packs_marked":    len(marked),
+		"packs_removed":   len(deletePacks),
+		"packs_rewritten": len(rewritePacks),
+	})
+
+	Verbosef("done\n")
+	return nil
+}
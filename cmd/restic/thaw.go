@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// thawPollInterval is the time between checks whether archived pack files
+// have been restored.
+var thawPollInterval = 5 * time.Minute
+
+// thawWorkers is the number of pack files which are checked in parallel.
+const thawWorkers = 8
+
+// thawPacks makes sure that all packs can be read. For backends which store
+// pack files in an archival storage tier, a restore is requested for all
+// archived packs. If wait is set, thawPacks waits until all packs have been
+// restored, otherwise an error is returned and the command needs to be run
+// again once the restore has finished.
+func thawPacks(ctx context.Context, repo restic.Repository, packs restic.IDSet, wait bool) error {
+	be := repo.Backend()
+	if _, ok := restic.Unwrap(be).(restic.Thawer); !ok {
+		return nil
+	}
+
+	pending := packs
+	for {
+		var err error
+		pending, err = thawPending(ctx, be, pending)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if !wait {
+			return errors.Fatalf("%d of %d pack files are archived and are being restored, run the command again "+
+				"once the restore has finished or use --wait-thaw", len(pending), len(packs))
+		}
+
+		Verbosef("waiting for %d of %d archived pack files to be restored\n", len(pending), len(packs))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(thawPollInterval):
+		}
+	}
+}
+
+// thawPending calls Thaw for all packs and returns the packs which cannot be
+// read yet.
+func thawPending(ctx context.Context, be restic.Backend, packs restic.IDSet) (restic.IDSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan restic.ID)
+	go func() {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		m        sync.Mutex
+		pending  = restic.NewIDSet()
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < thawWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ch {
+				h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+				ready, err := restic.Thaw(ctx, be, h)
+
+				m.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = errors.Wrapf(err, "thaw %v", id.Str())
+					cancel()
+				}
+				if err == nil && !ready {
+					pending.Insert(id)
+				}
+				m.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	debug.Log("%d of %d packs are not ready", len(pending), len(packs))
+	return pending, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// archiveBackend reports pack files as archived until Thaw has been called
+// for them a number of times.
+type archiveBackend struct {
+	restic.Backend
+
+	m     sync.Mutex
+	calls map[string]int
+	delay int
+}
+
+func (be *archiveBackend) Thaw(ctx context.Context, h restic.Handle) (bool, error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	be.calls[h.Name]++
+	return be.calls[h.Name] > be.delay, nil
+}
+
+func TestThawPacks(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	abe := &archiveBackend{Backend: be, calls: make(map[string]int), delay: 2}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, abe)
+	defer cleanup()
+
+	defer func(d time.Duration) {
+		thawPollInterval = d
+	}(thawPollInterval)
+	thawPollInterval = time.Millisecond
+
+	packs := restic.NewIDSet()
+	for i := 0; i < 20; i++ {
+		packs.Insert(restic.NewRandomID())
+	}
+
+	// without waiting, the user is asked to come back later
+	err := thawPacks(context.TODO(), repo, packs, false)
+	rtest.Assert(t, errors.IsFatal(err), "expected fatal error, got %v", err)
+
+	err = thawPacks(context.TODO(), repo, packs, true)
+	rtest.OK(t, err)
+	for id := range packs {
+		rtest.Equals(t, 3, abe.calls[id.String()])
+	}
+
+	// once restored, the packs can be read right away
+	err = thawPacks(context.TODO(), repo, packs, false)
+	rtest.OK(t, err)
+}
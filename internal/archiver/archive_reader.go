@@ -52,7 +52,7 @@ func (r *Reader) Archive(ctx context.Context, name string, rd io.Reader, p *rest
 			return nil, restic.ID{}, errors.Wrap(err, "chunker.Next()")
 		}
 
-		id := restic.Hash(chunk.Data)
+		id := repo.Config().Hash.Sum(chunk.Data)
 
 		if !repo.Index().Has(id, restic.DataBlob) {
 			_, err := repo.SaveBlob(ctx, restic.DataBlob, chunk.Data, id)
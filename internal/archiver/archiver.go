@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/restic/restic/internal/errors"
@@ -17,7 +18,9 @@ import (
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/metrics"
 	"github.com/restic/restic/internal/pipe"
+	"github.com/restic/restic/internal/tracing"
 
 	"github.com/restic/chunker"
 )
@@ -34,6 +37,10 @@ var archiverAllowAllFiles = func(string, os.FileInfo) bool { return true }
 
 // Archiver is used to backup a set of directories.
 type Archiver struct {
+	// dataAdded is accessed atomically and must be the first field, so that it
+	// is aligned for 64-bit atomic operations on 32-bit platforms.
+	dataAdded uint64
+
 	repo       restic.Repository
 	knownBlobs struct {
 		restic.IDSet
@@ -42,9 +49,51 @@ type Archiver struct {
 
 	blobToken chan struct{}
 
+	// hardlinks records the content of files with more than one link which
+	// have already been saved, so that other links to them are not read again.
+	hardlinks struct {
+		content map[hardlinkKey]*restic.Node
+		sync.Mutex
+	}
+
+	// saveErr is the first error returned by the repository when saving a
+	// blob or tree. Afterwards, no more files are read and Snapshot returns
+	// the error.
+	saveErr struct {
+		err error
+		sync.Mutex
+	}
+
 	Warn         func(dir string, fi os.FileInfo, err error)
 	SelectFilter pipe.SelectFunc
 	Excludes     []string
+
+	// SkipExtendedAttributes can be set to not save extended attributes.
+	SkipExtendedAttributes bool
+
+	// DryRun can be set to read and chunk all files as usual, but not save
+	// any data or the snapshot in the repository.
+	DryRun bool
+
+	// Changed, if set, is called for each file which is new or has been
+	// modified since the parent snapshot.
+	Changed func(path string, modified bool)
+
+	// CheckpointInterval, if set, is the interval in which the index for all
+	// packs uploaded so far is saved, even if it is not full yet. This allows
+	// a later backup to reuse the data when the current one is interrupted.
+	CheckpointInterval time.Duration
+
+	// ReadConcurrency is the number of files which are read and chunked in
+	// parallel. When it is zero, a default is used. It is independent of the
+	// number of packs uploaded in parallel, the repository uploads packs in
+	// the background and only blocks saving blobs while its upload queue is
+	// full.
+	ReadConcurrency uint
+
+	// FS is used to read the contents of the files, it defaults to the local
+	// file system.
+	FS fs.FS
 }
 
 // New returns a new archiver.
@@ -60,16 +109,60 @@ func New(repo restic.Repository) *Archiver {
 		},
 	}
 
+	arch.hardlinks.content = make(map[hardlinkKey]*restic.Node)
+
 	for i := 0; i < maxConcurrentBlobs; i++ {
 		arch.blobToken <- struct{}{}
 	}
 
 	arch.Warn = archiverPrintWarnings
 	arch.SelectFilter = archiverAllowAllFiles
+	arch.FS = fs.Local{}
 
 	return arch
 }
 
+// nodeFromFileInfo returns a new node for the file at path, extended
+// attributes are not read if they should not be saved.
+func (arch *Archiver) nodeFromFileInfo(path string, fi os.FileInfo) (*restic.Node, error) {
+	return restic.NodeFromFileInfo(path, fi, arch.SkipExtendedAttributes)
+}
+
+// hardlinkKey identifies a file with more than one link.
+type hardlinkKey struct {
+	inode, device uint64
+}
+
+// hardlinkContent returns the content of another link to the same file as
+// node, if that has already been saved and the file has not been modified in
+// the meantime.
+func (arch *Archiver) hardlinkContent(node *restic.Node) (restic.IDs, bool) {
+	if node.Links < 2 {
+		return nil, false
+	}
+
+	arch.hardlinks.Lock()
+	defer arch.hardlinks.Unlock()
+
+	other, ok := arch.hardlinks.content[hardlinkKey{node.Inode, node.DeviceID}]
+	if !ok || other.Size != node.Size || !other.ModTime.Equal(node.ModTime) {
+		return nil, false
+	}
+
+	return other.Content, true
+}
+
+// rememberHardlink records the content of node for other links to the file.
+func (arch *Archiver) rememberHardlink(node *restic.Node) {
+	if node.Links < 2 {
+		return
+	}
+
+	arch.hardlinks.Lock()
+	arch.hardlinks.content[hardlinkKey{node.Inode, node.DeviceID}] = node
+	arch.hardlinks.Unlock()
+}
+
 // isKnownBlob returns true iff the blob is not yet in the list of known blobs.
 // When the blob is not known, false is returned and the blob is added to the
 // list. This means that the caller false is returned to is responsible to save
@@ -84,12 +177,7 @@ func (arch *Archiver) isKnownBlob(id restic.ID, t restic.BlobType) bool {
 
 	arch.knownBlobs.Insert(id)
 
-	_, err := arch.repo.Index().Lookup(id, t)
-	if err == nil {
-		return true
-	}
-
-	return false
+	return arch.repo.Index().Has(id, t)
 }
 
 // Save stores a blob read from rd in the repository.
@@ -101,6 +189,11 @@ func (arch *Archiver) Save(ctx context.Context, t restic.BlobType, data []byte,
 		return nil
 	}
 
+	atomic.AddUint64(&arch.dataAdded, uint64(len(data)))
+	if arch.DryRun {
+		return nil
+	}
+
 	_, err := arch.repo.SaveBlob(ctx, t, data, id)
 	if err != nil {
 		debug.Log("Save(%v, %v): error %v\n", t, id.Str(), err)
@@ -120,14 +213,25 @@ func (arch *Archiver) SaveTreeJSON(ctx context.Context, tree *restic.Tree) (rest
 	data = append(data, '\n')
 
 	// check if tree has been saved before
-	id := restic.Hash(data)
+	id := arch.repo.Config().Hash.Sum(data)
 	if arch.isKnownBlob(id, restic.TreeBlob) {
 		return id, nil
 	}
 
+	atomic.AddUint64(&arch.dataAdded, uint64(len(data)))
+	if arch.DryRun {
+		return id, nil
+	}
+
 	return arch.repo.SaveBlob(ctx, restic.TreeBlob, data, id)
 }
 
+// DataAdded returns the number of bytes of new data (before encryption) which
+// has been saved to the repository, or would have been saved for a dry run.
+func (arch *Archiver) DataAdded() uint64 {
+	return atomic.LoadUint64(&arch.dataAdded)
+}
+
 func (arch *Archiver) reloadFileIfChanged(node *restic.Node, file fs.File) (*restic.Node, error) {
 	fi, err := file.Stat()
 	if err != nil {
@@ -140,7 +244,7 @@ func (arch *Archiver) reloadFileIfChanged(node *restic.Node, file fs.File) (*res
 
 	arch.Warn(node.Path, fi, errors.New("file has changed"))
 
-	node, err = restic.NodeFromFileInfo(node.Path, fi)
+	node, err = arch.nodeFromFileInfo(node.Path, fi)
 	if err != nil {
 		debug.Log("restic.NodeFromFileInfo returned error for %v: %v", node.Path, err)
 		arch.Warn(node.Path, fi, err)
@@ -149,21 +253,48 @@ func (arch *Archiver) reloadFileIfChanged(node *restic.Node, file fs.File) (*res
 	return node, nil
 }
 
+// setSaveError records err if it is the first error saving data in the
+// repository.
+func (arch *Archiver) setSaveError(err error) {
+	arch.saveErr.Lock()
+	defer arch.saveErr.Unlock()
+
+	if arch.saveErr.err == nil {
+		arch.saveErr.err = err
+	}
+}
+
+// saveError returns the first error saving data in the repository, if any.
+func (arch *Archiver) saveError() error {
+	arch.saveErr.Lock()
+	defer arch.saveErr.Unlock()
+
+	return arch.saveErr.err
+}
+
 type saveResult struct {
 	id    restic.ID
 	bytes uint64
+	err   error
 }
 
 func (arch *Archiver) saveChunk(ctx context.Context, chunk chunker.Chunk, p *restic.Progress, token struct{}, file fs.File, resultChannel chan<- saveResult) {
 	defer freeBuf(chunk.Data)
 
-	id := restic.Hash(chunk.Data)
+	ctx, span := tracing.Start(ctx, "archiver.saveChunk", tracing.Int("restic.bytes", int(chunk.Length)))
+	defer span.End()
+
+	_, hashSpan := tracing.Start(ctx, "archiver.hash")
+	id := arch.repo.Config().Hash.Sum(chunk.Data)
+	hashSpan.End()
+
 	err := arch.Save(ctx, restic.DataBlob, chunk.Data, id)
-	// TODO handle error
 	if err != nil {
 		debug.Log("Save(%v) failed: %v", id.Str(), err)
-		fmt.Printf("\nerror while saving data to the repo: %+v\n", err)
-		panic(err)
+		arch.setSaveError(err)
+		arch.blobToken <- token
+		resultChannel <- saveResult{err: err}
+		return
 	}
 
 	p.Report(restic.Stat{Bytes: uint64(chunk.Length)})
@@ -174,8 +305,17 @@ func (arch *Archiver) saveChunk(ctx context.Context, chunk chunker.Chunk, p *res
 func waitForResults(resultChannels [](<-chan saveResult)) ([]saveResult, error) {
 	results := []saveResult{}
 
+	var err error
 	for _, ch := range resultChannels {
-		results = append(results, <-ch)
+		res := <-ch
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+		results = append(results, res)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
 	if len(results) != len(resultChannels) {
@@ -209,8 +349,13 @@ func updateNodeContent(node *restic.Node, results []saveResult) error {
 
 // SaveFile stores the content of the file on the backend as a Blob by calling
 // Save for each chunk.
-func (arch *Archiver) SaveFile(ctx context.Context, p *restic.Progress, node *restic.Node) (*restic.Node, error) {
-	file, err := fs.Open(node.Path)
+func (arch *Archiver) SaveFile(ctx context.Context, p *restic.Progress, node *restic.Node) (_ *restic.Node, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.SaveFile", tracing.String("restic.path", node.Path))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	file, err := arch.FS.Open(node.Path)
 	if err != nil {
 		return node, errors.Wrap(err, "Open")
 	}
@@ -227,7 +372,9 @@ func (arch *Archiver) SaveFile(ctx context.Context, p *restic.Progress, node *re
 	resultChannels := [](<-chan saveResult){}
 
 	for {
+		_, readSpan := tracing.Start(ctx, "archiver.read")
 		chunk, err := chnker.Next(getBuf())
+		readSpan.End()
 		if errors.Cause(err) == io.EOF {
 			break
 		}
@@ -265,6 +412,12 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 
 			debug.Log("got job %v", e)
 
+			// stop reading files once data cannot be saved anymore
+			if arch.saveError() != nil {
+				e.Result() <- nil
+				continue
+			}
+
 			// check for errors
 			if e.Error() != nil {
 				debug.Log("job %v has errors: %v", e.Path(), e.Error())
@@ -273,16 +426,18 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 				// ignore this file
 				e.Result() <- nil
 				p.Report(restic.Stat{Errors: 1})
+				metrics.Errors.Inc()
 				continue
 			}
 
-			node, err := restic.NodeFromFileInfo(e.Fullpath(), e.Info())
+			node, err := arch.nodeFromFileInfo(e.Fullpath(), e.Info())
 			if err != nil {
 				debug.Log("restic.NodeFromFileInfo returned error for %v: %v", node.Path, err)
 				arch.Warn(e.Fullpath(), e.Info(), err)
 			}
 
 			// try to use old node, if present
+			useOld := false
 			if e.Node != nil {
 				debug.Log("   %v use old data", e.Path())
 
@@ -299,24 +454,44 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 
 				if !contentMissing {
 					node.Content = oldNode.Content
+					useOld = true
 					debug.Log("   %v content is complete", e.Path())
 				}
 			} else {
 				debug.Log("   %v no old data", e.Path())
 			}
 
+			if node.Type == "file" && !useOld && arch.Changed != nil {
+				arch.Changed(e.Fullpath(), e.Modified)
+			}
+
+			// use the content of another link to the same file
+			if node.Type == "file" && len(node.Content) == 0 {
+				if content, ok := arch.hardlinkContent(node); ok {
+					debug.Log("   %v use data of hard link", e.Path())
+					node.Content = content
+				}
+			}
+
 			// otherwise read file normally
 			if node.Type == "file" && len(node.Content) == 0 {
 				debug.Log("   read and save %v", e.Path())
 				node, err = arch.SaveFile(ctx, p, node)
+				if err != nil && arch.saveError() != nil {
+					// Snapshot returns the error
+					e.Result() <- nil
+					continue
+				}
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "error for %v: %v\n", node.Path, err)
 					arch.Warn(e.Path(), nil, err)
 					// ignore this file
 					e.Result() <- nil
 					p.Report(restic.Stat{Errors: 1})
+					metrics.Errors.Inc()
 					continue
 				}
+				arch.rememberHardlink(node)
 			} else {
 				// report old data size
 				p.Report(restic.Stat{Bytes: node.Size})
@@ -325,6 +500,7 @@ func (arch *Archiver) fileWorker(ctx context.Context, wg *sync.WaitGroup, p *res
 			debug.Log("   processed %v, %d blobs", e.Path(), len(node.Content))
 			e.Result() <- node
 			p.Report(restic.Stat{Files: 1})
+			metrics.FilesProcessed.Inc()
 		case <-ctx.Done():
 			// pipeline was cancelled
 			return
@@ -347,11 +523,22 @@ func (arch *Archiver) dirWorker(ctx context.Context, wg *sync.WaitGroup, p *rest
 			}
 			debug.Log("save dir %v (%d entries), error %v\n", dir.Path(), len(dir.Entries), dir.Error())
 
+			// no trees are saved once data cannot be saved anymore, but the
+			// results of all entries are still received
+			if arch.saveError() != nil {
+				for _, ch := range dir.Entries {
+					<-ch
+				}
+				dir.Result() <- nil
+				continue
+			}
+
 			// ignore dir nodes with errors
 			if dir.Error() != nil {
 				fmt.Fprintf(os.Stderr, "error walking dir %v: %v\n", dir.Path(), dir.Error())
 				dir.Result() <- nil
 				p.Report(restic.Stat{Errors: 1})
+				metrics.Errors.Inc()
 				continue
 			}
 
@@ -405,7 +592,7 @@ func (arch *Archiver) dirWorker(ctx context.Context, wg *sync.WaitGroup, p *rest
 			node := &restic.Node{}
 
 			if dir.Path() != "" && dir.Info() != nil {
-				n, err := restic.NodeFromFileInfo(dir.Fullpath(), dir.Info())
+				n, err := arch.nodeFromFileInfo(dir.Fullpath(), dir.Info())
 				if err != nil {
 					arch.Warn(dir.Path(), dir.Info(), err)
 				}
@@ -418,7 +605,10 @@ func (arch *Archiver) dirWorker(ctx context.Context, wg *sync.WaitGroup, p *rest
 
 			id, err := arch.SaveTreeJSON(ctx, tree)
 			if err != nil {
-				panic(err)
+				debug.Log("saving tree for %s failed: %v", dir.Path(), err)
+				arch.setSaveError(err)
+				dir.Result() <- nil
+				continue
 			}
 			debug.Log("save tree for %s: %v", dir.Path(), id.Str())
 			if id.IsNull() {
@@ -586,7 +776,9 @@ func (j archiveJob) Copy() pipe.Job {
 		// if file is newer, return the new job
 		if j.old.Node.IsNewer(j.new.Fullpath(), j.new.Info()) {
 			debug.Log("   job %v is newer", j.new.Path())
-			return j.new
+			e := j.new.(pipe.Entry)
+			e.Modified = true
+			return e
 		}
 
 		debug.Log("   job %v add old data", j.new.Path())
@@ -602,20 +794,35 @@ func (j archiveJob) Copy() pipe.Job {
 
 const saveIndexTime = 30 * time.Second
 
-// saveIndexes regularly queries the master index for full indexes and saves them.
+// saveIndexes regularly queries the master index for full indexes and saves
+// them. When a checkpoint is due, all indexes are saved.
 func (arch *Archiver) saveIndexes(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	ticker := time.NewTicker(saveIndexTime)
+	interval := saveIndexTime
+	if arch.CheckpointInterval > 0 && arch.CheckpointInterval < interval {
+		interval = arch.CheckpointInterval
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	lastCheckpoint := time.Now()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			debug.Log("saving full indexes")
-			err := arch.repo.SaveFullIndex(ctx)
+			var err error
+			if arch.CheckpointInterval > 0 && time.Since(lastCheckpoint) >= arch.CheckpointInterval {
+				debug.Log("checkpoint, saving all indexes")
+				err = arch.repo.SaveIndex(ctx)
+				lastCheckpoint = time.Now()
+			} else {
+				debug.Log("saving full indexes")
+				err = arch.repo.SaveFullIndex(ctx)
+			}
 			if err != nil {
 				debug.Log("save indexes returned an error: %v", err)
 				fmt.Fprintf(os.Stderr, "error saving preliminary index: %v\n", err)
@@ -723,9 +930,18 @@ func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, t
 	}()
 
 	// run workers
-	for i := 0; i < maxConcurrency; i++ {
-		wg.Add(2)
+	readConcurrency := int(arch.ReadConcurrency)
+	if readConcurrency == 0 {
+		readConcurrency = maxConcurrency
+	}
+
+	for i := 0; i < readConcurrency; i++ {
+		wg.Add(1)
 		go arch.fileWorker(ctx, &wg, p, entCh)
+	}
+
+	for i := 0; i < maxConcurrency; i++ {
+		wg.Add(1)
 		go arch.dirWorker(ctx, &wg, p, dirCh)
 	}
 
@@ -745,6 +961,10 @@ func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, t
 
 	debug.Log("workers terminated")
 
+	if err := arch.saveError(); err != nil {
+		return nil, restic.ID{}, err
+	}
+
 	// flush repository
 	err = arch.repo.Flush()
 	if err != nil {
@@ -756,6 +976,11 @@ func (arch *Archiver) Snapshot(ctx context.Context, p *restic.Progress, paths, t
 	debug.Log("root node received: %v", root.Subtree.Str())
 	sn.Tree = root.Subtree
 
+	if arch.DryRun {
+		debug.Log("dry run, not saving the snapshot")
+		return sn, restic.ID{}, nil
+	}
+
 	// load top-level tree again to see if it is empty
 	toptree, err := arch.repo.LoadTree(ctx, *root.Subtree)
 	if err != nil {
@@ -3,9 +3,14 @@ package archiver
 import (
 	"context"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/pipe"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/walk"
 )
 
@@ -143,3 +148,38 @@ func TestArchivePipe(t *testing.T) {
 		i++
 	}
 }
+
+func TestSaveIndexesCheckpoint(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, err := repo.SaveBlob(context.TODO(), restic.DataBlob, []byte("foobar"), restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush())
+
+	arch := New(repo)
+	arch.CheckpointInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go arch.saveIndexes(ctx, &wg)
+
+	// the index is not full, so it is only saved because of the checkpoint
+	deadline := time.Now().Add(10 * time.Second)
+	for countIndexes(repo) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+
+	rtest.Equals(t, 1, countIndexes(repo))
+}
+
+func countIndexes(repo restic.Repository) (n int) {
+	for range repo.Backend().List(context.TODO(), restic.IndexFile) {
+		n++
+	}
+	return n
+}
@@ -1,14 +1,20 @@
 package azure
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -30,15 +36,28 @@ const defaultListMaxItems = 5000
 // make sure that *Backend implements backend.Backend
 var _ restic.Backend = &Backend{}
 
-func open(cfg Config) (*Backend, error) {
+func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
-	client, err := storage.NewBasicClient(cfg.AccountName, cfg.AccountKey)
-	if err != nil {
-		return nil, errors.Wrap(err, "NewBasicClient")
+	var client storage.Client
+	switch {
+	case cfg.AccountKey != "":
+		var err error
+		client, err = storage.NewBasicClient(cfg.AccountName, cfg.AccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewBasicClient")
+		}
+	case cfg.AccountSAS != "":
+		token, err := url.ParseQuery(strings.TrimPrefix(cfg.AccountSAS, "?"))
+		if err != nil {
+			return nil, errors.Wrap(err, "ParseQuery")
+		}
+		client = storage.NewAccountSASClient(cfg.AccountName, token, azure.PublicCloud)
+	default:
+		return nil, errors.Fatal("azure: neither account key nor SAS token specified")
 	}
 
-	client.HTTPClient = &http.Client{Transport: backend.Transport()}
+	client.HTTPClient = &http.Client{Transport: rt}
 
 	service := client.GetBlobService()
 
@@ -63,14 +82,14 @@ func open(cfg Config) (*Backend, error) {
 }
 
 // Open opens the Azure backend at specified container.
-func Open(cfg Config) (restic.Backend, error) {
-	return open(cfg)
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
 }
 
 // Create opens the Azure backend at specified container and creates the container if
 // it does not exist yet.
-func Create(cfg Config) (restic.Backend, error) {
-	be, err := open(cfg)
+func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "open")
@@ -158,12 +177,70 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err
 
 	debug.Log("InsertObject(%v, %v)", be.container.Name, objName)
 
-	err = be.container.GetBlobReference(objName).CreateBlockBlobFromReader(rd, nil)
+	// read up to blockSize bytes, larger files are uploaded in several
+	// blocks
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, rd, blockSize+1)
+	if err != nil && err != io.EOF {
+		be.sem.ReleaseToken()
+		return errors.Wrap(err, "Read")
+	}
+
+	blob := be.container.GetBlobReference(objName)
+	if n <= blockSize {
+		err = errors.Wrap(blob.CreateBlockBlobFromReader(&buf, nil), "CreateBlockBlobFromReader")
+	} else {
+		err = be.saveLarge(ctx, blob, io.MultiReader(&buf, rd))
+	}
 
 	be.sem.ReleaseToken()
 	debug.Log("%v, err %#v", objName, err)
 
-	return errors.Wrap(err, "CreateBlockBlobFromReader")
+	return err
+}
+
+// blockSize is the size of the blocks used for uploading large files, smaller
+// files are uploaded with a single request.
+const blockSize = 16 * 1024 * 1024
+
+// saveLarge uploads the data read from rd as several blocks and commits them
+// afterwards. A block which cannot be sent is retried without sending the
+// other blocks again. The ID of a block is derived from its position and its
+// data, so when the upload fails nevertheless, the next attempt to save the
+// file skips all blocks which the service already holds as uncommitted
+// blocks of the blob.
+func (be *Backend) saveLarge(ctx context.Context, blob *storage.Blob, rd io.Reader) error {
+	uploaded := make(map[string]bool)
+	list, err := blob.GetBlockList(storage.BlockListTypeUncommitted, nil)
+	if err != nil {
+		debug.Log("unable to list uncommitted blocks: %v", err)
+	}
+	for _, b := range list.UncommittedBlocks {
+		uploaded[b.Name] = true
+	}
+
+	var blocks []storage.Block
+	_, err = backend.UploadParts(ctx, rd, blockSize, func(n int, data []byte) error {
+		// all block IDs of a blob must have the same length
+		sum := md5.Sum(data)
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d-%x", n, sum)))
+		// the function is called again when the upload of a block is retried
+		blocks = append(blocks[:n-1], storage.Block{ID: id, Status: storage.BlockStatusUncommitted})
+
+		if uploaded[id] {
+			debug.Log("block %v has already been uploaded", id)
+			return nil
+		}
+
+		debug.Log("PutBlock %v with %d bytes", id, len(data))
+		return errors.Wrap(blob.PutBlock(id, data, nil), "PutBlock")
+	})
+	if err != nil {
+		return err
+	}
+
+	debug.Log("PutBlockList with %d blocks", len(blocks))
+	return errors.Wrap(blob.PutBlockList(blocks, nil), "PutBlockList")
 }
 
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
@@ -331,7 +408,9 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ScrubFile,
+		restic.SessionFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
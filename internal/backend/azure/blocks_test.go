@@ -0,0 +1,119 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeBlocks implements the requests for block uploads of the blob service.
+// The blob starts with the uncommitted blocks in pending.
+type fakeBlocks struct {
+	sync.Mutex
+	pending map[string][]byte
+
+	// sent contains the IDs of all blocks received
+	sent []string
+
+	// committed contains the data of the blob after the block list has been
+	// committed
+	committed []byte
+}
+
+func (f *fakeBlocks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == "GET" && q.Get("comp") == "blocklist":
+		fmt.Fprint(w, `<BlockList><UncommittedBlocks>`)
+		for id, data := range f.pending {
+			fmt.Fprintf(w, `<Block><Name>%s</Name><Size>%d</Size></Block>`, id, len(data))
+		}
+		fmt.Fprint(w, `</UncommittedBlocks></BlockList>`)
+
+	case r.Method == "PUT" && q.Get("comp") == "block":
+		id := q.Get("blockid")
+		f.sent = append(f.sent, id)
+		f.pending[id] = buf
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == "PUT" && q.Get("comp") == "blocklist":
+		var list struct {
+			IDs []string `xml:"Uncommitted"`
+		}
+		if err := xml.Unmarshal(buf, &list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.committed = nil
+		for _, id := range list.IDs {
+			f.committed = append(f.committed, f.pending[id]...)
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// fakeBlob returns a blob for which all requests are sent to srv.
+func fakeBlob(t testing.TB, srv *httptest.Server) *storage.Blob {
+	client, err := storage.NewEmulatorClient()
+	rtest.OK(t, err)
+
+	client.HTTPClient = &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("tcp", srv.Listener.Addr().String())
+		},
+	}}
+
+	service := client.GetBlobService()
+	return service.GetContainerReference("container").GetBlobReference("blob")
+}
+
+func TestSaveLarge(t *testing.T) {
+	fake := &fakeBlocks{pending: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.TODO()
+	be := &Backend{}
+	blob := fakeBlob(t, srv)
+
+	data := rtest.Random(23, 2*blockSize+100)
+	rtest.OK(t, be.saveLarge(ctx, blob, bytes.NewReader(data)))
+	rtest.Equals(t, 3, len(fake.sent))
+	rtest.Assert(t, bytes.Equal(data, fake.committed), "wrong data committed")
+
+	// when the file is saved again, no block is sent
+	fake.sent = nil
+	fake.committed = nil
+	rtest.OK(t, be.saveLarge(ctx, blob, bytes.NewReader(data)))
+	rtest.Equals(t, 0, len(fake.sent))
+	rtest.Assert(t, bytes.Equal(data, fake.committed), "wrong data committed")
+
+	// blocks with different data are sent again
+	data[len(data)-1]++
+	rtest.OK(t, be.saveLarge(ctx, blob, bytes.NewReader(data)))
+	rtest.Equals(t, 1, len(fake.sent))
+	rtest.Assert(t, bytes.Equal(data, fake.committed), "wrong data committed")
+}
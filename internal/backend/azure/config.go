@@ -13,6 +13,7 @@ import (
 type Config struct {
 	AccountName string
 	AccountKey  string
+	AccountSAS  string
 	Container   string
 	Prefix      string
 
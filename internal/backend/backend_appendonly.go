@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// AppendOnlyBackend wraps a backend so that no data can be removed. Only lock
+// files may be removed, so that the repository can still be locked and
+// unlocked. This only stops restic itself from removing data, the storage
+// must refuse to remove or overwrite files as well, see
+// restic.AppendOnlyChecker.
+type AppendOnlyBackend struct {
+	restic.Backend
+}
+
+// statically ensure that AppendOnlyBackend implements restic.Backend.
+var _ restic.Backend = &AppendOnlyBackend{}
+
+// NewAppendOnlyBackend returns a backend which refuses to remove files in be.
+func NewAppendOnlyBackend(be restic.Backend) *AppendOnlyBackend {
+	return &AppendOnlyBackend{Backend: be}
+}
+
+// ErrAppendOnly is returned when an operation is not allowed for an
+// append-only backend.
+var ErrAppendOnly = errors.New("operation not allowed in append-only mode")
+
+// Remove removes the lock file h, all other files are kept.
+func (be *AppendOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type != restic.LockFile {
+		return errors.Wrapf(ErrAppendOnly, "remove %v", h)
+	}
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (be *AppendOnlyBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
+
+// AbortUploads is refused, incomplete uploads cannot be removed in append-only
+// mode.
+func (be *AppendOnlyBackend) AbortUploads(ctx context.Context, t restic.FileType, before time.Time) (int, error) {
+	return 0, errors.Wrapf(ErrAppendOnly, "abort uploads of %v", t)
+}
@@ -0,0 +1,38 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestAppendOnlyBackend(t *testing.T) {
+	ctx := context.TODO()
+	be := backend.NewAppendOnlyBackend(mem.New())
+
+	data := []byte("foobar")
+	for _, tpe := range []restic.FileType{restic.DataFile, restic.SnapshotFile, restic.LockFile} {
+		h := restic.Handle{Type: tpe, Name: restic.Hash(data).String()}
+
+		rtest.OK(t, be.Save(ctx, h, bytes.NewReader(data)))
+
+		err := be.Remove(ctx, h)
+		if tpe == restic.LockFile {
+			rtest.OK(t, err)
+			continue
+		}
+
+		rtest.Assert(t, errors.Cause(err) == backend.ErrAppendOnly,
+			"removing %v returned wrong error: %v", h, err)
+
+		exists, err := be.Test(ctx, h)
+		rtest.OK(t, err)
+		rtest.Assert(t, exists, "file %v has been removed", h)
+	}
+}
@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/restic/restic/internal/metrics"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MetricsBackend wraps a backend and records the number of bytes transferred
+// and the duration of all requests in package metrics.
+type MetricsBackend struct {
+	restic.Backend
+}
+
+// statically ensure that MetricsBackend implements restic.Backend.
+var _ restic.Backend = &MetricsBackend{}
+
+// NewMetricsBackend returns a backend which records metrics for be.
+func NewMetricsBackend(be restic.Backend) *MetricsBackend {
+	return &MetricsBackend{Backend: be}
+}
+
+func observe(operation string, start time.Time, err error) {
+	metrics.BackendRequestDuration.Observe(operation, time.Since(start))
+	if err != nil {
+		metrics.BackendErrors.Inc()
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	n uint64
+}
+
+func (rd *countingReader) Read(p []byte) (int, error) {
+	n, err := rd.Reader.Read(p)
+	atomic.AddUint64(&rd.n, uint64(n))
+	return n, err
+}
+
+type countingLenReader struct {
+	*countingReader
+	lenner
+}
+
+// lenner is implemented by readers which know how much data is left, some
+// backends use this to avoid buffering the data.
+type lenner interface {
+	Len() int
+}
+
+// Save stores the data in the backend under the given handle.
+func (be *MetricsBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err error) {
+	start := time.Now()
+
+	crd := &countingReader{Reader: rd}
+	var wrd io.Reader = crd
+	if l, ok := rd.(lenner); ok {
+		wrd = countingLenReader{countingReader: crd, lenner: l}
+	}
+
+	err = be.Backend.Save(ctx, h, wrd)
+	observe("save", start, err)
+	if err == nil {
+		metrics.BytesUploaded.Add(atomic.LoadUint64(&crd.n))
+	}
+	return err
+}
+
+// metricsReadCloser records the bytes read when it is closed.
+type metricsReadCloser struct {
+	countingReader
+	closer io.Closer
+}
+
+func (rd *metricsReadCloser) Close() error {
+	metrics.BytesDownloaded.Add(atomic.LoadUint64(&rd.n))
+	return rd.closer.Close()
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. The duration of the request is the time until the reader is
+// returned.
+func (be *MetricsBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rd, err := be.Backend.Load(ctx, h, length, offset)
+	observe("load", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsReadCloser{countingReader: countingReader{Reader: rd}, closer: rd}, nil
+}
+
+// Stat returns information about the File identified by h.
+func (be *MetricsBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	start := time.Now()
+	fi, err := be.Backend.Stat(ctx, h)
+	if err != nil && be.Backend.IsNotExist(err) {
+		// a file which does not exist is not a failed request
+		observe("stat", start, nil)
+		return fi, err
+	}
+
+	observe("stat", start, err)
+	return fi, err
+}
+
+// Test a boolean value whether a File with the name and type exists.
+func (be *MetricsBackend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	start := time.Now()
+	ok, err := be.Backend.Test(ctx, h)
+	observe("test", start, err)
+	return ok, err
+}
+
+// Remove removes a File with type t and name.
+func (be *MetricsBackend) Remove(ctx context.Context, h restic.Handle) error {
+	start := time.Now()
+	err := be.Backend.Remove(ctx, h)
+	observe("remove", start, err)
+	return err
+}
+
+// List returns a channel that yields all names of files of type t. The
+// duration of the request is the time until all names have been listed.
+func (be *MetricsBackend) List(ctx context.Context, t restic.FileType) <-chan string {
+	start := time.Now()
+	in := be.Backend.List(ctx, t)
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+		defer observe("list", start, nil)
+
+		for name := range in {
+			select {
+			case out <- name:
+			case <-ctx.Done():
+				// drain the channel so that the goroutine of the backend
+				// can finish
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// CopyFrom copies the file h from src, see restic.ServerSideCopier. No bytes
+// are counted, the data is not transferred by the client.
+func (be *MetricsBackend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	start := time.Now()
+	ok, err := restic.CopyFrom(ctx, be.Backend, src, h)
+	observe("copy", start, err)
+	return ok, err
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (be *MetricsBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
@@ -0,0 +1,50 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/metrics"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMetricsBackend(t *testing.T) {
+	be := backend.NewMetricsBackend(mem.New())
+
+	uploaded := metrics.BytesUploaded.Value()
+	downloaded := metrics.BytesDownloaded.Value()
+	saves := metrics.BackendRequestDuration.Count("save")
+	errs := metrics.BackendErrors.Value()
+
+	data := rtest.Random(23, 1234)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, bytes.NewReader(data)))
+
+	rtest.Equals(t, uploaded+uint64(len(data)), metrics.BytesUploaded.Value())
+	rtest.Equals(t, saves+1, metrics.BackendRequestDuration.Count("save"))
+
+	rd, err := be.Load(context.TODO(), h, 100, 10)
+	rtest.OK(t, err)
+	buf, err := ioutil.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+	rtest.Assert(t, bytes.Equal(data[10:110], buf), "wrong data returned")
+
+	rtest.Equals(t, downloaded+100, metrics.BytesDownloaded.Value())
+
+	// a file which does not exist is not counted as an error
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foo"})
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+	rtest.Equals(t, errs, metrics.BackendErrors.Value())
+
+	var names []string
+	for name := range be.List(context.TODO(), restic.DataFile) {
+		names = append(names, name)
+	}
+	rtest.Equals(t, []string{h.Name}, names)
+}
@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ReadOnlyBackend wraps a backend so that no data can be added or removed.
+// Only lock files may be saved and removed, so that the repository can still
+// be locked and unlocked.
+type ReadOnlyBackend struct {
+	restic.Backend
+}
+
+// statically ensure that ReadOnlyBackend implements restic.Backend.
+var _ restic.Backend = &ReadOnlyBackend{}
+
+// NewReadOnlyBackend returns a backend which refuses to save or remove files
+// in be.
+func NewReadOnlyBackend(be restic.Backend) *ReadOnlyBackend {
+	return &ReadOnlyBackend{Backend: be}
+}
+
+// ErrReadOnly is returned when an operation is not allowed for a read-only
+// backend.
+var ErrReadOnly = errors.New("operation not allowed in read-only mode")
+
+// Save stores the lock file h, all other files are refused.
+func (be *ReadOnlyBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	if h.Type != restic.LockFile {
+		return errors.Wrapf(ErrReadOnly, "save %v", h)
+	}
+
+	return be.Backend.Save(ctx, h, rd)
+}
+
+// Remove removes the lock file h, all other files are kept.
+func (be *ReadOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if h.Type != restic.LockFile {
+		return errors.Wrapf(ErrReadOnly, "remove %v", h)
+	}
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// CopyFrom refuses to copy any file, see restic.ServerSideCopier.
+func (be *ReadOnlyBackend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	return false, errors.Wrapf(ErrReadOnly, "copy %v", h)
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (be *ReadOnlyBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
+
+// ExtendRetention is refused, the retention of files cannot be changed in
+// read-only mode.
+func (be *ReadOnlyBackend) ExtendRetention(ctx context.Context, h restic.Handle, until time.Time) error {
+	return errors.Wrapf(ErrReadOnly, "extend retention of %v", h)
+}
+
+// AbortUploads is refused, incomplete uploads cannot be removed in read-only
+// mode.
+func (be *ReadOnlyBackend) AbortUploads(ctx context.Context, t restic.FileType, before time.Time) (int, error) {
+	return 0, errors.Wrapf(ErrReadOnly, "abort uploads of %v", t)
+}
@@ -0,0 +1,50 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestReadOnlyBackend(t *testing.T) {
+	ctx := context.TODO()
+	mb := mem.New()
+	be := backend.NewReadOnlyBackend(mb)
+
+	data := []byte("foobar")
+	for _, tpe := range []restic.FileType{restic.DataFile, restic.SnapshotFile, restic.LockFile} {
+		h := restic.Handle{Type: tpe, Name: restic.Hash(data).String()}
+
+		err := be.Save(ctx, h, bytes.NewReader(data))
+		if tpe == restic.LockFile {
+			rtest.OK(t, err)
+			rtest.OK(t, be.Remove(ctx, h))
+			continue
+		}
+
+		rtest.Assert(t, errors.Cause(err) == backend.ErrReadOnly,
+			"saving %v returned wrong error: %v", h, err)
+
+		// existing files can be read, but not removed
+		rtest.OK(t, mb.Save(ctx, h, bytes.NewReader(data)))
+		buf, err := backend.LoadAll(ctx, be, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+
+		err = be.Remove(ctx, h)
+		rtest.Assert(t, errors.Cause(err) == backend.ErrReadOnly,
+			"removing %v returned wrong error: %v", h, err)
+
+		_, err = be.CopyFrom(ctx, mem.New(), h)
+		rtest.Assert(t, errors.Cause(err) == backend.ErrReadOnly,
+			"copying %v returned wrong error: %v", h, err)
+	}
+
+	rtest.Assert(t, restic.Unwrap(be) == mb, "Unwrap returned a different backend")
+}
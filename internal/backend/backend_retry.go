@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// RetryBackend retries operations on the backend in case of an error with a
+// backoff.
+type RetryBackend struct {
+	restic.Backend
+	MaxTries int
+	MaxDelay time.Duration
+	Report   func(string, error, time.Duration)
+}
+
+// statically ensure that RetryBackend implements restic.Backend.
+var _ restic.Backend = &RetryBackend{}
+
+// initialRetryDelay is the time to wait before the first retry, it is doubled
+// for each subsequent retry until MaxDelay is reached.
+var initialRetryDelay = 500 * time.Millisecond
+
+// NewRetryBackend wraps be with a backend that retries operations after a
+// backoff. report is called with a description and the error, if one occurred.
+func NewRetryBackend(be restic.Backend, maxTries int, maxDelay time.Duration, report func(string, error, time.Duration)) *RetryBackend {
+	return &RetryBackend{
+		Backend:  be,
+		MaxTries: maxTries,
+		MaxDelay: maxDelay,
+		Report:   report,
+	}
+}
+
+// jitter returns a random duration between d/2 and 3d/2.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// retry runs f until it returns nil, MaxTries attempts have been made, or the
+// context is cancelled. Errors for which the underlying backend reports that
+// the file does not exist are not retried.
+func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) error {
+	delay := initialRetryDelay
+	if be.MaxDelay > 0 && delay > be.MaxDelay {
+		delay = be.MaxDelay
+	}
+
+	for try := 1; ; try++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		if try >= be.MaxTries || be.Backend.IsNotExist(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := jitter(delay)
+		debug.Log("%v failed (try %d): %v, retrying after %v", msg, try, err, wait)
+		if be.Report != nil {
+			be.Report(msg, err, wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if be.MaxDelay > 0 && delay > be.MaxDelay {
+			delay = be.MaxDelay
+		}
+	}
+}
+
+// Save stores the data in the backend under the given handle. The data is
+// only uploaded again if rd can be rewound to the start position.
+func (be *RetryBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	seeker, ok := rd.(io.Seeker)
+	if !ok {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	first := true
+	return be.retry(ctx, fmt.Sprintf("Save(%v)", h), func() error {
+		if !first {
+			// remove the (possibly incomplete) file from the failed attempt,
+			// the data is uploaded again below
+			_ = be.Backend.Remove(ctx, h)
+
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return errors.Wrap(err, "Seek")
+			}
+		}
+		first = false
+
+		return be.Backend.Save(ctx, h, rd)
+	})
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. If length is larger than zero, only a portion of the file
+// is returned. rd must be closed after use. Only opening the file is retried.
+func (be *RetryBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (rd io.ReadCloser, err error) {
+	err = be.retry(ctx, fmt.Sprintf("Load(%v, %v, %v)", h, length, offset),
+		func() error {
+			var innerErr error
+			rd, innerErr = be.Backend.Load(ctx, h, length, offset)
+
+			return innerErr
+		})
+	return rd, err
+}
+
+// Stat returns information about the File identified by h.
+func (be *RetryBackend) Stat(ctx context.Context, h restic.Handle) (fi restic.FileInfo, err error) {
+	err = be.retry(ctx, fmt.Sprintf("Stat(%v)", h),
+		func() error {
+			var innerError error
+			fi, innerError = be.Backend.Stat(ctx, h)
+
+			return innerError
+		})
+	return fi, err
+}
+
+// Remove removes a File with type t and name.
+func (be *RetryBackend) Remove(ctx context.Context, h restic.Handle) (err error) {
+	return be.retry(ctx, fmt.Sprintf("Remove(%v)", h), func() error {
+		return be.Backend.Remove(ctx, h)
+	})
+}
+
+// Test a boolean value whether a File with the name and type exists.
+func (be *RetryBackend) Test(ctx context.Context, h restic.Handle) (exists bool, err error) {
+	err = be.retry(ctx, fmt.Sprintf("Test(%v)", h), func() error {
+		var innerError error
+		exists, innerError = be.Backend.Test(ctx, h)
+
+		return innerError
+	})
+	return exists, err
+}
+
+// Thaw requests that the file at h is made readable, see restic.Thawer.
+func (be *RetryBackend) Thaw(ctx context.Context, h restic.Handle) (ready bool, err error) {
+	err = be.retry(ctx, fmt.Sprintf("Thaw(%v)", h), func() error {
+		var innerError error
+		ready, innerError = restic.Thaw(ctx, be.Backend, h)
+
+		return innerError
+	})
+	return ready, err
+}
+
+// CopyFrom copies the file h from src, see restic.ServerSideCopier.
+func (be *RetryBackend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (copied bool, err error) {
+	err = be.retry(ctx, fmt.Sprintf("CopyFrom(%v)", h), func() error {
+		var innerError error
+		copied, innerError = restic.CopyFrom(ctx, be.Backend, src, h)
+
+		return innerError
+	})
+	return copied, err
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (be *RetryBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
@@ -0,0 +1,105 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/mock"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestBackendRetrySave(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	errcount := 0
+	be := &mock.Backend{
+		SaveFn: func(ctx context.Context, h restic.Handle, rd io.Reader) error {
+			if errcount == 0 {
+				errcount++
+				_, err := io.CopyN(ioutil.Discard, rd, 120)
+				if err != nil {
+					return err
+				}
+
+				return errors.New("injected error")
+			}
+
+			_, err := io.Copy(buf, rd)
+			return err
+		},
+		RemoveFn: func(ctx context.Context, h restic.Handle) error {
+			return nil
+		},
+	}
+
+	var reports int
+	retryBackend := backend.NewRetryBackend(be, 10, time.Millisecond, func(msg string, err error, d time.Duration) {
+		reports++
+	})
+
+	data := rtest.Random(23, 5*1024*1024+11241)
+	err := retryBackend.Save(context.TODO(), restic.Handle{}, bytes.NewReader(data))
+	rtest.OK(t, err)
+
+	if len(data) != buf.Len() {
+		t.Errorf("wrong number of bytes written: want %d, got %d", len(data), buf.Len())
+	}
+
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("wrong data written to backend")
+	}
+
+	if reports != 1 {
+		t.Errorf("wrong number of reports: want 1, got %d", reports)
+	}
+}
+
+func TestBackendRetryMaxTries(t *testing.T) {
+	var calls int
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			calls++
+			return restic.FileInfo{}, errors.New("injected error")
+		},
+	}
+
+	retryBackend := backend.NewRetryBackend(be, 3, time.Millisecond, nil)
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{})
+	if err == nil {
+		t.Fatal("expected error not found")
+	}
+
+	if calls != 3 {
+		t.Errorf("wrong number of calls: want 3, got %d", calls)
+	}
+}
+
+func TestBackendRetryNotExist(t *testing.T) {
+	notFound := errors.New("not found")
+	var calls int
+	be := &mock.Backend{
+		LoadFn: func(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+			calls++
+			return nil, notFound
+		},
+		IsNotExistFn: func(err error) bool {
+			return err == notFound
+		},
+	}
+
+	retryBackend := backend.NewRetryBackend(be, 10, time.Millisecond, nil)
+	_, err := retryBackend.Load(context.TODO(), restic.Handle{}, 0, 0)
+	if err != notFound {
+		t.Fatalf("wrong error returned: %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("missing file was retried, %d calls", calls)
+	}
+}
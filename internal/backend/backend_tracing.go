@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/tracing"
+)
+
+// TracingBackend wraps a backend and records a span for each request, see
+// package tracing.
+type TracingBackend struct {
+	restic.Backend
+}
+
+// statically ensure that TracingBackend implements restic.Backend.
+var _ restic.Backend = &TracingBackend{}
+
+// NewTracingBackend returns a backend which records spans for be.
+func NewTracingBackend(be restic.Backend) *TracingBackend {
+	return &TracingBackend{Backend: be}
+}
+
+func startSpan(ctx context.Context, operation string, h restic.Handle, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	attrs = append(attrs,
+		tracing.String("restic.file.type", string(h.Type)),
+		tracing.String("restic.file.name", h.Name))
+	return tracing.Start(ctx, "backend."+operation, attrs...)
+}
+
+// Save stores the data in the backend under the given handle.
+func (be *TracingBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	ctx, span := startSpan(ctx, "save", h)
+
+	crd := &countingReader{Reader: rd}
+	var wrd io.Reader = crd
+	if l, ok := rd.(lenner); ok {
+		wrd = countingLenReader{countingReader: crd, lenner: l}
+	}
+
+	err := be.Backend.Save(ctx, h, wrd)
+	span.SetAttributes(tracing.Int64("restic.bytes", int64(atomic.LoadUint64(&crd.n))))
+	tracing.End(span, err)
+	return err
+}
+
+// tracingReadCloser ends the span of a load request when it is closed, so
+// that the span includes the time spent reading the data.
+type tracingReadCloser struct {
+	countingReader
+	closer io.Closer
+	span   *tracing.Span
+}
+
+func (rd *tracingReadCloser) Close() error {
+	err := rd.closer.Close()
+	rd.span.SetAttributes(tracing.Int64("restic.bytes", int64(atomic.LoadUint64(&rd.n))))
+	tracing.End(rd.span, err)
+	return err
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. The span ends when the reader is closed.
+func (be *TracingBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	ctx, span := startSpan(ctx, "load", h,
+		tracing.Int("restic.length", length),
+		tracing.Int64("restic.offset", offset))
+
+	rd, err := be.Backend.Load(ctx, h, length, offset)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	return &tracingReadCloser{countingReader: countingReader{Reader: rd}, closer: rd, span: span}, nil
+}
+
+// Stat returns information about the File identified by h.
+func (be *TracingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	ctx, span := startSpan(ctx, "stat", h)
+	fi, err := be.Backend.Stat(ctx, h)
+	if err != nil && be.Backend.IsNotExist(err) {
+		// a file which does not exist is not a failed request
+		tracing.End(span, nil)
+		return fi, err
+	}
+
+	tracing.End(span, err)
+	return fi, err
+}
+
+// Test a boolean value whether a File with the name and type exists.
+func (be *TracingBackend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	ctx, span := startSpan(ctx, "test", h)
+	ok, err := be.Backend.Test(ctx, h)
+	tracing.End(span, err)
+	return ok, err
+}
+
+// Remove removes a File with type t and name.
+func (be *TracingBackend) Remove(ctx context.Context, h restic.Handle) error {
+	ctx, span := startSpan(ctx, "remove", h)
+	err := be.Backend.Remove(ctx, h)
+	tracing.End(span, err)
+	return err
+}
+
+// List returns a channel that yields all names of files of type t. The span
+// ends when all names have been listed.
+func (be *TracingBackend) List(ctx context.Context, t restic.FileType) <-chan string {
+	ctx, span := tracing.Start(ctx, "backend.list",
+		tracing.String("restic.file.type", string(t)))
+	in := be.Backend.List(ctx, t)
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+
+		n := 0
+		defer func() {
+			span.SetAttributes(tracing.Int("restic.files", n))
+			tracing.End(span, ctx.Err())
+		}()
+
+		for name := range in {
+			select {
+			case out <- name:
+				n++
+			case <-ctx.Done():
+				// drain the channel so that the goroutine of the backend
+				// can finish
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// CopyFrom copies the file h from src and records a span for the request,
+// see restic.ServerSideCopier.
+func (be *TracingBackend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	ctx, span := startSpan(ctx, "copy", h)
+	ok, err := restic.CopyFrom(ctx, be.Backend, src, h)
+	tracing.End(span, err)
+	return ok, err
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (be *TracingBackend) Unwrap() restic.Backend {
+	return be.Backend
+}
@@ -0,0 +1,97 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/tracing"
+)
+
+type testSpan struct {
+	Name       string
+	Attributes []struct {
+		Key   string
+		Value struct {
+			IntValue string
+		}
+	}
+	Status struct {
+		Code int
+	}
+}
+
+func (s testSpan) attr(key string) string {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			return attr.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func TestTracingBackend(t *testing.T) {
+	buf := &bytes.Buffer{}
+	shutdown, err := tracing.Setup(tracing.ExporterStderr, "test", buf)
+	rtest.OK(t, err)
+
+	be := backend.NewTracingBackend(mem.New())
+
+	data := rtest.Random(23, 1234)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, bytes.NewReader(data)))
+
+	rd, err := be.Load(context.TODO(), h, 100, 10)
+	rtest.OK(t, err)
+	loaded, err := ioutil.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data[10:110], loaded), "wrong data returned")
+	rtest.OK(t, rd.Close())
+
+	// a file which does not exist is not recorded as an error
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foo"})
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+
+	err = be.Remove(context.TODO(), restic.Handle{Type: restic.DataFile, Name: "foo"})
+	rtest.Assert(t, err != nil, "expected error for removing a missing file")
+
+	var names []string
+	for name := range be.List(context.TODO(), restic.DataFile) {
+		names = append(names, name)
+	}
+	rtest.Equals(t, []string{h.Name}, names)
+
+	rtest.OK(t, shutdown())
+
+	var spans []testSpan
+	dec := json.NewDecoder(buf)
+	for {
+		var span testSpan
+		err := dec.Decode(&span)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		spans = append(spans, span)
+	}
+
+	var ops []string
+	for _, span := range spans {
+		ops = append(ops, span.Name)
+	}
+	rtest.Equals(t, []string{"backend.save", "backend.load", "backend.stat", "backend.remove", "backend.list"}, ops)
+
+	rtest.Equals(t, "1234", spans[0].attr("restic.bytes"))
+	// the span of a load request ends when the reader is closed
+	rtest.Equals(t, "100", spans[1].attr("restic.bytes"))
+
+	rtest.Equals(t, 0, spans[2].Status.Code)
+	rtest.Equals(t, 2, spans[3].Status.Code)
+}
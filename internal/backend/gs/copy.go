@@ -0,0 +1,56 @@
+package gs
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// make sure that *Backend implements restic.ServerSideCopier
+var _ restic.ServerSideCopier = &Backend{}
+
+// CopyFrom copies the file h from src with rewrite requests, so that the data
+// is not transferred through the client. This is possible for all GCS
+// backends, the credentials of this backend must allow reading the file in
+// the bucket of src.
+func (be *Backend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	if err := h.Valid(); err != nil {
+		return false, err
+	}
+
+	other, ok := restic.Unwrap(src).(*Backend)
+	if !ok {
+		return false, nil
+	}
+
+	srcName := other.Filename(h)
+	objName := be.Filename(h)
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	// large objects are copied with several requests, each one returns a
+	// token for the next one
+	token := ""
+	for {
+		debug.Log("Rewrite(%v/%v -> %v/%v)", other.bucketName, srcName, be.bucketName, objName)
+		call := be.service.Objects.Rewrite(other.bucketName, srcName, be.bucketName, objName, &storage.Object{}).Context(ctx)
+		if token != "" {
+			call = call.RewriteToken(token)
+		}
+
+		res, err := call.Do()
+		if err != nil {
+			return false, errors.Wrap(err, "service.Objects.Rewrite")
+		}
+
+		if res.Done {
+			return true, nil
+		}
+		token = res.RewriteToken
+	}
+}
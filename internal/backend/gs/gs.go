@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -16,11 +17,15 @@ import (
 
 	"io/ioutil"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
 	storage "google.golang.org/api/storage/v1"
 )
 
+// chunkSize is the size of the chunks of a resumable upload.
+const chunkSize = 16 * 1024 * 1024
+
 // Backend stores data in a GCS bucket.
 //
 // The service account used to access the bucket must have these permissions:
@@ -41,38 +46,66 @@ type Backend struct {
 // Ensure that *Backend implements restic.Backend.
 var _ restic.Backend = &Backend{}
 
-func getStorageService(jsonKeyPath string) (*storage.Service, error) {
+// getStorageService returns a storage service authenticated with the service
+// account key in the JSON file at jsonKeyPath. If jsonKeyPath is empty, the
+// Application Default Credentials are used. The project ID found in the
+// default credentials (if any) is returned as well.
+func getStorageService(jsonKeyPath string, rt http.RoundTripper) (*storage.Service, string, error) {
+	// use the given transport for all requests, including the ones for
+	// fetching tokens
+	httpClient := &http.Client{Transport: rt}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
+	var (
+		ts        oauth2.TokenSource
+		projectID string
+	)
+
+	if jsonKeyPath != "" {
+		raw, err := ioutil.ReadFile(jsonKeyPath)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "ReadFile")
+		}
 
-	raw, err := ioutil.ReadFile(jsonKeyPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "ReadFile")
-	}
+		conf, err := google.JWTConfigFromJSON(raw, storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, "", err
+		}
 
-	conf, err := google.JWTConfigFromJSON(raw, storage.DevstorageReadWriteScope)
-	if err != nil {
-		return nil, err
-	}
+		ts = conf.TokenSource(ctx)
+	} else {
+		debug.Log("no JSON key file specified, using default credentials")
+		creds, err := google.FindDefaultCredentials(ctx, storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, "", err
+		}
 
-	client := conf.Client(context.TODO())
+		ts = creds.TokenSource
+		projectID = creds.ProjectID
+	}
 
-	service, err := storage.New(client)
+	service, err := storage.New(oauth2.NewClient(ctx, ts))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return service, nil
+	return service, projectID, nil
 }
 
 const defaultListMaxItems = 1000
 
-func open(cfg Config) (*Backend, error) {
+func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
-	service, err := getStorageService(cfg.JSONKeyPath)
+	service, projectID, err := getStorageService(cfg.JSONKeyPath, rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "getStorageService")
 	}
 
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = projectID
+	}
+
 	sem, err := backend.NewSemaphore(cfg.Connections)
 	if err != nil {
 		return nil, err
@@ -95,8 +128,8 @@ func open(cfg Config) (*Backend, error) {
 }
 
 // Open opens the gs backend at the specified bucket.
-func Open(cfg Config) (restic.Backend, error) {
-	return open(cfg)
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
 }
 
 // Create opens the gs backend at the specified bucket and attempts to creates
@@ -104,8 +137,8 @@ func Open(cfg Config) (restic.Backend, error) {
 //
 // The service account must have the "storage.buckets.create" permission to
 // create a bucket the does not yet exist.
-func Create(cfg Config) (restic.Backend, error) {
-	be, err := open(cfg)
+func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "open")
 	}
@@ -214,10 +247,12 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err
 
 	debug.Log("InsertObject(%v, %v)", be.bucketName, objName)
 
+	// Files larger than a chunk are sent with a resumable upload, in which a
+	// chunk that fails to upload is retried on its own.
 	info, err := be.service.Objects.Insert(be.bucketName,
 		&storage.Object{
 			Name: objName,
-		}).Media(rd).Do()
+		}).Media(rd, googleapi.ChunkSize(chunkSize)).Context(ctx).Do()
 
 	be.sem.ReleaseToken()
 
@@ -401,7 +436,9 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ScrubFile,
+		restic.SessionFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -1,18 +1,49 @@
 package backend
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 )
 
+// TransportOptions collects various options which can be set for an HTTP based
+// transport.
+type TransportOptions struct {
+	// RootCertFilenames contains the names of files with PEM encoded root
+	// certificates to trust instead of the ones of the system.
+	RootCertFilenames []string
+
+	// TLSClientCertKeyFilename is the name of a file which contains the PEM
+	// encoded TLS client certificate and its private key.
+	TLSClientCertKeyFilename string
+
+	// Proxy is the URL of the proxy used for all connections, the schemes
+	// http, https, socks5 and socks5h are supported. Credentials for the
+	// proxy are given in the user info part of the URL. If Proxy is empty,
+	// the proxy is taken from the environment.
+	Proxy string
+}
+
 // Transport returns a new http.RoundTripper with default settings applied.
-func Transport() http.RoundTripper {
+func Transport(opts TransportOptions) (http.RoundTripper, error) {
+	proxy, err := proxyFunc(opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
 	// copied from net/http
 	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: proxy,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
@@ -25,6 +56,183 @@ func Transport() http.RoundTripper {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
+	if opts.TLSClientCertKeyFilename != "" || opts.RootCertFilenames != nil {
+		// a custom TLS config disables HTTP/2 unless it is requested explicitly
+		tr.TLSClientConfig = &tls.Config{}
+		tr.ForceAttemptHTTP2 = true
+	}
+
+	if opts.TLSClientCertKeyFilename != "" {
+		crt, err := readPEMCertKey(opts.TLSClientCertKeyFilename)
+		if err != nil {
+			return nil, err
+		}
+
+		tr.TLSClientConfig.Certificates = []tls.Certificate{crt}
+	}
+
+	if opts.RootCertFilenames != nil {
+		pool := x509.NewCertPool()
+		for _, filename := range opts.RootCertFilenames {
+			buf, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, errors.Fatalf("unable to read root certificates: %v", err)
+			}
+
+			if ok := pool.AppendCertsFromPEM(buf); !ok {
+				return nil, errors.Fatalf("no root certificates found in %v", filename)
+			}
+		}
+
+		tr.TLSClientConfig.RootCAs = pool
+	}
+
 	// wrap in the debug round tripper
-	return debug.RoundTripper(tr)
+	return debug.RoundTripper(tr), nil
+}
+
+// readPEMCertKey reads a TLS client certificate and its private key from a
+// file with PEM encoded blocks, the certificate chain may consist of several
+// certificates.
+func readPEMCertKey(filename string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return tls.Certificate{}, errors.Fatalf("unable to read TLS client certificate: %v", err)
+	}
+
+	var certs, key []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE":
+			certs = append(certs, pem.EncodeToMemory(block)...)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			if key != nil {
+				return tls.Certificate{}, errors.Fatalf("%v contains more than one private key", filename)
+			}
+			key = pem.EncodeToMemory(block)
+		default:
+			debug.Log("ignoring PEM block of type %q in %v", block.Type, filename)
+		}
+	}
+
+	if certs == nil {
+		return tls.Certificate{}, errors.Fatalf("no certificate found in %v", filename)
+	}
+	if key == nil {
+		return tls.Certificate{}, errors.Fatalf("no private key found in %v", filename)
+	}
+
+	crt, err := tls.X509KeyPair(certs, key)
+	if err != nil {
+		return tls.Certificate{}, errors.Fatalf("invalid TLS client certificate in %v: %v", filename, err)
+	}
+
+	return crt, nil
+}
+
+// proxyFunc returns the function which selects the proxy for a request. For
+// an empty proxy, the variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY are
+// used, with ALL_PROXY as the fallback for requests which none of them
+// matches.
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxy != "" {
+		u, err := parseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		debug.Log("using proxy %v", u.Redacted())
+		return http.ProxyURL(u), nil
+	}
+
+	allProxy := getenv("ALL_PROXY")
+	if allProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	all, err := parseProxy(allProxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "ALL_PROXY")
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := http.ProxyFromEnvironment(req)
+		if u != nil || err != nil {
+			return u, err
+		}
+
+		if useProxy(req.URL.Host, getenv("NO_PROXY")) {
+			return all, nil
+		}
+		return nil, nil
+	}, nil
+}
+
+// parseProxy parses the URL of a proxy, a URL without a scheme refers to an
+// HTTP proxy.
+func parseProxy(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Fatalf("invalid proxy URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.Fatalf("invalid proxy URL %q: unsupported scheme %q", u.Redacted(), u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.Fatalf("invalid proxy URL %q: host is missing", u.Redacted())
+	}
+
+	return u, nil
+}
+
+// getenv returns the value of the environment variable name, or of the lower
+// case variant if the former is not set.
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// useProxy returns false if host (which may include a port) matches one of
+// the comma separated entries in noProxy. An entry matches the host itself
+// and all its subdomains, "*" matches all hosts.
+func useProxy(host, noProxy string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return false
+		case host == entry, strings.HasSuffix(host, "."+entry):
+			return false
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,61 @@
+package ipfs
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to the HTTP API of
+// an IPFS node.
+type Config struct {
+	// URL is the address of the HTTP API, without the path.
+	URL *url.URL
+
+	// Path is the directory in the mutable file system (MFS) of the node
+	// which refers to the files of the repository.
+	Path string
+
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+}
+
+func init() {
+	options.Register("ipfs", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the API URL and the MFS path,
+// e.g. "ipfs:http://localhost:5001/restic".
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "ipfs:") {
+		return nil, errors.New("invalid IPFS backend specification")
+	}
+
+	u, err := url.Parse(s[5:])
+	if err != nil {
+		return nil, errors.Wrap(err, "url.Parse")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid IPFS API URL %q, scheme must be http or https", s[5:])
+	}
+
+	p := path.Clean("/" + u.Path)
+	if p == "/" {
+		return nil, errors.New("IPFS backend: path of the repository in the MFS is missing")
+	}
+
+	cfg := NewConfig()
+	cfg.URL = &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}
+	cfg.Path = p
+	return cfg, nil
+}
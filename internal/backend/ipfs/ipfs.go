@@ -0,0 +1,452 @@
+// Package ipfs implements an experimental backend which stores the files of a
+// repository on an IPFS node via its HTTP API.
+//
+// The data of each file is added to the node as content-addressed blocks. The
+// names of the files are kept in the mutable file system (MFS) of the node,
+// which links each name to the content identifier (CID) of the data. The MFS
+// also keeps the data from being removed by the garbage collection of the
+// node, removing a file only unlinks its name.
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend stores data on an IPFS node.
+type Backend struct {
+	cfg    Config
+	sem    *backend.Semaphore
+	client *http.Client
+	backend.Layout
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
+	debug.Log("open, config %v %v", cfg.URL.Host, cfg.Path)
+
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	be := &Backend{
+		cfg:    cfg,
+		sem:    sem,
+		client: &http.Client{Transport: rt},
+		Layout: &backend.DefaultLayout{
+			Path: cfg.Path,
+			Join: path.Join,
+		},
+	}
+
+	return be, nil
+}
+
+// Open opens the repository at the MFS path on the IPFS node. All requests are
+// sent via the round tripper rt.
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
+}
+
+// Create creates the directory for a new repository in the MFS of the IPFS
+// node.
+func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	be.sem.GetToken()
+	err = be.call(context.TODO(), "files/mkdir", url.Values{"arg": {cfg.Path}, "parents": {"true"}}, nil, "", nil)
+	be.sem.ReleaseToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return be, nil
+}
+
+// apiError is returned by the HTTP API of the IPFS node for failed commands.
+type apiError struct {
+	Message string
+	Code    int
+}
+
+func (e *apiError) Error() string {
+	return "ipfs: " + e.Message
+}
+
+// call runs the API command with the parameters and decodes the JSON response
+// into res, which may be nil.
+func (be *Backend) call(ctx context.Context, cmd string, params url.Values, body io.Reader, contentType string, res interface{}) error {
+	resp, err := be.post(ctx, cmd, params, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	cerr := resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "Decode")
+	}
+
+	return errors.Wrap(cerr, "Close")
+}
+
+// post sends the API command to the IPFS node and returns the response, which
+// must be closed by the caller if no error is returned. All commands of the
+// API use POST requests.
+func (be *Backend) post(ctx context.Context, cmd string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := *be.cfg.URL
+	u.Path = "/api/v0/" + cmd
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequest")
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := ctxhttp.Do(ctx, be.client, req)
+	if err != nil {
+		return nil, errors.Wrap(err, cmd)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	apiErr := &apiError{}
+	if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+		return nil, errors.Errorf("%v: unexpected HTTP response (%v): %v", cmd, resp.StatusCode, resp.Status)
+	}
+
+	debug.Log("%v %v failed: %v", cmd, params, apiErr.Message)
+	return nil, apiErr
+}
+
+// Location returns this backend's location, the API URL and the MFS path.
+func (be *Backend) Location() string {
+	return fmt.Sprintf("ipfs:%s://%s%s", be.cfg.URL.Scheme, be.cfg.URL.Host, be.cfg.Path)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	e, ok := errors.Cause(err).(*apiError)
+	return ok && strings.Contains(e.Message, "does not exist")
+}
+
+// addResult is the response of the add command.
+type addResult struct {
+	Hash string
+	Size string
+}
+
+// Save adds the data to the IPFS node and links it into the MFS at the
+// filename for h.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	// the data is sent as a multipart form, which is assembled while it is
+	// uploaded
+	pr, pw := io.Pipe()
+	defer func() {
+		_ = pr.Close()
+	}()
+
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", path.Base(be.Filename(h)))
+		if err == nil {
+			_, err = io.Copy(part, rd)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	params := url.Values{
+		"pin":         {"true"},
+		"cid-version": {"1"},
+		"raw-leaves":  {"true"},
+		"quieter":     {"true"},
+	}
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	var res addResult
+	err := be.call(ctx, "add", params, pr, mw.FormDataContentType(), &res)
+	if err != nil {
+		return err
+	}
+	if res.Hash == "" {
+		return errors.Errorf("add %v: no CID returned", h)
+	}
+
+	debug.Log("%v added as %v", h, res.Hash)
+
+	// the MFS refers to the data from now on, which also protects it from
+	// the garbage collection, so the pin is only needed while the link does
+	// not exist yet
+	err = be.call(ctx, "files/cp", url.Values{
+		"arg":     {"/ipfs/" + res.Hash, be.Filename(h)},
+		"parents": {"true"},
+	}, nil, "", nil)
+
+	if uerr := be.call(ctx, "pin/rm", url.Values{"arg": {res.Hash}}, nil, "", nil); uerr != nil {
+		debug.Log("unable to remove pin for %v: %v", res.Hash, uerr)
+	}
+
+	return err
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. If length is nonzero, only a portion of the file is
+// returned. rd must be closed after use.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	params := url.Values{
+		"arg":    {be.Filename(h)},
+		"offset": {strconv.FormatInt(offset, 10)},
+	}
+	if length > 0 {
+		params.Set("count", strconv.Itoa(length))
+	}
+
+	be.sem.GetToken()
+	resp, err := be.post(ctx, "files/read", params, nil, "")
+	if err != nil {
+		be.sem.ReleaseToken()
+		return nil, err
+	}
+
+	closeRd := wrapReader{
+		ReadCloser: resp.Body,
+		f: func() {
+			debug.Log("Close()")
+			be.sem.ReleaseToken()
+		},
+	}
+
+	return closeRd, nil
+}
+
+// wrapReader wraps an io.ReadCloser to run an additional function on Close.
+type wrapReader struct {
+	io.ReadCloser
+	f func()
+}
+
+func (wr wrapReader) Close() error {
+	err := wr.ReadCloser.Close()
+	wr.f()
+	return err
+}
+
+// statResult is the response of the files/stat command.
+type statResult struct {
+	Hash string
+	Size int64
+	Type string
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat %v", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	var res statResult
+	be.sem.GetToken()
+	err := be.call(ctx, "files/stat", url.Values{"arg": {be.Filename(h)}}, nil, "", &res)
+	be.sem.ReleaseToken()
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if res.Type != "file" {
+		return restic.FileInfo{}, errors.Errorf("%v is not a file but a %v", be.Filename(h), res.Type)
+	}
+
+	return restic.FileInfo{Size: res.Size}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	_, err := be.Stat(ctx, h)
+	if be.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove removes the link to the data of the file at h from the MFS.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	return be.call(ctx, "files/rm", url.Values{"arg": {be.Filename(h)}}, nil, "", nil)
+}
+
+// lsResult is the response of the files/ls command.
+type lsResult struct {
+	Entries []struct {
+		Name string
+		Type int
+	}
+}
+
+// entry types returned by files/ls
+const (
+	typeFile = 0
+	typeDir  = 1
+)
+
+// list returns the names of the entries of type typ in dir. A directory which
+// does not exist yet has no entries.
+func (be *Backend) list(ctx context.Context, dir string, typ int) ([]string, error) {
+	var res lsResult
+	be.sem.GetToken()
+	err := be.call(ctx, "files/ls", url.Values{"arg": {dir}, "long": {"true"}, "U": {"true"}}, nil, "", &res)
+	be.sem.ReleaseToken()
+	if be.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range res.Entries {
+		if e.Type == typ {
+			names = append(names, e.Name)
+		}
+	}
+	return names, nil
+}
+
+// List returns a channel that yields all names of blobs of type t. A
+// goroutine is started for this. If the channel done is closed, sending
+// stops.
+func (be *Backend) List(ctx context.Context, t restic.FileType) <-chan string {
+	debug.Log("List %v", t)
+	ch := make(chan string)
+
+	go func() {
+		defer close(ch)
+
+		basedir := be.Basedir(t)
+		dirs := []string{basedir}
+		if t == restic.DataFile {
+			subdirs, err := be.list(ctx, basedir, typeDir)
+			if err != nil {
+				debug.Log("List %v: %v", basedir, err)
+				return
+			}
+
+			dirs = dirs[:0]
+			for _, d := range subdirs {
+				dirs = append(dirs, path.Join(basedir, d))
+			}
+		}
+
+		for _, dir := range dirs {
+			names, err := be.list(ctx, dir, typeFile)
+			if err != nil {
+				debug.Log("List %v: %v", dir, err)
+				return
+			}
+
+			for _, name := range names {
+				select {
+				case ch <- name:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Delete removes the directory of the repository from the MFS. The data is
+// removed from the node by its next garbage collection.
+func (be *Backend) Delete(ctx context.Context) error {
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	err := be.call(ctx, "files/rm", url.Values{"arg": {be.cfg.Path}, "recursive": {"true"}}, nil, "", nil)
+	if be.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Close does nothing.
+func (be *Backend) Close() error { return nil }
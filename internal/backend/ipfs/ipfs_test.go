@@ -0,0 +1,295 @@
+package ipfs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/ipfs"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeNode implements the commands of the HTTP API of an IPFS node which are
+// used by the backend.
+type fakeNode struct {
+	sync.Mutex
+
+	// blocks maps a CID to the data
+	blocks map[string][]byte
+
+	// files maps the MFS path of a file to its CID
+	files map[string]string
+
+	// dirs contains the MFS paths of all directories
+	dirs map[string]bool
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{
+		blocks: make(map[string][]byte),
+		files:  make(map[string]string),
+		dirs:   map[string]bool{"/": true},
+	}
+}
+
+func (n *fakeNode) fail(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Message": msg, "Code": 0, "Type": "error"})
+}
+
+func (n *fakeNode) reply(w http.ResponseWriter, res interface{}) {
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func (n *fakeNode) mkdirAll(dir string) {
+	for ; dir != "/"; dir = path.Dir(dir) {
+		n.dirs[dir] = true
+	}
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.Lock()
+	defer n.Unlock()
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	args := r.URL.Query()["arg"]
+	p := ""
+	if len(args) > 0 {
+		p = args[0]
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "add":
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			n.fail(w, err.Error())
+			return
+		}
+		buf, err := ioutil.ReadAll(f)
+		if err != nil {
+			n.fail(w, err.Error())
+			return
+		}
+		sum := sha256.Sum256(buf)
+		cid := "bafk" + hex.EncodeToString(sum[:])
+		n.blocks[cid] = buf
+		n.reply(w, map[string]string{"Hash": cid, "Size": strconv.Itoa(len(buf))})
+
+	case "files/cp":
+		cid := strings.TrimPrefix(p, "/ipfs/")
+		if _, ok := n.blocks[cid]; !ok || len(args) != 2 {
+			n.fail(w, "invalid source")
+			return
+		}
+		if _, ok := n.files[args[1]]; ok {
+			n.fail(w, "directory already has entry by that name")
+			return
+		}
+		n.mkdirAll(path.Dir(args[1]))
+		n.files[args[1]] = cid
+		n.reply(w, struct{}{})
+
+	case "files/mkdir":
+		n.mkdirAll(p)
+		n.reply(w, struct{}{})
+
+	case "files/read":
+		cid, ok := n.files[p]
+		if !ok {
+			n.fail(w, "file does not exist")
+			return
+		}
+		buf := n.blocks[cid]
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset > len(buf) {
+			offset = len(buf)
+		}
+		buf = buf[offset:]
+		if count, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && count < len(buf) {
+			buf = buf[:count]
+		}
+		_, _ = w.Write(buf)
+
+	case "files/stat":
+		if cid, ok := n.files[p]; ok {
+			n.reply(w, map[string]interface{}{"Hash": cid, "Size": len(n.blocks[cid]), "Type": "file"})
+			return
+		}
+		if n.dirs[p] {
+			n.reply(w, map[string]interface{}{"Hash": "dir", "Size": 0, "Type": "directory"})
+			return
+		}
+		n.fail(w, "file does not exist")
+
+	case "files/ls":
+		if !n.dirs[p] {
+			n.fail(w, "file does not exist")
+			return
+		}
+		type entry struct {
+			Name string
+			Type int
+		}
+		var entries []entry
+		for f := range n.files {
+			if path.Dir(f) == p {
+				entries = append(entries, entry{path.Base(f), 0})
+			}
+		}
+		for d := range n.dirs {
+			if d != "/" && path.Dir(d) == p {
+				entries = append(entries, entry{path.Base(d), 1})
+			}
+		}
+		n.reply(w, map[string]interface{}{"Entries": entries})
+
+	case "files/rm":
+		if _, ok := n.files[p]; ok {
+			delete(n.files, p)
+			n.reply(w, struct{}{})
+			return
+		}
+		if !n.dirs[p] {
+			n.fail(w, "file does not exist")
+			return
+		}
+		if r.URL.Query().Get("recursive") != "true" {
+			n.fail(w, p+" is a directory, use -r to remove directories")
+			return
+		}
+		for f := range n.files {
+			if strings.HasPrefix(f, p+"/") {
+				delete(n.files, f)
+			}
+		}
+		for d := range n.dirs {
+			if d == p || strings.HasPrefix(d, p+"/") {
+				delete(n.dirs, d)
+			}
+		}
+		n.reply(w, struct{}{})
+
+	case "pin/rm":
+		n.reply(w, struct{}{})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestSuite(t testing.TB, srv *httptest.Server) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			n++
+			cfg := ipfs.NewConfig()
+			cfg.URL = u
+			cfg.Path = fmt.Sprintf("/restic-test-%d", n)
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(ipfs.Config)
+			return ipfs.Create(cfg, tr)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(ipfs.Config)
+			return ipfs.Open(cfg, tr)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(ipfs.Config)
+			be, err := ipfs.Open(cfg, tr)
+			if err != nil {
+				return err
+			}
+
+			return be.(restic.Deleter).Delete(context.TODO())
+		},
+	}
+}
+
+func TestBackendIPFS(t *testing.T) {
+	srv := httptest.NewServer(newFakeNode())
+	defer srv.Close()
+
+	newTestSuite(t, srv).RunTests(t)
+}
+
+func BenchmarkBackendIPFS(t *testing.B) {
+	srv := httptest.NewServer(newFakeNode())
+	defer srv.Close()
+
+	newTestSuite(t, srv).RunBenchmarks(t)
+}
+
+func TestRemoveKeepsData(t *testing.T) {
+	node := newFakeNode()
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	rtest.OK(t, err)
+
+	cfg := ipfs.NewConfig()
+	cfg.URL = u
+	cfg.Path = "/restic"
+
+	be, err := ipfs.Create(cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+
+	ctx := context.TODO()
+	data := []byte("foobar")
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, strings.NewReader(string(data))))
+
+	// the file is linked into the MFS at the path of the default layout
+	name := path.Join("/restic/data", h.Name[:2], h.Name)
+	cid, ok := node.files[name]
+	rtest.Assert(t, ok, "file %v not found in the MFS", name)
+
+	// removing the file only removes the link, the data is left for the
+	// garbage collection of the node
+	rtest.OK(t, be.Remove(ctx, h))
+	_, ok = node.files[name]
+	rtest.Assert(t, !ok, "file %v still present in the MFS", name)
+	rtest.Equals(t, data, node.blocks[cid])
+
+	_, err = be.Stat(ctx, h)
+	rtest.Assert(t, be.IsNotExist(err), "IsNotExist() did not recognize %v", err)
+	rtest.Assert(t, !be.IsNotExist(errors.New("foo")), "IsNotExist() recognized an unrelated error")
+}
@@ -21,6 +21,8 @@ var defaultLayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "locks",
 	restic.KeyFile:      "keys",
+	restic.ScrubFile:    "scrub",
+	restic.SessionFile:  "sessions",
 }
 
 func (l *DefaultLayout) String() string {
@@ -16,6 +16,8 @@ var s3LayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "lock",
 	restic.KeyFile:      "key",
+	restic.ScrubFile:    "scrub",
+	restic.SessionFile:  "session",
 }
 
 func (l *S3LegacyLayout) String() string {
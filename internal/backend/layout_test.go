@@ -110,6 +110,8 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "scrub"),
+			filepath.Join(tempdir, "sessions"),
 		}
 
 		for i := 0; i < 256; i++ {
@@ -187,6 +189,8 @@ func TestRESTLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "locks"),
 			filepath.Join(path, "keys"),
+			filepath.Join(path, "scrub"),
+			filepath.Join(path, "sessions"),
 		}
 
 		sort.Sort(sort.StringSlice(want))
@@ -333,6 +337,8 @@ func TestS3LegacyLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "lock"),
 			filepath.Join(path, "key"),
+			filepath.Join(path, "scrub"),
+			filepath.Join(path, "session"),
 		}
 
 		sort.Sort(sort.StringSlice(want))
@@ -128,6 +128,16 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err er
 
 	// create new file
 	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	if os.IsNotExist(errors.Cause(err)) {
+		// the directory for a file type may be missing in repositories
+		// created by older versions
+		if err := fs.MkdirAll(filepath.Dir(filename), backend.Modes.Dir); err != nil {
+			return errors.Wrap(err, "MkdirAll")
+		}
+
+		f, err = fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	}
+
 	if err != nil {
 		return errors.Wrap(err, "OpenFile")
 	}
@@ -7,11 +7,15 @@ import (
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
 	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/ipfs"
 	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/rados"
+	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/tape"
 	"github.com/restic/restic/internal/errors"
 )
 
@@ -38,6 +42,10 @@ var parsers = []parser{
 	{"azure", azure.ParseConfig},
 	{"swift", swift.ParseConfig},
 	{"rest", rest.ParseConfig},
+	{"rclone", rclone.ParseConfig},
+	{"ipfs", ipfs.ParseConfig},
+	{"tape", tape.ParseConfig},
+	{"rados", rados.ParseConfig},
 }
 
 func isPath(s string) bool {
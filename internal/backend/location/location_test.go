@@ -6,11 +6,15 @@ import (
 	"testing"
 
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/ipfs"
 	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/rados"
+	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/tape"
 )
 
 func parseURL(s string) *url.URL {
@@ -281,6 +285,46 @@ var parseTests = []struct {
 			},
 		},
 	},
+	{
+		"ipfs:http://localhost:5001/restic",
+		Location{Scheme: "ipfs",
+			Config: ipfs.Config{
+				URL:         parseURL("http://localhost:5001"),
+				Path:        "/restic",
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"tape:/mnt/ltfs/restic",
+		Location{Scheme: "tape",
+			Config: tape.Config{
+				Path:       "/mnt/ltfs/restic",
+				VolumeSize: 4096,
+			},
+		},
+	},
+	{
+		"rados:backup/restic",
+		Location{Scheme: "rados",
+			Config: rados.Config{
+				Pool:        "backup",
+				Namespace:   "restic",
+				Connections: 5,
+			},
+		},
+	},
+	{
+		"rclone:remote:path/to/repo",
+		Location{Scheme: "rclone",
+			Config: rclone.Config{
+				Program:     "rclone",
+				Args:        "serve restic --stdio --b2-hard-delete --drive-use-trash=false",
+				Remote:      "remote:path/to/repo",
+				Connections: 5,
+			},
+		},
+	},
 	{
 		"b2:bucketname:/prefix", Location{Scheme: "b2",
 			Config: b2.Config{
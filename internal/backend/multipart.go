@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// partTries is the number of attempts made to upload a single part of a file
+// before the upload fails.
+const partTries = 4
+
+// UploadParts reads rd in parts of partSize bytes and calls upload for each
+// of them, with the number of the part starting at one. Failed uploads of a
+// part are retried with the same data after a short delay, so that an
+// interrupted connection near the end of a large file only requires sending
+// the last part again. It returns the number of parts, a reader without any
+// data results in a single empty part.
+func UploadParts(ctx context.Context, rd io.Reader, partSize int, upload func(n int, data []byte) error) (int, error) {
+	buf := make([]byte, partSize)
+
+	for n := 1; ; n++ {
+		l, err := io.ReadFull(rd, buf)
+		if err == io.EOF && n > 1 {
+			return n - 1, nil
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, errors.Wrap(err, "ReadFull")
+		}
+
+		uerr := uploadPart(ctx, n, buf[:l], upload)
+		if uerr != nil {
+			return 0, uerr
+		}
+
+		if err != nil {
+			// the part was shorter than partSize, so it was the last one
+			return n, nil
+		}
+	}
+}
+
+// uploadPart calls upload for the part until it succeeds, partTries attempts
+// have been made or the context is cancelled.
+func uploadPart(ctx context.Context, n int, data []byte, upload func(n int, data []byte) error) error {
+	delay := initialRetryDelay
+
+	for try := 1; ; try++ {
+		err := upload(n, data)
+		if err == nil || try >= partTries || ctx.Err() != nil {
+			return err
+		}
+
+		wait := jitter(delay)
+		debug.Log("upload of part %d failed (try %d): %v, retrying after %v", n, try, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+	}
+}
@@ -0,0 +1,71 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestUploadParts(t *testing.T) {
+	ctx := context.TODO()
+
+	var tests = []struct {
+		size  int
+		parts int
+	}{
+		{0, 1},
+		{5, 1},
+		{10, 1},
+		{11, 2},
+		{35, 4},
+	}
+
+	for _, test := range tests {
+		data := rtest.Random(23, test.size)
+
+		var buf bytes.Buffer
+		next := 1
+		n, err := backend.UploadParts(ctx, bytes.NewReader(data), 10, func(n int, part []byte) error {
+			rtest.Equals(t, next, n)
+			next++
+			buf.Write(part)
+			return nil
+		})
+		rtest.OK(t, err)
+		rtest.Equals(t, test.parts, n)
+		rtest.Assert(t, bytes.Equal(data, buf.Bytes()), "wrong data uploaded for size %d", test.size)
+	}
+}
+
+func TestUploadPartsRetry(t *testing.T) {
+	ctx := context.TODO()
+	data := rtest.Random(23, 25)
+
+	// the upload of the last part fails once, only this part is sent again
+	var uploads []int
+	failed := false
+	n, err := backend.UploadParts(ctx, bytes.NewReader(data), 10, func(n int, part []byte) error {
+		uploads = append(uploads, n)
+		if n == 3 && !failed {
+			failed = true
+			return errors.New("connection reset")
+		}
+		rtest.Equals(t, data[(n-1)*10:(n-1)*10+len(part)], part)
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+	rtest.Equals(t, []int{1, 2, 3, 3}, uploads)
+
+	// the error is returned when the context is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = backend.UploadParts(ctx, bytes.NewReader(data), 10, func(n int, part []byte) error {
+		return errors.New("connection reset")
+	})
+	rtest.Assert(t, err != nil, "no error returned")
+}
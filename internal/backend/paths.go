@@ -9,6 +9,8 @@ var Paths = struct {
 	Index     string
 	Locks     string
 	Keys      string
+	Scrub     string
+	Sessions  string
 	Temp      string
 	Config    string
 }{
@@ -17,6 +19,8 @@ var Paths = struct {
 	"index",
 	"locks",
 	"keys",
+	"scrub",
+	"sessions",
 	"tmp",
 	"config",
 }
@@ -0,0 +1,56 @@
+package rados
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to access a RADOS pool via
+// librados.
+type Config struct {
+	Pool      string
+	Namespace string
+
+	Conf        string `option:"conf"        help:"path to the Ceph configuration file (default: the search path of librados)"`
+	ID          string `option:"id"          help:"name of the Ceph client without the client. prefix (default: admin)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent operations (default: 5)"`
+}
+
+func init() {
+	options.Register("rados", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the pool and the optional
+// namespace, e.g. "rados:pool" or "rados:pool/namespace".
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "rados:") {
+		return nil, errors.New("invalid RADOS backend specification")
+	}
+
+	s = s[6:]
+	pool, ns := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		pool, ns = s[:i], s[i+1:]
+	}
+
+	if pool == "" {
+		return nil, errors.New("rados: pool not specified")
+	}
+	if strings.Contains(ns, "/") {
+		return nil, errors.Errorf("rados: invalid namespace %q", ns)
+	}
+
+	cfg := NewConfig()
+	cfg.Pool = pool
+	cfg.Namespace = ns
+	return cfg, nil
+}
@@ -0,0 +1,56 @@
+package rados
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{
+			"rados:restic",
+			Config{
+				Pool:        "restic",
+				Connections: 5,
+			},
+		},
+		{
+			"rados:backup/host1",
+			Config{
+				Pool:        "backup",
+				Namespace:   "host1",
+				Connections: 5,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cfg, test.cfg) {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+			continue
+		}
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{
+		"rados:",
+		"rados:/namespace",
+		"rados:pool/name/space",
+		"foo:pool",
+	} {
+		if _, err := ParseConfig(s); err == nil {
+			t.Errorf("ParseConfig(%q) did not return an error", s)
+		}
+	}
+}
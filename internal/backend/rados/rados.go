@@ -0,0 +1,369 @@
+// +build ceph
+
+// Package rados implements a backend which stores the files of a repository
+// as objects in a pool of a Ceph cluster. It talks to the cluster directly via
+// librados, so no S3 gateway is required.
+//
+// The objects are named like the files in a local repository (e.g.
+// "data/2a/2a3f...") and can be restricted to a namespace of the pool. The
+// backend uses the go-ceph bindings, which need cgo and the librados headers,
+// so it is only built with the build tag "ceph".
+package rados
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+// maxObjectSize is the largest file which can be saved. Each file is written
+// with a single operation, so that no partially written objects are left
+// behind, and the OSDs refuse writes larger than osd_max_write_size, which is
+// 90 MiB by default.
+const maxObjectSize = 90 * 1024 * 1024
+
+// Backend stores data in a RADOS pool.
+type Backend struct {
+	cfg   Config
+	conn  *rados.Conn
+	ioctx *rados.IOContext
+	sem   *backend.Semaphore
+	backend.Layout
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+func open(cfg Config) (*Backend, error) {
+	debug.Log("open, config %#v", cfg)
+
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *rados.Conn
+	if cfg.ID != "" {
+		conn, err = rados.NewConnWithUser(cfg.ID)
+	} else {
+		conn, err = rados.NewConn()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "NewConn")
+	}
+
+	if cfg.Conf != "" {
+		err = conn.ReadConfigFile(cfg.Conf)
+	} else {
+		err = conn.ReadDefaultConfigFile()
+	}
+	if err != nil {
+		conn.Shutdown()
+		return nil, errors.Wrap(err, "ReadConfigFile")
+	}
+
+	err = conn.Connect()
+	if err != nil {
+		conn.Shutdown()
+		return nil, errors.Wrap(err, "Connect")
+	}
+
+	ioctx, err := conn.OpenIOContext(cfg.Pool)
+	if err != nil {
+		conn.Shutdown()
+		return nil, errors.Wrapf(err, "OpenIOContext(%v)", cfg.Pool)
+	}
+	ioctx.SetNamespace(cfg.Namespace)
+
+	be := &Backend{
+		cfg:   cfg,
+		conn:  conn,
+		ioctx: ioctx,
+		sem:   sem,
+		Layout: &backend.DefaultLayout{
+			Join: path.Join,
+		},
+	}
+
+	return be, nil
+}
+
+// Open opens the repository in the pool.
+func Open(cfg Config) (restic.Backend, error) {
+	return open(cfg)
+}
+
+// Create checks that the pool can be accessed and does not contain a
+// repository yet. Pools cannot be created by restic.
+func Create(cfg Config) (restic.Backend, error) {
+	be, err := open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+	if ok {
+		_ = be.Close()
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	return be, nil
+}
+
+// Location returns this backend's location (the pool and namespace).
+func (be *Backend) Location() string {
+	if be.cfg.Namespace == "" {
+		return be.cfg.Pool
+	}
+	return be.cfg.Pool + "/" + be.cfg.Namespace
+}
+
+// IsNotExist returns true if the error is caused by a non-existing object.
+func (be *Backend) IsNotExist(err error) bool {
+	return errors.Cause(err) == rados.ErrNotFound
+}
+
+// Save stores data in the backend at the handle. The object is created and
+// written with a single operation, which fails if it already exists.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(rd, maxObjectSize+1))
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+	if len(buf) > maxObjectSize {
+		return errors.Fatalf("%v is too large, objects in RADOS must be smaller than %d bytes", h, maxObjectSize)
+	}
+
+	op := rados.CreateWriteOp()
+	defer op.Release()
+	op.Create(rados.CreateExclusive)
+	op.WriteFull(buf)
+
+	be.sem.GetToken()
+	err = op.Operate(be.ioctx, be.Filename(h), rados.OperationNoFlag)
+	be.sem.ReleaseToken()
+
+	if errors.Cause(err) == rados.ErrObjectExists {
+		debug.Log("%v already exists", h)
+		return errors.New("object already exists")
+	}
+
+	return errors.Wrap(err, "Operate")
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. If length is nonzero, only a portion of the file is
+// returned. rd must be closed after use.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	fi, err := be.Stat(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	end := fi.Size
+	if length > 0 && offset+int64(length) < end {
+		end = offset + int64(length)
+	}
+
+	return &objectReader{
+		be:     be,
+		ctx:    ctx,
+		name:   be.Filename(h),
+		offset: offset,
+		end:    end,
+	}, nil
+}
+
+// objectReader reads the range of an object up to end. Each call to Read
+// reads the data at the current offset from the cluster.
+type objectReader struct {
+	be     *Backend
+	ctx    context.Context
+	name   string
+	offset int64
+	end    int64
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.end {
+		return 0, io.EOF
+	}
+
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if int64(len(p)) > r.end-r.offset {
+		p = p[:r.end-r.offset]
+	}
+
+	r.be.sem.GetToken()
+	n, err := r.be.ioctx.Read(r.name, p, uint64(r.offset))
+	r.be.sem.ReleaseToken()
+
+	r.offset += int64(n)
+	if err != nil {
+		return n, errors.Wrap(err, "Read")
+	}
+	if n == 0 {
+		// the object has been truncated in the meantime
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return n, nil
+}
+
+// Close does nothing, no resources are held between reads.
+func (r *objectReader) Close() error {
+	return nil
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat %v", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	be.sem.GetToken()
+	stat, err := be.ioctx.Stat(be.Filename(h))
+	be.sem.ReleaseToken()
+	if err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "Stat")
+	}
+
+	return restic.FileInfo{Size: int64(stat.Size)}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	_, err := be.Stat(ctx, h)
+	if be.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove removes the blob with the given name and type.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+
+	be.sem.GetToken()
+	err := be.ioctx.Delete(be.Filename(h))
+	be.sem.ReleaseToken()
+
+	return errors.Wrap(err, "Delete")
+}
+
+// List returns a channel that yields all names of blobs of type t. A
+// goroutine is started for this.
+func (be *Backend) List(ctx context.Context, t restic.FileType) <-chan string {
+	debug.Log("List %v", t)
+	ch := make(chan string)
+
+	prefix := be.Dirname(restic.Handle{Type: t})
+
+	go func() {
+		defer close(ch)
+
+		// librados lists all objects in the namespace of the pool
+		iter, err := be.ioctx.Iter()
+		if err != nil {
+			debug.Log("List: %v", err)
+			return
+		}
+		defer iter.Close()
+
+		for iter.Next() {
+			name := iter.Value()
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			select {
+			case ch <- path.Base(name):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			debug.Log("List: %v", err)
+		}
+	}()
+
+	return ch
+}
+
+// Delete removes all restic objects in the pool or namespace.
+func (be *Backend) Delete(ctx context.Context) error {
+	alltypes := []restic.FileType{
+		restic.DataFile,
+		restic.KeyFile,
+		restic.LockFile,
+		restic.SnapshotFile,
+		restic.IndexFile,
+		restic.ScrubFile,
+		restic.SessionFile}
+
+	for _, t := range alltypes {
+		for name := range be.List(ctx, t) {
+			err := be.Remove(ctx, restic.Handle{Type: t, Name: name})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := be.Remove(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil && !be.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the connection to the cluster.
+func (be *Backend) Close() error {
+	be.ioctx.Destroy()
+	be.conn.Shutdown()
+	return nil
+}
@@ -0,0 +1,21 @@
+// +build !ceph
+
+package rados
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// errDisabled is returned when restic has been built without librados.
+var errDisabled = errors.Fatal("the rados backend is not available, restic must be built with the build tag ceph")
+
+// Open returns an error, the backend needs librados.
+func Open(cfg Config) (restic.Backend, error) {
+	return nil, errDisabled
+}
+
+// Create returns an error, the backend needs librados.
+func Create(cfg Config) (restic.Backend, error) {
+	return nil, errDisabled
+}
@@ -0,0 +1,21 @@
+// +build !ceph
+
+package rados
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func TestDisabled(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Pool = "restic"
+
+	for _, fn := range []func(Config) (restic.Backend, error){Open, Create} {
+		be, err := fn(cfg)
+		if be != nil || err != errDisabled {
+			t.Errorf("expected error without librados, got %v, %v", be, err)
+		}
+	}
+}
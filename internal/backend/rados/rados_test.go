@@ -0,0 +1,80 @@
+// +build ceph
+
+package rados_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/rados"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			cfg := rados.NewConfig()
+			cfg.Pool = os.Getenv("RESTIC_TEST_RADOS_POOL")
+			cfg.Conf = os.Getenv("RESTIC_TEST_RADOS_CONF")
+			cfg.ID = os.Getenv("RESTIC_TEST_RADOS_ID")
+			cfg.Namespace = fmt.Sprintf("test-%d", time.Now().UnixNano())
+			t.Logf("using namespace %v of pool %v", cfg.Namespace, cfg.Pool)
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(rados.Config)
+			return rados.Create(cfg)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(rados.Config)
+			return rados.Open(cfg)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(rados.Config)
+
+			be, err := rados.Open(cfg)
+			if err != nil {
+				return err
+			}
+			defer be.Close()
+
+			return be.(restic.Deleter).Delete(context.TODO())
+		},
+	}
+}
+
+func TestBackendRados(t *testing.T) {
+	defer func() {
+		if t.Skipped() {
+			rtest.SkipDisallowed(t, "restic/backend/rados.TestBackendRados")
+		}
+	}()
+
+	if os.Getenv("RESTIC_TEST_RADOS_POOL") == "" {
+		t.Skipf("environment variable RESTIC_TEST_RADOS_POOL not set")
+		return
+	}
+
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackendRados(t *testing.B) {
+	if os.Getenv("RESTIC_TEST_RADOS_POOL") == "" {
+		t.Skipf("environment variable RESTIC_TEST_RADOS_POOL not set")
+		return
+	}
+
+	newTestSuite(t).RunBenchmarks(t)
+}
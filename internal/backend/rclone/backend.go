@@ -0,0 +1,241 @@
+package rclone
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/net/http2"
+)
+
+// Backend is used to access data stored somewhere via rclone.
+type Backend struct {
+	restic.Backend
+
+	remote string
+	tr     *http2.Transport
+	cmd    *exec.Cmd
+	conn   *StdioConn
+	result <-chan error
+	wg     sync.WaitGroup
+}
+
+// make sure that *Backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// waitTimeout is the time to wait for rclone to terminate after the
+// connection was closed.
+const waitTimeout = 5 * time.Second
+
+// run starts command with args and initializes the StdioConn. The function
+// preExec is run just before, postExec just after starting the program.
+func run(preExec, postExec func(), command string, args ...string) (*StdioConn, *exec.Cmd, error) {
+	cmd := exec.Command(command, args...)
+
+	// prefix the errors with the program name
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cmd.StderrPipe")
+	}
+
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			fmt.Fprintf(os.Stderr, "rclone: %v\n", sc.Text())
+		}
+	}()
+
+	// use pipes backed by files, they support deadlines
+	r, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "os.Pipe")
+	}
+
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		_ = r.Close()
+		_ = stdin.Close()
+		return nil, nil, errors.Wrap(err, "os.Pipe")
+	}
+
+	cmd.Stdin = r
+	cmd.Stdout = w
+
+	if preExec != nil {
+		preExec()
+	}
+
+	err = cmd.Start()
+
+	if postExec != nil {
+		postExec()
+	}
+
+	// the child has its own copies of these now
+	_ = r.Close()
+	_ = w.Close()
+
+	if err != nil {
+		_ = stdin.Close()
+		_ = stdout.Close()
+		return nil, nil, errors.Wrap(err, "cmd.Start")
+	}
+
+	c := &StdioConn{
+		receive: stdout,
+		send:    stdin,
+	}
+
+	return c, cmd, nil
+}
+
+// newBackend starts rclone and returns a backend which talks to it via HTTP/2
+// over stdin/stdout. The REST backend is not yet set.
+func newBackend(cfg Config, preExec, postExec func()) (*Backend, error) {
+	debug.Log("new backend created with config %#v", cfg)
+
+	program, args, err := backend.SplitShellArgs(cfg.Program + " " + cfg.Args)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, cfg.Remote)
+
+	debug.Log("running command: %v %v", program, args)
+	conn, cmd, err := run(preExec, postExec, program, args...)
+	if err != nil {
+		return nil, errors.Fatalf("unable to start rclone: %v", err)
+	}
+
+	var dialCount int
+	tr := &http2.Transport{
+		AllowHTTP: true, // this is not really HTTP, just stdin/stdout
+		DialTLS: func(network, address string, cfg *tls.Config) (net.Conn, error) {
+			debug.Log("new connection requested, %v %v", network, address)
+			if dialCount > 0 {
+				return nil, errors.New("connection to rclone was already used")
+			}
+			dialCount++
+			return conn, nil
+		},
+	}
+
+	ch := make(chan error, 1)
+	be := &Backend{
+		remote: cfg.Remote,
+		tr:     tr,
+		cmd:    cmd,
+		conn:   conn,
+		result: ch,
+	}
+
+	be.wg.Add(1)
+	go func() {
+		defer be.wg.Done()
+		err := cmd.Wait()
+		debug.Log("rclone exited, err %v", err)
+		ch <- errors.Wrap(err, "cmd.Wait")
+	}()
+
+	return be, nil
+}
+
+// restConfig returns the configuration for the REST backend used to talk to
+// rclone.
+func restConfig(cfg Config) rest.Config {
+	url, err := url.Parse("http://localhost/")
+	if err != nil {
+		panic(err)
+	}
+
+	restCfg := rest.NewConfig()
+	restCfg.Connections = cfg.Connections
+	restCfg.URL = url
+	return restCfg
+}
+
+// Open starts an rclone process with the given config.
+func Open(cfg Config, preExec, postExec func()) (*Backend, error) {
+	be, err := newBackend(cfg, preExec, postExec)
+	if err != nil {
+		return nil, err
+	}
+
+	restBackend, err := rest.Open(restConfig(cfg), debug.RoundTripper(be.tr))
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	be.Backend = restBackend
+	return be, nil
+}
+
+// Create initializes a new restic repo with rclone.
+func Create(cfg Config, preExec, postExec func()) (*Backend, error) {
+	be, err := newBackend(cfg, preExec, postExec)
+	if err != nil {
+		return nil, err
+	}
+
+	debug.Log("new backend created")
+
+	restBackend, err := rest.Create(restConfig(cfg), debug.RoundTripper(be.tr))
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	be.Backend = restBackend
+	return be, nil
+}
+
+// Location returns the location of the repository.
+func (be *Backend) Location() string {
+	return "rclone:" + be.remote
+}
+
+// Close terminates the backend.
+func (be *Backend) Close() error {
+	debug.Log("exiting rclone")
+	be.tr.CloseIdleConnections()
+
+	// closing the connection closes rclone's stdin, which makes it exit
+	err := be.conn.Close()
+	if err != nil {
+		debug.Log("closing the connection returned %v", err)
+	}
+
+	select {
+	case err = <-be.result:
+		debug.Log("rclone exited, err %v", err)
+	case <-time.After(waitTimeout):
+		debug.Log("timeout, killing rclone")
+		if err := be.cmd.Process.Kill(); err != nil {
+			return err
+		}
+
+		// get the error, but ignore it
+		<-be.result
+		err = nil
+	}
+
+	be.wg.Wait()
+
+	if err != nil {
+		return errors.Errorf("rclone exited with error: %v", err)
+	}
+
+	return nil
+}
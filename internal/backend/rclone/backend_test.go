@@ -0,0 +1,75 @@
+package rclone_test
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/rclone"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-rclone-")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("use backend at %v", dir)
+
+			cfg := rclone.NewConfig()
+			cfg.Remote = dir
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(rclone.Config)
+			return rclone.Create(cfg, nil, nil)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(rclone.Config)
+			return rclone.Open(cfg, nil, nil)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(rclone.Config)
+			if !rtest.TestCleanupTempDirs {
+				t.Logf("leaving test backend dir at %v", cfg.Remote)
+			}
+
+			rtest.RemoveAll(t, cfg.Remote)
+			return nil
+		},
+	}
+}
+
+func TestBackendRclone(t *testing.T) {
+	defer func() {
+		if t.Skipped() {
+			rtest.SkipDisallowed(t, "restic/backend/rclone.TestBackendRclone")
+		}
+	}()
+
+	if _, err := exec.LookPath("rclone"); err != nil {
+		t.Skip("rclone binary not found")
+	}
+
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackendRclone(t *testing.B) {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		t.Skip("rclone binary not found")
+	}
+
+	newTestSuite(t).RunBenchmarks(t)
+}
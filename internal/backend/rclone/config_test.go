@@ -0,0 +1,62 @@
+package rclone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{
+			"rclone:local:foo:/bar",
+			Config{
+				Remote:      "local:foo:/bar",
+				Program:     defaultConfig.Program,
+				Args:        defaultConfig.Args,
+				Connections: defaultConfig.Connections,
+			},
+		},
+		{
+			"rclone:b2:bucket/prefix",
+			Config{
+				Remote:      "b2:bucket/prefix",
+				Program:     defaultConfig.Program,
+				Args:        defaultConfig.Args,
+				Connections: defaultConfig.Connections,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cfg, test.cfg) {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+			continue
+		}
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	var tests = []string{
+		"rclone:",
+		"local:/foo",
+	}
+
+	for _, s := range tests {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q not found", s)
+		}
+	}
+}
+
+var defaultConfig = NewConfig()
@@ -0,0 +1,95 @@
+package rclone
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// StdioConn implements a net.Conn via stdin/stdout of a child process.
+// Data is received from the child's stdout and sent to its stdin.
+type StdioConn struct {
+	receive *os.File
+	send    *os.File
+	close   sync.Once
+}
+
+func (s *StdioConn) Read(p []byte) (int, error) {
+	n, err := s.receive.Read(p)
+	return n, err
+}
+
+func (s *StdioConn) Write(p []byte) (int, error) {
+	n, err := s.send.Write(p)
+	return n, err
+}
+
+// Close closes both streams.
+func (s *StdioConn) Close() (err error) {
+	s.close.Do(func() {
+		debug.Log("close stdio connection")
+		var errs []error
+
+		for _, f := range []func() error{s.receive.Close, s.send.Close} {
+			err := f()
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if len(errs) > 0 {
+			err = errors.Errorf("error closing stdio connection: %v", errs)
+		}
+	})
+
+	return err
+}
+
+// LocalAddr returns a dummy address.
+func (s *StdioConn) LocalAddr() net.Addr {
+	return Addr{}
+}
+
+// RemoteAddr returns a dummy address.
+func (s *StdioConn) RemoteAddr() net.Addr {
+	return Addr{}
+}
+
+// SetDeadline sets the read/write deadline.
+func (s *StdioConn) SetDeadline(t time.Time) error {
+	err1 := s.receive.SetReadDeadline(t)
+	err2 := s.send.SetWriteDeadline(t)
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// SetReadDeadline sets the read deadline.
+func (s *StdioConn) SetReadDeadline(t time.Time) error {
+	return s.receive.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (s *StdioConn) SetWriteDeadline(t time.Time) error {
+	return s.send.SetWriteDeadline(t)
+}
+
+// make sure StdioConn implements net.Conn
+var _ net.Conn = &StdioConn{}
+
+// Addr implements net.Addr for stdin/stdout.
+type Addr struct{}
+
+// Network returns the network type as a string.
+func (a Addr) Network() string {
+	return "stdio"
+}
+
+func (a Addr) String() string {
+	return "stdio"
+}
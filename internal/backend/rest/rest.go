@@ -20,8 +20,10 @@ import (
 	"github.com/restic/restic/internal/backend"
 )
 
-// make sure the rest backend implements restic.Backend
+// make sure the rest backend implements restic.Backend and
+// restic.AppendOnlyChecker
 var _ restic.Backend = &restBackend{}
+var _ restic.AppendOnlyChecker = &restBackend{}
 
 type restBackend struct {
 	url    *url.URL
@@ -30,9 +32,8 @@ type restBackend struct {
 	backend.Layout
 }
 
-// Open opens the REST backend with the given config.
-func Open(cfg Config) (restic.Backend, error) {
-	client := &http.Client{Transport: backend.Transport()}
+func open(cfg Config, rt http.RoundTripper) (*restBackend, error) {
+	client := &http.Client{Transport: rt}
 
 	sem, err := backend.NewSemaphore(cfg.Connections)
 	if err != nil {
@@ -55,9 +56,15 @@ func Open(cfg Config) (restic.Backend, error) {
 	return be, nil
 }
 
+// Open opens the REST backend with the given config. All requests are sent
+// via the round tripper rt.
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
+}
+
 // Create creates a new REST on server configured in config.
-func Create(cfg Config) (restic.Backend, error) {
-	be, err := Open(cfg)
+func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +79,9 @@ func Create(cfg Config) (restic.Backend, error) {
 	values.Set("create", "true")
 	url.RawQuery = values.Encode()
 
-	resp, err := http.Post(url.String(), "binary/octet-stream", strings.NewReader(""))
+	be.sem.GetToken()
+	resp, err := ctxhttp.Post(context.TODO(), be.client, url.String(), "binary/octet-stream", strings.NewReader(""))
+	be.sem.ReleaseToken()
 	if err != nil {
 		return nil, err
 	}
@@ -94,9 +103,27 @@ func Create(cfg Config) (restic.Backend, error) {
 	return be, nil
 }
 
-// Location returns this backend's location (the server's URL).
+// Location returns this backend's location (the server's URL). A password
+// contained in the URL is replaced.
 func (b *restBackend) Location() string {
-	return b.url.String()
+	return StripPassword(b.url)
+}
+
+// StripPassword returns the URL as a string with the password (if any)
+// replaced by "***", so that it can be printed safely.
+func StripPassword(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+
+	if _, set := u.User.Password(); !set {
+		return u.String()
+	}
+
+	u2 := *u
+	u2.User = nil
+	prefix := u2.Scheme + "://"
+	return prefix + url.User(u.User.Username()).String() + ":***@" + strings.TrimPrefix(u2.String(), prefix)
 }
 
 // Save stores data in the backend at the handle.
@@ -291,6 +318,36 @@ func (b *restBackend) Remove(ctx context.Context, h restic.Handle) error {
 	return errors.Wrap(resp.Body.Close(), "Close")
 }
 
+// AppendOnly returns true if the server refuses to remove files, i.e. if it
+// has been started with --append-only. This is tested by removing a data file
+// which does not exist, see restic.AppendOnlyChecker.
+func (b *restBackend) AppendOnly(ctx context.Context) (bool, error) {
+	h := restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+	req, err := http.NewRequest("DELETE", b.Filename(h), nil)
+	if err != nil {
+		return false, errors.Wrap(err, "http.NewRequest")
+	}
+	b.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, b.client, req)
+	b.sem.ReleaseToken()
+
+	if err != nil {
+		return false, errors.Wrap(err, "client.Do")
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		return true, nil
+	case http.StatusOK, http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+}
+
 // List returns a channel that yields all names of blobs of type t. A
 // goroutine is started for this. If the channel done is closed, sending
 // stops.
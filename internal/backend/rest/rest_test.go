@@ -4,12 +4,16 @@ import (
 	"context"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/test"
 	"github.com/restic/restic/internal/restic"
@@ -61,6 +65,11 @@ func runRESTServer(ctx context.Context, t testing.TB, dir string) func() {
 }
 
 func newTestSuite(ctx context.Context, t testing.TB) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
 	return &test.Suite{
 		// NewConfig returns a config for a new temporary backend that will be used in tests.
 		NewConfig: func() (interface{}, error) {
@@ -84,13 +93,13 @@ func newTestSuite(ctx context.Context, t testing.TB) *test.Suite {
 		// CreateFn is a function that creates a temporary repository for the tests.
 		Create: func(config interface{}) (restic.Backend, error) {
 			cfg := config.(rest.Config)
-			return rest.Create(cfg)
+			return rest.Create(cfg, tr)
 		},
 
 		// OpenFn is a function that opens a previously created temporary repository.
 		Open: func(config interface{}) (restic.Backend, error) {
 			cfg := config.(rest.Config)
-			return rest.Open(cfg)
+			return rest.Open(cfg, tr)
 		},
 
 		// CleanupFn removes data created during the tests.
@@ -131,3 +140,36 @@ func BenchmarkBackendREST(t *testing.B) {
 
 	newTestSuite(ctx, t).RunBenchmarks(t)
 }
+
+func TestAppendOnly(t *testing.T) {
+	for _, test := range []struct {
+		status     int
+		appendOnly bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusNotFound, false},
+		{http.StatusOK, false},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "DELETE" || !strings.HasPrefix(r.URL.Path, "/repo/data/") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		u, err := url.Parse(srv.URL + "/repo")
+		rtest.OK(t, err)
+
+		cfg := rest.NewConfig()
+		cfg.URL = u
+		be, err := rest.Open(cfg, http.DefaultTransport)
+		rtest.OK(t, err)
+
+		appendOnly, err := restic.AppendOnly(context.TODO(), be)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.appendOnly, appendOnly)
+
+		srv.Close()
+	}
+}
@@ -18,9 +18,14 @@ type Config struct {
 	Bucket        string
 	Prefix        string
 	Layout        string `option:"layout" help:"use this backend layout (default: auto-detect)"`
+	Region        string `option:"region" help:"set region (default: auto-detect from endpoint or bucket location)"`
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	MaxRetries  uint `option:"retries" help:"set the number of retries attempted"`
+
+	StorageClass string `option:"storage-class" help:"set the storage class for pack files with data blobs, e.g. STANDARD_IA or GLACIER (default: bucket default)"`
+	RestoreDays  uint   `option:"restore-days" help:"restore archived pack files for n days before reading them, set this if lifecycle rules archive pack files (default: 1 for GLACIER and DEEP_ARCHIVE)"`
+	RestoreTier  string `option:"restore-tier" help:"set the retrieval tier for restoring archived pack files: Expedited, Standard or Bulk (default: Standard)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/minio/minio-go"
+)
+
+// make sure that *Backend implements restic.ServerSideCopier
+var _ restic.ServerSideCopier = &Backend{}
+
+// CopyFrom copies the file h from src with a CopyObject request, so that the
+// data is not transferred through the client. This is only possible if src
+// is an S3 backend on the same server, the credentials of this backend must
+// allow reading the file in the bucket of src.
+func (be *Backend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	if err := h.Valid(); err != nil {
+		return false, err
+	}
+
+	other, ok := restic.Unwrap(src).(*Backend)
+	if !ok || other.cfg.Endpoint != be.cfg.Endpoint || other.cfg.UseHTTP != be.cfg.UseHTTP {
+		return false, nil
+	}
+
+	srcName := other.Filename(h)
+	objName := be.Filename(h)
+
+	srcInfo := minio.NewSourceInfo(other.cfg.Bucket, srcName, nil)
+	// the storage class is not copied from the source object, it is sent
+	// with the copy request like for an upload
+	for k, v := range be.metadata(ctx, h) {
+		if k != "Content-Type" {
+			srcInfo.Headers[k] = v
+		}
+	}
+
+	dst, err := minio.NewDestinationInfo(be.cfg.Bucket, objName, nil, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "NewDestinationInfo")
+	}
+
+	be.sem.GetToken()
+	debug.Log("CopyObject(%v/%v -> %v/%v)", other.cfg.Bucket, srcName, be.cfg.Bucket, objName)
+	err = be.client.CopyObject(dst, srcInfo)
+	be.sem.ReleaseToken()
+
+	if err != nil {
+		return false, errors.Wrap(err, "client.CopyObject")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeCopy records the copy requests sent to an S3 server.
+type fakeCopy struct {
+	sync.Mutex
+	source string
+	class  string
+}
+
+func (f *fakeCopy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch {
+	case r.Method == "HEAD":
+		w.Header().Set("Content-Length", "23")
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2018 00:00:00 GMT")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.source = r.Header.Get("X-Amz-Copy-Source")
+		f.class = r.Header.Get("X-Amz-Storage-Class")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	fake := &fakeCopy{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.TODO()
+	h := restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+
+	cfg := NewConfig()
+	cfg.StorageClass = "STANDARD_IA"
+	be := openFake(t, srv, cfg)
+
+	src := openFake(t, srv, NewConfig())
+	src.cfg.Bucket = "source"
+
+	// files can only be copied from other S3 backends
+	copied, err := be.CopyFrom(ctx, mem.New(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !copied, "file copied from a mem backend")
+	rtest.Equals(t, "", fake.source)
+
+	// wrapped backends are unwrapped
+	copied, err = be.CopyFrom(restic.ContextWithBlobType(ctx, restic.DataBlob), backend.NewReadOnlyBackend(src), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, copied, "file not copied")
+	rtest.Equals(t, "source/"+src.Filename(h), fake.source)
+	rtest.Equals(t, "STANDARD_IA", fake.class)
+
+	// pack files with tree blobs use the default storage class
+	copied, err = be.CopyFrom(restic.ContextWithBlobType(ctx, restic.TreeBlob), src, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, copied, "file not copied")
+	rtest.Equals(t, "", fake.class)
+}
@@ -0,0 +1,204 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/minio/minio-go"
+)
+
+// partSize is the size of the parts of files uploaded with a multipart
+// upload, smaller files are uploaded with a single request.
+const partSize = 16 * 1024 * 1024
+
+// needsMultipart returns a reader for the data of rd and whether it is larger
+// than a single part. For readers which do not know their length, up to
+// partSize+1 bytes are read into memory to find out.
+func needsMultipart(rd io.Reader) (io.Reader, bool, error) {
+	if l, ok := rd.(lenner); ok {
+		return rd, l.Len() > partSize, nil
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, rd, partSize+1)
+	if err != nil && err != io.EOF {
+		return nil, false, errors.Wrap(err, "Read")
+	}
+
+	if n <= partSize {
+		return bytes.NewReader(buf.Bytes()), false, nil
+	}
+
+	return io.MultiReader(&buf, rd), true, nil
+}
+
+// make sure that *Backend implements restic.UploadAborter
+var _ restic.UploadAborter = &Backend{}
+
+// saveMultipart uploads the data read from rd with a multipart upload, a part
+// which cannot be sent is retried without sending the other parts again. If
+// the upload fails nevertheless, it is aborted so that the parts do not use
+// storage. An upload left behind by a client which was killed is resumed by
+// the next attempt to save the same file, parts which have already been
+// uploaded with the same data are skipped. Each part is sent with a
+// Content-MD5 header, which buckets with Object Lock require.
+func (be *Backend) saveMultipart(ctx context.Context, h restic.Handle, objName string, rd io.Reader) (err error) {
+	core := minio.Core{Client: be.client}
+
+	uploadID, uploaded := be.findUpload(objName)
+	if uploadID == "" {
+		var err error
+		uploadID, err = core.NewMultipartUpload(be.cfg.Bucket, objName, be.metadata(ctx, h))
+		if err != nil {
+			return errors.Wrap(err, "NewMultipartUpload")
+		}
+	}
+
+	debug.Log("multipart upload %v for %v", uploadID, objName)
+
+	defer func() {
+		if err != nil {
+			be.abortUpload(objName, uploadID)
+		}
+	}()
+
+	var parts []minio.CompletePart
+	_, err = backend.UploadParts(ctx, rd, partSize, func(n int, data []byte) error {
+		sum := md5.Sum(data)
+		etag := hex.EncodeToString(sum[:])
+		if uploaded[n] == etag {
+			debug.Log("part %d of %v has already been uploaded", n, objName)
+			parts = append(parts, minio.CompletePart{PartNumber: n, ETag: etag})
+			return nil
+		}
+
+		be.sem.GetToken()
+		part, err := core.PutObjectPart(be.cfg.Bucket, objName, uploadID, n, int64(len(data)), bytes.NewReader(data), sum[:], nil)
+		be.sem.ReleaseToken()
+		if err != nil {
+			return errors.Wrapf(err, "PutObjectPart(%d)", n)
+		}
+
+		parts = append(parts, minio.CompletePart{PartNumber: n, ETag: part.ETag})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	be.sem.GetToken()
+	err = core.CompleteMultipartUpload(be.cfg.Bucket, objName, uploadID, parts)
+	be.sem.ReleaseToken()
+
+	debug.Log("completed upload of %v with %d parts, err %v", objName, len(parts), err)
+	return errors.Wrap(err, "CompleteMultipartUpload")
+}
+
+// findUpload returns the ID of an incomplete multipart upload for objName and
+// the MD5 hashes of the parts uploaded so far. An empty ID is returned if there
+// is no such upload, or if the uploads cannot be listed.
+func (be *Backend) findUpload(objName string) (string, map[int]string) {
+	core := minio.Core{Client: be.client}
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	res, err := core.ListMultipartUploads(be.cfg.Bucket, objName, "", "", "", 1000)
+	if err != nil {
+		debug.Log("unable to list multipart uploads: %v", err)
+		return "", nil
+	}
+
+	var upload *minio.ObjectMultipartInfo
+	for i, u := range res.Uploads {
+		if u.Key == objName && (upload == nil || u.Initiated.After(upload.Initiated)) {
+			upload = &res.Uploads[i]
+		}
+	}
+	if upload == nil {
+		return "", nil
+	}
+
+	parts, err := core.ListObjectParts(be.cfg.Bucket, objName, upload.UploadID, 0, 10000)
+	if err != nil {
+		debug.Log("unable to list parts of upload %v: %v", upload.UploadID, err)
+		return "", nil
+	}
+
+	uploaded := make(map[int]string, len(parts.ObjectParts))
+	for _, p := range parts.ObjectParts {
+		uploaded[p.PartNumber] = strings.Trim(p.ETag, `"`)
+	}
+
+	debug.Log("resuming upload %v of %v with %d parts", upload.UploadID, objName, len(uploaded))
+	return upload.UploadID, uploaded
+}
+
+// abortUpload aborts the multipart upload, so that the parts uploaded so far
+// are removed. Errors are only logged, the upload can also be aborted later
+// by prune.
+func (be *Backend) abortUpload(objName, uploadID string) {
+	core := minio.Core{Client: be.client}
+
+	be.sem.GetToken()
+	err := core.AbortMultipartUpload(be.cfg.Bucket, objName, uploadID)
+	be.sem.ReleaseToken()
+
+	debug.Log("aborted upload %v of %v, err %v", uploadID, objName, err)
+}
+
+// AbortUploads aborts all incomplete multipart uploads of files of type t
+// which have been started before the given time, see restic.UploadAborter.
+func (be *Backend) AbortUploads(ctx context.Context, t restic.FileType, before time.Time) (int, error) {
+	core := minio.Core{Client: be.client}
+
+	prefix := be.Dirname(restic.Handle{Type: t})
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var keyMarker, uploadIDMarker string
+	aborted := 0
+	for {
+		if ctx.Err() != nil {
+			return aborted, ctx.Err()
+		}
+
+		be.sem.GetToken()
+		res, err := core.ListMultipartUploads(be.cfg.Bucket, prefix, keyMarker, uploadIDMarker, "", 1000)
+		be.sem.ReleaseToken()
+		if err != nil {
+			return aborted, errors.Wrap(err, "ListMultipartUploads")
+		}
+
+		for _, u := range res.Uploads {
+			if !u.Initiated.Before(before) {
+				continue
+			}
+
+			debug.Log("aborting upload %v of %v started at %v", u.UploadID, u.Key, u.Initiated)
+			be.sem.GetToken()
+			err := core.AbortMultipartUpload(be.cfg.Bucket, u.Key, u.UploadID)
+			be.sem.ReleaseToken()
+			if err != nil {
+				return aborted, errors.Wrap(err, "AbortMultipartUpload")
+			}
+			aborted++
+		}
+
+		if !res.IsTruncated {
+			return aborted, nil
+		}
+		keyMarker, uploadIDMarker = res.NextKeyMarker, res.NextUploadIDMarker
+	}
+}
@@ -0,0 +1,185 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeMultipart implements the requests for multipart uploads of an S3
+// server. It starts with an incomplete upload for the object name given in
+// pending, with the parts in parts.
+type fakeMultipart struct {
+	sync.Mutex
+	pending string
+
+	// parts maps the part number to the MD5 hash of the part for the
+	// incomplete upload
+	parts map[int]string
+
+	// sent contains the numbers of all parts received
+	sent []int
+
+	// completed contains the parts of the completed upload
+	completed []int
+	uploadID  string
+
+	// failComplete makes requests to complete an upload fail
+	failComplete bool
+
+	// aborted contains the IDs of all aborted uploads
+	aborted []string
+
+	// listPrefix is the prefix of the last listing of uploads
+	listPrefix string
+}
+
+func (f *fakeMultipart) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == "HEAD":
+		w.WriteHeader(http.StatusNotFound)
+
+	case r.Method == "GET" && q["uploads"] != nil:
+		f.listPrefix = q.Get("prefix")
+		fmt.Fprintf(w, `<ListMultipartUploadsResult><Upload><Key>%s</Key><UploadId>pending</UploadId>`+
+			`<Initiated>2018-01-01T00:00:00.000Z</Initiated></Upload></ListMultipartUploadsResult>`, f.pending)
+
+	case r.Method == "GET" && q.Get("uploadId") != "":
+		fmt.Fprint(w, `<ListPartsResult>`)
+		for n, etag := range f.parts {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%s"</ETag><Size>%d</Size></Part>`, n, etag, partSize)
+		}
+		fmt.Fprint(w, `</ListPartsResult>`)
+
+	case r.Method == "POST" && q["uploads"] != nil:
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>new</UploadId></InitiateMultipartUploadResult>`)
+
+	case r.Method == "PUT" && q.Get("partNumber") != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		f.sent = append(f.sent, n)
+
+		// the body may use a chunked encoding, so use the Content-MD5
+		// header for the ETag
+		sum, _ := base64.StdEncoding.DecodeString(r.Header.Get("Content-Md5"))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum)+`"`)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		f.aborted = append(f.aborted, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "POST" && q.Get("uploadId") != "" && f.failComplete:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>invalid part</Message></Error>`)
+
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		var body completeBody
+		if err := xml.Unmarshal(buf, &body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.uploadID = q.Get("uploadId")
+		f.completed = nil
+		for _, p := range body.Parts {
+			f.completed = append(f.completed, p.PartNumber)
+		}
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><ETag>"x"</ETag></CompleteMultipartUploadResult>`)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// completeBody is used to decode the parts of a complete request.
+type completeBody struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int
+	} `xml:"Part"`
+}
+
+func TestSaveMultipart(t *testing.T) {
+	ctx := context.TODO()
+	data := rtest.Random(23, 2*partSize+100)
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+
+	sum := md5.Sum(data[:partSize])
+	fake := &fakeMultipart{
+		parts: map[int]string{
+			1: hex.EncodeToString(sum[:]),
+			2: "d41d8cd98f00b204e9800998ecf8427e",
+		},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	be := openFake(t, srv, NewConfig())
+
+	// without an incomplete upload for the file, all parts are sent
+	fake.pending = "foo"
+	rtest.OK(t, be.Save(ctx, h, bytes.NewReader(data)))
+	rtest.Equals(t, []int{1, 2, 3}, fake.sent)
+	rtest.Equals(t, "new", fake.uploadID)
+	rtest.Equals(t, []int{1, 2, 3}, fake.completed)
+
+	// the incomplete upload is resumed, only the parts which differ are sent
+	fake.pending = be.Filename(h)
+	fake.sent = nil
+	rtest.OK(t, be.Save(ctx, h, ioutil.NopCloser(bytes.NewReader(data))))
+	rtest.Equals(t, []int{2, 3}, fake.sent)
+	rtest.Equals(t, "pending", fake.uploadID)
+	rtest.Equals(t, []int{1, 2, 3}, fake.completed)
+	rtest.Equals(t, []string(nil), fake.aborted)
+
+	// a failed upload is aborted
+	fake.pending = "foo"
+	fake.failComplete = true
+	err := be.Save(ctx, h, bytes.NewReader(data))
+	rtest.Assert(t, err != nil, "failed upload returned no error")
+	rtest.Equals(t, []string{"new"}, fake.aborted)
+}
+
+func TestAbortUploads(t *testing.T) {
+	ctx := context.TODO()
+	fake := &fakeMultipart{pending: "restic/data/ab/abcd"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	be := openFake(t, srv, NewConfig())
+
+	// the upload was started on 2018-01-01
+	n, err := be.AbortUploads(ctx, restic.DataFile, time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC))
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+	rtest.Equals(t, "restic/data/", fake.listPrefix)
+
+	n, err = be.AbortUploads(ctx, restic.DataFile, time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, n)
+	rtest.Equals(t, []string{"pending"}, fake.aborted)
+}
@@ -0,0 +1,164 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/minio/minio-go"
+)
+
+// make sure that *Backend implements restic.ObjectLocker
+var _ restic.ObjectLocker = &Backend{}
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// versioningConfiguration is the body of a request to configure the
+// versioning of a bucket.
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Status  string   `xml:"Status"`
+}
+
+// objectLockConfiguration is the body of a request to configure the object
+// lock of a bucket, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObjectLockConfiguration.html
+type objectLockConfiguration struct {
+	XMLName           xml.Name `xml:"ObjectLockConfiguration"`
+	Xmlns             string   `xml:"xmlns,attr"`
+	ObjectLockEnabled string   `xml:"ObjectLockEnabled"`
+	Mode              string   `xml:"Rule>DefaultRetention>Mode"`
+	Days              uint     `xml:"Rule>DefaultRetention>Days"`
+}
+
+// EnableObjectLock enables versioning and Object Lock for the bucket, with a
+// default retention so that all objects saved afterwards are kept for
+// lock.Days days. This applies to the whole bucket, not only the prefix of
+// the repository.
+func (be *Backend) EnableObjectLock(ctx context.Context, lock restic.ObjectLock) error {
+	debug.Log("enable object lock for bucket %v: %v", be.cfg.Bucket, lock)
+
+	err := be.signedRequest(ctx, "PUT", "", "versioning", versioningConfiguration{
+		Xmlns:  s3Namespace,
+		Status: "Enabled",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = be.signedRequest(ctx, "PUT", "", "object-lock", objectLockConfiguration{
+		Xmlns:             s3Namespace,
+		ObjectLockEnabled: "Enabled",
+		Mode:              strings.ToUpper(lock.Mode),
+		Days:              lock.Days,
+	})
+	if err != nil {
+		return err
+	}
+
+	be.objectLock = &lock
+	return nil
+}
+
+// UseObjectLock makes the backend send the Content-MD5 header for all
+// uploads, which buckets with Object Lock require.
+func (be *Backend) UseObjectLock(lock restic.ObjectLock) {
+	be.objectLock = &lock
+}
+
+// retention is the body of requests to get and set the retention of an
+// object, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObjectRetention.html
+type retention struct {
+	XMLName         xml.Name  `xml:"Retention"`
+	Xmlns           string    `xml:"xmlns,attr,omitempty"`
+	Mode            string    `xml:"Mode"`
+	RetainUntilDate time.Time `xml:"RetainUntilDate"`
+}
+
+// ExtendRetention sets the retention of the current version of the object for
+// h to until. S3 refuses to shorten the retention in compliance mode, and in
+// governance mode without special permissions.
+func (be *Backend) ExtendRetention(ctx context.Context, h restic.Handle, until time.Time) error {
+	if be.objectLock == nil {
+		return errors.Fatal("the bucket does not use object lock")
+	}
+
+	debug.Log("extend retention of %v until %v", h, until)
+	return be.signedRequest(ctx, "PUT", be.Filename(h), "retention", retention{
+		Xmlns:           s3Namespace,
+		Mode:            strings.ToUpper(be.objectLock.Mode),
+		RetainUntilDate: until.UTC(),
+	})
+}
+
+// RetainUntil returns the end of the retention of the current version of the
+// object for h.
+func (be *Backend) RetainUntil(ctx context.Context, h restic.Handle) (time.Time, error) {
+	var r retention
+	err := be.request(ctx, "GET", be.Filename(h), "retention", nil, &r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	debug.Log("%v is retained until %v", h, r.RetainUntilDate)
+	return r.RetainUntilDate, nil
+}
+
+// saveWithMD5 uploads the data with a single request which includes the
+// Content-MD5 header. Readers which cannot seek are read into memory first.
+func (be *Backend) saveWithMD5(ctx context.Context, h restic.Handle, objName string, rd io.Reader) error {
+	rs, ok := rd.(io.ReadSeeker)
+	if !ok {
+		buf, err := ioutil.ReadAll(rd)
+		if err != nil {
+			return errors.Wrap(err, "ReadAll")
+		}
+		rs = bytes.NewReader(buf)
+	}
+
+	sum, size, err := contentMD5(rs)
+	if err != nil {
+		return err
+	}
+
+	be.sem.GetToken()
+	debug.Log("PutObject(%v, %v) with MD5 %x", be.cfg.Bucket, objName, sum)
+	core := minio.Core{Client: be.client}
+	_, err = core.PutObject(be.cfg.Bucket, objName, size, rs, sum, nil, be.metadata(ctx, h))
+	be.sem.ReleaseToken()
+
+	return errors.Wrap(err, "client.PutObject")
+}
+
+// contentMD5 returns the MD5 hash and the length of the remaining data in rd
+// and seeks back to the current position.
+func contentMD5(rd io.ReadSeeker) ([]byte, int64, error) {
+	pos, err := rd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Seek")
+	}
+
+	h := md5.New()
+	n, err := io.Copy(h, rd)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Copy")
+	}
+
+	_, err = rd.Seek(pos, io.SeekStart)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Seek")
+	}
+
+	return h.Sum(nil), n, nil
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeLockedBucket records the configuration requests and the Content-MD5
+// headers of uploads sent to an S3 server.
+type fakeLockedBucket struct {
+	sync.Mutex
+	config  map[string]string
+	uploads map[string]string
+}
+
+func (f *fakeLockedBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sum := md5.Sum(buf)
+	md5ok := r.Header.Get("Content-Md5") == base64.StdEncoding.EncodeToString(sum[:])
+
+	switch {
+	case r.URL.RawQuery == "retention=" && r.Method == "PUT" && md5ok:
+		f.config[r.URL.Path+"?retention="] = string(buf)
+		w.WriteHeader(http.StatusOK)
+	case r.URL.RawQuery == "retention=" && r.Method == "GET":
+		_, _ = w.Write([]byte(f.config[r.URL.Path+"?retention="]))
+	case r.Method == "HEAD":
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == "PUT" && r.URL.Path == "/bucket" && md5ok:
+		f.config[r.URL.RawQuery] = string(buf)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "PUT":
+		// the minio client uses a chunked encoding for the body of uploads
+		// via HTTP, so only the header is recorded
+		f.uploads[r.URL.Path] = r.Header.Get("Content-Md5")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestObjectLock(t *testing.T) {
+	fake := &fakeLockedBucket{
+		config:  make(map[string]string),
+		uploads: make(map[string]string),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.TODO()
+	be := openFake(t, srv, NewConfig())
+
+	lock := restic.ObjectLock{Mode: restic.ObjectLockCompliance, Days: 30}
+	rtest.OK(t, be.EnableObjectLock(ctx, lock))
+
+	rtest.Assert(t, strings.Contains(fake.config["versioning="], "<Status>Enabled</Status>"),
+		"versioning not enabled: %q", fake.config["versioning="])
+	rtest.Assert(t, strings.Contains(fake.config["object-lock="], "<Mode>COMPLIANCE</Mode><Days>30</Days>"),
+		"default retention not set: %q", fake.config["object-lock="])
+
+	// all uploads include the Content-MD5 header now, also for readers which
+	// cannot seek
+	data := []byte("foobar data")
+	sum := md5.Sum(data)
+	want := base64.StdEncoding.EncodeToString(sum[:])
+
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, bytes.NewReader(data)))
+	rtest.Equals(t, want, fake.uploads["/bucket/"+be.Filename(h)])
+
+	h = restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(ctx, h, ioutil.NopCloser(bytes.NewReader(data))))
+	rtest.Equals(t, want, fake.uploads["/bucket/"+be.Filename(h)])
+
+	// the retention of single objects is set with the mode of the bucket
+	until := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	rtest.OK(t, be.ExtendRetention(ctx, h, until.In(time.FixedZone("CET", 3600))))
+	body := fake.config["/bucket/"+be.Filename(h)+"?retention="]
+	rtest.Assert(t, strings.Contains(body, "<Mode>COMPLIANCE</Mode><RetainUntilDate>2018-03-01T12:00:00Z</RetainUntilDate>"),
+		"wrong retention request: %q", body)
+
+	retained, err := be.RetainUntil(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, retained.Equal(until), "wrong retention, want %v, got %v", until, retained)
+}
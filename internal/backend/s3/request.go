@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/s3signer"
+	"github.com/minio/minio-go/pkg/s3utils"
+)
+
+// signedRequest sends a request which the minio client does not support. The
+// request is for the sub-resource query (e.g. "restore") of the object, or of
+// the bucket if objName is empty. The body is marshalled as XML. Responses
+// with a status other than 200, 202 and the ones listed in ok are returned as
+// an error.
+func (be *Backend) signedRequest(ctx context.Context, method, objName, query string, body interface{}, ok ...int) error {
+	return be.request(ctx, method, objName, query, body, nil, ok...)
+}
+
+// request works like signedRequest, a nil body sends an empty request. If
+// response is not nil, the XML body of a successful response is decoded into
+// it.
+func (be *Backend) request(ctx context.Context, method, objName, query string, body, response interface{}, ok ...int) error {
+	var buf []byte
+	var err error
+	if body != nil {
+		buf, err = xml.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "xml.Marshal")
+		}
+	}
+
+	region := be.cfg.Region
+	if region == "" {
+		region, err = be.client.GetBucketLocation(be.cfg.Bucket)
+		if err != nil {
+			return errors.Wrap(err, "client.GetBucketLocation")
+		}
+	}
+
+	target := be.requestURL(objName, query)
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(buf))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequest")
+	}
+
+	sha := sha256.Sum256(buf)
+	sum := md5.Sum(buf)
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha[:]))
+
+	creds, err := be.creds.Get()
+	if err != nil {
+		return errors.Wrap(err, "credentials.Get")
+	}
+	req = s3signer.SignV4(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, region)
+
+	be.sem.GetToken()
+	defer be.sem.ReleaseToken()
+
+	debug.Log("%v %v", method, target.String())
+	client := http.Client{Transport: be.transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "%v request", query)
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		if response != nil {
+			return errors.Wrap(xml.NewDecoder(resp.Body).Decode(response), "xml.Decode")
+		}
+		return nil
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+
+	var e minio.ErrorResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		return errors.Errorf("%v request failed: %v", query, resp.Status)
+	}
+
+	return errors.Errorf("%v request failed: %v: %v", query, e.Code, e.Message)
+}
+
+// requestURL returns the URL of the sub-resource query of the object, or of
+// the bucket if objName is empty. Like the minio client, virtual-host style is
+// used for servers which support it.
+func (be *Backend) requestURL(objName, query string) *url.URL {
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     be.cfg.Endpoint,
+		RawQuery: query,
+	}
+	if be.cfg.UseHTTP {
+		u.Scheme = "http"
+	}
+
+	if s3utils.IsVirtualHostSupported(*u, be.cfg.Bucket) {
+		u.Host = fmt.Sprintf("%s.%s", be.cfg.Bucket, u.Host)
+		u.Path = "/" + objName
+	} else {
+		u.Path = "/" + be.cfg.Bucket
+		if objName != "" {
+			u.Path += "/" + objName
+		}
+	}
+
+	return u
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -22,9 +23,17 @@ import (
 // Backend stores data on an S3 endpoint.
 type Backend struct {
 	client *minio.Client
+	creds  *credentials.Credentials
 	sem    *backend.Semaphore
 	cfg    Config
 	backend.Layout
+
+	// transport is also used for requests the minio client does not support
+	transport http.RoundTripper
+
+	// objectLock is set for buckets with Object Lock, which require the
+	// Content-MD5 header for all uploads
+	objectLock *restic.ObjectLock
 }
 
 // make sure that *Backend implements backend.Backend
@@ -32,31 +41,49 @@ var _ restic.Backend = &Backend{}
 
 const defaultLayout = "default"
 
-func open(cfg Config) (*Backend, error) {
+func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
 	if cfg.MaxRetries > 0 {
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
-	var client *minio.Client
-	var err error
-
-	if cfg.KeyID == "" || cfg.Secret == "" {
-		debug.Log("key/secret not found, trying to get them from IAM")
-		creds := credentials.NewIAM("")
-		client, err = minio.NewWithCredentials(cfg.Endpoint, creds, !cfg.UseHTTP, "")
+	// Chains all credential types, in the following order:
+	//  - Static credentials provided by user
+	//  - AWS env vars (i.e. AWS_ACCESS_KEY_ID)
+	//  - Minio env vars (i.e. MINIO_ACCESS_KEY)
+	//  - AWS creds file (i.e. AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials)
+	//  - Minio creds file (i.e. MINIO_SHARED_CREDENTIALS_FILE or ~/.mc/config.json)
+	//  - IAM profile based credentials. (performs an HTTP
+	//    call to a pre-defined endpoint, only valid inside
+	//    configured ec2 instances)
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		signedOnly{&credentials.Static{
+			Value: credentials.Value{
+				AccessKeyID:     cfg.KeyID,
+				SecretAccessKey: cfg.Secret,
+			},
+		}},
+		signedOnly{&credentials.EnvAWS{}},
+		signedOnly{&credentials.EnvMinio{}},
+		signedOnly{&credentials.FileAWSCredentials{}},
+		signedOnly{&credentials.FileMinioClient{}},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	})
 
-		if err != nil {
-			return nil, errors.Wrap(err, "minio.NewWithCredentials")
-		}
-	} else {
-		debug.Log("key/secret found")
-		client, err = minio.New(cfg.Endpoint, cfg.KeyID, cfg.Secret, !cfg.UseHTTP)
+	client, err := minio.NewWithCredentials(cfg.Endpoint, creds, !cfg.UseHTTP, cfg.Region)
+	if err != nil {
+		return nil, errors.Wrap(err, "minio.NewWithCredentials")
+	}
 
-		if err != nil {
-			return nil, errors.Wrap(err, "minio.New")
-		}
+	switch cfg.RestoreTier {
+	case "", "Expedited", "Standard", "Bulk":
+	default:
+		return nil, errors.Fatalf("invalid restore tier %q, must be Expedited, Standard or Bulk", cfg.RestoreTier)
 	}
 
 	sem, err := backend.NewSemaphore(cfg.Connections)
@@ -65,12 +92,14 @@ func open(cfg Config) (*Backend, error) {
 	}
 
 	be := &Backend{
-		client: client,
-		sem:    sem,
-		cfg:    cfg,
+		client:    client,
+		creds:     creds,
+		sem:       sem,
+		transport: rt,
+		cfg:       cfg,
 	}
 
-	client.SetCustomTransport(backend.Transport())
+	client.SetCustomTransport(be.transport)
 
 	l, err := backend.ParseLayout(be, cfg.Layout, defaultLayout, cfg.Prefix)
 	if err != nil {
@@ -82,16 +111,36 @@ func open(cfg Config) (*Backend, error) {
 	return be, nil
 }
 
+// signedOnly wraps a credentials provider and returns an error instead of
+// anonymous credentials, so that the next provider in a chain is tried.
+type signedOnly struct {
+	credentials.Provider
+}
+
+// Retrieve returns the credentials of the underlying provider.
+func (p signedOnly) Retrieve() (credentials.Value, error) {
+	v, err := p.Provider.Retrieve()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	if v.SignerType.IsAnonymous() || v.AccessKeyID == "" || v.SecretAccessKey == "" {
+		return credentials.Value{}, errors.New("no credentials found")
+	}
+
+	return v, nil
+}
+
 // Open opens the S3 backend at bucket and region. The bucket is created if it
 // does not exist yet.
-func Open(cfg Config) (restic.Backend, error) {
-	return open(cfg)
+func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	return open(cfg, rt)
 }
 
 // Create opens the S3 backend at bucket and region and creates the bucket if
 // it does not exist yet.
-func Create(cfg Config) (restic.Backend, error) {
-	be, err := open(cfg)
+func Create(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
+	be, err := open(cfg, rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "open")
 	}
@@ -102,8 +151,8 @@ func Create(cfg Config) (restic.Backend, error) {
 	}
 
 	if !found {
-		// create new bucket with default ACL in default region
-		err = be.client.MakeBucket(cfg.Bucket, "")
+		// create new bucket with default ACL in the configured region
+		err = be.client.MakeBucket(cfg.Bucket, cfg.Region)
 		if err != nil {
 			return nil, errors.Wrap(err, "client.MakeBucket")
 		}
@@ -273,9 +322,22 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err
 		debug.Log("reader is %#T, no specific workaround enabled", rd)
 	}
 
+	var multipart bool
+	rd, multipart, err = needsMultipart(rd)
+	if err != nil {
+		return err
+	}
+	if multipart {
+		return be.saveMultipart(ctx, h, objName, rd)
+	}
+
+	if be.objectLock != nil {
+		return be.saveWithMD5(ctx, h, objName, rd)
+	}
+
 	be.sem.GetToken()
 	debug.Log("PutObject(%v, %v)", be.cfg.Bucket, objName)
-	n, err := be.client.PutObject(be.cfg.Bucket, objName, rd, "application/octet-stream")
+	n, err := be.client.PutObjectWithMetadata(be.cfg.Bucket, objName, rd, be.metadata(ctx, h), nil)
 	be.sem.ReleaseToken()
 
 	debug.Log("%v -> %v bytes, err %#v: %v", objName, n, err, err)
@@ -283,6 +345,26 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (err
 	return errors.Wrap(err, "client.PutObject")
 }
 
+// metadata returns the headers sent when the file at h is uploaded. The
+// storage class is only set for pack files with data blobs, tree blobs and all
+// other files must always be readable without restoring them first.
+func (be *Backend) metadata(ctx context.Context, h restic.Handle) map[string][]string {
+	md := map[string][]string{
+		"Content-Type": {"application/octet-stream"},
+	}
+
+	if be.cfg.StorageClass == "" || h.Type != restic.DataFile {
+		return md
+	}
+
+	if t, ok := restic.BlobTypeFromContext(ctx); !ok || t != restic.DataBlob {
+		return md
+	}
+
+	md["X-Amz-Storage-Class"] = []string{be.cfg.StorageClass}
+	return md
+}
+
 // wrapReader wraps an io.ReadCloser to run an additional function on Close.
 type wrapReader struct {
 	io.ReadCloser
@@ -328,6 +410,9 @@ func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset
 	rd, _, err := coreClient.GetObject(be.cfg.Bucket, objName, headers)
 	if err != nil {
 		be.sem.ReleaseToken()
+		if minio.ToErrorResponse(err).Code == "InvalidObjectState" {
+			return nil, errors.Wrapf(err, "%v is archived and must be restored before it can be read", h)
+		}
 		return nil, err
 	}
 
@@ -452,7 +537,9 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ScrubFile,
+		restic.SessionFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// make sure that *Backend implements restic.Thawer
+var _ restic.Thawer = &Backend{}
+
+// archived returns true if objects in the storage class must be restored
+// before they can be read.
+func archived(class string) bool {
+	return class == "GLACIER" || class == "DEEP_ARCHIVE"
+}
+
+// restoreState is the state of the restore of an archived object.
+type restoreState int
+
+const (
+	restoreNone restoreState = iota
+	restoreOngoing
+	restoreDone
+)
+
+// parseRestoreHeader returns the state of the restore from the value of the
+// X-Amz-Restore header, e.g. `ongoing-request="false", expiry-date="..."`.
+func parseRestoreHeader(s string) restoreState {
+	switch {
+	case strings.Contains(s, `ongoing-request="true"`):
+		return restoreOngoing
+	case strings.Contains(s, `ongoing-request="false"`):
+		return restoreDone
+	default:
+		return restoreNone
+	}
+}
+
+// Thaw checks whether the pack file at h is archived. If it is, a restore is
+// requested (unless this has already been done) and false is returned. This
+// is only done if archived pack files are expected, either because of the
+// storage class or because restore-days is set, for all other files true is
+// returned without contacting the server.
+func (be *Backend) Thaw(ctx context.Context, h restic.Handle) (bool, error) {
+	if h.Type != restic.DataFile || (be.cfg.RestoreDays == 0 && !archived(be.cfg.StorageClass)) {
+		return true, nil
+	}
+
+	objName := be.Filename(h)
+
+	be.sem.GetToken()
+	info, err := be.client.StatObject(be.cfg.Bucket, objName)
+	be.sem.ReleaseToken()
+	if err != nil {
+		return false, errors.Wrap(err, "client.StatObject")
+	}
+
+	class := info.Metadata.Get("X-Amz-Storage-Class")
+	if !archived(class) {
+		return true, nil
+	}
+
+	switch parseRestoreHeader(info.Metadata.Get("X-Amz-Restore")) {
+	case restoreDone:
+		return true, nil
+	case restoreOngoing:
+		debug.Log("restore of %v is in progress", h)
+		return false, nil
+	}
+
+	debug.Log("%v is stored with class %v, requesting restore", h, class)
+	return false, be.requestRestore(ctx, objName)
+}
+
+// restoreRequest is the body of a restore request, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectPOSTrestore.html
+type restoreRequest struct {
+	XMLName xml.Name `xml:"RestoreRequest"`
+	Days    uint     `xml:"Days"`
+	Tier    string   `xml:"GlacierJobParameters>Tier"`
+}
+
+// requestRestore asks the server to restore the archived object.
+func (be *Backend) requestRestore(ctx context.Context, objName string) error {
+	body := restoreRequest{
+		Days: be.cfg.RestoreDays,
+		Tier: be.cfg.RestoreTier,
+	}
+	if body.Days == 0 {
+		body.Days = 1
+	}
+	if body.Tier == "" {
+		body.Tier = "Standard"
+	}
+
+	// a conflict means that the restore has already been requested by
+	// another client
+	return be.signedRequest(ctx, "POST", objName, "restore", body, http.StatusConflict)
+}
@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeArchive emulates the HEAD and restore requests of an S3 server for a
+// single archived object.
+type fakeArchive struct {
+	sync.Mutex
+	class    string
+	restore  string
+	requests []restoreRequest
+}
+
+func (f *fakeArchive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch {
+	case r.Method == "HEAD":
+		w.Header().Set("Content-Length", "23")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2018 00:00:00 GMT")
+		w.Header().Set("X-Amz-Storage-Class", f.class)
+		if f.restore != "" {
+			w.Header().Set("X-Amz-Restore", f.restore)
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "POST" && r.URL.Query()["restore"] != nil:
+		buf, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var req restoreRequest
+		if err := xml.Unmarshal(buf, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.requests = append(f.requests, req)
+		f.restore = `ongoing-request="true"`
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func openFake(t testing.TB, srv *httptest.Server, cfg Config) *Backend {
+	u, err := url.Parse(srv.URL)
+	rtest.OK(t, err)
+
+	cfg.Endpoint = u.Host
+	cfg.UseHTTP = true
+	cfg.KeyID = "key"
+	cfg.Secret = "secret"
+	cfg.Bucket = "bucket"
+	cfg.Prefix = "restic"
+	cfg.Layout = "default"
+	cfg.Region = "us-east-1"
+
+	be, err := open(cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+	return be
+}
+
+func TestThaw(t *testing.T) {
+	fake := &fakeArchive{class: "GLACIER"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.TODO()
+	h := restic.Handle{Type: restic.DataFile, Name: restic.NewRandomID().String()}
+
+	// without an archival storage class or restore-days, the server is not
+	// contacted at all
+	cfg := NewConfig()
+	be := openFake(t, srv, cfg)
+	ready, err := be.Thaw(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ready, "file not ready")
+	rtest.Equals(t, 0, len(fake.requests))
+
+	cfg.StorageClass = "DEEP_ARCHIVE"
+	cfg.RestoreDays = 3
+	cfg.RestoreTier = "Bulk"
+	be = openFake(t, srv, cfg)
+
+	// the first call requests the restore
+	ready, err = be.Thaw(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ready, "archived file is ready")
+	rtest.Equals(t, []restoreRequest{{XMLName: xml.Name{Local: "RestoreRequest"}, Days: 3, Tier: "Bulk"}}, fake.requests)
+
+	// while the restore is in progress, no new request is sent
+	ready, err = be.Thaw(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ready, "file is ready while the restore is in progress")
+	rtest.Equals(t, 1, len(fake.requests))
+
+	fake.restore = `ongoing-request="false", expiry-date="Fri, 23 Dec 2012 00:00:00 GMT"`
+	ready, err = be.Thaw(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ready, "restored file is not ready")
+
+	// files in other storage classes can be read directly
+	fake.class = "STANDARD"
+	fake.restore = ""
+	ready, err = be.Thaw(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ready, "file in standard storage class is not ready")
+	rtest.Equals(t, 1, len(fake.requests))
+}
+
+func TestParseRestoreHeader(t *testing.T) {
+	var tests = []struct {
+		header string
+		state  restoreState
+	}{
+		{"", restoreNone},
+		{`ongoing-request="true"`, restoreOngoing},
+		{`ongoing-request="false", expiry-date="Fri, 23 Dec 2012 00:00:00 GMT"`, restoreDone},
+	}
+
+	for _, test := range tests {
+		rtest.Equals(t, test.state, parseRestoreHeader(test.header))
+	}
+}
+
+func TestMetadataStorageClass(t *testing.T) {
+	be := &Backend{cfg: Config{StorageClass: "GLACIER"}}
+
+	var tests = []struct {
+		ctx   context.Context
+		h     restic.Handle
+		class string
+	}{
+		{restic.ContextWithBlobType(context.TODO(), restic.DataBlob), restic.Handle{Type: restic.DataFile}, "GLACIER"},
+		{restic.ContextWithBlobType(context.TODO(), restic.TreeBlob), restic.Handle{Type: restic.DataFile}, ""},
+		{context.TODO(), restic.Handle{Type: restic.DataFile}, ""},
+		{restic.ContextWithBlobType(context.TODO(), restic.DataBlob), restic.Handle{Type: restic.IndexFile}, ""},
+	}
+
+	for _, test := range tests {
+		md := be.metadata(test.ctx, test.h)
+		rtest.Equals(t, "application/octet-stream", http.Header(md).Get("Content-Type"))
+		rtest.Equals(t, test.class, http.Header(md).Get("X-Amz-Storage-Class"))
+	}
+}
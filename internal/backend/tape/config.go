@@ -0,0 +1,41 @@
+package tape
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config holds all information needed to open a repository stored in
+// sequential volume files.
+type Config struct {
+	Path       string
+	VolumeSize uint `option:"volume-size" help:"start a new volume file when the current one has reached this size in MiB (default: 4096)"`
+}
+
+func init() {
+	options.Register("tape", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		VolumeSize: 4096,
+	}
+}
+
+// ParseConfig parses a tape backend config.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "tape:") {
+		return nil, errors.New(`invalid format, prefix "tape" not found`)
+	}
+
+	if s[5:] == "" {
+		return nil, errors.New("tape: directory for the volume files is missing")
+	}
+
+	cfg := NewConfig()
+	cfg.Path = s[5:]
+	return cfg, nil
+}
@@ -0,0 +1,574 @@
+// Package tape implements a backend which writes the files of a repository
+// sequentially into large volume files, which are never modified after they
+// have been written. This suits tape drives (e.g. via LTFS) and write-once
+// media. Removing a file appends a removal record to the current volume.
+//
+// Lock files are an exception: they only exist while restic is running, so
+// they are stored as regular files in the "locks" subdirectory.
+//
+// On Open, the index at the end of each volume is read, so that files can be
+// loaded from the volumes directly. Volumes can also be read without random
+// access by Unpack, which restores the files into another backend.
+package tape
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// location is the position of the data of a file in a volume.
+type location struct {
+	volume string
+	offset int64
+	length int64
+}
+
+// Backend stores files in sequential volumes in a directory.
+type Backend struct {
+	cfg Config
+
+	m     sync.Mutex
+	files map[restic.Handle]location
+
+	// cur is the volume which is currently written, if any
+	cur        *os.File
+	curName    string
+	curSize    int64
+	curEntries []indexEntry
+	nextVolume int
+}
+
+// ensure statically that *Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+const (
+	volumePrefix = "volume-"
+	volumeSuffix = ".rvol"
+	locksDir     = "locks"
+)
+
+// volumeName returns the file name of the volume with the number n.
+func volumeName(n int) string {
+	return fmt.Sprintf("%s%06d%s", volumePrefix, n, volumeSuffix)
+}
+
+// parseVolumeName returns the number of the volume with the file name.
+func parseVolumeName(name string) (int, bool) {
+	if !strings.HasPrefix(name, volumePrefix) || !strings.HasSuffix(name, volumeSuffix) {
+		return 0, false
+	}
+
+	var n int
+	_, err := fmt.Sscanf(name[len(volumePrefix):len(name)-len(volumeSuffix)], "%d", &n)
+	return n, err == nil
+}
+
+// readDir returns the entries of the directory.
+func readDir(dir string) ([]os.FileInfo, error) {
+	return (&backend.LocalFilesystem{}).ReadDir(dir)
+}
+
+// Open opens the repository in the directory of the config and reads the
+// index of all volumes.
+func Open(cfg Config) (*Backend, error) {
+	debug.Log("open tape backend at %v", cfg.Path)
+
+	if cfg.VolumeSize == 0 {
+		return nil, errors.Fatal("tape: volume size must be larger than zero")
+	}
+
+	be := &Backend{
+		cfg:        cfg,
+		files:      make(map[restic.Handle]location),
+		nextVolume: 1,
+	}
+
+	entries, err := readDir(cfg.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	var volumes []string
+	for _, fi := range entries {
+		n, ok := parseVolumeName(fi.Name())
+		if !ok || !fi.Mode().IsRegular() {
+			continue
+		}
+
+		volumes = append(volumes, fi.Name())
+		if n >= be.nextVolume {
+			be.nextVolume = n + 1
+		}
+	}
+
+	// the names of the volumes sort in the order in which they were written
+	sort.Strings(volumes)
+	for _, name := range volumes {
+		if err := be.loadVolume(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return be, nil
+}
+
+// loadVolume adds the files recorded in the index of the volume.
+func (be *Backend) loadVolume(name string) error {
+	f, err := fs.OpenFile(filepath.Join(be.cfg.Path, name), os.O_RDONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+
+	entries, finished, err := readIndex(f)
+	_ = f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "volume %v", name)
+	}
+
+	if !finished {
+		debug.Log("volume %v has not been finished, recovered %d records", name, len(entries))
+	}
+
+	for _, e := range entries {
+		if e.Removed {
+			delete(be.files, e.handle())
+			continue
+		}
+
+		be.files[e.handle()] = location{volume: name, offset: e.Offset, length: e.Length}
+	}
+
+	return nil
+}
+
+// Create creates the directory for the volumes of a new repository.
+func Create(cfg Config) (*Backend, error) {
+	debug.Log("create tape backend at %v", cfg.Path)
+
+	if err := fs.MkdirAll(filepath.Join(cfg.Path, locksDir), backend.Modes.Dir); err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	be, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := be.files[restic.Handle{Type: restic.ConfigFile}]; ok {
+		return nil, errors.New("config file already exists")
+	}
+
+	return be, nil
+}
+
+// Location returns this backend's location (the directory name).
+func (be *Backend) Location() string {
+	return be.cfg.Path
+}
+
+// lockFilename returns the name of the lock file for h.
+func (be *Backend) lockFilename(h restic.Handle) string {
+	return filepath.Join(be.cfg.Path, locksDir, h.Name)
+}
+
+// fileHandle returns the handle under which the file for h is stored. There
+// is only one config file, its name is ignored.
+func fileHandle(h restic.Handle) restic.Handle {
+	if h.Type == restic.ConfigFile {
+		h.Name = ""
+	}
+	return h
+}
+
+// notExist returns an error for a file which does not exist.
+func notExist(h restic.Handle) error {
+	return errors.Wrapf(os.ErrNotExist, "%v", h)
+}
+
+// IsNotExist returns true if the error is caused by a non existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
+
+// lenner wraps Len().
+type lenner interface {
+	Len() int
+}
+
+// readerSize returns the number of bytes which can be read from rd and a
+// reader yielding them. Readers which cannot report their size are read into
+// memory.
+func readerSize(rd io.Reader) (int64, io.Reader, error) {
+	switch r := rd.(type) {
+	case lenner:
+		return int64(r.Len()), rd, nil
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			break
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "Seek")
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, nil, errors.Wrap(err, "Seek")
+		}
+		return end - cur, rd, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, rd); err != nil {
+		return 0, nil, errors.Wrap(err, "Read")
+	}
+	return int64(buf.Len()), &buf, nil
+}
+
+// Save appends the data to the current volume.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+	h = fileHandle(h)
+
+	if h.Type == restic.LockFile {
+		return be.saveLock(h, rd)
+	}
+
+	length, rd, err := readerSize(rd)
+	if err != nil {
+		return err
+	}
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if _, ok := be.files[h]; ok {
+		return errors.Errorf("%v already exists", h)
+	}
+
+	offset, err := be.appendRecord(recordFile, h, length, rd)
+	if err != nil {
+		return err
+	}
+
+	be.files[h] = location{volume: be.curName, offset: offset, length: length}
+	be.curEntries = append(be.curEntries, indexEntry{Type: h.Type, Name: h.Name, Offset: offset, Length: length})
+
+	if be.curSize >= int64(be.cfg.VolumeSize)*1024*1024 {
+		// the file has been saved, a volume without an index can still be
+		// read sequentially
+		if err := be.finishVolume(); err != nil {
+			debug.Log("finishing volume failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// appendRecord writes a record to the current volume, which is created if
+// necessary, and returns the offset of the data in the volume. be.m must be
+// held by the caller.
+func (be *Backend) appendRecord(kind byte, h restic.Handle, length int64, rd io.Reader) (int64, error) {
+	if be.cur == nil {
+		if err := be.startVolume(); err != nil {
+			return 0, err
+		}
+	}
+
+	start := be.curSize
+	hdrLen, err := writeRecord(be.cur, kind, recordName(h), length, rd)
+	if err == nil {
+		err = errors.Wrap(be.cur.Sync(), "Sync")
+	}
+
+	if err != nil {
+		// the volume contains an incomplete record, so nothing can be
+		// appended to it anymore. The index lists all complete records.
+		debug.Log("writing %v to %v failed: %v", h, be.curName, err)
+		if pos, serr := be.cur.Seek(0, io.SeekCurrent); serr == nil {
+			be.curSize = pos
+		}
+		if ferr := be.finishVolume(); ferr != nil {
+			debug.Log("finishing volume %v failed: %v", be.curName, ferr)
+		}
+		return 0, err
+	}
+
+	be.curSize += hdrLen + length + crcSize
+	return start + hdrLen, nil
+}
+
+// startVolume creates a new volume. be.m must be held by the caller.
+func (be *Backend) startVolume() error {
+	for {
+		name := volumeName(be.nextVolume)
+		be.nextVolume++
+
+		f, err := fs.OpenFile(filepath.Join(be.cfg.Path, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+		if os.IsExist(errors.Cause(err)) {
+			// another process has created the volume in the meantime
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "OpenFile")
+		}
+
+		debug.Log("started volume %v", name)
+		be.cur, be.curName, be.curSize, be.curEntries = f, name, 0, nil
+		return nil
+	}
+}
+
+// finishVolume writes the index and trailer to the current volume and closes
+// it. be.m must be held by the caller.
+func (be *Backend) finishVolume() error {
+	if be.cur == nil {
+		return nil
+	}
+
+	debug.Log("finish volume %v with %d records", be.curName, len(be.curEntries))
+
+	err := writeIndex(be.cur, be.curSize, be.curEntries)
+	if err == nil {
+		err = errors.Wrap(be.cur.Sync(), "Sync")
+	}
+
+	cerr := be.cur.Close()
+	be.cur, be.curName, be.curSize, be.curEntries = nil, "", 0, nil
+
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(cerr, "Close")
+}
+
+// Load returns a reader that yields the contents of the file at h at the
+// given offset. If length is nonzero, only a portion of the file is
+// returned. rd must be closed after use.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, err
+	}
+	h = fileHandle(h)
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if h.Type == restic.LockFile {
+		return be.loadLock(h, length, offset)
+	}
+
+	be.m.Lock()
+	loc, ok := be.files[h]
+	be.m.Unlock()
+	if !ok {
+		return nil, notExist(h)
+	}
+
+	if offset > loc.length {
+		offset = loc.length
+	}
+
+	f, err := fs.Open(filepath.Join(be.cfg.Path, loc.volume))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = f.Seek(loc.offset+offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "Seek")
+	}
+
+	n := loc.length - offset
+	if length > 0 && int64(length) < n {
+		n = int64(length)
+	}
+
+	return backend.LimitReadCloser(f, n), nil
+}
+
+// Stat returns information about a blob.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat %v", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, err
+	}
+	h = fileHandle(h)
+
+	if h.Type == restic.LockFile {
+		fi, err := fs.Stat(be.lockFilename(h))
+		if err != nil {
+			return restic.FileInfo{}, errors.Wrap(err, "Stat")
+		}
+		return restic.FileInfo{Size: fi.Size()}, nil
+	}
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	loc, ok := be.files[h]
+	if !ok {
+		return restic.FileInfo{}, notExist(h)
+	}
+
+	return restic.FileInfo{Size: loc.length}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	_, err := be.Stat(ctx, h)
+	if be.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove appends a record to the current volume which marks the file as
+// removed, the data stays in the volume it was written to.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove %v", h)
+	if err := h.Valid(); err != nil {
+		return err
+	}
+	h = fileHandle(h)
+
+	if h.Type == restic.LockFile {
+		return fs.Remove(be.lockFilename(h))
+	}
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if _, ok := be.files[h]; !ok {
+		return notExist(h)
+	}
+
+	if _, err := be.appendRecord(recordRemoved, h, 0, bytes.NewReader(nil)); err != nil {
+		return err
+	}
+
+	delete(be.files, h)
+	be.curEntries = append(be.curEntries, indexEntry{Type: h.Type, Name: h.Name, Removed: true})
+	return nil
+}
+
+// List returns a channel that yields all names of blobs of type t. A
+// goroutine is started for this.
+func (be *Backend) List(ctx context.Context, t restic.FileType) <-chan string {
+	debug.Log("List %v", t)
+
+	var names []string
+	if t == restic.LockFile {
+		entries, err := readDir(filepath.Join(be.cfg.Path, locksDir))
+		if err != nil {
+			debug.Log("ReadDir: %v", err)
+		}
+		for _, fi := range entries {
+			if fi.Mode().IsRegular() {
+				names = append(names, fi.Name())
+			}
+		}
+	} else {
+		be.m.Lock()
+		for h := range be.files {
+			if h.Type == t {
+				names = append(names, h.Name)
+			}
+		}
+		be.m.Unlock()
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for _, name := range names {
+			select {
+			case ch <- name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Close finishes the current volume.
+func (be *Backend) Close() error {
+	debug.Log("Close()")
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	return be.finishVolume()
+}
+
+// saveLock stores a lock file in the locks directory.
+func (be *Backend) saveLock(h restic.Handle, rd io.Reader) error {
+	filename := be.lockFilename(h)
+	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	if os.IsNotExist(errors.Cause(err)) {
+		if err := fs.MkdirAll(filepath.Dir(filename), backend.Modes.Dir); err != nil {
+			return errors.Wrap(err, "MkdirAll")
+		}
+
+		f, err = fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	}
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	if _, err = io.Copy(f, rd); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Write")
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Sync")
+	}
+
+	return errors.Wrap(f.Close(), "Close")
+}
+
+// loadLock returns a reader for a lock file.
+func (be *Backend) loadLock(h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	f, err := fs.Open(be.lockFilename(h))
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	if length > 0 {
+		return backend.LimitReadCloser(f, int64(length)), nil
+	}
+
+	return f, nil
+}
@@ -0,0 +1,263 @@
+package tape_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/tape"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-tape-")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("create new backend at %v", dir)
+
+			cfg := tape.NewConfig()
+			cfg.Path = dir
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(tape.Config)
+			return tape.Create(cfg)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(tape.Config)
+			return tape.Open(cfg)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(tape.Config)
+			if !rtest.TestCleanupTempDirs {
+				t.Logf("leaving test backend dir at %v", cfg.Path)
+			}
+
+			rtest.RemoveAll(t, cfg.Path)
+			return nil
+		},
+	}
+}
+
+func TestBackend(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackend(t *testing.B) {
+	newTestSuite(t).RunBenchmarks(t)
+}
+
+func testHandle(data string) restic.Handle {
+	return restic.Handle{Type: restic.DataFile, Name: restic.Hash([]byte(data)).String()}
+}
+
+func save(t testing.TB, be restic.Backend, data string) restic.Handle {
+	h := testHandle(data)
+	rtest.OK(t, be.Save(context.TODO(), h, strings.NewReader(data)))
+	return h
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) string {
+	rd, err := be.Load(context.TODO(), h, 0, 0)
+	rtest.OK(t, err)
+	buf, err := ioutil.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+	return string(buf)
+}
+
+func listVolumes(t testing.TB, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "volume-*.rvol"))
+	rtest.OK(t, err)
+	return names
+}
+
+func newTestConfig(t testing.TB) (tape.Config, func()) {
+	dir, cleanup := rtest.TempDir(t)
+	cfg := tape.NewConfig()
+	cfg.Path = dir
+	return cfg, cleanup
+}
+
+func TestVolumeRollover(t *testing.T) {
+	cfg, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	cfg.VolumeSize = 1
+	be, err := tape.Create(cfg)
+	rtest.OK(t, err)
+
+	// each file fills a volume of 1 MiB
+	data := []string{
+		strings.Repeat("a", 1024*1024),
+		strings.Repeat("b", 1024*1024),
+		"foo",
+	}
+
+	var handles []restic.Handle
+	for _, d := range data {
+		handles = append(handles, save(t, be, d))
+	}
+	rtest.OK(t, be.Close())
+
+	rtest.Equals(t, 3, len(listVolumes(t, cfg.Path)))
+
+	be, err = tape.Open(cfg)
+	rtest.OK(t, err)
+	for i, h := range handles {
+		rtest.Equals(t, data[i], load(t, be, h))
+	}
+	rtest.OK(t, be.Close())
+}
+
+func TestRecoverUnfinishedVolume(t *testing.T) {
+	cfg, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	be, err := tape.Create(cfg)
+	rtest.OK(t, err)
+
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+	h3 := save(t, be, "baz")
+	rtest.OK(t, be.Remove(context.TODO(), h2))
+
+	// the backend is not closed, so the volume has no index
+	be, err = tape.Open(cfg)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, "foo", load(t, be, h1))
+	rtest.Equals(t, "baz", load(t, be, h3))
+	ok, err := be.Test(context.TODO(), h2)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "removed file %v is still present", h2)
+
+	// new files are written to a new volume
+	h4 := save(t, be, "qux")
+	rtest.OK(t, be.Close())
+	rtest.Equals(t, 2, len(listVolumes(t, cfg.Path)))
+
+	be, err = tape.Open(cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, "qux", load(t, be, h4))
+}
+
+func TestRecoverTruncatedVolume(t *testing.T) {
+	cfg, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	be, err := tape.Create(cfg)
+	rtest.OK(t, err)
+
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+
+	volumes := listVolumes(t, cfg.Path)
+	rtest.Equals(t, 1, len(volumes))
+
+	// cut off the checksum of the last record
+	fi, err := os.Stat(volumes[0])
+	rtest.OK(t, err)
+	rtest.OK(t, os.Truncate(volumes[0], fi.Size()-2))
+
+	be, err = tape.Open(cfg)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, "foo", load(t, be, h1))
+	ok, err := be.Test(context.TODO(), h2)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "incomplete file %v is present", h2)
+}
+
+func unpackVolumes(t testing.TB, dir string, dst restic.Backend) tape.UnpackStats {
+	var total tape.UnpackStats
+	for _, name := range listVolumes(t, dir) {
+		f, err := os.Open(name)
+		rtest.OK(t, err)
+
+		stats, err := tape.Unpack(context.TODO(), f, dst)
+		rtest.OK(t, err)
+		rtest.OK(t, f.Close())
+
+		total.Saved += stats.Saved
+		total.Skipped += stats.Skipped
+		total.Removed += stats.Removed
+	}
+	return total
+}
+
+func TestUnpack(t *testing.T) {
+	cfg, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	cfg.VolumeSize = 1
+	be, err := tape.Create(cfg)
+	rtest.OK(t, err)
+
+	large := strings.Repeat("x", 1024*1024)
+	h1 := save(t, be, large)
+	h2 := save(t, be, "foo")
+	h3 := save(t, be, "bar")
+	rtest.OK(t, be.Remove(context.TODO(), h1))
+	rtest.OK(t, be.Close())
+
+	dst := mem.New()
+	stats := unpackVolumes(t, cfg.Path, dst)
+	rtest.Equals(t, tape.UnpackStats{Saved: 3, Removed: 1}, stats)
+
+	ok, err := dst.Test(context.TODO(), h1)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "removed file %v has been unpacked", h1)
+	rtest.Equals(t, "foo", load(t, dst, h2))
+	rtest.Equals(t, "bar", load(t, dst, h3))
+
+	// unpacking the volumes again skips all files which are present
+	stats = unpackVolumes(t, cfg.Path, dst)
+	rtest.Equals(t, tape.UnpackStats{Saved: 1, Skipped: 2, Removed: 1}, stats)
+}
+
+func TestUnpackTruncatedVolume(t *testing.T) {
+	cfg, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	be, err := tape.Create(cfg)
+	rtest.OK(t, err)
+
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+
+	volumes := listVolumes(t, cfg.Path)
+	fi, err := os.Stat(volumes[0])
+	rtest.OK(t, err)
+	rtest.OK(t, os.Truncate(volumes[0], fi.Size()-2))
+
+	f, err := os.Open(volumes[0])
+	rtest.OK(t, err)
+	defer f.Close()
+
+	dst := mem.New()
+	_, err = tape.Unpack(context.TODO(), f, dst)
+	rtest.Assert(t, err != nil, "expected error for an incomplete record")
+
+	rtest.Equals(t, "foo", load(t, dst, h1))
+	ok, err := dst.Test(context.TODO(), h2)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "incomplete file %v has been unpacked", h2)
+}
@@ -0,0 +1,102 @@
+package tape
+
+import (
+	"context"
+	"io"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// UnpackStats describes the files processed by Unpack.
+type UnpackStats struct {
+	Saved   int
+	Skipped int
+	Removed int
+}
+
+// Unpack reads a volume sequentially from rd and stores the files in dst,
+// files which already exist in dst are skipped. Files which have been removed
+// by a record in the volume are also removed from dst, so unpacking all
+// volumes in the order in which they were written restores the state of the
+// repository. A volume which ends prematurely is unpacked up to the last
+// complete record.
+func Unpack(ctx context.Context, rd io.Reader, dst restic.Backend) (UnpackStats, error) {
+	var stats UnpackStats
+
+	// last is the file saved for the last record, if any
+	var last *restic.Handle
+
+	_, err := readVolume(rd, func(e indexEntry, data io.Reader) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		h := e.handle()
+		last = nil
+
+		ok, err := dst.Test(ctx, h)
+		if err != nil {
+			return err
+		}
+
+		if e.Removed {
+			if ok {
+				stats.Removed++
+				return dst.Remove(ctx, h)
+			}
+			return nil
+		}
+
+		if ok {
+			stats.Skipped++
+			return nil
+		}
+
+		// the length of the data is known, so dst does not need to buffer it
+		if err := dst.Save(ctx, h, &lengthReader{Reader: data, n: int(e.Length)}); err != nil {
+			return err
+		}
+
+		stats.Saved++
+		last = &h
+		return nil
+	})
+
+	switch err {
+	case nil, errTruncated:
+		if err != nil {
+			debug.Log("volume is truncated, all records have been unpacked")
+		}
+		return stats, nil
+	case errBrokenRecord:
+		// the data of the last record is incomplete or damaged
+		if last != nil {
+			stats.Saved--
+			if rerr := dst.Remove(ctx, *last); rerr != nil {
+				debug.Log("removing %v failed: %v", *last, rerr)
+			}
+		}
+		return stats, errors.New("the volume ends with an incomplete or damaged record")
+	}
+
+	return stats, err
+}
+
+// lengthReader reports the number of bytes remaining in the reader.
+type lengthReader struct {
+	io.Reader
+	n int
+}
+
+func (r *lengthReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n -= n
+	return n, err
+}
+
+// Len returns the number of bytes which have not been read yet.
+func (r *lengthReader) Len() int {
+	return r.n
+}
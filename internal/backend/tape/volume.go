@@ -0,0 +1,325 @@
+package tape
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// A volume is a file which is only ever appended to. It consists of a
+// sequence of records, each of which holds a file of the repository or
+// records that a file has been removed:
+//
+//   magic   [4]byte  "RVR1"
+//   kind    uint8    recordFile, recordRemoved or recordIndex
+//   nameLen uint16
+//   name    [nameLen]byte, "type/name" of the file
+//   length  uint64
+//   data    [length]byte
+//   crc     uint32, CRC-32 (IEEE) of data
+//
+// When a volume is finished, an index record is appended which lists all
+// records of the volume as JSON, followed by a trailer with the offset of the
+// index record:
+//
+//   magic   [8]byte  "RVEND\x00\x00\x00"
+//   offset  uint64
+//
+// A volume can therefore be read front to back without random access, and
+// the records of a volume whose index is missing (e.g. because restic was
+// interrupted) can be recovered by reading it sequentially. All integers are
+// stored in little endian byte order.
+
+const (
+	recordFile    = 1
+	recordRemoved = 2
+	recordIndex   = 3
+)
+
+var (
+	recordMagic  = [4]byte{'R', 'V', 'R', '1'}
+	trailerMagic = [8]byte{'R', 'V', 'E', 'N', 'D', 0, 0, 0}
+)
+
+const (
+	// headerSize is the size of a record header without the name.
+	headerSize = 4 + 1 + 2 + 8
+	crcSize    = 4
+	// trailerSize is the size of the trailer at the end of a volume.
+	trailerSize = 8 + 8
+)
+
+// indexEntry describes a record of a volume.
+type indexEntry struct {
+	Type    restic.FileType `json:"type"`
+	Name    string          `json:"name"`
+	Offset  int64           `json:"offset,omitempty"`
+	Length  int64           `json:"length,omitempty"`
+	Removed bool            `json:"removed,omitempty"`
+}
+
+// handle returns the handle of the file described by e.
+func (e indexEntry) handle() restic.Handle {
+	return restic.Handle{Type: e.Type, Name: e.Name}
+}
+
+// recordName returns the name of the record for h.
+func recordName(h restic.Handle) string {
+	return string(h.Type) + "/" + h.Name
+}
+
+// parseRecordName returns the handle for the name of a record.
+func parseRecordName(name string) (restic.Handle, error) {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return restic.Handle{}, errors.Errorf("invalid record name %q", name)
+	}
+
+	return restic.Handle{Type: restic.FileType(name[:i]), Name: name[i+1:]}, nil
+}
+
+// writeRecord writes a record of kind with length bytes read from rd to wr
+// and returns the offset of the data relative to the start of the record.
+func writeRecord(wr io.Writer, kind byte, name string, length int64, rd io.Reader) (int64, error) {
+	if len(name) > 1<<16-1 {
+		return 0, errors.Errorf("record name %q is too long", name)
+	}
+
+	hdr := make([]byte, headerSize+len(name))
+	copy(hdr, recordMagic[:])
+	hdr[4] = kind
+	binary.LittleEndian.PutUint16(hdr[5:], uint16(len(name)))
+	copy(hdr[7:], name)
+	binary.LittleEndian.PutUint64(hdr[7+len(name):], uint64(length))
+
+	if _, err := wr.Write(hdr); err != nil {
+		return 0, errors.Wrap(err, "Write")
+	}
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(wr, crc), io.LimitReader(rd, length))
+	if err != nil {
+		return 0, errors.Wrap(err, "Write")
+	}
+	if n != length {
+		return 0, errors.Errorf("wrote %d bytes instead of %d", n, length)
+	}
+
+	buf := make([]byte, crcSize)
+	binary.LittleEndian.PutUint32(buf, crc.Sum32())
+	if _, err := wr.Write(buf); err != nil {
+		return 0, errors.Wrap(err, "Write")
+	}
+
+	return int64(len(hdr)), nil
+}
+
+// writeIndex writes the index record for the entries and the trailer to wr,
+// offset is the current size of the volume.
+func writeIndex(wr io.Writer, offset int64, entries []indexEntry) error {
+	if entries == nil {
+		entries = []indexEntry{}
+	}
+
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	_, err = writeRecord(wr, recordIndex, "", int64(len(buf)), strings.NewReader(string(buf)))
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, trailerSize)
+	copy(trailer, trailerMagic[:])
+	binary.LittleEndian.PutUint64(trailer[8:], uint64(offset))
+	_, err = wr.Write(trailer)
+	return errors.Wrap(err, "Write")
+}
+
+var (
+	// errTruncated is returned by readVolume if the header of a record ends
+	// prematurely, all previous records are complete.
+	errTruncated = errors.New("volume is truncated")
+
+	// errBrokenRecord is returned by readVolume if the data of the last
+	// record passed to fn is incomplete or does not match the checksum.
+	errBrokenRecord = errors.New("incomplete or damaged record")
+)
+
+// readVolume reads the records of a volume from rd in order and calls fn for
+// each file and removal record, data yields the contents of the file. The CRC
+// of the data is verified after fn has returned. readVolume returns true if
+// the volume has been finished with an index, and false if it ends after the
+// last complete record.
+func readVolume(rd io.Reader, fn func(e indexEntry, data io.Reader) error) (finished bool, err error) {
+	br := bufio.NewReader(rd)
+	var offset int64
+
+	for {
+		hdr := make([]byte, headerSize)
+		n, err := io.ReadFull(br, hdr[:7])
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, errTruncated
+		}
+
+		if [4]byte{hdr[0], hdr[1], hdr[2], hdr[3]} != recordMagic {
+			return false, errors.Errorf("invalid record at offset %d", offset)
+		}
+
+		name := make([]byte, binary.LittleEndian.Uint16(hdr[5:]))
+		if _, err := io.ReadFull(br, name); err != nil {
+			return false, errTruncated
+		}
+		if _, err := io.ReadFull(br, hdr[7:]); err != nil {
+			return false, errTruncated
+		}
+
+		kind := hdr[4]
+		length := int64(binary.LittleEndian.Uint64(hdr[7:]))
+		offset += int64(n + len(name) + 8)
+
+		if kind == recordIndex {
+			// the index and the trailer follow all other records
+			return true, nil
+		}
+
+		e := indexEntry{Offset: offset, Length: length}
+		h, err := parseRecordName(string(name))
+		if err != nil {
+			return false, err
+		}
+		e.Type, e.Name = h.Type, h.Name
+
+		switch kind {
+		case recordFile:
+		case recordRemoved:
+			e.Removed = true
+			e.Offset, e.Length = 0, 0
+		default:
+			return false, errors.Errorf("invalid record type %d at offset %d", kind, offset)
+		}
+
+		crc := crc32.NewIEEE()
+		data := io.TeeReader(io.LimitReader(br, length), crc)
+		if err := fn(e, data); err != nil {
+			return false, err
+		}
+
+		// skip the data which has not been read by fn
+		if _, err := io.Copy(ioutil.Discard, data); err != nil {
+			return false, errors.Wrap(err, "Read")
+		}
+
+		buf := make([]byte, crcSize)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return false, errBrokenRecord
+		}
+		if binary.LittleEndian.Uint32(buf) != crc.Sum32() {
+			return false, errBrokenRecord
+		}
+
+		offset += length + crcSize
+	}
+}
+
+// readIndex returns the entries of the volume in f. The index at the end of
+// the volume is used if it exists, otherwise the complete records are
+// recovered by reading the volume from the start.
+func readIndex(f *os.File) ([]indexEntry, bool, error) {
+	entries, err := readIndexTrailer(f)
+	if err == nil {
+		return entries, true, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, errors.Wrap(err, "Seek")
+	}
+
+	entries = nil
+	_, err = readVolume(f, func(e indexEntry, _ io.Reader) error {
+		entries = append(entries, e)
+		return nil
+	})
+	switch err {
+	case nil, errTruncated:
+	case errBrokenRecord:
+		// the last record has not been written completely, it is ignored
+		entries = entries[:len(entries)-1]
+	default:
+		return nil, false, err
+	}
+
+	return entries, false, nil
+}
+
+// readIndexTrailer reads the index of a finished volume via the trailer.
+func readIndexTrailer(f *os.File) ([]indexEntry, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "Stat")
+	}
+
+	if fi.Size() < trailerSize {
+		return nil, errors.New("volume is too short")
+	}
+
+	trailer := make([]byte, trailerSize)
+	if _, err := f.ReadAt(trailer, fi.Size()-trailerSize); err != nil {
+		return nil, errors.Wrap(err, "ReadAt")
+	}
+
+	var magic [8]byte
+	copy(magic[:], trailer)
+	if magic != trailerMagic {
+		return nil, errors.New("trailer not found")
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(trailer[8:]))
+	if offset < 0 || offset >= fi.Size()-trailerSize {
+		return nil, errors.New("invalid index offset")
+	}
+
+	rd := io.NewSectionReader(f, offset, fi.Size()-trailerSize-offset)
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return nil, errors.Wrap(err, "Read")
+	}
+	if [4]byte{hdr[0], hdr[1], hdr[2], hdr[3]} != recordMagic || hdr[4] != recordIndex {
+		return nil, errors.New("index record not found")
+	}
+
+	length := int64(binary.LittleEndian.Uint64(hdr[7:]))
+	if length != rd.Size()-headerSize-crcSize {
+		return nil, errors.New("invalid length of the index")
+	}
+
+	buf := make([]byte, length+crcSize)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return nil, errors.Wrap(err, "Read")
+	}
+
+	data := buf[:length]
+	if binary.LittleEndian.Uint32(buf[length:]) != crc32.ChecksumIEEE(data) {
+		return nil, errors.New("checksum mismatch for the index")
+	}
+
+	var entries []indexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	return entries, nil
+}
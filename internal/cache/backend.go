@@ -229,3 +229,18 @@ func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, e
 func (b *Backend) IsNotExist(err error) bool {
 	return b.Backend.IsNotExist(err)
 }
+
+// Thaw returns true for files in the cache, the request is passed to the
+// backend for all other files.
+func (b *Backend) Thaw(ctx context.Context, h restic.Handle) (bool, error) {
+	if b.Cache.Has(h) {
+		return true, nil
+	}
+
+	return restic.Thaw(ctx, b.Backend, h)
+}
+
+// Unwrap returns the wrapped backend, see restic.Unwrapper.
+func (b *Backend) Unwrap() restic.Backend {
+	return b.Backend
+}
@@ -2,9 +2,9 @@ package checker
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync"
 
@@ -33,6 +33,20 @@ type Checker struct {
 	indexes       map[restic.ID]*repository.Index
 	orphanedPacks restic.IDs
 
+	// obsoletePacks have been removed from the index by prune and are
+	// deleted after a grace period.
+	obsoletePacks restic.IDSet
+	// obsoleteBlobs maps blobs which are only contained in obsolete packs to
+	// the pack. A backup running at the same time as prune may have
+	// referenced them, the next prune adds the pack to the index again.
+	obsoleteBlobs map[restic.ID]restic.ID
+	// deletedPacks are obsolete packs which have already been deleted.
+	deletedPacks restic.IDSet
+	usedObsolete struct {
+		sync.Mutex
+		M restic.IDSet
+	}
+
 	masterIndex *repository.MasterIndex
 
 	repo restic.Repository
@@ -41,14 +55,18 @@ type Checker struct {
 // New returns a new checker which runs on repo.
 func New(repo restic.Repository) *Checker {
 	c := &Checker{
-		packs:       restic.NewIDSet(),
-		blobs:       restic.NewIDSet(),
-		masterIndex: repository.NewMasterIndex(),
-		indexes:     make(map[restic.ID]*repository.Index),
-		repo:        repo,
+		packs:         restic.NewIDSet(),
+		blobs:         restic.NewIDSet(),
+		obsoletePacks: restic.NewIDSet(),
+		obsoleteBlobs: make(map[restic.ID]restic.ID),
+		deletedPacks:  restic.NewIDSet(),
+		masterIndex:   repository.NewMasterIndex(),
+		indexes:       make(map[restic.ID]*repository.Index),
+		repo:          repo,
 	}
 
 	c.blobRefs.M = make(map[restic.ID]uint)
+	c.usedObsolete.M = restic.NewIDSet()
 
 	return c
 }
@@ -154,6 +172,10 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 		}
 
 		debug.Log("%d blobs processed", cnt)
+
+		for _, op := range res.Index.Obsolete() {
+			c.obsoletePacks.Insert(op.ID)
+		}
 	}
 
 	debug.Log("checking for duplicate packs")
@@ -167,6 +189,33 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 		}
 	}
 
+	// trees in obsolete packs may be referenced by snapshots, make them
+	// available for loading
+	oidx := repository.NewIndex()
+	for _, idx := range c.indexes {
+		for _, op := range idx.Obsolete() {
+			if c.packs.Has(op.ID) {
+				continue
+			}
+
+			for _, blob := range op.Blobs {
+				if c.blobs.Has(blob.ID) {
+					continue
+				}
+				c.obsoleteBlobs[blob.ID] = op.ID
+				oidx.Store(restic.PackedBlob{Blob: blob, PackID: op.ID})
+			}
+		}
+	}
+
+	if len(c.obsoleteBlobs) > 0 {
+		debug.Log("%d blobs are only contained in obsolete packs", len(c.obsoleteBlobs))
+		if err := oidx.Finalize(ioutil.Discard); err != nil {
+			errs = append(errs, err)
+		}
+		c.masterIndex.Insert(oidx)
+	}
+
 	c.repo.SetIndex(c.masterIndex)
 
 	return hints, errs
@@ -242,9 +291,16 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 	workerWG.Wait()
 	debug.Log("workers terminated")
 
+	listedObsolete := restic.NewIDSet()
 	for id := range c.repo.List(ctx, restic.DataFile) {
 		debug.Log("check data blob %v", id.Str())
 		if !seenPacks.Has(id) {
+			if c.obsoletePacks.Has(id) {
+				debug.Log("pack %v is obsolete and will be deleted by prune", id.Str())
+				listedObsolete.Insert(id)
+				continue
+			}
+
 			c.orphanedPacks = append(c.orphanedPacks, id)
 			select {
 			case <-ctx.Done():
@@ -253,6 +309,17 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 			}
 		}
 	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	for id := range c.obsoletePacks {
+		if !seenPacks.Has(id) && !listedObsolete.Has(id) {
+			debug.Log("obsolete pack %v has been deleted", id.Str())
+			c.deletedPacks.Insert(id)
+		}
+	}
 }
 
 // Error is an error that occurred while checking a repository.
@@ -440,6 +507,10 @@ func (c *Checker) checkTreeWorker(ctx context.Context, in <-chan treeJob, out ch
 			if job.error != nil {
 				errs = append(errs, job.error)
 			} else {
+				if !c.blobs.Has(job.ID) {
+					// the tree has been loaded from an obsolete pack
+					c.inObsoletePack(job.ID)
+				}
 				errs = c.checkTree(job.ID, job.Tree)
 			}
 
@@ -627,7 +698,7 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 		debug.Log("blob %v refcount %d", blobID.Str(), c.blobRefs.M[blobID])
 		c.blobRefs.Unlock()
 
-		if !c.blobs.Has(blobID) {
+		if !c.blobs.Has(blobID) && !c.inObsoletePack(blobID) {
 			debug.Log("tree %v references blob %v which isn't contained in index", id.Str(), blobID.Str())
 
 			errs = append(errs, Error{TreeID: id, BlobID: blobID, Err: errors.New("not found in index")})
@@ -637,6 +708,34 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 	return errs
 }
 
+// inObsoletePack returns true if the blob is contained in a pack which prune
+// has removed from the index, but not deleted yet. The pack is recorded, see
+// UsedObsoletePacks.
+func (c *Checker) inObsoletePack(id restic.ID) bool {
+	packID, ok := c.obsoleteBlobs[id]
+	if !ok || c.deletedPacks.Has(packID) {
+		return false
+	}
+
+	debug.Log("blob %v is contained in obsolete pack %v", id.Str(), packID.Str())
+	c.usedObsolete.Lock()
+	c.usedObsolete.M.Insert(packID)
+	c.usedObsolete.Unlock()
+	return true
+}
+
+// UsedObsoletePacks returns the packs which have been removed from the index
+// by prune, but contain blobs referenced by snapshots. This happens when a
+// backup runs at the same time as prune with a grace period. The blobs are
+// available until the pack is deleted, the next prune adds the pack to the
+// index again.
+func (c *Checker) UsedObsoletePacks() restic.IDs {
+	c.usedObsolete.Lock()
+	defer c.usedObsolete.Unlock()
+
+	return c.usedObsolete.M.List()
+}
+
 // UnusedBlobs returns all blobs that have never been referenced.
 func (c *Checker) UnusedBlobs() (blobs restic.IDs) {
 	c.blobRefs.Lock()
@@ -678,7 +777,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		os.Remove(packfile.Name())
 	}()
 
-	hrd := hashing.NewReader(rd, sha256.New())
+	hrd := hashing.NewReader(rd, r.Config().Hash.New())
 	size, err := io.Copy(packfile, hrd)
 	if err != nil {
 		return errors.Wrap(err, "Copy")
@@ -730,9 +829,18 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
 			continue
 		}
-		buf = buf[:n]
 
-		hash := restic.Hash(buf)
+		plaintext := buf[:n]
+		if blob.IsCompressed() {
+			plaintext, err = restic.Decompress(nil, plaintext, blob.UncompressedLength)
+			if err != nil {
+				debug.Log("  error decompressing blob %v: %v", blob.ID.Str(), err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+		}
+
+		hash := r.Config().Hash.Sum(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str())
 			errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
@@ -747,8 +855,35 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	return nil
 }
 
+// GetPacks returns the set of all packs contained in the index.
+func (c *Checker) GetPacks() restic.IDSet {
+	return c.packs
+}
+
 // ReadData loads all data from the repository and checks the integrity.
 func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan<- error) {
+	c.readPacks(ctx, c.repo.List(ctx, restic.DataFile), p, errChan)
+}
+
+// ReadPacks loads the given packs from the repository and checks the
+// integrity.
+func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.Progress, errChan chan<- error) {
+	ch := make(chan restic.ID)
+	go func() {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- id:
+			}
+		}
+	}()
+
+	c.readPacks(ctx, ch, p, errChan)
+}
+
+func (c *Checker) readPacks(ctx context.Context, ch <-chan restic.ID, p *restic.Progress, errChan chan<- error) {
 	defer close(errChan)
 
 	p.Start()
@@ -774,6 +909,7 @@ func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan
 			if err == nil {
 				continue
 			}
+			err = PackError{ID: id, Err: err}
 
 			select {
 			case <-ctx.Done():
@@ -783,8 +919,6 @@ func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan
 		}
 	}
 
-	ch := c.repo.List(ctx, restic.DataFile)
-
 	var wg sync.WaitGroup
 	for i := 0; i < defaultParallelism; i++ {
 		wg.Add(1)
@@ -0,0 +1,126 @@
+package checker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ScrubState records when the packs in the repository have last been read
+// and verified. It is saved in the repository, so that the verification of
+// all data can be spread over several runs of check.
+type ScrubState struct {
+	// LastRun is the time of the last run which read data.
+	LastRun time.Time
+	// Verified contains the time each pack was last verified.
+	Verified map[restic.ID]time.Time
+
+	// files are the IDs of the files the state was loaded from.
+	files restic.IDs
+}
+
+type scrubPackJSON struct {
+	ID       restic.ID `json:"id"`
+	Verified time.Time `json:"verified"`
+}
+
+type scrubStateJSON struct {
+	LastRun time.Time       `json:"last_run"`
+	Packs   []scrubPackJSON `json:"packs"`
+}
+
+// LoadScrubState loads the scrub state from the repository. If several files
+// are found (e.g. because an old file could not be removed), they are merged.
+func LoadScrubState(ctx context.Context, repo restic.Repository) (*ScrubState, error) {
+	s := &ScrubState{Verified: make(map[restic.ID]time.Time)}
+
+	for id := range repo.List(ctx, restic.ScrubFile) {
+		var sj scrubStateJSON
+		err := repo.LoadJSONUnpacked(ctx, restic.ScrubFile, id, &sj)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load scrub state %v", id.Str())
+		}
+
+		s.files = append(s.files, id)
+		if sj.LastRun.After(s.LastRun) {
+			s.LastRun = sj.LastRun
+		}
+		for _, p := range sj.Packs {
+			if p.Verified.After(s.Verified[p.ID]) {
+				s.Verified[p.ID] = p.Verified
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Select returns the packs which need to be read so that every pack is
+// verified at least once per window. Packs which have never been verified or
+// not within the window are always selected. In addition, the least recently
+// verified packs are selected in proportion to the time since the last run,
+// so that the work is spread evenly over the runs.
+func (s *ScrubState) Select(packs restic.IDSet, window time.Duration, now time.Time) restic.IDSet {
+	since := now.Sub(s.LastRun)
+	if s.LastRun.IsZero() || since > window {
+		since = window
+	}
+
+	list := packs.List()
+	sort.SliceStable(list, func(i, j int) bool {
+		return s.Verified[list[i]].Before(s.Verified[list[j]])
+	})
+
+	quota := int(math.Ceil(float64(len(list)) * float64(since) / float64(window)))
+
+	selected := restic.NewIDSet()
+	for i, id := range list {
+		if i >= quota && now.Sub(s.Verified[id]) < window {
+			break
+		}
+		selected.Insert(id)
+	}
+
+	return selected
+}
+
+// Save records that the packs in verified have been read at time now and
+// saves the state to the repository. Packs which are not contained in packs
+// any more are dropped from the state. The files the state was loaded from
+// are removed afterwards.
+func (s *ScrubState) Save(ctx context.Context, repo restic.Repository, packs, verified restic.IDSet, now time.Time) (restic.ID, error) {
+	s.LastRun = now
+	for id := range verified {
+		s.Verified[id] = now
+	}
+
+	sj := scrubStateJSON{LastRun: s.LastRun}
+	for id, t := range s.Verified {
+		if !packs.Has(id) {
+			delete(s.Verified, id)
+			continue
+		}
+		sj.Packs = append(sj.Packs, scrubPackJSON{ID: id, Verified: t})
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.ScrubFile, sj)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	debug.Log("saved scrub state as %v", id.Str())
+
+	for _, old := range s.files {
+		h := restic.Handle{Type: restic.ScrubFile, Name: old.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return id, errors.Wrapf(err, "remove old scrub state %v", old.Str())
+		}
+	}
+	s.files = restic.IDs{id}
+
+	return id, nil
+}
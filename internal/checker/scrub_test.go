@@ -0,0 +1,89 @@
+package checker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestScrubStateSelect(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := 10 * 24 * time.Hour
+
+	packs := restic.NewIDSet()
+	state := &checker.ScrubState{Verified: make(map[restic.ID]time.Time)}
+	for i := 0; i < 100; i++ {
+		id := restic.NewRandomID()
+		packs.Insert(id)
+		state.Verified[id] = now.Add(-time.Duration(i) * time.Hour)
+	}
+
+	// without a previous run, all packs are read
+	test.Equals(t, 100, len(state.Select(packs, window, now)))
+
+	// one day after the last run, a tenth of the packs is read, the least
+	// recently verified first
+	state.LastRun = now.Add(-24 * time.Hour)
+	selected := state.Select(packs, window, now)
+	test.Equals(t, 10, len(selected))
+	for id := range selected {
+		test.Assert(t, now.Sub(state.Verified[id]) >= 90*time.Hour,
+			"pack verified at %v selected", state.Verified[id])
+	}
+
+	// packs which have never been verified or not within the window are
+	// always read
+	overdue := restic.NewRandomID()
+	packs.Insert(overdue)
+	state.Verified[overdue] = now.Add(-window)
+	unverified := restic.NewRandomID()
+	packs.Insert(unverified)
+
+	state.LastRun = now.Add(-time.Minute)
+	selected = state.Select(packs, window, now)
+	test.Equals(t, 2, len(selected))
+	test.Assert(t, selected.Has(overdue) && selected.Has(unverified),
+		"overdue packs not selected: %v", selected)
+}
+
+func TestScrubStateSave(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	now := time.Now().UTC().Round(time.Second)
+
+	state, err := checker.LoadScrubState(ctx, repo)
+	test.OK(t, err)
+	test.Assert(t, state.LastRun.IsZero(), "new repository has a last run")
+
+	packs := restic.NewIDSet(restic.NewRandomID(), restic.NewRandomID())
+	verified := restic.NewIDSet(packs.List()[0])
+	_, err = state.Save(ctx, repo, packs, verified, now)
+	test.OK(t, err)
+
+	// packs which have been removed from the repository are dropped
+	packs.Delete(packs.List()[0])
+	_, err = state.Save(ctx, repo, packs, packs, now.Add(time.Hour))
+	test.OK(t, err)
+
+	files := 0
+	for range repo.List(ctx, restic.ScrubFile) {
+		files++
+	}
+	test.Equals(t, 1, files)
+
+	state, err = checker.LoadScrubState(ctx, repo)
+	test.OK(t, err)
+	test.Assert(t, state.LastRun.Equal(now.Add(time.Hour)), "wrong last run %v", state.LastRun)
+	test.Equals(t, 1, len(state.Verified))
+	for id, ts := range state.Verified {
+		test.Assert(t, packs.Has(id), "unknown pack %v in state", id.Str())
+		test.Assert(t, ts.Equal(now.Add(time.Hour)), "wrong verification time %v", ts)
+	}
+}
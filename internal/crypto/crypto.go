@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 
@@ -36,6 +37,15 @@ var (
 type Key struct {
 	MACKey        `json:"mac"`
 	EncryptionKey `json:"encrypt"`
+
+	// sessionKeys are tried by Decrypt when the ciphertext cannot be
+	// authenticated with this key, by the session ID in the IV, see
+	// AddSessionKey.
+	sessionKeys map[sessionID][]*Key
+
+	// session is set for keys returned by NewSessionKey, which store their
+	// session ID in the IV.
+	session bool
 }
 
 // EncryptionKey is key used for encryption
@@ -256,6 +266,10 @@ func (k *Key) Encrypt(ciphertext []byte, plaintext []byte) ([]byte, error) {
 	}
 
 	iv := newIV()
+	if k.session {
+		id := k.sessionID()
+		copy(iv[:sessionIDSize], id[:])
+	}
 	copy(ciphertext, iv[:])
 
 	c, err := aes.NewCipher(k.EncryptionKey[:])
@@ -276,8 +290,30 @@ func (k *Key) Encrypt(ciphertext []byte, plaintext []byte) ([]byte, error) {
 
 // Decrypt verifies and decrypts the ciphertext. Ciphertext must be in the form
 // IV || Ciphertext || MAC. plaintext and ciphertext may point to (exactly) the
-// same slice.
+// same slice. If the ciphertext cannot be authenticated with the key, the
+// session keys added with AddSessionKey whose ID matches the IV are tried.
 func (k *Key) Decrypt(plaintext []byte, ciphertextWithMac []byte) (int, error) {
+	n, err := k.decrypt(plaintext, ciphertextWithMac)
+	if err != ErrUnauthenticated {
+		return n, err
+	}
+
+	// decrypt returns ErrUnauthenticated only for ciphertexts which contain
+	// an IV
+	var id sessionID
+	copy(id[:], ciphertextWithMac[:sessionIDSize])
+
+	for _, sk := range k.sessionKeys[id] {
+		n, err = sk.decrypt(plaintext, ciphertextWithMac)
+		if err != ErrUnauthenticated {
+			return n, err
+		}
+	}
+
+	return 0, ErrUnauthenticated
+}
+
+func (k *Key) decrypt(plaintext []byte, ciphertextWithMac []byte) (int, error) {
 	if !k.Valid() {
 		return 0, errors.New("invalid key")
 	}
@@ -315,6 +351,17 @@ func (k *Key) Decrypt(plaintext []byte, ciphertextWithMac []byte) (int, error) {
 		panic(fmt.Sprintf("unable to create cipher: %v", err))
 	}
 	e := cipher.NewCTR(c, iv)
+
+	// when plaintext and ciphertext share the same underlying buffer, the
+	// ciphertext starts ivSize bytes after the plaintext. The stream cipher
+	// only supports exactly overlapping buffers, so decrypt in place and move
+	// the plaintext to the front afterwards.
+	if len(plaintext) > 0 && len(ciphertextWithMac) > 0 && &plaintext[0] == &ciphertextWithMac[0] {
+		e.XORKeyStream(ciphertext, ciphertext)
+		copy(plaintext, ciphertext)
+		return plaintextLength, nil
+	}
+
 	e.XORKeyStream(plaintext, ciphertext)
 
 	return plaintextLength, nil
@@ -324,3 +371,22 @@ func (k *Key) Decrypt(plaintext []byte, ciphertextWithMac []byte) (int, error) {
 func (k *Key) Valid() bool {
 	return k.EncryptionKey.Valid() && k.MACKey.Valid()
 }
+
+// Equal returns true if k and other consist of the same encryption and MAC
+// keys, so that data encrypted with one of them can be decrypted with the
+// other. Session keys are not compared.
+func (k *Key) Equal(other *Key) bool {
+	if k == nil || other == nil {
+		return false
+	}
+
+	// the MAC keys are only masked when they are first used, so compare
+	// masked copies
+	m1, m2 := k.MACKey, other.MACKey
+	maskKey(&m1)
+	maskKey(&m2)
+
+	return subtle.ConstantTimeCompare(k.EncryptionKey[:], other.EncryptionKey[:]) == 1 &&
+		subtle.ConstantTimeCompare(m1.K[:], m2.K[:]) == 1 &&
+		subtle.ConstantTimeCompare(m1.R[:], m2.R[:]) == 1
+}
@@ -3,6 +3,7 @@ package crypto_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"io"
 	"testing"
 
@@ -169,3 +170,96 @@ func BenchmarkDecrypt(b *testing.B) {
 		rtest.OK(b, err)
 	}
 }
+
+func TestSessionKey(t *testing.T) {
+	master := crypto.NewRandomKey()
+	sk := crypto.NewSessionKey()
+
+	sealed, err := crypto.SealKeys(master.PublicKey(), sk)
+	rtest.OK(t, err)
+
+	_, err = crypto.NewRandomKey().OpenSealedKeys(sealed)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "sealed key opened with wrong key, err %v", err)
+
+	opened, err := master.OpenSealedKeys(sealed)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(opened))
+
+	data := rtest.Random(23, 1000)
+	ciphertext, err := sk.Encrypt(nil, data)
+	rtest.OK(t, err)
+
+	buf := make([]byte, len(ciphertext))
+	_, err = master.Decrypt(buf, ciphertext)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "data decrypted without session key, err %v", err)
+
+	master.AddSessionKey(opened[0])
+	n, err := master.Decrypt(buf, ciphertext)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf[:n])
+
+	// adding the same key again does nothing
+	master.AddSessionKey(sk)
+	rtest.Equals(t, 1, len(master.SessionKeys()))
+}
+
+func TestManySessionKeys(t *testing.T) {
+	master := crypto.NewRandomKey()
+
+	var keys []*crypto.Key
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, crypto.NewSessionKey())
+	}
+
+	sealed, err := crypto.SealKeys(master.PublicKey(), keys...)
+	rtest.OK(t, err)
+	opened, err := master.OpenSealedKeys(sealed)
+	rtest.OK(t, err)
+	rtest.Equals(t, len(keys), len(opened))
+
+	for _, sk := range opened {
+		master.AddSessionKey(sk)
+	}
+	rtest.Equals(t, len(keys), len(master.SessionKeys()))
+
+	data := rtest.Random(42, 100)
+	buf := make([]byte, len(data)+crypto.Extension)
+	for i, sk := range keys {
+		ciphertext, err := sk.Encrypt(nil, data)
+		rtest.OK(t, err)
+
+		n, err := master.Decrypt(buf, ciphertext)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(data, buf[:n]), "wrong data decrypted with session key %d", i)
+	}
+
+	// data encrypted with a key which has not been added is not decrypted
+	ciphertext, err := crypto.NewSessionKey().Encrypt(nil, data)
+	rtest.OK(t, err)
+	_, err = master.Decrypt(buf, ciphertext)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "data decrypted with unknown session key, err %v", err)
+}
+
+func TestKeyEqual(t *testing.T) {
+	k := crypto.NewRandomKey()
+	rtest.Assert(t, k.Equal(k), "key is not equal to itself")
+	rtest.Assert(t, !k.Equal(crypto.NewRandomKey()), "different keys are equal")
+	rtest.Assert(t, !k.Equal(nil), "key is equal to nil")
+
+	// a key restored from JSON has a masked MAC key
+	buf, err := json.Marshal(k)
+	rtest.OK(t, err)
+	var k2 crypto.Key
+	rtest.OK(t, json.Unmarshal(buf, &k2))
+	rtest.Assert(t, k.Equal(&k2), "key is not equal to its JSON representation")
+}
+
+func TestLockKey(t *testing.T) {
+	master := crypto.NewRandomKey()
+	public := *master.PublicKey()
+
+	lk := crypto.LockKey(master.PublicKey())
+	rtest.Assert(t, lk.Valid(), "lock key is not valid")
+	rtest.Assert(t, lk.Equal(crypto.LockKey(&public)), "lock keys derived from the same public key differ")
+	rtest.Assert(t, !lk.Equal(crypto.LockKey(crypto.NewRandomKey().PublicKey())), "lock keys of different keys are equal")
+}
@@ -48,6 +48,24 @@ func Calibrate(timeout time.Duration, memory int) (KDFParams, error) {
 	}, nil
 }
 
+// Check returns an error if the parameters are not valid.
+func (p KDFParams) Check() error {
+	// scrypt requires N to be a power of two
+	if p.N <= 1 || p.N&(p.N-1) != 0 {
+		return errors.Errorf("invalid KDF parameter N=%d, must be a power of two larger than one", p.N)
+	}
+
+	params := sscrypt.Params{
+		N:       p.N,
+		R:       p.R,
+		P:       p.P,
+		DKLen:   sscrypt.DefaultParams.DKLen,
+		SaltLen: saltLength,
+	}
+
+	return errors.Wrap(params.Check(), "Check")
+}
+
 // KDF derives encryption and message authentication keys from the password
 // using the supplied parameters N, R and P and the Salt.
 func KDF(p KDFParams, salt []byte, password string) (*Key, error) {
@@ -12,3 +12,27 @@ func TestCalibrate(t *testing.T) {
 	}
 	t.Logf("testing calibrate, params after: %v", params)
 }
+
+func TestKDFParamsCheck(t *testing.T) {
+	var tests = []struct {
+		params KDFParams
+		valid  bool
+	}{
+		{DefaultKDFParams, true},
+		{KDFParams{N: 1 << 15, R: 8, P: 1}, true},
+		{KDFParams{N: 1000, R: 8, P: 1}, false},
+		{KDFParams{N: 1 << 15, R: 0, P: 1}, false},
+		{KDFParams{N: 1 << 15, R: 8, P: 0}, false},
+	}
+
+	for _, test := range tests {
+		err := test.params.Check()
+		if test.valid && err != nil {
+			t.Errorf("params %v: unexpected error %v", test.params, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("params %v: expected error not found", test.params)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// PublicKeySize is the size of the public key used to seal session keys.
+const PublicKeySize = 32
+
+const nonceSize = 24
+
+// boxKeyLabel is used to derive the private key for sealing session keys from
+// the master key.
+const boxKeyLabel = "restic session key sealing"
+
+// privateKey derives the private key which belongs to PublicKey() from the
+// key.
+func (k *Key) privateKey() *[32]byte {
+	mac := hmac.New(sha256.New, k.EncryptionKey[:])
+	_, _ = mac.Write([]byte(boxKeyLabel))
+
+	var priv [32]byte
+	copy(priv[:], mac.Sum(nil))
+	return &priv
+}
+
+// PublicKey returns the public key which can be used to seal session keys for
+// k, see SealKeys. It is derived from the key, so it does not need to be
+// stored.
+func (k *Key) PublicKey() *[PublicKeySize]byte {
+	var pub [PublicKeySize]byte
+	curve25519.ScalarBaseMult(&pub, k.privateKey())
+	return &pub
+}
+
+// sessionIDSize is the number of bytes at the start of the IV which identify
+// the session key used to encrypt the data, so that Decrypt only needs to try
+// the session keys with this ID. The remaining bytes of the IV are random.
+const sessionIDSize = 4
+
+type sessionID [sessionIDSize]byte
+
+// sessionIDLabel is used to derive the session ID from a session key.
+const sessionIDLabel = "restic session key id"
+
+// sessionID returns the ID of k when it is used as a session key.
+func (k *Key) sessionID() (id sessionID) {
+	mac := hmac.New(sha256.New, k.EncryptionKey[:])
+	_, _ = mac.Write([]byte(sessionIDLabel))
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+// NewSessionKey returns a new random key for the data saved by a client using
+// a write-only key. The ID of the key is stored in the IV of all data it
+// encrypts, so that clients with the master key find the session key needed
+// to decrypt the data without trying all of them.
+func NewSessionKey() *Key {
+	k := NewRandomKey()
+	k.session = true
+	return k
+}
+
+// SealKeys encrypts the session keys so that they can only be decrypted with
+// the key the public key belongs to. The result has the form
+// EphemeralPublicKey || Nonce || Box.
+func SealKeys(public *[PublicKeySize]byte, keys ...*Key) ([]byte, error) {
+	buf, err := json.Marshal(keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	ephemeralPublic, ephemeralPrivate, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "GenerateKey")
+	}
+
+	var nonce [nonceSize]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "Read")
+	}
+
+	out := make([]byte, 0, PublicKeySize+nonceSize+len(buf)+box.Overhead)
+	out = append(out, ephemeralPublic[:]...)
+	out = append(out, nonce[:]...)
+	return box.Seal(out, buf, &nonce, public, ephemeralPrivate), nil
+}
+
+// OpenSealedKeys decrypts the session keys sealed with SealKeys for the public
+// key of k.
+func (k *Key) OpenSealedKeys(data []byte) ([]*Key, error) {
+	if len(data) < PublicKeySize+nonceSize+box.Overhead {
+		return nil, errors.New("sealed key too short")
+	}
+
+	var ephemeralPublic [PublicKeySize]byte
+	var nonce [nonceSize]byte
+	copy(ephemeralPublic[:], data[:PublicKeySize])
+	copy(nonce[:], data[PublicKeySize:PublicKeySize+nonceSize])
+
+	buf, ok := box.Open(nil, data[PublicKeySize+nonceSize:], &nonce, &ephemeralPublic, k.privateKey())
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	for _, sk := range keys {
+		if sk == nil || !sk.Valid() {
+			return nil, errors.New("invalid session key")
+		}
+	}
+
+	return keys, nil
+}
+
+// AddSessionKey adds sk to the keys which are tried when data cannot be
+// decrypted with k, unless it has already been added. It must not be called
+// concurrently with Decrypt.
+func (k *Key) AddSessionKey(sk *Key) {
+	id := sk.sessionID()
+	for _, other := range k.sessionKeys[id] {
+		if other.Equal(sk) {
+			return
+		}
+	}
+
+	if k.sessionKeys == nil {
+		k.sessionKeys = make(map[sessionID][]*Key)
+	}
+	k.sessionKeys[id] = append(k.sessionKeys[id], sk)
+}
+
+// SessionKeys returns all keys added with AddSessionKey.
+func (k *Key) SessionKeys() []*Key {
+	var keys []*Key
+	for _, list := range k.sessionKeys {
+		keys = append(keys, list...)
+	}
+	return keys
+}
+
+// lockKeyLabel is used to derive the key for lock files from the public key.
+const lockKeyLabel = "restic lock file key"
+
+// LockKey derives the key for encrypting lock files from the public key used
+// to seal session keys. Clients holding the master key and clients holding
+// only the public key (write-only keys) derive the same key, so all of them
+// can read the locks of the others.
+func LockKey(public *[PublicKeySize]byte) *Key {
+	var buf []byte
+	for i := byte(0); len(buf) < aesKeySize+macKeySizeK+macKeySizeR; i++ {
+		mac := hmac.New(sha256.New, public[:])
+		_, _ = mac.Write([]byte(lockKeyLabel))
+		_, _ = mac.Write([]byte{i})
+		buf = mac.Sum(buf)
+	}
+
+	k := &Key{}
+	copy(k.EncryptionKey[:], buf[:aesKeySize])
+	copy(k.MACKey.K[:], buf[aesKeySize:aesKeySize+macKeySizeK])
+	copy(k.MACKey.R[:], buf[aesKeySize+macKeySizeK:])
+	maskKey(&k.MACKey)
+	return k
+}
@@ -0,0 +1,202 @@
+// Package dirstruct builds the virtual directory structure in which snapshots
+// are presented by the mount and serve commands.
+package dirstruct
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DefaultTemplates are the templates used to build the directory structure
+// when no templates are configured.
+var DefaultTemplates = []string{
+	"ids/%i",
+	"snapshots/%T",
+	"hosts/%h/%T",
+	"tags/%t/%T",
+}
+
+// Dir is a directory in the structure built from the path templates. It
+// either contains other directories or snapshots.
+type Dir struct {
+	Dirs      map[string]*Dir
+	Snapshots map[string]*restic.Snapshot
+
+	// Latest is set if the snapshots are named by their time, in which case
+	// an entry "latest" for the newest snapshot should be presented.
+	Latest bool
+}
+
+func newDir() *Dir {
+	return &Dir{
+		Dirs:      make(map[string]*Dir),
+		Snapshots: make(map[string]*restic.Snapshot),
+	}
+}
+
+// CheckTemplate returns an error if tmpl cannot be used as a path template.
+func CheckTemplate(tmpl string) error {
+	segments := strings.Split(tmpl, "/")
+	if len(segments) < 2 {
+		return errors.Errorf("path template %q must contain at least one directory", tmpl)
+	}
+
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.Errorf("path template %q contains an invalid path component", tmpl)
+		}
+	}
+
+	return nil
+}
+
+// expandTemplate returns the paths for the snapshot sn according to the
+// template. The following placeholders are supported: %i (short snapshot ID),
+// %I (long snapshot ID), %h (hostname), %u (username), %T (time of the
+// snapshot), %t (tag, one path for each tag of the snapshot) and %% (a
+// literal percent sign).
+func expandTemplate(tmpl string, sn *restic.Snapshot) []string {
+	tags := []string{""}
+	if strings.Contains(tmpl, "%t") {
+		tags = sn.Tags
+	}
+
+	var paths []string
+	for _, tag := range tags {
+		var b strings.Builder
+		for i := 0; i < len(tmpl); i++ {
+			if tmpl[i] != '%' || i == len(tmpl)-1 {
+				b.WriteByte(tmpl[i])
+				continue
+			}
+
+			i++
+			switch tmpl[i] {
+			case 'i':
+				b.WriteString(sn.ID().Str())
+			case 'I':
+				b.WriteString(sn.ID().String())
+			case 'h':
+				b.WriteString(sn.Hostname)
+			case 'u':
+				b.WriteString(sn.Username)
+			case 'T':
+				b.WriteString(sn.Time.Format(time.RFC3339))
+			case 't':
+				b.WriteString(tag)
+			case '%':
+				b.WriteByte('%')
+			default:
+				b.WriteByte('%')
+				b.WriteByte(tmpl[i])
+			}
+		}
+		paths = append(paths, b.String())
+	}
+
+	return paths
+}
+
+// Build returns the directory structure for the snapshots according to the
+// templates. If no templates are given, DefaultTemplates are used.
+func Build(templates []string, snapshots restic.Snapshots) (*Dir, error) {
+	if len(templates) == 0 {
+		templates = DefaultTemplates
+	}
+
+	root := newDir()
+
+	for _, tmpl := range templates {
+		latest := strings.HasSuffix(tmpl, "%T")
+
+		for _, sn := range snapshots {
+			for _, path := range expandTemplate(tmpl, sn) {
+				segments := strings.Split(path, "/")
+
+				dir := root
+				for _, name := range segments[:len(segments)-1] {
+					if name == "" {
+						// ignore paths with empty components, e.g. for an
+						// empty hostname
+						dir = nil
+						break
+					}
+
+					if _, ok := dir.Snapshots[name]; ok {
+						return nil, errors.Errorf("path template %q conflicts with another template at %q", tmpl, name)
+					}
+
+					sub, ok := dir.Dirs[name]
+					if !ok {
+						sub = newDir()
+						dir.Dirs[name] = sub
+					}
+					dir = sub
+				}
+
+				if dir == nil {
+					continue
+				}
+
+				if err := dir.addSnapshot(segments[len(segments)-1], sn); err != nil {
+					return nil, errors.Errorf("path template %q: %v", tmpl, err)
+				}
+				dir.Latest = dir.Latest || latest
+			}
+		}
+	}
+
+	return root, nil
+}
+
+// addSnapshot adds the snapshot with the given name. If another snapshot with
+// the same name already exists, a suffix is added to the name.
+func (d *Dir) addSnapshot(name string, sn *restic.Snapshot) error {
+	if _, ok := d.Dirs[name]; ok {
+		return errors.Errorf("snapshot name %q conflicts with a directory", name)
+	}
+
+	base := name
+	for i := 1; ; i++ {
+		other, ok := d.Snapshots[name]
+		if !ok {
+			break
+		}
+
+		if other == sn {
+			// the snapshot has already been added, e.g. for a duplicate tag
+			return nil
+		}
+
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	d.Snapshots[name] = sn
+	return nil
+}
+
+// LatestName returns the name of the newest snapshot in d, or the empty
+// string if d does not have an entry "latest".
+func (d *Dir) LatestName() string {
+	if !d.Latest {
+		return ""
+	}
+
+	var (
+		latest     string
+		latestTime time.Time
+	)
+	for name, sn := range d.Snapshots {
+		if latest == "" || sn.Time.After(latestTime) ||
+			(sn.Time.Equal(latestTime) && name > latest) {
+			latestTime = sn.Time
+			latest = name
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,106 @@
+package dirstruct
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testSnapshot saves a new snapshot in the repo and loads it again, so that
+// its ID is set.
+func testSnapshot(t testing.TB, repo restic.Repository, host string, tm time.Time, tags ...string) *restic.Snapshot {
+	sn, err := restic.NewSnapshot([]string{"/data"}, tags, host, tm)
+	rtest.OK(t, err)
+	sn.Username = "user"
+
+	id, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	sn, err = restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	return sn
+}
+
+func TestExpandTemplate(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tm := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	sn := testSnapshot(t, repo, "foo", tm, "a", "b")
+
+	var tests = []struct {
+		template string
+		paths    []string
+	}{
+		{"ids/%i", []string{"ids/" + sn.ID().Str()}},
+		{"long/%I", []string{"long/" + sn.ID().String()}},
+		{"hosts/%h/%T", []string{"hosts/foo/2018-01-02T03:04:05Z"}},
+		{"users/%u/100%%/%T", []string{"users/user/100%/2018-01-02T03:04:05Z"}},
+		{"tags/%t/%i", []string{"tags/a/" + sn.ID().Str(), "tags/b/" + sn.ID().Str()}},
+		{"unknown/%x%", []string{"unknown/%x%"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			rtest.Equals(t, test.paths, expandTemplate(test.template, sn))
+		})
+	}
+
+	// snapshots without tags are not listed for templates containing %t
+	sn = testSnapshot(t, repo, "bar", tm)
+	rtest.Equals(t, 0, len(expandTemplate("tags/%t/%T", sn)))
+}
+
+func dirNames(d *Dir) []string {
+	var names []string
+	for name := range d.Dirs {
+		names = append(names, name)
+	}
+	for name := range d.Snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBuild(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tm := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	sn1 := testSnapshot(t, repo, "foo", tm, "a")
+	sn2 := testSnapshot(t, repo, "bar", tm, "a", "b")
+	sn3 := testSnapshot(t, repo, "foo", tm.Add(time.Hour))
+
+	tree, err := Build(nil, restic.Snapshots{sn1, sn2, sn3})
+	rtest.OK(t, err)
+
+	rtest.Equals(t, []string{"hosts", "ids", "snapshots", "tags"}, dirNames(tree))
+	rtest.Equals(t, []string{"bar", "foo"}, dirNames(tree.Dirs["hosts"]))
+	rtest.Equals(t, []string{"2018-01-02T03:04:05Z", "2018-01-02T04:04:05Z"}, dirNames(tree.Dirs["hosts"].Dirs["foo"]))
+	rtest.Equals(t, []string{"a", "b"}, dirNames(tree.Dirs["tags"]))
+
+	// snapshots with the same time get a suffix
+	rtest.Equals(t, []string{"2018-01-02T03:04:05Z", "2018-01-02T03:04:05Z-1", "2018-01-02T04:04:05Z"},
+		dirNames(tree.Dirs["snapshots"]))
+	rtest.Assert(t, tree.Dirs["snapshots"].Latest, "latest link missing for snapshots")
+	rtest.Assert(t, !tree.Dirs["ids"].Latest, "unexpected latest link for ids")
+	rtest.Equals(t, "2018-01-02T04:04:05Z", tree.Dirs["snapshots"].LatestName())
+	rtest.Equals(t, "", tree.Dirs["ids"].LatestName())
+
+	_, err = Build([]string{"x/%h", "x/foo/%T"}, restic.Snapshots{sn1})
+	rtest.Assert(t, err != nil, "expected an error for conflicting templates")
+}
+
+func TestCheckTemplate(t *testing.T) {
+	rtest.OK(t, CheckTemplate("hosts/%h/%T"))
+
+	for _, tmpl := range []string{"", "%T", "hosts//%T", "../%T"} {
+		rtest.Assert(t, CheckTemplate(tmpl) != nil, "expected an error for template %q", tmpl)
+	}
+}
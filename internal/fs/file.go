@@ -65,6 +65,12 @@ func Link(oldname, newname string) error {
 	return os.Link(fixpath(oldname), fixpath(newname))
 }
 
+// Truncate changes the size of the named file.
+// If there is an error, it will be of type *PathError.
+func Truncate(name string, size int64) error {
+	return os.Truncate(fixpath(name), size)
+}
+
 // Stat returns a FileInfo structure describing the named file.
 // If there is an error, it will be of type *PathError.
 func Stat(name string) (os.FileInfo, error) {
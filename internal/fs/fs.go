@@ -0,0 +1,18 @@
+package fs
+
+// FS opens files for reading. It is used by the archiver to read the contents
+// of the files to back up.
+type FS interface {
+	Open(name string) (File, error)
+}
+
+// Local is the local file system as seen by the operating system.
+type Local struct{}
+
+// statically ensure that Local implements FS.
+var _ FS = Local{}
+
+// Open opens a file for reading.
+func (Local) Open(name string) (File, error) {
+	return Open(name)
+}
@@ -0,0 +1,22 @@
+// +build !windows
+
+package fs
+
+import "github.com/restic/restic/internal/errors"
+
+// LocalVss is the local file system. Volume Shadow Copies are only supported
+// on Windows, so it does not differ from Local on other systems.
+type LocalVss struct {
+	Local
+}
+
+// NewLocalVss returns an error, file system snapshots are only supported on
+// Windows.
+func NewLocalVss(paths []string, printf func(string, ...interface{})) (*LocalVss, error) {
+	return nil, errors.New("file system snapshots are only supported on Windows")
+}
+
+// DeleteSnapshots does nothing.
+func (fs *LocalVss) DeleteSnapshots() error {
+	return nil
+}
@@ -0,0 +1,448 @@
+// +build windows
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// This file implements the parts of the Volume Shadow Copy Service (VSS) API
+// needed to create non-persistent snapshots of local volumes. The COM
+// interfaces are called directly through their virtual method tables.
+
+var (
+	modole32           = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx = modole32.NewProc("CoInitializeEx")
+
+	modvssapi                             = syscall.NewLazyDLL("vssapi.dll")
+	procCreateVssBackupComponentsInternal = modvssapi.NewProc("CreateVssBackupComponentsInternal")
+	procVssFreeSnapshotPropertiesInternal = modvssapi.NewProc("VssFreeSnapshotPropertiesInternal")
+)
+
+const (
+	coinitMultithreaded = 0x0
+
+	vssCtxBackup = 0
+	vssBtCopy    = 5
+
+	hrOK                = 0x0
+	hrFalse             = 0x1
+	hrRPCChangedMode    = 0x80010106
+	hrAccessDenied      = 0x80070005
+	hrVssAsyncFinished  = 0x0004230a
+	hrVssAsyncPending   = 0x00042309
+	hrVssAsyncCancelled = 0x0004230b
+)
+
+// hresultNames contains descriptions for the most common errors returned by
+// the VSS API.
+var hresultNames = map[uint32]string{
+	hrAccessDenied: "access denied, restic must be run with administrator rights",
+	0x80042302:     "unexpected VSS error, see the event log for details",
+	0x80042306:     "the volume is not supported by the shadow copy provider",
+	0x8004230c:     "the volume is not supported",
+	0x8004230f:     "the shadow copy provider had an unexpected error",
+	0x80042312:     "the maximum number of shadow copies has been reached",
+	0x80042313:     "the system is busy with another shadow copy",
+	0x80042314:     "the shadow copy provider timed out while flushing writes",
+	0x80042316:     "another shadow copy is already being created",
+	0x80042317:     "the maximum number of volumes for this operation has been reached",
+	0x8004231f:     "there is not enough storage for the shadow copy",
+}
+
+// vssError is returned when a VSS operation fails.
+type vssError struct {
+	op string
+	hr uint32
+}
+
+func (e vssError) Error() string {
+	if msg, ok := hresultNames[e.hr]; ok {
+		return fmt.Sprintf("%v failed: %v (%#08x)", e.op, msg, e.hr)
+	}
+	return fmt.Sprintf("%v failed: HRESULT %#08x", e.op, e.hr)
+}
+
+func checkHresult(op string, hr uintptr) error {
+	if uint32(hr) != hrOK {
+		return vssError{op: op, hr: uint32(hr)}
+	}
+	return nil
+}
+
+// guid is the binary representation of a Windows GUID.
+type guid struct {
+	data1 uint32
+	data2 uint16
+	data3 uint16
+	data4 [8]byte
+}
+
+// guidArgs returns the arguments needed to pass id by value to a function.
+// On 386 the value is put on the stack, on other architectures a pointer to a
+// copy is passed.
+func guidArgs(id *guid) []uintptr {
+	if runtime.GOARCH == "386" {
+		return (*[4]uintptr)(unsafe.Pointer(id))[:]
+	}
+	return []uintptr{uintptr(unsafe.Pointer(id))}
+}
+
+// comCall calls the COM method fn with the arguments.
+func comCall(fn uintptr, args ...uintptr) uintptr {
+	var a [9]uintptr
+	copy(a[:], args)
+
+	var hr uintptr
+	switch {
+	case len(args) <= 3:
+		hr, _, _ = syscall.Syscall(fn, uintptr(len(args)), a[0], a[1], a[2])
+	case len(args) <= 6:
+		hr, _, _ = syscall.Syscall6(fn, uintptr(len(args)), a[0], a[1], a[2], a[3], a[4], a[5])
+	case len(args) <= 9:
+		hr, _, _ = syscall.Syscall9(fn, uintptr(len(args)), a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], a[8])
+	default:
+		panic("too many arguments for COM call")
+	}
+
+	return hr
+}
+
+// vssSnapshotProperties is VSS_SNAPSHOT_PROP.
+type vssSnapshotProperties struct {
+	snapshotID           guid
+	snapshotSetID        guid
+	snapshotsCount       int32
+	snapshotDeviceObject *uint16
+	originalVolumeName   *uint16
+	originatingMachine   *uint16
+	serviceMachine       *uint16
+	exposedName          *uint16
+	exposedPath          *uint16
+	providerID           guid
+	snapshotAttributes   int32
+	creationTimestamp    int64
+	status               int32
+	_                    int32 // trailing padding of the C struct
+}
+
+// iVssAsyncVtbl is the virtual method table of IVssAsync.
+type iVssAsyncVtbl struct {
+	queryInterface uintptr
+	addRef         uintptr
+	release        uintptr
+	cancel         uintptr
+	wait           uintptr
+	queryStatus    uintptr
+}
+
+type iVssAsync struct {
+	vtbl *iVssAsyncVtbl
+}
+
+// waitAndRelease waits until the asynchronous operation is done.
+func (a *iVssAsync) waitAndRelease(op string) error {
+	defer comCall(a.vtbl.release, uintptr(unsafe.Pointer(a)))
+
+	const infinite = 0xffffffff
+	if err := checkHresult(op, comCall(a.vtbl.wait, uintptr(unsafe.Pointer(a)), infinite)); err != nil {
+		return err
+	}
+
+	var status uint32
+	hr := comCall(a.vtbl.queryStatus, uintptr(unsafe.Pointer(a)), uintptr(unsafe.Pointer(&status)), 0)
+	if err := checkHresult(op, hr); err != nil {
+		return err
+	}
+
+	switch status {
+	case hrVssAsyncFinished:
+		return nil
+	case hrVssAsyncCancelled:
+		return errors.Errorf("%v was cancelled", op)
+	case hrVssAsyncPending:
+		return errors.Errorf("%v is still pending", op)
+	default:
+		return vssError{op: op, hr: status}
+	}
+}
+
+// iVssBackupComponentsVtbl is the virtual method table of
+// IVssBackupComponents, the order of the methods must match vsbackup.h.
+type iVssBackupComponentsVtbl struct {
+	queryInterface                uintptr
+	addRef                        uintptr
+	release                       uintptr
+	getWriterComponentsCount      uintptr
+	getWriterComponents           uintptr
+	initializeForBackup           uintptr
+	setBackupState                uintptr
+	initializeForRestore          uintptr
+	setRestoreState               uintptr
+	gatherWriterMetadata          uintptr
+	getWriterMetadataCount        uintptr
+	getWriterMetadata             uintptr
+	freeWriterMetadata            uintptr
+	addComponent                  uintptr
+	prepareForBackup              uintptr
+	abortBackup                   uintptr
+	gatherWriterStatus            uintptr
+	getWriterStatusCount          uintptr
+	freeWriterStatus              uintptr
+	getWriterStatus               uintptr
+	setBackupSucceeded            uintptr
+	setBackupOptions              uintptr
+	setSelectedForRestore         uintptr
+	setRestoreOptions             uintptr
+	setAdditionalRestores         uintptr
+	setPreviousBackupStamp        uintptr
+	saveAsXML                     uintptr
+	backupComplete                uintptr
+	addAlternativeLocationMapping uintptr
+	addRestoreSubcomponent        uintptr
+	setFileRestoreStatus          uintptr
+	addNewTarget                  uintptr
+	setRangesFilePath             uintptr
+	preRestore                    uintptr
+	postRestore                   uintptr
+	setContext                    uintptr
+	startSnapshotSet              uintptr
+	addToSnapshotSet              uintptr
+	doSnapshotSet                 uintptr
+	deleteSnapshots               uintptr
+	importSnapshots               uintptr
+	breakSnapshotSet              uintptr
+	getSnapshotProperties         uintptr
+}
+
+type iVssBackupComponents struct {
+	vtbl *iVssBackupComponentsVtbl
+}
+
+func (c *iVssBackupComponents) call(op string, fn uintptr, args ...uintptr) error {
+	args = append([]uintptr{uintptr(unsafe.Pointer(c))}, args...)
+	return checkHresult(op, comCall(fn, args...))
+}
+
+func (c *iVssBackupComponents) callAsync(op string, fn uintptr) error {
+	var async *iVssAsync
+	if err := c.call(op, fn, uintptr(unsafe.Pointer(&async))); err != nil {
+		return err
+	}
+	return async.waitAndRelease(op)
+}
+
+func (c *iVssBackupComponents) release() {
+	comCall(c.vtbl.release, uintptr(unsafe.Pointer(c)))
+}
+
+// LocalVss is the local file system, but files on volumes for which a shadow
+// copy has been created are read from the shadow copy. It is used by the
+// archiver to read the files to back up, all other file system operations use
+// the live file system.
+type LocalVss struct {
+	// devices maps the upper-case name of a volume (e.g. "C:") to the device
+	// object of its shadow copy.
+	devices map[string]string
+	comp    *iVssBackupComponents
+}
+
+// statically ensure that LocalVss implements FS.
+var _ FS = &LocalVss{}
+
+// snapshotPath returns the path of abspath within a shadow copy of its volume.
+func (fs *LocalVss) snapshotPath(abspath string) (string, bool) {
+	vol := filepath.VolumeName(abspath)
+	dev, ok := fs.devices[strings.ToUpper(vol)]
+	if !ok {
+		return "", false
+	}
+
+	return dev + abspath[len(vol):], true
+}
+
+// Open opens a file for reading, from the shadow copy if one exists for the
+// volume of the file.
+func (fs *LocalVss) Open(name string) (File, error) {
+	abspath, err := filepath.Abs(name)
+	if err == nil {
+		if p, ok := fs.snapshotPath(abspath); ok {
+			return os.Open(p)
+		}
+	}
+
+	return Open(name)
+}
+
+// DeleteSnapshots releases the shadow copies, they are not persistent and
+// are deleted by the system afterwards.
+func (fs *LocalVss) DeleteSnapshots() error {
+	if fs.comp == nil {
+		return nil
+	}
+
+	err := fs.comp.callAsync("BackupComplete", fs.comp.vtbl.backupComplete)
+	fs.comp.release()
+	fs.comp = nil
+	return err
+}
+
+// snapshotVolumes returns the volumes for the paths.
+func snapshotVolumes(paths []string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var volumes []string
+	for _, p := range paths {
+		abspath, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+
+		vol := strings.ToUpper(filepath.VolumeName(abspath))
+		if len(vol) != 2 || vol[1] != ':' {
+			return nil, errors.Errorf("unable to create a snapshot for %v: only local volumes are supported", p)
+		}
+
+		if _, ok := seen[vol]; ok {
+			continue
+		}
+		seen[vol] = struct{}{}
+		volumes = append(volumes, vol)
+	}
+
+	return volumes, nil
+}
+
+// NewLocalVss creates a Volume Shadow Copy for the volumes of all paths and
+// returns a file system which reads files from the shadow copies.
+// DeleteSnapshots must be called to release the shadow copies when the backup
+// is done.
+func NewLocalVss(paths []string, printf func(string, ...interface{})) (*LocalVss, error) {
+	if printf == nil {
+		printf = func(string, ...interface{}) {}
+	}
+
+	volumes, err := snapshotVolumes(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = procCreateVssBackupComponentsInternal.Find(); err != nil {
+		return nil, errors.Wrap(err, "VSS is not available")
+	}
+
+	// COM calls must be made from a thread which has been initialized for COM
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	hr, _, _ := procCoInitializeEx.Call(0, coinitMultithreaded)
+	if uint32(hr) != hrOK && uint32(hr) != hrFalse && uint32(hr) != hrRPCChangedMode {
+		return nil, vssError{op: "CoInitializeEx", hr: uint32(hr)}
+	}
+
+	var comp *iVssBackupComponents
+	hr, _, _ = procCreateVssBackupComponentsInternal.Call(uintptr(unsafe.Pointer(&comp)))
+	if err = checkHresult("CreateVssBackupComponents", hr); err != nil {
+		return nil, err
+	}
+
+	ok := false
+	defer func() {
+		if !ok {
+			_ = comp.call("AbortBackup", comp.vtbl.abortBackup)
+			comp.release()
+		}
+	}()
+
+	if err = comp.call("InitializeForBackup", comp.vtbl.initializeForBackup, 0); err != nil {
+		return nil, err
+	}
+
+	if err = comp.call("SetContext", comp.vtbl.setContext, vssCtxBackup); err != nil {
+		return nil, err
+	}
+
+	if err = comp.call("SetBackupState", comp.vtbl.setBackupState, 0, 0, vssBtCopy, 0); err != nil {
+		return nil, err
+	}
+
+	if err = comp.callAsync("GatherWriterMetadata", comp.vtbl.gatherWriterMetadata); err != nil {
+		return nil, err
+	}
+
+	var setID guid
+	if err = comp.call("StartSnapshotSet", comp.vtbl.startSnapshotSet, uintptr(unsafe.Pointer(&setID))); err != nil {
+		return nil, err
+	}
+
+	snapshotIDs := make([]guid, len(volumes))
+	for i, vol := range volumes {
+		name, err := syscall.UTF16PtrFromString(vol + `\`)
+		if err != nil {
+			return nil, err
+		}
+
+		var provider guid
+		args := []uintptr{uintptr(unsafe.Pointer(name))}
+		args = append(args, guidArgs(&provider)...)
+		args = append(args, uintptr(unsafe.Pointer(&snapshotIDs[i])))
+
+		if err = comp.call("AddToSnapshotSet", comp.vtbl.addToSnapshotSet, args...); err != nil {
+			return nil, errors.Wrap(err, vol)
+		}
+	}
+
+	if err = comp.callAsync("PrepareForBackup", comp.vtbl.prepareForBackup); err != nil {
+		return nil, err
+	}
+
+	printf("creating VSS snapshot for %v\n", strings.Join(volumes, ", "))
+	if err = comp.callAsync("DoSnapshotSet", comp.vtbl.doSnapshotSet); err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]string, len(volumes))
+	for i, vol := range volumes {
+		var prop vssSnapshotProperties
+		args := guidArgs(&snapshotIDs[i])
+		args = append(args, uintptr(unsafe.Pointer(&prop)))
+
+		if err = comp.call("GetSnapshotProperties", comp.vtbl.getSnapshotProperties, args...); err != nil {
+			return nil, errors.Wrap(err, vol)
+		}
+
+		devices[vol] = utf16PtrToString(prop.snapshotDeviceObject)
+		_, _, _ = procVssFreeSnapshotPropertiesInternal.Call(uintptr(unsafe.Pointer(&prop)))
+
+		printf("using VSS snapshot %v for %v\n", devices[vol], vol)
+	}
+
+	ok = true
+
+	return &LocalVss{devices: devices, comp: comp}, nil
+}
+
+// utf16PtrToString converts the zero-terminated UTF-16 string p to a string.
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+
+	var s []uint16
+	for ptr := unsafe.Pointer(p); ; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		c := *(*uint16)(ptr)
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+
+	return syscall.UTF16ToString(s)
+}
@@ -0,0 +1,51 @@
+// +build windows
+
+package fs
+
+import (
+	"testing"
+)
+
+func TestVssSnapshotPath(t *testing.T) {
+	fs := &LocalVss{
+		devices: map[string]string{
+			"C:": `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1`,
+		},
+	}
+
+	var tests = []struct {
+		path   string
+		result string
+		ok     bool
+	}{
+		{`C:\`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\`, true},
+		{`C:\Users\foo\bar.txt`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Users\foo\bar.txt`, true},
+		{`c:\Windows`, `\\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy1\Windows`, true},
+		{`D:\data`, ``, false},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res, ok := fs.snapshotPath(test.path)
+			if ok != test.ok || res != test.result {
+				t.Fatalf("wrong result for %q, want %q, %v, got %q, %v", test.path, test.result, test.ok, res, ok)
+			}
+		})
+	}
+}
+
+func TestSnapshotVolumes(t *testing.T) {
+	volumes, err := snapshotVolumes([]string{`C:\foo`, `c:\bar`, `D:\`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(volumes) != 2 || volumes[0] != "C:" || volumes[1] != "D:" {
+		t.Fatalf("wrong volumes returned: %v", volumes)
+	}
+
+	_, err = snapshotVolumes([]string{`\\server\share\foo`})
+	if err == nil {
+		t.Fatal("no error returned for UNC path")
+	}
+}
@@ -109,8 +109,8 @@ func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = os.ModeDir | d.node.Mode
 
 	if !d.root.cfg.OwnerIsRoot {
-		a.Uid = d.node.UID
-		a.Gid = d.node.GID
+		a.Uid = d.root.cfg.mapUID(d.node.UID)
+		a.Gid = d.root.cfg.mapGID(d.node.GID)
 	}
 	a.Atime = d.node.AccessTime
 	a.Ctime = d.node.ChangeTime
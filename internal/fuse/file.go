@@ -71,8 +71,8 @@ func (f *file) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Nlink = uint32(f.node.Links)
 
 	if !f.root.cfg.OwnerIsRoot {
-		a.Uid = f.node.UID
-		a.Gid = f.node.GID
+		a.Uid = f.root.cfg.mapUID(f.node.UID)
+		a.Gid = f.root.cfg.mapGID(f.node.GID)
 	}
 	a.Atime = f.node.AccessTime
 	a.Ctime = f.node.ChangeTime
@@ -32,8 +32,8 @@ func (l *link) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mode = l.node.Mode
 
 	if !l.root.cfg.OwnerIsRoot {
-		a.Uid = l.node.UID
-		a.Gid = l.node.GID
+		a.Uid = l.root.cfg.mapUID(l.node.UID)
+		a.Gid = l.root.cfg.mapGID(l.node.GID)
 	}
 	a.Atime = l.node.AccessTime
 	a.Ctime = l.node.ChangeTime
@@ -5,6 +5,7 @@ package fuse
 
 import (
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dirstruct"
 	"github.com/restic/restic/internal/restic"
 
 	"golang.org/x/net/context"
@@ -18,6 +19,29 @@ type Config struct {
 	Host        string
 	Tags        []restic.TagList
 	Paths       []string
+
+	// PathTemplates describe the directory structure for the snapshots, see
+	// package dirstruct for the supported placeholders.
+	PathTemplates []string
+
+	// UIDMap and GIDMap map the user and group IDs stored in the snapshots
+	// to the IDs presented in the mount. IDs not in the maps are unchanged.
+	UIDMap map[uint32]uint32
+	GIDMap map[uint32]uint32
+}
+
+func (cfg Config) mapUID(uid uint32) uint32 {
+	if id, ok := cfg.UIDMap[uid]; ok {
+		return id
+	}
+	return uid
+}
+
+func (cfg Config) mapGID(gid uint32) uint32 {
+	if id, ok := cfg.GIDMap[gid]; ok {
+		return id
+	}
+	return gid
 }
 
 // Root is the root node of the fuse mount of a repository.
@@ -52,58 +76,16 @@ func NewRoot(ctx context.Context, repo restic.Repository, cfg Config) (*Root, er
 		blobSizeCache: NewBlobSizeCache(ctx, repo.Index()),
 	}
 
-	entries := map[string]fs.Node{
-		"snapshots": NewSnapshotsDir(root, fs.GenerateDynamicInode(root.inode, "snapshots"), snapshots),
-		"tags":      NewTagsDir(root, fs.GenerateDynamicInode(root.inode, "tags"), snapshots),
-		"hosts":     NewHostsDir(root, fs.GenerateDynamicInode(root.inode, "hosts"), snapshots),
-		"ids":       NewSnapshotsIDSDir(root, fs.GenerateDynamicInode(root.inode, "ids"), snapshots),
+	tree, err := dirstruct.Build(cfg.PathTemplates, snapshots)
+	if err != nil {
+		return nil, err
 	}
 
-	root.MetaDir = NewMetaDir(root, rootInode, entries)
+	root.MetaDir = NewMetaDir(root, rootInode, dirstructEntries(root, rootInode, tree))
 
 	return root, nil
 }
 
-// NewTagsDir returns a new directory containing entries, which in turn contains
-// snapshots with this tag set.
-func NewTagsDir(root *Root, inode uint64, snapshots restic.Snapshots) fs.Node {
-	tags := make(map[string]restic.Snapshots)
-	for _, sn := range snapshots {
-		for _, tag := range sn.Tags {
-			tags[tag] = append(tags[tag], sn)
-		}
-	}
-
-	debug.Log("create tags dir with %d tags, inode %d", len(tags), inode)
-
-	entries := make(map[string]fs.Node)
-	for name, snapshots := range tags {
-		debug.Log("  tag %v has %v snapshots", name, len(snapshots))
-		entries[name] = NewSnapshotsDir(root, fs.GenerateDynamicInode(inode, name), snapshots)
-	}
-
-	return NewMetaDir(root, inode, entries)
-}
-
-// NewHostsDir returns a new directory containing hostnames, which in
-// turn contains snapshots of a single host each.
-func NewHostsDir(root *Root, inode uint64, snapshots restic.Snapshots) fs.Node {
-	hosts := make(map[string]restic.Snapshots)
-	for _, sn := range snapshots {
-		hosts[sn.Hostname] = append(hosts[sn.Hostname], sn)
-	}
-
-	debug.Log("create hosts dir with %d snapshots, inode %d", len(hosts), inode)
-
-	entries := make(map[string]fs.Node)
-	for name, snapshots := range hosts {
-		debug.Log("  host %v has %v snapshots", name, len(snapshots))
-		entries[name] = NewSnapshotsDir(root, fs.GenerateDynamicInode(inode, name), snapshots)
-	}
-
-	return NewMetaDir(root, inode, entries)
-}
-
 // Root is just there to satisfy fs.Root, it returns itself.
 func (r *Root) Root() (fs.Node, error) {
 	debug.Log("Root()")
@@ -4,9 +4,7 @@
 package fuse
 
 import (
-	"fmt"
 	"os"
-	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/restic"
@@ -19,11 +17,10 @@ import (
 
 // SnapshotsDir is a fuse directory which contains snapshots.
 type SnapshotsDir struct {
-	inode     uint64
-	root      *Root
-	snapshots restic.Snapshots
-	names     map[string]*restic.Snapshot
-	latest    string
+	inode  uint64
+	root   *Root
+	names  map[string]*restic.Snapshot
+	latest string
 }
 
 // ensure that *SnapshotsDir implements these interfaces
@@ -31,59 +28,17 @@ var _ = fs.HandleReadDirAller(&SnapshotsDir{})
 var _ = fs.NodeStringLookuper(&SnapshotsDir{})
 var _ = fs.NodeReadlinker(&snapshotLink{})
 
-// NewSnapshotsDir returns a new directory containing snapshots.
-func NewSnapshotsDir(root *Root, inode uint64, snapshots restic.Snapshots) *SnapshotsDir {
-	debug.Log("create snapshots dir with %d snapshots, inode %d", len(snapshots), inode)
-	d := &SnapshotsDir{
-		root:      root,
-		inode:     inode,
-		snapshots: snapshots,
-		names:     make(map[string]*restic.Snapshot, len(snapshots)),
+// newSnapshotsDirFromNames returns a new directory containing the snapshots
+// under the given names. If latest is not empty, a link named "latest" to the
+// snapshot with that name is added.
+func newSnapshotsDirFromNames(root *Root, inode uint64, names map[string]*restic.Snapshot, latest string) *SnapshotsDir {
+	debug.Log("create snapshots dir with %d snapshots, inode %d", len(names), inode)
+	return &SnapshotsDir{
+		root:   root,
+		inode:  inode,
+		names:  names,
+		latest: latest,
 	}
-
-	// Track latest Snapshot
-	var latestTime time.Time
-	d.latest = ""
-
-	for _, sn := range snapshots {
-		name := sn.Time.Format(time.RFC3339)
-		if d.latest == "" || !sn.Time.Before(latestTime) {
-			latestTime = sn.Time
-			d.latest = name
-		}
-		for i := 1; ; i++ {
-			if _, ok := d.names[name]; !ok {
-				break
-			}
-
-			name = fmt.Sprintf("%s-%d", sn.Time.Format(time.RFC3339), i)
-		}
-
-		d.names[name] = sn
-		debug.Log("  add snapshot %v as dir %v", sn.ID().Str(), name)
-	}
-
-	return d
-}
-
-// NewSnapshotsIDSDir returns a new directory containing snapshots named by ids.
-func NewSnapshotsIDSDir(root *Root, inode uint64, snapshots restic.Snapshots) *SnapshotsDir {
-	debug.Log("create snapshots ids dir with %d snapshots, inode %d", len(snapshots), inode)
-	d := &SnapshotsDir{
-		root:      root,
-		inode:     inode,
-		snapshots: snapshots,
-		names:     make(map[string]*restic.Snapshot, len(snapshots)),
-	}
-
-	for _, sn := range snapshots {
-		name := sn.ID().Str()
-
-		d.names[name] = sn
-		debug.Log("  add snapshot %v", name)
-	}
-
-	return d
 }
 
 // Attr returns the attributes for the root node.
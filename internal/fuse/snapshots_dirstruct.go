@@ -0,0 +1,31 @@
+// +build !openbsd
+// +build !windows
+
+package fuse
+
+import (
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dirstruct"
+
+	"bazil.org/fuse/fs"
+)
+
+// dirstructNode returns the fuse node for the directory d.
+func dirstructNode(root *Root, inode uint64, d *dirstruct.Dir) fs.Node {
+	if len(d.Snapshots) > 0 {
+		return newSnapshotsDirFromNames(root, inode, d.Snapshots, d.LatestName())
+	}
+
+	return NewMetaDir(root, inode, dirstructEntries(root, inode, d))
+}
+
+// dirstructEntries returns the fuse nodes for all subdirectories of d.
+func dirstructEntries(root *Root, inode uint64, d *dirstruct.Dir) map[string]fs.Node {
+	entries := make(map[string]fs.Node, len(d.Dirs))
+	for name, sub := range d.Dirs {
+		debug.Log("  add dir %v", name)
+		entries[name] = dirstructNode(root, fs.GenerateDynamicInode(inode, name), sub)
+	}
+
+	return entries
+}
@@ -80,10 +80,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 type indexJSON struct {
@@ -135,10 +136,11 @@ func Load(ctx context.Context, repo restic.Repository, p *restic.Progress) (*Ind
 			entries := make([]restic.Blob, 0, len(jpack.Blobs))
 			for _, blob := range jpack.Blobs {
 				entry := restic.Blob{
-					ID:     blob.ID,
-					Type:   blob.Type,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					ID:                 blob.ID,
+					Type:               blob.Type,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				}
 				entries = append(entries, entry)
 			}
@@ -274,10 +276,11 @@ func Save(ctx context.Context, repo restic.Repository, packs map[restic.ID][]res
 		b := make([]blobJSON, 0, len(blobs))
 		for _, blob := range blobs {
 			b = append(b, blobJSON{
-				ID:     blob.ID,
-				Type:   blob.Type,
-				Offset: blob.Offset,
-				Length: blob.Length,
+				ID:                 blob.ID,
+				Type:               blob.Type,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 		}
 
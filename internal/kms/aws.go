@@ -0,0 +1,227 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/minio/minio-go/pkg/credentials"
+)
+
+// awsKMS uses a key in AWS KMS. The credentials are found the same way as for
+// the s3 backend, e.g. via $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY. The
+// region is taken from the ARN of the key or from $AWS_REGION.
+type awsKMS struct {
+	uri      string
+	key      string
+	region   string
+	endpoint string
+	creds    *credentials.Credentials
+	client   *http.Client
+}
+
+func newAWSKMS(uri, name string) (*awsKMS, error) {
+	if name == "" {
+		return nil, errors.Fatalf("invalid AWS KMS key %q, expected aws-kms:<key ARN, ID or alias>", uri)
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	// arn:aws:kms:<region>:<account>:key/<id>
+	if parts := strings.SplitN(name, ":", 6); len(parts) == 6 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return nil, errors.Fatalf("unable to find the region of AWS KMS key %q, use the ARN of the key or set $AWS_REGION", uri)
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	})
+
+	return &awsKMS{
+		uri:      uri,
+		key:      name,
+		region:   region,
+		endpoint: strings.TrimRight(endpoint, "/") + "/",
+		creds:    creds,
+		client:   http.DefaultClient,
+	}, nil
+}
+
+func (a *awsKMS) Name() string {
+	return a.uri
+}
+
+// call runs the operation op (Encrypt or Decrypt) of the KMS API.
+func (a *awsKMS) call(ctx context.Context, op string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	req, err := http.NewRequest("POST", a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "NewRequest")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+op)
+
+	creds, err := a.creds.Get()
+	if err != nil {
+		return errors.Wrap(err, "credentials.Get")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return errors.Fatal("no AWS credentials found for KMS")
+	}
+	signV4(req, body, creds, a.region, "kms", time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "aws kms")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return errors.Errorf("aws kms %v failed: %v %v: %v", op, resp.Status, e.Type, e.Message)
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(response), "Decode")
+}
+
+func (a *awsKMS) Wrap(ctx context.Context, plaintext []byte) (string, error) {
+	var resp struct {
+		CiphertextBlob []byte
+	}
+
+	// byte slices are encoded as base64 by encoding/json, like KMS expects
+	err := a.call(ctx, "Encrypt", map[string]interface{}{
+		"KeyId":     a.key,
+		"Plaintext": plaintext,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(resp.CiphertextBlob), nil
+}
+
+func (a *awsKMS) Unwrap(ctx context.Context, ciphertext string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeString")
+	}
+
+	var resp struct {
+		Plaintext []byte
+	}
+
+	err = a.call(ctx, "Decrypt", map[string]interface{}{
+		"KeyId":          a.key,
+		"CiphertextBlob": blob,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Plaintext, nil
+}
+
+// signV4 signs the request with AWS Signature Version 4. The signer of
+// minio-go cannot be used, it only signs requests for the service "s3". All
+// headers set on req are signed.
+func signV4(req *http.Request, body []byte, creds credentials.Value, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payload := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(hashedRequest[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/minio/minio-go/pkg/credentials"
+)
+
+// TestSignV4 uses the request "get-vanilla" of the test suite for AWS
+// Signature Version 4.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	rtest.OK(t, err)
+
+	creds := credentials.Value{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	rtest.Equals(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, "+
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+// fakeAWSKMS implements the Encrypt and Decrypt operations of AWS KMS by
+// prefixing the plaintext.
+func fakeAWSKMS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") ||
+			!strings.Contains(auth, "/eu-west-1/kms/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"__type": "InvalidSignatureException", "message": "invalid signature"})
+			return
+		}
+
+		var req struct {
+			KeyId          string
+			Plaintext      []byte
+			CiphertextBlob []byte
+		}
+		rtest.OK(t, json.NewDecoder(r.Body).Decode(&req))
+
+		resp := make(map[string][]byte)
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			resp["CiphertextBlob"] = append([]byte("kms:"), req.Plaintext...)
+		case "TrentService.Decrypt":
+			if !bytes.HasPrefix(req.CiphertextBlob, []byte("kms:")) {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"__type": "InvalidCiphertextException", "message": "invalid ciphertext"})
+				return
+			}
+			resp["Plaintext"] = req.CiphertextBlob[4:]
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestAWSKMS(t *testing.T) {
+	srv := fakeAWSKMS(t)
+	defer srv.Close()
+
+	for _, name := range []string{"AWS_ENDPOINT_URL_KMS", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	rtest.OK(t, os.Setenv("AWS_ENDPOINT_URL_KMS", srv.URL))
+	rtest.OK(t, os.Setenv("AWS_REGION", ""))
+	rtest.OK(t, os.Setenv("AWS_DEFAULT_REGION", ""))
+	rtest.OK(t, os.Setenv("AWS_ACCESS_KEY_ID", "key"))
+	rtest.OK(t, os.Setenv("AWS_SECRET_ACCESS_KEY", "secret"))
+
+	ctx := context.TODO()
+	uri := "aws-kms:arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	w, err := Open(ctx, uri)
+	rtest.OK(t, err)
+	rtest.Equals(t, uri, w.Name())
+
+	wrapped, err := w.Wrap(ctx, []byte("secret"))
+	rtest.OK(t, err)
+
+	buf, err := w.Unwrap(ctx, wrapped)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("secret"), buf)
+
+	_, err = w.Unwrap(ctx, "Zm9v")
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "InvalidCiphertextException"),
+		"unexpected error %v", err)
+
+	// without an ARN, the region is required
+	_, err = Open(ctx, "aws-kms:alias/restic")
+	rtest.Assert(t, err != nil, "expected error for a key without region")
+
+	rtest.OK(t, os.Setenv("AWS_REGION", "eu-west-1"))
+	w, err = Open(ctx, "aws-kms:alias/restic")
+	rtest.OK(t, err)
+	_, err = w.Wrap(ctx, []byte("secret"))
+	rtest.OK(t, err)
+
+	// the signature is made for the region of the key
+	rtest.OK(t, os.Setenv("AWS_REGION", "us-east-1"))
+	w, err = Open(ctx, "aws-kms:alias/restic")
+	rtest.OK(t, err)
+	_, err = w.Wrap(ctx, []byte("secret"))
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "InvalidSignatureException"),
+		"unexpected error %v", err)
+}
@@ -0,0 +1,63 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/oauth2/google"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// googleKMS uses a key in Google Cloud KMS. The credentials are found the
+// same way as for the gs backend, e.g. via $GOOGLE_APPLICATION_CREDENTIALS.
+type googleKMS struct {
+	uri     string
+	name    string
+	service *cloudkms.Service
+}
+
+func newGoogleKMS(ctx context.Context, uri, name string) (*googleKMS, error) {
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/cryptoKeys/") {
+		return nil, errors.Fatalf("invalid Google Cloud KMS key %q, expected gcp-kms:projects/.../cryptoKeys/name", uri)
+	}
+
+	client, err := google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "DefaultClient")
+	}
+
+	service, err := cloudkms.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "cloudkms.New")
+	}
+
+	return &googleKMS{uri: uri, name: name, service: service}, nil
+}
+
+func (g *googleKMS) Name() string {
+	return g.uri
+}
+
+func (g *googleKMS) Wrap(ctx context.Context, plaintext []byte) (string, error) {
+	req := &cloudkms.EncryptRequest{Plaintext: base64.StdEncoding.EncodeToString(plaintext)}
+	resp, err := g.service.Projects.Locations.KeyRings.CryptoKeys.Encrypt(g.name, req).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrap(err, "Encrypt")
+	}
+
+	return resp.Ciphertext, nil
+}
+
+func (g *googleKMS) Unwrap(ctx context.Context, ciphertext string) ([]byte, error) {
+	req := &cloudkms.DecryptRequest{Ciphertext: ciphertext}
+	resp, err := g.service.Projects.Locations.KeyRings.CryptoKeys.Decrypt(g.name, req).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "Decrypt")
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	return buf, errors.Wrap(err, "DecodeString")
+}
@@ -0,0 +1,46 @@
+// Package kms wraps and unwraps keys using external key management services,
+// so that access to a repository can be controlled centrally.
+package kms
+
+import (
+	"context"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Wrapper encrypts (wraps) and decrypts (unwraps) small secrets with a key
+// which is stored in a key management service and never leaves it.
+type Wrapper interface {
+	// Name returns the URI of the key, which is stored in the key file.
+	Name() string
+
+	// Wrap encrypts the plaintext and returns the ciphertext in a format
+	// specific to the service.
+	Wrap(ctx context.Context, plaintext []byte) (string, error)
+
+	// Unwrap decrypts a ciphertext returned by Wrap.
+	Unwrap(ctx context.Context, ciphertext string) ([]byte, error)
+}
+
+// Open returns a Wrapper for the key uri. Supported are keys in the transit
+// secrets engine of HashiCorp Vault ("vault-transit:mount/name"), in Google
+// Cloud KMS ("gcp-kms:projects/.../cryptoKeys/name") and in AWS KMS
+// ("aws-kms:arn:aws:kms:...", or a key ID or alias with $AWS_REGION).
+func Open(ctx context.Context, uri string) (Wrapper, error) {
+	scheme, name := uri, ""
+	if i := strings.Index(uri, ":"); i >= 0 {
+		scheme, name = uri[:i], uri[i+1:]
+	}
+
+	switch scheme {
+	case "vault-transit":
+		return newVaultTransit(uri, name)
+	case "gcp-kms":
+		return newGoogleKMS(ctx, uri, name)
+	case "aws-kms":
+		return newAWSKMS(uri, name)
+	}
+
+	return nil, errors.Fatalf("unsupported KMS key %q", uri)
+}
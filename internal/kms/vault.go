@@ -0,0 +1,131 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// vaultTransit uses a key of the transit secrets engine of HashiCorp Vault.
+// The address and token are read from the environment variables VAULT_ADDR
+// and VAULT_TOKEN, like the vault command does.
+type vaultTransit struct {
+	uri   string
+	mount string
+	key   string
+
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultTransit(uri, name string) (*vaultTransit, error) {
+	name = strings.Trim(name, "/")
+	mount, key := path.Split(name)
+	mount = strings.Trim(mount, "/")
+	if mount == "" || key == "" {
+		return nil, errors.Fatalf("invalid Vault transit key %q, expected vault-transit:mount/name", uri)
+	}
+
+	v := &vaultTransit{
+		uri:       uri,
+		mount:     mount,
+		key:       key,
+		addr:      strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    http.DefaultClient,
+	}
+
+	if v.addr == "" {
+		return nil, errors.Fatal("$VAULT_ADDR is not set")
+	}
+
+	if v.token == "" {
+		return nil, errors.Fatal("$VAULT_TOKEN is not set")
+	}
+
+	return v, nil
+}
+
+func (v *vaultTransit) Name() string {
+	return v.uri
+}
+
+// call runs the operation op (encrypt or decrypt) of the transit engine.
+func (v *vaultTransit) call(ctx context.Context, op string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	url := v.addr + "/v1/" + v.mount + "/" + op + "/" + v.key
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "NewRequest")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "vault")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return errors.Errorf("vault %v failed: %v %v", op, resp.Status, strings.Join(e.Errors, ", "))
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(response), "Decode")
+}
+
+func (v *vaultTransit) Wrap(ctx context.Context, plaintext []byte) (string, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+
+	err := v.call(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Data.Ciphertext, nil
+}
+
+func (v *vaultTransit) Unwrap(ctx context.Context, ciphertext string) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+
+	err := v.call(ctx, "decrypt", map[string]string{
+		"ciphertext": ciphertext,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	return buf, errors.Wrap(err, "DecodeString")
+}
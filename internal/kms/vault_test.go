@@ -0,0 +1,77 @@
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// fakeVault implements the encrypt and decrypt operations of the transit
+// engine by prefixing the plaintext.
+func fakeVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		var req map[string]string
+		rtest.OK(t, json.NewDecoder(r.Body).Decode(&req))
+
+		data := make(map[string]string)
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/restic":
+			data["ciphertext"] = "vault:v1:" + req["plaintext"]
+		case "/v1/transit/decrypt/restic":
+			data["plaintext"] = strings.TrimPrefix(req["ciphertext"], "vault:v1:")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := fakeVault(t)
+	defer srv.Close()
+
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	rtest.OK(t, os.Setenv("VAULT_ADDR", srv.URL))
+	rtest.OK(t, os.Setenv("VAULT_TOKEN", "token"))
+
+	ctx := context.TODO()
+	w, err := Open(ctx, "vault-transit:transit/restic")
+	rtest.OK(t, err)
+	rtest.Equals(t, "vault-transit:transit/restic", w.Name())
+
+	wrapped, err := w.Wrap(ctx, []byte("secret"))
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.HasPrefix(wrapped, "vault:v1:"), "unexpected ciphertext %q", wrapped)
+
+	buf, err := w.Unwrap(ctx, wrapped)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("secret"), buf)
+
+	// access revoked
+	rtest.OK(t, os.Setenv("VAULT_TOKEN", "revoked"))
+	w, err = Open(ctx, "vault-transit:transit/restic")
+	rtest.OK(t, err)
+	_, err = w.Unwrap(ctx, wrapped)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "permission denied"),
+		"unexpected error %v", err)
+
+	for _, uri := range []string{"vault-transit:restic", "vault-transit:", "aws-kms:", "foo"} {
+		_, err = Open(ctx, uri)
+		rtest.Assert(t, err != nil, "expected error for %q", uri)
+	}
+}
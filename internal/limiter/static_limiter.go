@@ -0,0 +1,123 @@
+package limiter
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+type staticLimiter struct {
+	upstream   *bucket
+	downstream *bucket
+}
+
+// NewStaticLimiter constructs a Limiter with a fixed (static) upload and
+// download rate cap, both given in KiB/s. A rate of zero disables limiting
+// in the respective direction.
+func NewStaticLimiter(uploadKb, downloadKb int) Limiter {
+	var (
+		upstream   *bucket
+		downstream *bucket
+	)
+
+	if uploadKb > 0 {
+		upstream = newBucket(toByteRate(uploadKb))
+	}
+
+	if downloadKb > 0 {
+		downstream = newBucket(toByteRate(downloadKb))
+	}
+
+	return staticLimiter{
+		upstream:   upstream,
+		downstream: downstream,
+	}
+}
+
+func (l staticLimiter) Upstream(r io.Reader) io.Reader {
+	return l.limit(r, l.upstream)
+}
+
+func (l staticLimiter) Downstream(r io.Reader) io.Reader {
+	return l.limit(r, l.downstream)
+}
+
+func (l staticLimiter) limit(r io.Reader, b *bucket) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &rateLimitedReader{rd: r, bucket: b}
+}
+
+func toByteRate(val int) float64 {
+	return float64(val) * 1024.
+}
+
+// bucket is a token bucket which is refilled at a constant rate (in bytes per
+// second) and holds at most one second worth of tokens.
+type bucket struct {
+	m        sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{
+		rate:     rate,
+		capacity: rate,
+		tokens:   rate,
+		last:     time.Now(),
+	}
+}
+
+// take removes n tokens from the bucket and returns the time the caller needs
+// to wait until the tokens would have been available.
+func (b *bucket) take(now time.Time, n int) time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// maxRead returns the maximum number of bytes that should be read at once.
+func (b *bucket) maxRead() int {
+	if b.capacity < 1 {
+		return 1
+	}
+	return int(b.capacity)
+}
+
+type rateLimitedReader struct {
+	rd     io.Reader
+	bucket *bucket
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if max := r.bucket.maxRead(); len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := r.rd.Read(p)
+	if n > 0 {
+		if d := r.bucket.take(time.Now(), n); d > 0 {
+			time.Sleep(d)
+		}
+	}
+
+	return n, err
+}
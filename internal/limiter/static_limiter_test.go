@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestLimiterPassesData(t *testing.T) {
+	l := NewStaticLimiter(100*1024, 100*1024)
+	data := rtest.Random(23, 2*1024*1024)
+
+	for _, rd := range []func() []byte{
+		func() []byte {
+			buf, err := ioutil.ReadAll(l.Upstream(bytes.NewReader(data)))
+			rtest.OK(t, err)
+			return buf
+		},
+		func() []byte {
+			buf, err := ioutil.ReadAll(l.Downstream(bytes.NewReader(data)))
+			rtest.OK(t, err)
+			return buf
+		},
+	} {
+		if !bytes.Equal(rd(), data) {
+			t.Errorf("limited reader returned wrong data")
+		}
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewStaticLimiter(0, 0)
+	rd := bytes.NewReader(nil)
+
+	if l.Upstream(rd) != rd {
+		t.Errorf("upstream reader was wrapped although limiting is disabled")
+	}
+
+	if l.Downstream(rd) != rd {
+		t.Errorf("downstream reader was wrapped although limiting is disabled")
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	start := time.Now()
+	b := newBucket(1000)
+	b.last = start
+
+	// the bucket starts full
+	rtest.Equals(t, time.Duration(0), b.take(start, 1000))
+
+	// half a second later, 500 bytes are available again
+	rtest.Equals(t, time.Duration(0), b.take(start.Add(500*time.Millisecond), 500))
+
+	// taking another 250 bytes requires waiting for a quarter of a second
+	rtest.Equals(t, 250*time.Millisecond, b.take(start.Add(500*time.Millisecond), 250))
+
+	// after a long pause the bucket holds at most one second worth of tokens
+	now := start.Add(time.Hour)
+	rtest.Equals(t, time.Duration(0), b.take(now, 1000))
+	rtest.Equals(t, time.Second, b.take(now, 1000))
+}
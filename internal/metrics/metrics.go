@@ -0,0 +1,190 @@
+// Package metrics collects counters about the operations restic performs and
+// exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is a value which can be written in the Prometheus text format.
+type metric interface {
+	write(w io.Writer) error
+}
+
+var registry []metric
+
+// The metrics collected by restic.
+var (
+	BytesUploaded = newCounter("restic_backend_uploaded_bytes_total",
+		"Number of bytes uploaded to the backend.")
+	BytesDownloaded = newCounter("restic_backend_downloaded_bytes_total",
+		"Number of bytes downloaded from the backend.")
+	BackendErrors = newCounter("restic_backend_errors_total",
+		"Number of failed backend requests.")
+	BackendRequestDuration = newHistogram("restic_backend_request_duration_seconds",
+		"Duration of backend requests.", "operation",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60})
+
+	FilesProcessed = newCounter("restic_files_processed_total",
+		"Number of files processed by the backup.")
+	Errors = newCounter("restic_errors_total",
+		"Number of files and directories which could not be read by the backup.")
+)
+
+// Counter is a value which only increases.
+type Counter struct {
+	// v is accessed atomically and must be the first field, so that it is
+	// aligned for 64-bit atomic operations on 32-bit platforms.
+	v uint64
+
+	name string
+	help string
+}
+
+func newCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	registry = append(registry, c)
+	return c
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+func (c *Counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name, c.help, c.name, c.name, c.Value())
+	return err
+}
+
+// Histogram records the distribution of durations, separately for each
+// value of a label.
+type Histogram struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+
+	m      sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	registry = append(registry, h)
+	return h
+}
+
+// Observe records the duration d for the label value.
+func (h *Histogram) Observe(value string, d time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	v, ok := h.values[value]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[value] = v
+	}
+
+	sec := d.Seconds()
+	for i, upper := range h.buckets {
+		if sec <= upper {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += sec
+}
+
+// Count returns the number of durations recorded for the label value.
+func (h *Histogram) Count(value string) uint64 {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if v, ok := h.values[value]; ok {
+		return v.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+
+	var values []string
+	for value := range h.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	for _, value := range values {
+		v := h.values[value]
+		for i, upper := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"%g\"} %d\n", h.name, h.label, value, upper, v.counts[i]); err != nil {
+				return err
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n%s_sum{%s=%q} %g\n%s_count{%s=%q} %d\n",
+			h.name, h.label, value, v.count,
+			h.name, h.label, value, v.sum,
+			h.name, h.label, value, v.count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write writes all metrics to w in the Prometheus text format.
+func Write(w io.Writer) error {
+	for _, m := range registry {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler which serves all metrics in the Prometheus
+// text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = Write(w)
+	})
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestWrite(t *testing.T) {
+	c := &Counter{name: "test_total", help: "Test counter."}
+	c.Add(3)
+	c.Inc()
+
+	h := &Histogram{
+		name:    "test_seconds",
+		help:    "Test histogram.",
+		label:   "op",
+		buckets: []float64{0.1, 1},
+		values:  make(map[string]*histogramValue),
+	}
+	h.Observe("load", 50*time.Millisecond)
+	h.Observe("load", 500*time.Millisecond)
+	h.Observe("load", 5*time.Second)
+
+	var buf bytes.Buffer
+	rtest.OK(t, c.write(&buf))
+	rtest.OK(t, h.write(&buf))
+
+	want := strings.Join([]string{
+		"# HELP test_total Test counter.",
+		"# TYPE test_total counter",
+		"test_total 4",
+		"# HELP test_seconds Test histogram.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{op="load",le="0.1"} 1`,
+		`test_seconds_bucket{op="load",le="1"} 2`,
+		`test_seconds_bucket{op="load",le="+Inf"} 3`,
+		`test_seconds_sum{op="load"} 5.55`,
+		`test_seconds_count{op="load"} 3`,
+	}, "\n") + "\n"
+
+	rtest.Equals(t, want, buf.String())
+}
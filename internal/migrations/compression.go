@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&Compression{})
+}
+
+// Compression enables the compression feature in the config of a repository,
+// so that new data is saved compressed. Existing data is not changed.
+type Compression struct{}
+
+// Check tests whether the migration can be applied.
+func (m *Compression) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	if cfg.HasFeature(restic.FeatureCompression) {
+		debug.Log("repository already uses compression")
+		return false, nil
+	}
+
+	if cfg.ObjectLock != nil {
+		debug.Log("config cannot be replaced, it is protected by the object lock")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *Compression) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	cfg.Features = append(cfg.Features, restic.FeatureCompression)
+
+	be := repo.Backend()
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// keep the old config, so that it can be restored if saving the new one
+	// fails
+	buf, err := backend.LoadAll(ctx, be, h)
+	if err != nil {
+		return err
+	}
+
+	if err = be.Remove(ctx, h); err != nil {
+		return errors.Wrap(err, "remove old config")
+	}
+
+	_, err = repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		debug.Log("saving the new config failed, restoring the old one: %v", err)
+		if rerr := be.Save(ctx, h, bytes.NewReader(buf)); rerr != nil {
+			return errors.Errorf("saving the new config failed: %v, restoring the old config failed: %v", err, rerr)
+		}
+		return errors.Wrap(err, "save new config")
+	}
+
+	return nil
+}
+
+// Name returns the name for this migration.
+func (m *Compression) Name() string {
+	return "compression"
+}
+
+// Desc returns a short description what the migration does.
+func (m *Compression) Desc() string {
+	return "enable compression of new data, the repository cannot be read by restic versions without compression support afterwards"
+}
@@ -34,13 +34,15 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 	return &Packer{k: k, wr: wr}
 }
 
-// Add saves the data read from rd as a new blob to the packer. Returned is the
-// number of bytes written to the pack.
-func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
+// Add saves the data read from rd as a new blob to the packer. If data is
+// compressed, uncompressedLength is the length of the plaintext before
+// compression, otherwise it must be zero. Returned is the number of bytes
+// written to the pack.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	c := restic.Blob{Type: t, ID: id}
+	c := restic.Blob{Type: t, ID: id, UncompressedLength: uint(uncompressedLength)}
 
 	n, err := p.wr.Write(data)
 	c.Length = uint(n)
@@ -51,7 +53,46 @@ func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error)
 	return n, errors.Wrap(err, "Write")
 }
 
-var entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+var (
+	// entrySize is the size of the header entry of an uncompressed blob
+	entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+	// compressedEntrySize additionally includes the uncompressed length
+	compressedEntrySize = entrySize + uint(binary.Size(uint32(0)))
+)
+
+// The types of blobs in the pack header. Compressed blobs have a different
+// type, so that older versions of restic refuse to read them instead of
+// returning garbage.
+const (
+	headerTypeData           = 0
+	headerTypeTree           = 1
+	headerTypeCompressedData = 2
+	headerTypeCompressedTree = 3
+)
+
+// headerEntrySize returns the size of the header entry for the blob.
+func headerEntrySize(b restic.Blob) uint {
+	if b.IsCompressed() {
+		return compressedEntrySize
+	}
+	return entrySize
+}
+
+// PackedSizeOfBlob returns the number of bytes the blob uses in a pack file,
+// including its header entry.
+func PackedSizeOfBlob(b restic.Blob) uint {
+	return b.Length + headerEntrySize(b)
+}
+
+// CalculateHeaderSize returns the size of the header of a pack file with the
+// blobs, including the encryption overhead and the header length at the end.
+func CalculateHeaderSize(blobs []restic.Blob) uint {
+	size := uint(0)
+	for _, b := range blobs {
+		size += headerEntrySize(b)
+	}
+	return uint(restic.CiphertextLength(int(size)) + binary.Size(uint32(0)))
+}
 
 // headerEntry is used with encoding/binary to read and write header entries
 type headerEntry struct {
@@ -60,6 +101,14 @@ type headerEntry struct {
 	ID     restic.ID
 }
 
+// compressedHeaderEntry is the header entry of a compressed blob.
+type compressedHeaderEntry struct {
+	Type               uint8
+	Length             uint32
+	UncompressedLength uint32
+	ID                 restic.ID
+}
+
 // Finalize writes the header for all added blobs and finalizes the pack.
 // Returned are the number of bytes written, including the header. If the
 // underlying writer implements io.Closer, it is closed.
@@ -94,7 +143,7 @@ func (p *Packer) Finalize() (uint, error) {
 	bytesWritten += uint(hdrBytes)
 
 	// write length
-	err = binary.Write(p.wr, binary.LittleEndian, uint32(restic.CiphertextLength(len(p.blobs)*int(entrySize))))
+	err = binary.Write(p.wr, binary.LittleEndian, uint32(hdrBytes))
 	if err != nil {
 		return 0, errors.Wrap(err, "binary.Write")
 	}
@@ -112,26 +161,38 @@ func (p *Packer) Finalize() (uint, error) {
 // writeHeader constructs and writes the header to wr.
 func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 	for _, b := range p.blobs {
-		entry := headerEntry{
-			Length: uint32(b.Length),
-			ID:     b.ID,
-		}
-
+		var tpe, compressedType uint8
 		switch b.Type {
 		case restic.DataBlob:
-			entry.Type = 0
+			tpe, compressedType = headerTypeData, headerTypeCompressedData
 		case restic.TreeBlob:
-			entry.Type = 1
+			tpe, compressedType = headerTypeTree, headerTypeCompressedTree
 		default:
 			return 0, errors.Errorf("invalid blob type %v", b.Type)
 		}
 
+		var entry interface{}
+		if b.IsCompressed() {
+			entry = compressedHeaderEntry{
+				Type:               compressedType,
+				Length:             uint32(b.Length),
+				UncompressedLength: uint32(b.UncompressedLength),
+				ID:                 b.ID,
+			}
+		} else {
+			entry = headerEntry{
+				Type:   tpe,
+				Length: uint32(b.Length),
+				ID:     b.ID,
+			}
+		}
+
 		err := binary.Write(wr, binary.LittleEndian, entry)
 		if err != nil {
 			return bytesWritten, errors.Wrap(err, "binary.Write")
 		}
 
-		bytesWritten += entrySize
+		bytesWritten += headerEntrySize(b)
 	}
 
 	return
@@ -224,11 +285,6 @@ func readHeader(rd io.ReaderAt, size int64) ([]byte, error) {
 		return nil, errors.Wrap(err, "readHeader")
 	}
 
-	if (hl-crypto.Extension)%uint32(entrySize) != 0 {
-		err := InvalidFileError{Message: "header length is invalid"}
-		return nil, errors.Wrap(err, "readHeader")
-	}
-
 	if int64(hl) > size-int64(binary.Size(hl)) {
 		err := InvalidFileError{Message: "header is larger than file"}
 		return nil, errors.Wrap(err, "readHeader")
@@ -274,40 +330,52 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 	}
 	buf = buf[:n]
 
-	hdrRd := bytes.NewReader(buf)
-
 	entries = make([]restic.Blob, 0, uint(n)/entrySize)
 
 	pos := uint(0)
-	for {
-		e := headerEntry{}
-		err = binary.Read(hdrRd, binary.LittleEndian, &e)
-		if errors.Cause(err) == io.EOF {
-			break
-		}
+	for len(buf) > 0 {
+		entry := restic.Blob{Offset: pos}
 
-		if err != nil {
-			return nil, errors.Wrap(err, "binary.Read")
-		}
-
-		entry := restic.Blob{
-			Length: uint(e.Length),
-			ID:     e.ID,
-			Offset: pos,
-		}
-
-		switch e.Type {
-		case 0:
+		switch buf[0] {
+		case headerTypeData, headerTypeCompressedData:
 			entry.Type = restic.DataBlob
-		case 1:
+		case headerTypeTree, headerTypeCompressedTree:
 			entry.Type = restic.TreeBlob
 		default:
-			return nil, errors.Errorf("invalid type %d", e.Type)
+			return nil, errors.Errorf("invalid type %d", buf[0])
+		}
+
+		if buf[0] >= headerTypeCompressedData {
+			e := compressedHeaderEntry{}
+			if uint(len(buf)) < compressedEntrySize {
+				return nil, errors.New("header entry is truncated")
+			}
+			err = binary.Read(bytes.NewReader(buf[:compressedEntrySize]), binary.LittleEndian, &e)
+			if err != nil {
+				return nil, errors.Wrap(err, "binary.Read")
+			}
+			if e.UncompressedLength == 0 {
+				return nil, errors.Errorf("compressed blob %v has no uncompressed length", e.ID.Str())
+			}
+			entry.Length, entry.ID = uint(e.Length), e.ID
+			entry.UncompressedLength = uint(e.UncompressedLength)
+			buf = buf[compressedEntrySize:]
+		} else {
+			e := headerEntry{}
+			if uint(len(buf)) < entrySize {
+				return nil, errors.New("header entry is truncated")
+			}
+			err = binary.Read(bytes.NewReader(buf[:entrySize]), binary.LittleEndian, &e)
+			if err != nil {
+				return nil, errors.Wrap(err, "binary.Read")
+			}
+			entry.Length, entry.ID = uint(e.Length), e.ID
+			buf = buf[entrySize:]
 		}
 
 		entries = append(entries, entry)
 
-		pos += uint(e.Length)
+		pos += entry.Length
 	}
 
 	return entries, nil
@@ -38,7 +38,7 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	// pack blobs
 	p := pack.NewPacker(k, nil)
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		p.Add(restic.TreeBlob, b.id, b.data, 0)
 	}
 
 	_, err := p.Finalize()
@@ -92,6 +92,46 @@ func TestCreatePack(t *testing.T) {
 	bufs, packData, packSize := newPack(t, k, testLens)
 	rtest.Equals(t, uint(len(packData)), packSize)
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+
+	// the size of the header only depends on the blobs
+	var blobs uint
+	list := make([]restic.Blob, 0, len(bufs))
+	for _, buf := range bufs {
+		blobs += uint(len(buf.data))
+		list = append(list, restic.Blob{Type: restic.TreeBlob, Length: uint(len(buf.data))})
+	}
+	rtest.Equals(t, packSize, blobs+pack.CalculateHeaderSize(list))
+}
+
+func TestCompressedBlobs(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	// the packer does not compress data itself, so the content of the
+	// blobs does not matter here
+	p := pack.NewPacker(k, nil)
+	blobs := []restic.Blob{
+		{Type: restic.DataBlob, ID: restic.NewRandomID(), Length: 123, UncompressedLength: 4567},
+		{Type: restic.TreeBlob, ID: restic.NewRandomID(), Length: 234},
+		{Type: restic.TreeBlob, ID: restic.NewRandomID(), Length: 345, UncompressedLength: 89012},
+		{Type: restic.DataBlob, ID: restic.NewRandomID(), Length: 456},
+	}
+
+	var size uint
+	for i, b := range blobs {
+		blobs[i].Offset = size
+		size += b.Length
+		_, err := p.Add(b.Type, b.ID, make([]byte, b.Length), int(b.UncompressedLength))
+		rtest.OK(t, err)
+	}
+
+	packSize, err := p.Finalize()
+	rtest.OK(t, err)
+	rtest.Equals(t, size+pack.CalculateHeaderSize(blobs), packSize)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, blobs, entries)
 }
 
 var blobTypeJSON = []struct {
@@ -34,6 +34,10 @@ type Entry struct {
 	// points to the old node if available, interface{} is used to prevent
 	// circular import
 	Node interface{}
+
+	// Modified is set if an older version of the file exists which has been
+	// modified since.
+	Modified bool
 }
 
 func (e Entry) Path() string          { return e.path }
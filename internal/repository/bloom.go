@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+const (
+	// bloomBitsPerBlob and bloomHashes result in a false positive rate of
+	// about one percent.
+	bloomBitsPerBlob = 10
+	bloomHashes      = 7
+
+	// bloomMinCapacity is the minimal number of blobs a filter is built for.
+	bloomMinCapacity = 1 << 16
+)
+
+// bloomFilter is a bloom filter for blob handles. It answers whether a blob
+// is definitely not contained in the index without looking at the index.
+// Adding blobs and testing for them can be done concurrently.
+type bloomFilter struct {
+	bits     []uint32
+	capacity uint64
+	count    uint64 // accessed atomically
+}
+
+// newBloomFilter returns a filter for up to capacity blobs.
+func newBloomFilter(capacity uint64) *bloomFilter {
+	if capacity < bloomMinCapacity {
+		capacity = bloomMinCapacity
+	}
+
+	return &bloomFilter{
+		bits:     make([]uint32, (capacity*bloomBitsPerBlob+31)/32),
+		capacity: capacity,
+	}
+}
+
+// positions calls fn for the bits of h. Since blob IDs are SHA-256 hashes,
+// the hash functions are derived from the ID directly.
+func (f *bloomFilter) positions(h restic.BlobHandle, fn func(word int, mask uint32) bool) {
+	h1 := binary.LittleEndian.Uint64(h.ID[0:8]) ^ uint64(h.Type)
+	h2 := binary.LittleEndian.Uint64(h.ID[8:16]) | 1
+
+	n := uint64(len(f.bits)) * 32
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if !fn(int(bit/32), 1<<(bit%32)) {
+			return
+		}
+	}
+}
+
+// add adds h to the filter.
+func (f *bloomFilter) add(h restic.BlobHandle) {
+	f.positions(h, func(word int, mask uint32) bool {
+		for {
+			old := atomic.LoadUint32(&f.bits[word])
+			if old&mask != 0 || atomic.CompareAndSwapUint32(&f.bits[word], old, old|mask) {
+				return true
+			}
+		}
+	})
+	atomic.AddUint64(&f.count, 1)
+}
+
+// mayContain returns false if h has definitely not been added to the filter.
+func (f *bloomFilter) mayContain(h restic.BlobHandle) bool {
+	found := true
+	f.positions(h, func(word int, mask uint32) bool {
+		if atomic.LoadUint32(&f.bits[word])&mask == 0 {
+			found = false
+		}
+		return found
+	})
+	return found
+}
+
+// full returns true if more blobs than the capacity have been added, so that
+// the false positive rate rises.
+func (f *bloomFilter) full() bool {
+	return atomic.LoadUint64(&f.count) > f.capacity
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(0)
+
+	var handles []restic.BlobHandle
+	for i := 0; i < bloomMinCapacity; i++ {
+		h := restic.BlobHandle{ID: restic.NewRandomID(), Type: restic.DataBlob}
+		f.add(h)
+		handles = append(handles, h)
+	}
+
+	if f.full() {
+		t.Fatalf("filter is full after adding %d blobs", len(handles))
+	}
+
+	for _, h := range handles {
+		if !f.mayContain(h) {
+			t.Fatalf("blob %v not found in filter", h)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.mayContain(restic.BlobHandle{ID: restic.NewRandomID(), Type: restic.DataBlob}) {
+			falsePositives++
+		}
+	}
+
+	// the expected rate is about one percent
+	if falsePositives > 300 {
+		t.Errorf("too many false positives: %d of 10000", falsePositives)
+	}
+
+	f.add(restic.BlobHandle{ID: restic.NewRandomID(), Type: restic.DataBlob})
+	if !f.full() {
+		t.Errorf("filter is not full after adding more than %d blobs", f.capacity)
+	}
+}
+
+func TestMasterIndexBloom(t *testing.T) {
+	mi := NewMasterIndex()
+
+	idx := NewIndex()
+	var blobs []restic.PackedBlob
+	for i := 0; i < 2*bloomMinCapacity; i++ {
+		pb := restic.PackedBlob{
+			Blob:   restic.Blob{Type: restic.DataBlob, ID: restic.NewRandomID(), Length: 10},
+			PackID: restic.NewRandomID(),
+		}
+		idx.Store(pb)
+		blobs = append(blobs, pb)
+	}
+	idx.final = true
+	mi.Insert(idx)
+
+	// blobs saved later are found as well, also after the filter has grown
+	for i := 0; i < 2*bloomMinCapacity; i++ {
+		pb := restic.PackedBlob{
+			Blob:   restic.Blob{Type: restic.TreeBlob, ID: restic.NewRandomID(), Length: 10},
+			PackID: restic.NewRandomID(),
+		}
+		mi.Store(pb)
+		blobs = append(blobs, pb)
+
+		if i == bloomMinCapacity {
+			for _, idx := range mi.NotFinalIndexes() {
+				idx.final = true
+			}
+		}
+	}
+
+	for _, pb := range blobs {
+		if !mi.Has(pb.ID, pb.Type) {
+			t.Fatalf("blob %v not found", pb.ID.Str())
+		}
+	}
+
+	if mi.Has(blobs[0].ID, restic.TreeBlob) {
+		t.Errorf("blob %v found with wrong type", blobs[0].ID.Str())
+	}
+
+	if mi.bloom == nil || mi.bloom.full() {
+		t.Errorf("bloom filter missing or full")
+	}
+}
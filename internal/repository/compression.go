@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// unpackedCompressed is the first byte of the plaintext of compressed
+// snapshot and index files. Uncompressed files contain JSON, which never
+// starts with this byte.
+const unpackedCompressed = 2
+
+// SetCompression sets the compression mode for new blobs, snapshots and
+// indexes. It must be called after the repository has been opened. Data is
+// only compressed in repositories which use the compression feature, older
+// versions of restic cannot read it. For these, mode "auto" is ignored and
+// "max" returns an error. An empty mode selects "auto".
+func (r *Repository) SetCompression(mode string) error {
+	if mode == "" {
+		mode = restic.CompressionAuto
+	}
+
+	if err := restic.ValidCompression(mode); err != nil {
+		return err
+	}
+
+	if mode == restic.CompressionMax && !r.cfg.HasFeature(restic.FeatureCompression) {
+		return errors.Fatal("the repository does not support compression, run 'restic migrate compression' first")
+	}
+
+	r.compression = mode
+	return nil
+}
+
+// compressionMode returns the compression mode used for new data.
+func (r *Repository) compressionMode() string {
+	if !r.cfg.HasFeature(restic.FeatureCompression) {
+		return restic.CompressionOff
+	}
+	return r.compression
+}
+
+// compressUnpacked compresses the plaintext of a snapshot or index file if the
+// repository uses compression.
+func (r *Repository) compressUnpacked(t restic.FileType, p []byte) ([]byte, error) {
+	if t != restic.SnapshotFile && t != restic.IndexFile {
+		return p, nil
+	}
+
+	buf, err := restic.Compress(r.compressionMode(), p)
+	if err != nil || buf == nil {
+		return p, err
+	}
+
+	debug.Log("compressed %v from %d to %d bytes", t, len(p), len(buf))
+	return append([]byte{unpackedCompressed}, buf...), nil
+}
+
+// decompressUnpacked returns the plaintext of a snapshot or index file which
+// may have been compressed.
+func (r *Repository) decompressUnpacked(t restic.FileType, p []byte) ([]byte, error) {
+	if t != restic.SnapshotFile && t != restic.IndexFile {
+		return p, nil
+	}
+
+	if len(p) == 0 || p[0] != unpackedCompressed {
+		return p, nil
+	}
+
+	return restic.Decompress(nil, p[1:], 0)
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// SameKey returns true if r and other use the same master key and hash
+// algorithm, so that the pack files of one repository can be used in the
+// other one as they are.
+func (r *Repository) SameKey(other *Repository) bool {
+	return r.key.Equal(other.key) && r.cfg.Hash.Equal(other.cfg.Hash)
+}
+
+// CopySessionKeys saves the session keys of src which are missing in r, so
+// that pack files copied from src which were saved with write-only keys can
+// be decrypted. Both repositories must use the same master key.
+func (r *Repository) CopySessionKeys(ctx context.Context, src *Repository) error {
+	for name := range src.be.List(ctx, restic.SessionFile) {
+		h := restic.Handle{Type: restic.SessionFile, Name: name}
+
+		exists, err := r.be.Test(ctx, h)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		buf, err := backend.LoadAll(ctx, src.be, h)
+		if err != nil {
+			return errors.Wrapf(err, "load session key %v", name)
+		}
+
+		debug.Log("copy session key %v", name)
+		err = r.be.Save(ctx, h, bytes.NewReader(buf))
+		if err != nil {
+			return errors.Wrapf(err, "save session key %v", name)
+		}
+
+		if keys, err := r.key.OpenSealedKeys(buf); err == nil {
+			for _, sk := range keys {
+				r.key.AddSessionKey(sk)
+			}
+			r.sessionFiles = append(r.sessionFiles, name)
+		}
+	}
+
+	return nil
+}
+
+// CopyPack copies the pack file id from src with a server-side copy of the
+// backend and adds the blobs it contains to the index. It returns false if
+// the backend cannot copy the file from src, the blobs must be copied one by
+// one then. Both repositories must use the same key, see SameKey.
+func (r *Repository) CopyPack(ctx context.Context, src *Repository, id restic.ID) (bool, error) {
+	blobs := src.idx.ListPack(id)
+	if len(blobs) == 0 {
+		return false, errors.Errorf("pack %v not found in the index", id.Str())
+	}
+
+	list := make([]restic.Blob, 0, len(blobs))
+	for _, pb := range blobs {
+		// older versions of restic cannot read compressed blobs
+		if pb.IsCompressed() && !r.cfg.HasFeature(restic.FeatureCompression) {
+			debug.Log("pack %v contains compressed blobs", id.Str())
+			return false, nil
+		}
+		list = append(list, pb.Blob)
+	}
+
+	size := uint64(pack.CalculateHeaderSize(list))
+	for _, pb := range blobs {
+		size += uint64(pb.Length)
+	}
+
+	err := r.reserveSize(ctx, size)
+	if err != nil {
+		return false, err
+	}
+
+	// the backend may store pack files with tree blobs differently
+	ctx = restic.ContextWithBlobType(ctx, blobs[0].Type)
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+
+	copied, err := restic.CopyFrom(ctx, r.be, src.be, h)
+	if err != nil || !copied {
+		r.releaseSize(size)
+		return false, err
+	}
+
+	debug.Log("copied pack %v with %d blobs", id.Str(), len(blobs))
+	for _, pb := range blobs {
+		r.idx.Store(pb)
+	}
+
+	return true, nil
+}
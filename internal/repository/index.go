@@ -16,37 +16,66 @@ import (
 // Index holds a lookup table for id -> pack.
 type Index struct {
 	m         sync.Mutex
-	pack      map[restic.BlobHandle][]indexEntry
+	pack      indexStore
 	treePacks restic.IDs
 
 	final      bool      // set to true for all indexes read from the backend ("finalized")
 	id         restic.ID // set to the ID of the index when it's finalized
 	supersedes restic.IDs
+	obsolete   []ObsoletePack
 	created    time.Time
+
+	// fallback is set for the index of blobs in obsolete packs, which
+	// Repository.LoadIndex adds after all other indexes.
+	fallback bool
+}
+
+// ObsoletePack is a pack which has been removed from the index by prune, but
+// is only deleted after a grace period. Until then, prune can add it to the
+// index again if a snapshot created in the meantime references its blobs.
+type ObsoletePack struct {
+	ID    restic.ID
+	Time  time.Time // when the pack was removed from the index
+	Blobs []restic.Blob
 }
 
 type indexEntry struct {
-	packID restic.ID
-	offset uint
-	length uint
+	packID             restic.ID
+	offset             uint
+	length             uint
+	uncompressedLength uint
 }
 
 // NewIndex returns a new index.
 func NewIndex() *Index {
 	return &Index{
-		pack:    make(map[restic.BlobHandle][]indexEntry),
+		pack:    make(mapStore),
+		created: time.Now(),
+	}
+}
+
+// newLoadedIndex returns a new index for the packs loaded from the repository.
+func newLoadedIndex(packs []*packJSON) *Index {
+	n := 0
+	for _, pack := range packs {
+		n += len(pack.Blobs)
+	}
+
+	return &Index{
+		pack:    newIndexStore(n),
 		created: time.Now(),
 	}
 }
 
 func (idx *Index) store(blob restic.PackedBlob) {
 	newEntry := indexEntry{
-		packID: blob.PackID,
-		offset: blob.Offset,
-		length: blob.Length,
+		packID:             blob.PackID,
+		offset:             blob.Offset,
+		length:             blob.Length,
+		uncompressedLength: blob.UncompressedLength,
 	}
 	h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-	idx.pack[h] = append(idx.pack[h], newEntry)
+	idx.pack.add(h, newEntry)
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -72,7 +101,7 @@ var IndexFull = func(idx *Index) bool {
 
 	debug.Log("checking whether index %p is full", idx)
 
-	packs := len(idx.pack)
+	packs := idx.pack.len()
 	age := time.Now().Sub(idx.created)
 
 	if age > indexMaxAge {
@@ -116,7 +145,7 @@ func (idx *Index) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.Pack
 
 	h := restic.BlobHandle{ID: id, Type: tpe}
 
-	if packs, ok := idx.pack[h]; ok {
+	if packs := idx.pack.get(h); len(packs) > 0 {
 		blobs = make([]restic.PackedBlob, 0, len(packs))
 
 		for _, p := range packs {
@@ -125,10 +154,11 @@ func (idx *Index) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.Pack
 
 			blob := restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   tpe,
-					Length: p.length,
-					ID:     id,
-					Offset: p.offset,
+					Type:               tpe,
+					Length:             p.length,
+					ID:                 id,
+					Offset:             p.offset,
+					UncompressedLength: p.uncompressedLength,
 				},
 				PackID: p.packID,
 			}
@@ -148,21 +178,21 @@ func (idx *Index) ListPack(id restic.ID) (list []restic.PackedBlob) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	for h, packList := range idx.pack {
-		for _, entry := range packList {
-			if entry.packID == id {
-				list = append(list, restic.PackedBlob{
-					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Length: entry.length,
-						Offset: entry.offset,
-					},
-					PackID: entry.packID,
-				})
-			}
+	idx.pack.each(func(h restic.BlobHandle, entry indexEntry) bool {
+		if entry.packID == id {
+			list = append(list, restic.PackedBlob{
+				Blob: restic.Blob{
+					ID:                 h.ID,
+					Type:               h.Type,
+					Length:             entry.length,
+					Offset:             entry.offset,
+					UncompressedLength: entry.uncompressedLength,
+				},
+				PackID: entry.packID,
+			})
 		}
-	}
+		return true
+	})
 
 	return list
 }
@@ -185,7 +215,18 @@ func (idx *Index) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength
 		return 0, err
 	}
 
-	return uint(restic.PlaintextLength(int(blobs[0].Length))), nil
+	return blobs[0].DataLength(), nil
+}
+
+// addToBloom adds all blobs in the index to the bloom filter f.
+func (idx *Index) addToBloom(f *bloomFilter) {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	idx.pack.each(func(h restic.BlobHandle, entry indexEntry) bool {
+		f.add(h)
+		return true
+	})
 }
 
 // Supersedes returns the list of indexes this index supersedes, if any.
@@ -207,6 +248,30 @@ func (idx *Index) AddToSupersedes(ids ...restic.ID) error {
 	return nil
 }
 
+// Obsolete returns the list of packs which have been removed from the index
+// and are waiting to be deleted.
+func (idx *Index) Obsolete() []ObsoletePack {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	return idx.obsolete
+}
+
+// SetObsolete sets the list of packs which have been removed from the index
+// and are waiting to be deleted. If the index has already been finalized, an
+// error is returned.
+func (idx *Index) SetObsolete(list []ObsoletePack) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.final {
+		return errors.New("index already finalized")
+	}
+
+	idx.obsolete = list
+	return nil
+}
+
 // Each returns a channel that yields all blobs known to the index. When the
 // context is cancelled, the background goroutine terminates. This blocks any
 // modification of the index.
@@ -221,23 +286,23 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 			close(ch)
 		}()
 
-		for h, packs := range idx.pack {
-			for _, blob := range packs {
-				select {
-				case <-ctx.Done():
-					return
-				case ch <- restic.PackedBlob{
-					Blob: restic.Blob{
-						ID:     h.ID,
-						Type:   h.Type,
-						Offset: blob.offset,
-						Length: blob.length,
-					},
-					PackID: blob.packID,
-				}:
-				}
+		idx.pack.each(func(h restic.BlobHandle, blob indexEntry) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case ch <- restic.PackedBlob{
+				Blob: restic.Blob{
+					ID:                 h.ID,
+					Type:               h.Type,
+					Offset:             blob.offset,
+					Length:             blob.length,
+					UncompressedLength: blob.uncompressedLength,
+				},
+				PackID: blob.packID,
+			}:
 			}
-		}
+			return true
+		})
 	}()
 
 	return ch
@@ -249,11 +314,10 @@ func (idx *Index) Packs() restic.IDSet {
 	defer idx.m.Unlock()
 
 	packs := restic.NewIDSet()
-	for _, list := range idx.pack {
-		for _, entry := range list {
-			packs.Insert(entry.packID)
-		}
-	}
+	idx.pack.each(func(h restic.BlobHandle, entry indexEntry) bool {
+		packs.Insert(entry.packID)
+		return true
+	})
 
 	return packs
 }
@@ -264,13 +328,12 @@ func (idx *Index) Count(t restic.BlobType) (n uint) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	for h, list := range idx.pack {
-		if h.Type != t {
-			continue
+	idx.pack.each(func(h restic.BlobHandle, entry indexEntry) bool {
+		if h.Type == t {
+			n++
 		}
-
-		n += uint(len(list))
-	}
+		return true
+	})
 
 	return
 }
@@ -281,10 +344,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -292,39 +356,45 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 	list := []*packJSON{}
 	packs := make(map[restic.ID]*packJSON)
 
-	for h, packedBlobs := range idx.pack {
-		for _, blob := range packedBlobs {
-			if blob.packID.IsNull() {
-				panic("null pack id")
-			}
+	var err error
+	idx.pack.each(func(h restic.BlobHandle, blob indexEntry) bool {
+		if blob.packID.IsNull() {
+			panic("null pack id")
+		}
 
-			debug.Log("handle blob %v", h)
+		debug.Log("handle blob %v", h)
 
-			if blob.packID.IsNull() {
-				debug.Log("blob %v has no packID! (offset %v, length %v)",
-					h, blob.offset, blob.length)
-				return nil, errors.Errorf("unable to serialize index: pack for blob %v hasn't been written yet", h)
-			}
+		if blob.packID.IsNull() {
+			debug.Log("blob %v has no packID! (offset %v, length %v)",
+				h, blob.offset, blob.length)
+			err = errors.Errorf("unable to serialize index: pack for blob %v hasn't been written yet", h)
+			return false
+		}
 
-			// see if pack is already in map
-			p, ok := packs[blob.packID]
-			if !ok {
-				// else create new pack
-				p = &packJSON{ID: blob.packID}
+		// see if pack is already in map
+		p, ok := packs[blob.packID]
+		if !ok {
+			// else create new pack
+			p = &packJSON{ID: blob.packID}
 
-				// and append it to the list and map
-				list = append(list, p)
-				packs[p.ID] = p
-			}
-
-			// add blob
-			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     h.ID,
-				Type:   h.Type,
-				Offset: blob.offset,
-				Length: blob.length,
-			})
+			// and append it to the list and map
+			list = append(list, p)
+			packs[p.ID] = p
 		}
+
+		// add blob
+		p.Blobs = append(p.Blobs, blobJSON{
+			ID:                 h.ID,
+			Type:               h.Type,
+			Offset:             blob.offset,
+			Length:             blob.length,
+			UncompressedLength: blob.uncompressedLength,
+		})
+		return true
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
 	debug.Log("done")
@@ -332,9 +402,35 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 	return list, nil
 }
 
+type obsoleteJSON struct {
+	ID    restic.ID  `json:"id"`
+	Time  time.Time  `json:"time"`
+	Blobs []blobJSON `json:"blobs"`
+}
+
 type jsonIndex struct {
-	Supersedes restic.IDs  `json:"supersedes,omitempty"`
-	Packs      []*packJSON `json:"packs"`
+	Supersedes restic.IDs     `json:"supersedes,omitempty"`
+	Packs      []*packJSON    `json:"packs"`
+	Obsolete   []obsoleteJSON `json:"obsolete,omitempty"`
+}
+
+// obsoleteList returns the JSON representation of the obsolete packs.
+func (idx *Index) obsoleteList() []obsoleteJSON {
+	var list []obsoleteJSON
+	for _, op := range idx.obsolete {
+		o := obsoleteJSON{ID: op.ID, Time: op.Time}
+		for _, blob := range op.Blobs {
+			o.Blobs = append(o.Blobs, blobJSON{
+				ID:                 blob.ID,
+				Type:               blob.Type,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+			})
+		}
+		list = append(list, o)
+	}
+	return list
 }
 
 // Encode writes the JSON serialization of the index to the writer w.
@@ -359,6 +455,7 @@ func (idx *Index) encode(w io.Writer) error {
 	idxJSON := jsonIndex{
 		Supersedes: idx.supersedes,
 		Packs:      list,
+		Obsolete:   idx.obsoleteList(),
 	}
 	return enc.Encode(idxJSON)
 }
@@ -421,6 +518,7 @@ func (idx *Index) Dump(w io.Writer) error {
 	outer := jsonIndex{
 		Supersedes: idx.Supersedes(),
 		Packs:      list,
+		Obsolete:   idx.obsoleteList(),
 	}
 
 	buf, err := json.MarshalIndent(outer, "", "  ")
@@ -473,17 +571,18 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 		return nil, errors.Wrap(err, "Decode")
 	}
 
-	idx = NewIndex()
+	idx = newLoadedIndex(idxJSON.Packs)
 	for _, pack := range idxJSON.Packs {
 		var data, tree bool
 
 		for _, blob := range pack.Blobs {
 			idx.store(restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					Type:               blob.Type,
+					ID:                 blob.ID,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				},
 				PackID: pack.ID,
 			})
@@ -501,6 +600,21 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 		}
 	}
 	idx.supersedes = idxJSON.Supersedes
+
+	for _, o := range idxJSON.Obsolete {
+		op := ObsoletePack{ID: o.ID, Time: o.Time}
+		for _, blob := range o.Blobs {
+			op.Blobs = append(op.Blobs, restic.Blob{
+				Type:               blob.Type,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+			})
+		}
+		idx.obsolete = append(idx.obsolete, op)
+	}
+
 	idx.final = true
 
 	debug.Log("done")
@@ -518,17 +632,18 @@ func DecodeOldIndex(buf []byte) (idx *Index, err error) {
 		return nil, errors.Wrap(err, "Decode")
 	}
 
-	idx = NewIndex()
+	idx = newLoadedIndex(list)
 	for _, pack := range list {
 		var data, tree bool
 
 		for _, blob := range pack.Blobs {
 			idx.store(restic.PackedBlob{
 				Blob: restic.Blob{
-					Type:   blob.Type,
-					ID:     blob.ID,
-					Offset: blob.Offset,
-					Length: blob.Length,
+					Type:               blob.Type,
+					ID:                 blob.ID,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
 				},
 				PackID: pack.ID,
 			})
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Index modes select how the entries of indexes loaded from the repository
+// are kept in memory.
+const (
+	// IndexModeDefault uses a map, which is fast but needs a lot of memory.
+	IndexModeDefault = "default"
+	// IndexModeCompact uses a sorted list, which needs about a third of the
+	// memory of the map, lookups are slower.
+	IndexModeCompact = "compact"
+)
+
+var indexMode = IndexModeDefault
+
+// SetIndexMode sets the mode used for all indexes which are loaded
+// afterwards, an empty mode selects the default. Indexes which are created while saving data always use a map.
+func SetIndexMode(mode string) error {
+	switch mode {
+	case "":
+		indexMode = IndexModeDefault
+		return nil
+	case IndexModeDefault, IndexModeCompact:
+		indexMode = mode
+		return nil
+	default:
+		return errors.Fatalf("invalid index mode %q, valid modes are %q and %q", mode, IndexModeDefault, IndexModeCompact)
+	}
+}
+
+// indexStore holds the entries of an index. Access is synchronized by the
+// index.
+type indexStore interface {
+	add(h restic.BlobHandle, e indexEntry)
+	get(h restic.BlobHandle) []indexEntry
+	// each calls fn for all entries until fn returns false.
+	each(fn func(h restic.BlobHandle, e indexEntry) bool)
+	// len returns the number of blobs in the index.
+	len() int
+}
+
+// newIndexStore returns the store for an index with n blobs loaded from the
+// repository, depending on the index mode.
+func newIndexStore(n int) indexStore {
+	if indexMode == IndexModeCompact {
+		return &compactStore{entries: make([]compactEntry, 0, n)}
+	}
+	return make(mapStore, n)
+}
+
+// mapStore keeps the entries in a map.
+type mapStore map[restic.BlobHandle][]indexEntry
+
+func (s mapStore) add(h restic.BlobHandle, e indexEntry) {
+	s[h] = append(s[h], e)
+}
+
+func (s mapStore) get(h restic.BlobHandle) []indexEntry {
+	return s[h]
+}
+
+func (s mapStore) each(fn func(h restic.BlobHandle, e indexEntry) bool) {
+	for h, list := range s {
+		for _, e := range list {
+			if !fn(h, e) {
+				return
+			}
+		}
+	}
+}
+
+func (s mapStore) len() int {
+	return len(s)
+}
+
+// compactEntry is an entry of the compact store. The pack ID is stored as an
+// index into the list of packs.
+type compactEntry struct {
+	id                 restic.ID
+	pack               uint32
+	offset             uint32
+	length             uint32
+	uncompressedLength uint32
+	tpe                restic.BlobType
+}
+
+func (e compactEntry) less(other compactEntry) bool {
+	if c := bytes.Compare(e.id[:], other.id[:]); c != 0 {
+		return c < 0
+	}
+	return e.tpe < other.tpe
+}
+
+// compactStore keeps the entries in a list sorted by blob ID, which is sorted
+// lazily on the first lookup. Entries which do not fit into a compactEntry
+// are kept in a map.
+type compactStore struct {
+	packs    restic.IDs
+	entries  []compactEntry
+	sorted   bool
+	overflow mapStore
+}
+
+func (s *compactStore) add(h restic.BlobHandle, e indexEntry) {
+	if e.offset > math.MaxUint32 || e.length > math.MaxUint32 || e.uncompressedLength > math.MaxUint32 ||
+		uint64(len(s.packs)) >= math.MaxUint32 {
+		if s.overflow == nil {
+			s.overflow = make(mapStore)
+		}
+		s.overflow.add(h, e)
+		return
+	}
+
+	// the blobs of a pack are added one after the other
+	if len(s.packs) == 0 || s.packs[len(s.packs)-1] != e.packID {
+		s.packs = append(s.packs, e.packID)
+	}
+
+	s.entries = append(s.entries, compactEntry{
+		id:                 h.ID,
+		pack:               uint32(len(s.packs) - 1),
+		offset:             uint32(e.offset),
+		length:             uint32(e.length),
+		uncompressedLength: uint32(e.uncompressedLength),
+		tpe:                h.Type,
+	})
+	s.sorted = false
+}
+
+func (s *compactStore) sort() {
+	if s.sorted {
+		return
+	}
+
+	sort.Slice(s.entries, func(i, j int) bool {
+		return s.entries[i].less(s.entries[j])
+	})
+	s.sorted = true
+}
+
+func (s *compactStore) entry(e compactEntry) indexEntry {
+	return indexEntry{
+		packID:             s.packs[e.pack],
+		offset:             uint(e.offset),
+		length:             uint(e.length),
+		uncompressedLength: uint(e.uncompressedLength),
+	}
+}
+
+func (s *compactStore) get(h restic.BlobHandle) (list []indexEntry) {
+	s.sort()
+
+	key := compactEntry{id: h.ID, tpe: h.Type}
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].less(key)
+	})
+
+	for ; i < len(s.entries) && s.entries[i].id == h.ID && s.entries[i].tpe == h.Type; i++ {
+		list = append(list, s.entry(s.entries[i]))
+	}
+
+	if s.overflow != nil {
+		list = append(list, s.overflow.get(h)...)
+	}
+
+	return list
+}
+
+func (s *compactStore) each(fn func(h restic.BlobHandle, e indexEntry) bool) {
+	for _, e := range s.entries {
+		if !fn(restic.BlobHandle{ID: e.id, Type: e.tpe}, s.entry(e)) {
+			return
+		}
+	}
+
+	if s.overflow != nil {
+		s.overflow.each(fn)
+	}
+}
+
+func (s *compactStore) len() int {
+	return len(s.entries) + len(s.overflow)
+}
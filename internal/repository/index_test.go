@@ -2,7 +2,9 @@ package repository_test
 
 import (
 	"bytes"
+	"context"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -379,3 +381,115 @@ func TestIndexPacks(t *testing.T) {
 	idxPacks := idx.Packs()
 	rtest.Assert(t, packs.Equals(idxPacks), "packs in index do not match packs added to index")
 }
+
+func TestIndexCompact(t *testing.T) {
+	idx := repository.NewIndex()
+
+	var blobs []restic.PackedBlob
+	for i := 0; i < 50; i++ {
+		packID := restic.NewRandomID()
+		for j := 0; j < 20; j++ {
+			pb := restic.PackedBlob{
+				Blob: restic.Blob{
+					Type:   restic.BlobType(1 + j%2),
+					ID:     restic.NewRandomID(),
+					Offset: uint(j * 100),
+					Length: 100,
+				},
+				PackID: packID,
+			}
+
+			// some blobs are compressed
+			if j%3 == 0 {
+				pb.UncompressedLength = 300
+			}
+
+			// the same blob is also saved in a second pack
+			if j == 0 && i > 0 {
+				pb.ID = blobs[0].ID
+				pb.Type = blobs[0].Type
+			}
+
+			idx.Store(pb)
+			blobs = append(blobs, pb)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Finalize(buf))
+
+	rtest.OK(t, repository.SetIndexMode(repository.IndexModeCompact))
+	defer func() {
+		rtest.OK(t, repository.SetIndexMode(repository.IndexModeDefault))
+	}()
+
+	compact, err := repository.DecodeIndex(buf.Bytes())
+	rtest.OK(t, err)
+
+	for _, tpe := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+		rtest.Equals(t, idx.Count(tpe), compact.Count(tpe))
+	}
+	rtest.Assert(t, idx.Packs().Equals(compact.Packs()), "packs in compact index do not match")
+
+	for _, pb := range blobs {
+		list, err := compact.Lookup(pb.ID, pb.Type)
+		rtest.OK(t, err)
+
+		found := false
+		for _, b := range list {
+			if b == pb {
+				found = true
+			}
+		}
+		rtest.Assert(t, found, "blob %v not found in compact index, got %v", pb, list)
+	}
+
+	list, err := compact.Lookup(blobs[0].ID, blobs[0].Type)
+	rtest.OK(t, err)
+	rtest.Equals(t, 50, len(list))
+
+	rtest.Assert(t, !compact.Has(restic.NewRandomID(), restic.DataBlob), "random blob found in compact index")
+	rtest.Equals(t, 20, len(compact.ListPack(blobs[20].PackID)))
+
+	n := 0
+	for range compact.Each(context.TODO()) {
+		n++
+	}
+	rtest.Equals(t, len(blobs), n)
+
+	rtest.Assert(t, repository.SetIndexMode("foo") != nil, "invalid index mode accepted")
+}
+
+func TestIndexObsolete(t *testing.T) {
+	idx := repository.NewIndex()
+	idx.Store(restic.PackedBlob{
+		Blob:   restic.Blob{Type: restic.DataBlob, ID: restic.NewRandomID(), Length: 23},
+		PackID: restic.NewRandomID(),
+	})
+
+	obsolete := []repository.ObsoletePack{
+		{
+			ID:   restic.NewRandomID(),
+			Time: time.Date(2018, 5, 6, 7, 8, 9, 0, time.UTC),
+			Blobs: []restic.Blob{
+				{Type: restic.TreeBlob, ID: restic.NewRandomID(), Offset: 0, Length: 42},
+				{Type: restic.DataBlob, ID: restic.NewRandomID(), Offset: 42, Length: 100},
+			},
+		},
+	}
+	rtest.OK(t, idx.SetObsolete(obsolete))
+
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Finalize(buf))
+	rtest.Assert(t, idx.SetObsolete(nil) != nil, "SetObsolete succeeded for a finalized index")
+
+	idx2, err := repository.DecodeIndex(buf.Bytes())
+	rtest.OK(t, err)
+
+	rtest.Equals(t, obsolete, idx2.Obsolete())
+
+	// blobs in obsolete packs are not contained in the index
+	for _, blob := range obsolete[0].Blobs {
+		rtest.Assert(t, !idx2.Has(blob.ID, blob.Type), "blob %v of obsolete pack found in index", blob.ID.Str())
+	}
+}
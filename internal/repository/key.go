@@ -31,6 +31,15 @@ type Key struct {
 	Username string    `json:"username"`
 	Hostname string    `json:"hostname"`
 
+	// Label is a human-readable description of the key, e.g. the name of
+	// the person or host using it.
+	Label string `json:"label,omitempty"`
+
+	// Role restricts what can be done with the key, see KeyRoleAppendOnly,
+	// KeyRoleReadOnly and KeyRoleWriteOnly. Keys without a role have full
+	// access.
+	Role string `json:"role,omitempty"`
+
 	KDF  string `json:"kdf"`
 	N    int    `json:"N"`
 	R    int    `json:"r"`
@@ -38,12 +47,72 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
+	// KMS is the URI of the key used to wrap the user key, Wrapped contains
+	// the wrapped user key. They are only set for keys with the KDF "kms".
+	KMS     string `json:"kms,omitempty"`
+	Wrapped string `json:"wrapped,omitempty"`
+
 	user   *crypto.Key
 	master *crypto.Key
 
+	// public and config are set instead of master for write-only keys
+	public *[crypto.PublicKeySize]byte
+	config *restic.Config
+
 	name string
 }
 
+// KeyRoleAppendOnly is the role of keys which can only be used to add data to
+// the repository. When the repository is opened with such a key, no files
+// except for locks can be removed or overwritten.
+const KeyRoleAppendOnly = "append-only"
+
+// KeyRoleReadOnly is the role of keys which can only be used to read data
+// from the repository. When the repository is opened with such a key, no
+// files except for locks can be saved or removed.
+const KeyRoleReadOnly = "read-only"
+
+// KeyRoleWriteOnly is the role of keys which do not contain the master key,
+// but only the public key derived from it and the repository config. Data
+// saved with such a key is encrypted with a new session key, which is stored
+// in the repository sealed with the public key. The data can be read only
+// with the master key, so a write-only key cannot be used to read any data
+// from the repository, not even the data saved with it. Write-only keys are
+// append-only as well.
+const KeyRoleWriteOnly = "write-only"
+
+// KeyMeta contains the role and the label of a new key.
+type KeyMeta struct {
+	Role  string
+	Label string
+}
+
+// KeyWrapper wraps and unwraps the user key with a key which is stored in an
+// external key management service, see kms.Wrapper.
+type KeyWrapper interface {
+	Name() string
+	Wrap(ctx context.Context, plaintext []byte) (string, error)
+	Unwrap(ctx context.Context, ciphertext string) ([]byte, error)
+}
+
+// kdfKMS is used as the KDF of keys whose user key is not derived from a
+// password, but random and wrapped with a KeyWrapper.
+const kdfKMS = "kms"
+
+// masterKey is stored encrypted in the Data field of all keys except for
+// write-only keys. The role is stored with the master key, so that it is
+// authenticated and cannot be changed by modifying the key file.
+type masterKey struct {
+	*crypto.Key
+	Role string `json:"role,omitempty"`
+}
+
+// writeOnlyKey is stored encrypted in the Data field of write-only keys.
+type writeOnlyKey struct {
+	Public []byte        `json:"public"`
+	Config restic.Config `json:"config"`
+}
+
 // KDFParams tracks the parameters used for the KDF. If not set, it will be
 // calibrated on the first run of AddKey().
 var KDFParams *crypto.KDFParams
@@ -59,7 +128,7 @@ var (
 // createMasterKey creates a new master key in the given backend and encrypts
 // it with the password.
 func createMasterKey(s *Repository, password string) (*Key, error) {
-	return AddKey(context.TODO(), s, password, nil)
+	return AddKey(context.TODO(), s, password, nil, KeyMeta{})
 }
 
 // OpenKey tries do decrypt the key specified by name with the given password.
@@ -86,28 +155,110 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 		return nil, errors.Wrap(err, "crypto.KDF")
 	}
 
+	err = k.openMaster(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// OpenWrappedKey tries to decrypt the key specified by name by unwrapping its
+// user key with w.
+func OpenWrappedKey(ctx context.Context, s *Repository, name string, w KeyWrapper) (*Key, error) {
+	k, err := LoadKey(ctx, s, name)
+	if err != nil {
+		debug.Log("LoadKey(%v) returned error %v", name, err)
+		return nil, err
+	}
+
+	if k.KDF != kdfKMS || k.KMS != w.Name() {
+		return nil, errors.Errorf("key is not wrapped with %v", w.Name())
+	}
+
+	buf, err := w.Unwrap(ctx, k.Wrapped)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unwrap")
+	}
+
+	k.user = &crypto.Key{}
+	err = json.Unmarshal(buf, k.user)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	err = k.openMaster(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// openMaster decrypts the master key (or the public key for write-only keys)
+// with the user key.
+func (k *Key) openMaster(name string) error {
 	// decrypt master keys
 	buf := make([]byte, len(k.Data))
 	n, err := k.user.Decrypt(buf, k.Data)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	buf = buf[:n]
 
 	// restore json
-	k.master = &crypto.Key{}
-	err = json.Unmarshal(buf, k.master)
+	if k.WriteOnly() {
+		err = k.restoreWriteOnly(buf)
+	} else {
+		err = k.restoreMaster(buf)
+	}
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
-		return nil, errors.Wrap(err, "Unmarshal")
+		return errors.Wrap(err, "Unmarshal")
 	}
 	k.name = name
 
 	if !k.Valid() {
-		return nil, errors.New("Invalid key for repository")
+		return errors.New("Invalid key for repository")
 	}
 
-	return k, nil
+	return nil
+}
+
+// restoreMaster restores the master key and checks that the role of the key
+// matches the authenticated role stored with it, so that a role cannot be
+// removed or changed by modifying the key file. Keys without a role have full
+// access to the repository.
+func (k *Key) restoreMaster(buf []byte) error {
+	mk := masterKey{Key: &crypto.Key{}}
+	err := json.Unmarshal(buf, &mk)
+	if err != nil {
+		return err
+	}
+
+	if mk.Role != k.Role {
+		return errors.Errorf("role %q of the key does not match the role %q stored with the master key, the key file has been modified", k.Role, mk.Role)
+	}
+
+	k.master = mk.Key
+	return nil
+}
+
+func (k *Key) restoreWriteOnly(buf []byte) error {
+	var wk writeOnlyKey
+	err := json.Unmarshal(buf, &wk)
+	if err != nil {
+		return err
+	}
+
+	if len(wk.Public) != crypto.PublicKeySize {
+		return errors.Errorf("invalid public key size %d", len(wk.Public))
+	}
+
+	k.public = &[crypto.PublicKeySize]byte{}
+	copy(k.public[:], wk.Public)
+	k.config = &wk.Config
+	return nil
 }
 
 // SearchKey tries to decrypt at most maxKeys keys in the backend with the
@@ -146,6 +297,30 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int)
 	return nil, ErrNoKeyFound
 }
 
+// SearchWrappedKey tries to open at most maxKeys keys in the backend which
+// are wrapped with w. If none could be found, ErrNoKeyFound is returned.
+func SearchWrappedKey(ctx context.Context, s *Repository, w KeyWrapper, maxKeys int) (*Key, error) {
+	checked := 0
+
+	for name := range s.Backend().List(ctx, restic.KeyFile) {
+		if maxKeys > 0 && checked > maxKeys {
+			return nil, ErrMaxKeysReached
+		}
+
+		debug.Log("trying key %q", name)
+		key, err := OpenWrappedKey(ctx, s, name, w)
+		if err != nil {
+			debug.Log("key %v returned error %v", name, err)
+			continue
+		}
+
+		debug.Log("successfully opened key %v", name)
+		return key, nil
+	}
+
+	return nil, ErrNoKeyFound
+}
+
 // LoadKey loads a key from the backend.
 func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error) {
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
@@ -163,8 +338,16 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 	return k, nil
 }
 
-// AddKey adds a new key to an already existing repository.
-func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key) (*Key, error) {
+// AddKey adds a new key with the given role and label to an already existing
+// repository. The role is either empty (full access), KeyRoleAppendOnly,
+// KeyRoleReadOnly or KeyRoleWriteOnly. Write-only keys require the master key
+// as the template.
+func AddKey(ctx context.Context, s *Repository, password string, template *crypto.Key, meta KeyMeta) (*Key, error) {
+	newkey, err := newKey(template, meta)
+	if err != nil {
+		return nil, err
+	}
+
 	// make sure we have valid KDF parameters
 	if KDFParams == nil {
 		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
@@ -176,13 +359,70 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 		debug.Log("calibrated KDF parameters are %v", p)
 	}
 
+	newkey.KDF = "scrypt"
+	newkey.N = KDFParams.N
+	newkey.R = KDFParams.R
+	newkey.P = KDFParams.P
+
+	// generate random salt
+	newkey.Salt, err = crypto.NewSalt()
+	if err != nil {
+		panic("unable to read enough random bytes for salt: " + err.Error())
+	}
+
+	// call KDF to derive user key
+	newkey.user, err = crypto.KDF(*KDFParams, newkey.Salt, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return saveKey(ctx, s, newkey, template)
+}
+
+// AddWrappedKey adds a new key with the given role and label to an already
+// existing repository. Instead of deriving the user key from a password, a
+// random user key is generated and stored wrapped with w, so the key can only
+// be opened as long as w is accessible.
+func AddWrappedKey(ctx context.Context, s *Repository, w KeyWrapper, template *crypto.Key, meta KeyMeta) (*Key, error) {
+	newkey, err := newKey(template, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	newkey.KDF = kdfKMS
+	newkey.KMS = w.Name()
+	newkey.user = crypto.NewRandomKey()
+
+	buf, err := json.Marshal(newkey.user)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	newkey.Wrapped, err = w.Wrap(ctx, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "Wrap")
+	}
+
+	return saveKey(ctx, s, newkey, template)
+}
+
+// newKey checks the role and returns a new key with the meta data filled in.
+func newKey(template *crypto.Key, meta KeyMeta) (*Key, error) {
+	switch meta.Role {
+	case "", KeyRoleAppendOnly, KeyRoleReadOnly:
+	case KeyRoleWriteOnly:
+		if template == nil {
+			return nil, errors.New("write-only key requires the master key")
+		}
+	default:
+		return nil, errors.Errorf("invalid key role %q", meta.Role)
+	}
+
 	// fill meta data about key
 	newkey := &Key{
 		Created: time.Now(),
-		KDF:     "scrypt",
-		N:       KDFParams.N,
-		R:       KDFParams.R,
-		P:       KDFParams.P,
+		Label:   meta.Label,
+		Role:    meta.Role,
 	}
 
 	hn, err := os.Hostname()
@@ -195,18 +435,12 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 		newkey.Username = usr.Username
 	}
 
-	// generate random salt
-	newkey.Salt, err = crypto.NewSalt()
-	if err != nil {
-		panic("unable to read enough random bytes for salt: " + err.Error())
-	}
-
-	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*KDFParams, newkey.Salt, password)
-	if err != nil {
-		return nil, err
-	}
+	return newkey, nil
+}
 
+// saveKey encrypts the master key with the user key of newkey and saves the
+// key in the repository.
+func saveKey(ctx context.Context, s *Repository, newkey *Key, template *crypto.Key) (*Key, error) {
 	if template == nil {
 		// generate new random master keys
 		newkey.master = crypto.NewRandomKey()
@@ -215,13 +449,27 @@ func AddKey(ctx context.Context, s *Repository, password string, template *crypt
 		newkey.master = template
 	}
 
-	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
+	// encrypt master keys (as json) with user key, write-only keys only
+	// contain the public key and the config
+	var buf []byte
+	var err error
+	if newkey.WriteOnly() {
+		cfg := s.Config()
+		newkey.public = template.PublicKey()
+		newkey.config = &cfg
+		newkey.master = nil
+		buf, err = json.Marshal(writeOnlyKey{Public: newkey.public[:], Config: cfg})
+	} else {
+		buf, err = json.Marshal(masterKey{Key: newkey.master, Role: newkey.Role})
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "Marshal")
 	}
 
 	newkey.Data, err = newkey.user.Encrypt(nil, buf)
+	if err != nil {
+		return nil, err
+	}
 
 	// dump as json
 	buf, err = json.Marshal(newkey)
@@ -257,7 +505,27 @@ func (k Key) Name() string {
 	return k.name
 }
 
+// AppendOnly returns true if the key only allows adding data to the repository.
+func (k *Key) AppendOnly() bool {
+	return k.Role == KeyRoleAppendOnly || k.Role == KeyRoleWriteOnly
+}
+
+// ReadOnly returns true if the key only allows reading data from the
+// repository.
+func (k *Key) ReadOnly() bool {
+	return k.Role == KeyRoleReadOnly
+}
+
+// WriteOnly returns true if the key does not contain the master key and can
+// therefore not be used to read data from the repository.
+func (k *Key) WriteOnly() bool {
+	return k.Role == KeyRoleWriteOnly
+}
+
 // Valid tests whether the mac and encryption keys are valid (i.e. not zero)
 func (k *Key) Valid() bool {
+	if k.WriteOnly() {
+		return k.user.Valid() && k.public != nil && k.config != nil
+	}
 	return k.user.Valid() && k.master.Valid()
 }
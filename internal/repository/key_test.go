@@ -0,0 +1,105 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testWrapper "wraps" keys by encoding them, access can be revoked.
+type testWrapper struct {
+	name    string
+	revoked bool
+}
+
+func (w *testWrapper) Name() string {
+	return w.name
+}
+
+func (w *testWrapper) Wrap(ctx context.Context, plaintext []byte) (string, error) {
+	return base64.StdEncoding.EncodeToString(plaintext), nil
+}
+
+func (w *testWrapper) Unwrap(ctx context.Context, ciphertext string) ([]byte, error) {
+	if w.revoked {
+		return nil, errors.New("access denied")
+	}
+	return base64.StdEncoding.DecodeString(ciphertext)
+}
+
+func TestWrappedKey(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	ctx := context.TODO()
+	w := &testWrapper{name: "test:key"}
+	_, err := repository.AddWrappedKey(ctx, repo, w, repo.Key(), repository.KeyMeta{})
+	rtest.OK(t, err)
+
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchWrappedKey(ctx, w, 10))
+	rtest.Equals(t, repo.Config(), repo2.Config())
+
+	// keys wrapped with other KMS keys are not used
+	repo2 = repository.New(repo.Backend())
+	err = repo2.SearchWrappedKey(ctx, &testWrapper{name: "test:other"}, 10)
+	rtest.Assert(t, err == repository.ErrNoKeyFound, "unexpected error %v", err)
+
+	// access has been revoked
+	w.revoked = true
+	repo2 = repository.New(repo.Backend())
+	err = repo2.SearchWrappedKey(ctx, w, 10)
+	rtest.Assert(t, err == repository.ErrNoKeyFound, "unexpected error %v", err)
+
+	// the password still works
+	repo2 = repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 10))
+}
+
+func TestKeyRoleModified(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	ctx := context.TODO()
+	meta := repository.KeyMeta{Role: repository.KeyRoleAppendOnly}
+	k, err := repository.AddKey(ctx, repo, "append", repo.Key(), meta)
+	rtest.OK(t, err)
+
+	k, err = repository.OpenKey(ctx, repo, k.Name(), "append")
+	rtest.OK(t, err)
+	rtest.Assert(t, k.AppendOnly(), "key is not append-only")
+
+	// remove the role from the key file
+	k.Role = ""
+	buf, err := json.Marshal(k)
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.KeyFile, Name: restic.Hash(buf).String()}
+	rtest.OK(t, repo.Backend().Save(ctx, h, bytes.NewReader(buf)))
+
+	_, err = repository.OpenKey(ctx, repo, h.Name, "append")
+	rtest.Assert(t, err != nil, "modified key has been opened")
+
+	// adding a role to a key without one is refused as well
+	k, err = repository.OpenKey(ctx, repo, repo.KeyName(), rtest.TestPassword)
+	rtest.OK(t, err)
+
+	k.Role = repository.KeyRoleReadOnly
+	buf, err = json.Marshal(k)
+	rtest.OK(t, err)
+
+	h = restic.Handle{Type: restic.KeyFile, Name: restic.Hash(buf).String()}
+	rtest.OK(t, repo.Backend().Save(ctx, h, bytes.NewReader(buf)))
+
+	_, err = repository.OpenKey(ctx, repo, h.Name, rtest.TestPassword)
+	rtest.Assert(t, err != nil, "modified key has been opened")
+}
@@ -14,6 +14,11 @@ import (
 type MasterIndex struct {
 	idx      []*Index
 	idxMutex sync.RWMutex
+
+	// bloom contains all blobs in the indexes, so that a lookup for a blob
+	// which is not contained in any index can return early. It is only
+	// replaced while idxMutex is locked for writing.
+	bloom *bloomFilter
 }
 
 // NewMasterIndex creates a new master index.
@@ -28,6 +33,11 @@ func (mi *MasterIndex) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic
 
 	debug.Log("looking up id %v, tpe %v", id.Str(), tpe)
 
+	if !mi.mayContain(id, tpe) {
+		debug.Log("id %v not found in bloom filter", id.Str())
+		return nil, errors.Errorf("id %v not found in any index", id)
+	}
+
 	for _, idx := range mi.idx {
 		blobs, err = idx.Lookup(id, tpe)
 		if err == nil {
@@ -45,6 +55,10 @@ func (mi *MasterIndex) LookupSize(id restic.ID, tpe restic.BlobType) (uint, erro
 	mi.idxMutex.RLock()
 	defer mi.idxMutex.RUnlock()
 
+	if !mi.mayContain(id, tpe) {
+		return 0, errors.Errorf("id %v not found in any index", id)
+	}
+
 	for _, idx := range mi.idx {
 		if idx.Has(id, tpe) {
 			return idx.LookupSize(id, tpe)
@@ -70,12 +84,22 @@ func (mi *MasterIndex) ListPack(id restic.ID) (list []restic.PackedBlob) {
 	return nil
 }
 
-// Has queries all known Indexes for the ID and returns the first match.
+// Has queries all known Indexes for the ID and returns the first match. Blobs
+// which are only contained in obsolete packs are not reported, so that new
+// data is not deduplicated against packs which prune is about to delete.
 func (mi *MasterIndex) Has(id restic.ID, tpe restic.BlobType) bool {
 	mi.idxMutex.RLock()
 	defer mi.idxMutex.RUnlock()
 
+	if !mi.mayContain(id, tpe) {
+		return false
+	}
+
 	for _, idx := range mi.idx {
+		if idx.fallback {
+			continue
+		}
+
 		if idx.Has(id, tpe) {
 			return true
 		}
@@ -97,12 +121,55 @@ func (mi *MasterIndex) Count(t restic.BlobType) (n uint) {
 	return sum
 }
 
-// Insert adds a new index to the MasterIndex.
+// mayContain returns false if the blob is definitely not contained in any
+// index. The caller must hold idxMutex.
+func (mi *MasterIndex) mayContain(id restic.ID, tpe restic.BlobType) bool {
+	if mi.bloom == nil {
+		return true
+	}
+	return mi.bloom.mayContain(restic.BlobHandle{ID: id, Type: tpe})
+}
+
+// updateBloom builds a new bloom filter when the current one is full or does
+// not exist yet. The caller must hold idxMutex for writing.
+func (mi *MasterIndex) updateBloom() {
+	if mi.bloom != nil && !mi.bloom.full() {
+		return
+	}
+
+	var capacity uint64
+	if mi.bloom != nil {
+		capacity = 2 * mi.bloom.count
+	}
+
+	for {
+		f := newBloomFilter(capacity)
+		for _, idx := range mi.idx {
+			idx.addToBloom(f)
+		}
+
+		if !f.full() {
+			debug.Log("new bloom filter for %d blobs, capacity %d", f.count, f.capacity)
+			mi.bloom = f
+			return
+		}
+
+		capacity = 2 * f.count
+	}
+}
+
+// Insert adds a new index to the MasterIndex. Blobs must not be added to the
+// index directly afterwards, but only with Store.
 func (mi *MasterIndex) Insert(idx *Index) {
 	mi.idxMutex.Lock()
 	defer mi.idxMutex.Unlock()
 
 	mi.idx = append(mi.idx, idx)
+
+	if mi.bloom != nil {
+		idx.addToBloom(mi.bloom)
+	}
+	mi.updateBloom()
 }
 
 // Remove deletes an index from the MasterIndex.
@@ -120,11 +187,18 @@ func (mi *MasterIndex) Remove(index *Index) {
 
 // Store remembers the id and pack in the index.
 func (mi *MasterIndex) Store(pb restic.PackedBlob) {
+	h := restic.BlobHandle{ID: pb.ID, Type: pb.Type}
+
+	// the bloom filter must not be replaced before the blob has been added
+	// to the index, so the read lock is held until then
 	mi.idxMutex.RLock()
 	for _, idx := range mi.idx {
 		if !idx.Final() {
-			mi.idxMutex.RUnlock()
+			if mi.bloom != nil {
+				mi.bloom.add(h)
+			}
 			idx.Store(pb)
+			mi.idxMutex.RUnlock()
 			return
 		}
 	}
@@ -136,6 +210,11 @@ func (mi *MasterIndex) Store(pb restic.PackedBlob) {
 	newIdx := NewIndex()
 	newIdx.Store(pb)
 	mi.idx = append(mi.idx, newIdx)
+
+	if mi.bloom != nil {
+		mi.bloom.add(h)
+	}
+	mi.updateBloom()
 }
 
 // NotFinalIndexes returns all indexes that have not yet been saved.
@@ -233,6 +312,11 @@ func (mi *MasterIndex) RebuildIndex(packBlacklist restic.IDSet) (*Index, error)
 	defer cancel()
 
 	for i, idx := range mi.idx {
+		if idx.fallback {
+			debug.Log("skipping index %d of obsolete packs", i)
+			continue
+		}
+
 		debug.Log("adding index %d", i)
 
 		for pb := range idx.Each(ctx) {
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// SetObjectLock configures the retention of files for a new repository. Init
+// enables it in the backend and records it in the config, lock must be set
+// before Init is called.
+func (r *Repository) SetObjectLock(lock restic.ObjectLock) error {
+	switch lock.Mode {
+	case restic.ObjectLockGovernance, restic.ObjectLockCompliance:
+	default:
+		return errors.Fatalf("invalid object lock mode %q, must be %v or %v",
+			lock.Mode, restic.ObjectLockGovernance, restic.ObjectLockCompliance)
+	}
+
+	if lock.Days == 0 {
+		return errors.Fatal("the retention period of the object lock must be at least one day")
+	}
+
+	r.objectLock = &lock
+	return nil
+}
+
+// enableObjectLock configures the backend to keep all files for the
+// retention period.
+func (r *Repository) enableObjectLock(ctx context.Context) error {
+	debug.Log("enable object lock %v for %d days", r.objectLock.Mode, r.objectLock.Days)
+	return restic.EnableObjectLock(ctx, r.be, *r.objectLock)
+}
+
+// useObjectLock passes the object lock from the config to the backend, it
+// must be called before any file is saved.
+func (r *Repository) useObjectLock() {
+	if r.cfg.ObjectLock != nil {
+		restic.UseObjectLock(r.be, *r.cfg.ObjectLock)
+	}
+}
+
+// extendRetentionParallelism is the number of pack files whose retention is
+// extended in parallel.
+const extendRetentionParallelism = 20
+
+// retentionMargin returns the time the retention of a pack file is extended
+// beyond the retention period, so that later snapshots referencing the same
+// pack file within this time do not need to extend it again. It is a tenth of
+// the retention period, but at least one day.
+func retentionMargin(lock restic.ObjectLock) time.Duration {
+	margin := time.Duration(lock.Days) * 24 * time.Hour / 10
+	if margin < 24*time.Hour {
+		margin = 24 * time.Hour
+	}
+	return margin
+}
+
+// ExtendObjectLock makes the storage keep all pack files which contain blobs
+// of the tree for at least the retention period, counted from now. Otherwise,
+// pack files saved earlier could be removed from the storage while a new
+// snapshot still references them. Only pack files which would be removed
+// earlier are extended, until the end of the retention period plus a margin,
+// see retentionMargin. Repositories opened with a write-only key only
+// reference pack files saved in the current run, nothing is done for them.
+func (r *Repository) ExtendObjectLock(ctx context.Context, tree restic.ID) error {
+	if r.cfg.ObjectLock == nil || r.WriteOnly() {
+		return nil
+	}
+
+	blobs := restic.NewBlobSet()
+	err := restic.FindUsedBlobs(ctx, r, tree, blobs, restic.NewBlobSet())
+	if err != nil {
+		return err
+	}
+
+	packs := restic.NewIDSet()
+	for h := range blobs {
+		pbs, err := r.idx.Lookup(h.ID, h.Type)
+		if err != nil {
+			return err
+		}
+		for _, pb := range pbs {
+			packs.Insert(pb.PackID)
+		}
+	}
+
+	deadline := time.Now().AddDate(0, 0, int(r.cfg.ObjectLock.Days))
+	until := deadline.Add(retentionMargin(*r.cfg.ObjectLock))
+	debug.Log("extend retention of %d packs retained before %v until %v", len(packs), deadline, until)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan restic.ID)
+	go func() {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, extendRetentionParallelism)
+	for i := 0; i < extendRetentionParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ch {
+				h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+				retained, err := restic.RetainUntil(ctx, r.be, h)
+				if err == nil && retained.Before(deadline) {
+					err = restic.ExtendRetention(ctx, r.be, h, until)
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Retained returns true if the storage still keeps the file h because of the
+// object lock. The end of the retention is asked from the storage, so that it
+// does not depend on times recorded by clients, e.g. the time of a snapshot.
+func (r *Repository) Retained(ctx context.Context, h restic.Handle) (bool, error) {
+	if r.cfg.ObjectLock == nil {
+		return false, nil
+	}
+
+	until, err := restic.RetainUntil(ctx, r.be, h)
+	if err != nil {
+		return false, err
+	}
+
+	return time.Now().Before(until), nil
+}
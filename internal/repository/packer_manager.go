@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"crypto/sha256"
 	"io"
 	"os"
 	"sync"
@@ -15,6 +14,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/tracing"
 )
 
 // Saver implements saving data in a backend.
@@ -33,11 +33,17 @@ type Packer struct {
 type packerManager struct {
 	be      Saver
 	key     *crypto.Key
+	hash    restic.HashAlgorithm
 	pm      sync.Mutex
 	packers []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
+// minPackSize is the default and the minimal target size of pack files,
+// maxPackSize is the largest target size which can be configured.
+const (
+	minPackSize = 4 * 1024 * 1024
+	maxPackSize = 128 * 1024 * 1024
+)
 
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
@@ -68,7 +74,7 @@ func (r *packerManager) findPacker() (packer *Packer, err error) {
 		return nil, errors.Wrap(err, "fs.TempFile")
 	}
 
-	hw := hashing.NewWriter(tmpfile, sha256.New())
+	hw := hashing.NewWriter(tmpfile, r.hash.New())
 	p := pack.NewPacker(r.key, hw)
 	packer = &Packer{
 		Packer:  p,
@@ -88,14 +94,35 @@ func (r *packerManager) insertPacker(p *Packer) {
 	debug.Log("%d packers\n", len(r.packers))
 }
 
-// savePacker stores p in the backend.
+// savePacker finalizes p and queues it for the pack uploaders, it blocks while
+// the queue is full.
 func (r *Repository) savePacker(t restic.BlobType, p *Packer) error {
 	debug.Log("save packer for %v with %d blobs (%d bytes)\n", t, p.Packer.Count(), p.Packer.Size())
-	_, err := p.Packer.Finalize()
+	size, err := p.Packer.Finalize()
+	if err != nil {
+		return err
+	}
+
+	err = r.reserveSize(context.TODO(), uint64(size))
 	if err != nil {
 		return err
 	}
 
+	return r.packerUploader().Upload(t, p)
+}
+
+// uploadPacker stores the finalized pack p in the backend and adds its blobs
+// to the index. It is called by the pack uploaders.
+func (r *Repository) uploadPacker(t restic.BlobType, p *Packer) (err error) {
+	// the backend may store pack files with tree blobs differently
+	ctx := restic.ContextWithBlobType(context.TODO(), t)
+	ctx, span := tracing.Start(ctx, "repository.uploadPack",
+		tracing.String("restic.blob.type", t.String()),
+		tracing.Int("restic.blobs", p.Count()))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	_, err = p.tmpfile.Seek(0, 0)
 	if err != nil {
 		return errors.Wrap(err, "Seek")
@@ -104,7 +131,7 @@ func (r *Repository) savePacker(t restic.BlobType, p *Packer) error {
 	id := restic.IDFromHash(p.hw.Sum(nil))
 	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
 
-	err = r.be.Save(context.TODO(), h, p.tmpfile)
+	err = r.be.Save(ctx, h, p.tmpfile)
 	if err != nil {
 		debug.Log("Save(%v) error: %v", h, err)
 		return err
@@ -141,10 +168,11 @@ func (r *Repository) savePacker(t restic.BlobType, p *Packer) error {
 		debug.Log("  updating blob %v to pack %v", b.ID.Str(), id.Str())
 		r.idx.Store(restic.PackedBlob{
 			Blob: restic.Blob{
-				Type:   b.Type,
-				ID:     b.ID,
-				Offset: b.Offset,
-				Length: uint(b.Length),
+				Type:               b.Type,
+				ID:                 b.ID,
+				Offset:             b.Offset,
+				Length:             uint(b.Length),
+				UncompressedLength: b.UncompressedLength,
 			},
 			PackID: id,
 		})
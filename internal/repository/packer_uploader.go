@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// defaultPackUploaders is the number of packs uploaded in parallel unless
+// set with SetPackUploaders, it matches the default number of connections of
+// most backends.
+const defaultPackUploaders = 5
+
+// uploadTask is a finalized pack which is waiting to be uploaded.
+type uploadTask struct {
+	t restic.BlobType
+	p *Packer
+}
+
+// packerUploader uploads finalized packs with a fixed number of workers, which
+// receive the packs from a channel. The channel buffers as many packs as there
+// are workers, so that new data can be saved (and files can be read by the
+// archiver) while all workers are busy. Only when the buffer is full as well,
+// saving more data blocks until an upload has finished.
+type packerUploader struct {
+	queue chan uploadTask
+	wg    sync.WaitGroup
+
+	errMu sync.Mutex
+	err   error
+}
+
+// newPackerUploader starts n workers which call upload for each pack.
+func newPackerUploader(n uint, upload func(restic.BlobType, *Packer) error) *packerUploader {
+	u := &packerUploader{
+		queue: make(chan uploadTask, n),
+	}
+
+	debug.Log("start %d pack uploaders", n)
+	for i := uint(0); i < n; i++ {
+		u.wg.Add(1)
+		go func() {
+			defer u.wg.Done()
+			for task := range u.queue {
+				// after an error, the remaining packs are only removed
+				if u.Err() != nil {
+					_ = task.p.tmpfile.Close()
+					_ = fs.RemoveIfExists(task.p.tmpfile.Name())
+					continue
+				}
+
+				if err := upload(task.t, task.p); err != nil {
+					debug.Log("upload failed: %v", err)
+					u.setErr(err)
+				}
+			}
+		}()
+	}
+
+	return u
+}
+
+func (u *packerUploader) setErr(err error) {
+	u.errMu.Lock()
+	if u.err == nil {
+		u.err = err
+	}
+	u.errMu.Unlock()
+}
+
+// Err returns the first error returned by an upload.
+func (u *packerUploader) Err() error {
+	u.errMu.Lock()
+	defer u.errMu.Unlock()
+	return u.err
+}
+
+// Upload queues p for one of the workers, it blocks while the queue is full.
+// If a previous upload has failed, its error is returned.
+func (u *packerUploader) Upload(t restic.BlobType, p *Packer) error {
+	if err := u.Err(); err != nil {
+		return err
+	}
+
+	u.queue <- uploadTask{t: t, p: p}
+	return nil
+}
+
+// Close waits until all packs have been uploaded and stops the workers. The
+// first error returned by an upload is returned.
+func (u *packerUploader) Close() error {
+	close(u.queue)
+	u.wg.Wait()
+	return u.Err()
+}
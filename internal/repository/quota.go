@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/restic"
+)
+
+// sizeParallelism is the number of pack files which are not in the index for
+// which the size is requested from the backend in parallel.
+const sizeParallelism = 20
+
+// ErrQuotaExceeded is returned when saving a pack file would exceed the
+// maximum size of the repository. It is a fatal error, so that it is printed
+// to the user without a stack trace.
+var ErrQuotaExceeded = errors.Fatalf("repository size quota exceeded")
+
+// SetMaxSize sets the maximum size of the repository in bytes. It takes
+// precedence over the maximum size stored in the config, zero means that the
+// size from the config is used. New repositories created with Init() store
+// the size in the config.
+func (r *Repository) SetMaxSize(size uint64) {
+	r.maxSize = size
+}
+
+// MaxSize returns the maximum size of the repository in bytes, zero means
+// that the size is not limited.
+func (r *Repository) MaxSize() uint64 {
+	if r.maxSize > 0 {
+		return r.maxSize
+	}
+	return r.cfg.MaxSize
+}
+
+// Size returns the size of all pack files in the repository. It is computed
+// the first time it is needed: the sizes of the pack files in the index are
+// computed from the blobs they contain, the sizes of all other pack files in
+// the backend are requested from the backend. So it includes pack files which
+// are not contained in the index, or which cannot be read with a write-only
+// key. Pack files saved afterwards are added.
+func (r *Repository) Size(ctx context.Context) (uint64, error) {
+	r.sizeMu.Lock()
+	defer r.sizeMu.Unlock()
+
+	err := r.computeSize(ctx)
+	return r.size, err
+}
+
+// indexedPackSizes returns the sizes of the pack files in the index. Packs
+// marked for deletion are left out, the index only contains some of their
+// blobs.
+func (r *Repository) indexedPackSizes(ctx context.Context) (map[restic.ID]uint64, error) {
+	sizes := make(map[restic.ID]uint64)
+	for _, idx := range r.idx.All() {
+		if idx.fallback {
+			continue
+		}
+
+		// a pack file may be listed in several index files, but each
+		// contains all blobs of the pack
+		seen := restic.NewIDSet()
+		for pb := range idx.Each(ctx) {
+			size, ok := sizes[pb.PackID]
+			if !ok {
+				size = uint64(pack.CalculateHeaderSize(nil))
+				seen.Insert(pb.PackID)
+			} else if !seen.Has(pb.PackID) {
+				continue
+			}
+			sizes[pb.PackID] = size + uint64(pack.PackedSizeOfBlob(pb.Blob))
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return sizes, nil
+}
+
+// computeSize computes the size of the pack files in the backend, unless this
+// has already been done. sizeMu must be held.
+func (r *Repository) computeSize(ctx context.Context) error {
+	if r.sizeKnown {
+		return nil
+	}
+
+	sizes, err := r.indexedPackSizes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var size, packs, stats uint64
+	err = FilesInParallel(ctx, r.be, restic.DataFile, sizeParallelism, func(ctx context.Context, name string) error {
+		atomic.AddUint64(&packs, 1)
+
+		id, err := restic.ParseID(name)
+		if err == nil {
+			if packSize, ok := sizes[id]; ok {
+				atomic.AddUint64(&size, packSize)
+				return nil
+			}
+		}
+
+		atomic.AddUint64(&stats, 1)
+		fi, err := r.be.Stat(ctx, restic.Handle{Type: restic.DataFile, Name: name})
+		if err != nil {
+			// the pack file may have been removed by prune in the meantime
+			if r.be.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrap(err, "Stat")
+		}
+
+		atomic.AddUint64(&size, uint64(fi.Size))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	debug.Log("%d pack files use %d bytes, %d of them are not in the index", packs, size, stats)
+	r.size = size
+	r.sizeKnown = true
+	return nil
+}
+
+// reserveSize checks that a pack file of the given size can be saved without
+// exceeding the maximum size of the repository and adds it to the size.
+func (r *Repository) reserveSize(ctx context.Context, size uint64) error {
+	max := r.MaxSize()
+
+	r.sizeMu.Lock()
+	defer r.sizeMu.Unlock()
+
+	if max > 0 {
+		if err := r.computeSize(ctx); err != nil {
+			return err
+		}
+		if r.size+size > max {
+			return errors.Wrapf(ErrQuotaExceeded, "saving %d bytes would grow the repository to %d bytes, the maximum is %d bytes",
+				size, r.size+size, max)
+		}
+	}
+
+	// if the size has not been computed yet, the pack file is included later
+	if r.sizeKnown {
+		r.size += size
+	}
+	return nil
+}
+
+// releaseSize removes a pack file reserved with reserveSize which has not been
+// saved from the size.
+func (r *Repository) releaseSize(size uint64) {
+	r.sizeMu.Lock()
+	defer r.sizeMu.Unlock()
+
+	if r.sizeKnown {
+		r.size -= size
+	}
+}
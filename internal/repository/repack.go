@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -37,7 +36,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 			return nil, err
 		}
 
-		hrd := hashing.NewReader(beRd, sha256.New())
+		hrd := hashing.NewReader(beRd, repo.Config().Hash.New())
 		packLength, err := io.Copy(tempfile, hrd)
 		if err != nil {
 			return nil, errors.Wrap(err, "Copy")
@@ -95,9 +94,15 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				return nil, err
 			}
 
-			buf = buf[:n]
+			plaintext := buf[:n]
+			if entry.IsCompressed() {
+				plaintext, err = restic.Decompress(nil, plaintext, entry.UncompressedLength)
+				if err != nil {
+					return nil, errors.Errorf("decompressing blob %v failed: %v", h, err)
+				}
+			}
 
-			id := restic.Hash(buf)
+			id := repo.Config().Hash.Sum(plaintext)
 			if !id.Equal(entry.ID) {
 				debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
 					h.Type, h.ID, tempfile.Name(), id)
@@ -105,7 +110,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 					h, tempfile.Name(), id)
 			}
 
-			_, err = repo.SaveBlob(ctx, entry.Type, buf, entry.ID)
+			_, err = repo.SaveBlob(ctx, entry.Type, plaintext, entry.ID)
 			if err != nil {
 				return nil, err
 			}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
@@ -15,6 +17,7 @@ import (
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/tracing"
 )
 
 // Repository is used to access a repository in a backend.
@@ -23,11 +26,49 @@ type Repository struct {
 	cfg     restic.Config
 	key     *crypto.Key
 	keyName string
+	keyRole string
 	idx     *MasterIndex
+
+	// lockKey encrypts the lock files of repositories which are used with
+	// write-only keys, so that all clients can read them, see
+	// crypto.LockKey. sharedLocks is set if new locks are encrypted with it.
+	lockKey     *crypto.Key
+	sharedLocks bool
+
+	// sessionFiles are the names of the session files whose keys have been
+	// added to key, see FoldSessionKeys.
+	sessionFiles []string
+
 	restic.Cache
 
+	// packSize is the size a pack file needs to reach before it is saved.
+	packSize uint
+
+	// maxSize is the maximum size of the repository, see SetMaxSize.
+	maxSize uint64
+
+	// objectLock is stored in the config by Init, see SetObjectLock.
+	objectLock *restic.ObjectLock
+
+	// hash is stored in the config by Init, see SetHashAlgorithm.
+	hash restic.HashAlgorithm
+
+	// compression is the mode for compressing new data, see SetCompression.
+	compression string
+
+	sizeMu    sync.Mutex
+	size      uint64
+	sizeKnown bool
+
 	treePM *packerManager
 	dataPM *packerManager
+
+	// packUploaders is the number of packs uploaded in parallel, see
+	// SetPackUploaders. The uploaders are started when the first pack is
+	// saved and stopped by Flush.
+	packUploaders uint
+	uploaderMu    sync.Mutex
+	uploader      *packerUploader
 }
 
 // New returns a new repository with backend be.
@@ -37,11 +78,62 @@ func New(be restic.Backend) *Repository {
 		idx:    NewMasterIndex(),
 		dataPM: newPackerManager(be, nil),
 		treePM: newPackerManager(be, nil),
+
+		packSize:      minPackSize,
+		packUploaders: defaultPackUploaders,
+		compression:   restic.CompressionAuto,
 	}
 
 	return repo
 }
 
+// SetPackSize sets the target size for new pack files. It must be between 4
+// MiB and 128 MiB.
+func (r *Repository) SetPackSize(size uint) error {
+	if size < minPackSize || size > maxPackSize {
+		return errors.Fatalf("invalid pack size %d MiB, must be between %d and %d MiB",
+			size/(1024*1024), minPackSize/(1024*1024), maxPackSize/(1024*1024))
+	}
+
+	r.packSize = size
+	return nil
+}
+
+// SetPackUploaders sets the number of pack files which are uploaded in
+// parallel. It must be called before any data is saved.
+func (r *Repository) SetPackUploaders(n uint) error {
+	if n == 0 {
+		return errors.Fatal("at least one pack uploader is required")
+	}
+
+	r.packUploaders = n
+	return nil
+}
+
+// packerUploader returns the pack uploaders, they are started if necessary.
+func (r *Repository) packerUploader() *packerUploader {
+	r.uploaderMu.Lock()
+	defer r.uploaderMu.Unlock()
+
+	if r.uploader == nil {
+		r.uploader = newPackerUploader(r.packUploaders, r.uploadPacker)
+	}
+
+	return r.uploader
+}
+
+// PackSize returns the target size for new pack files.
+func (r *Repository) PackSize() uint {
+	return r.packSize
+}
+
+// SetHashAlgorithm sets the algorithm for computing IDs in a new repository
+// created with Init(). Existing repositories use the algorithm from their
+// config.
+func (r *Repository) SetHashAlgorithm(hash restic.HashAlgorithm) {
+	r.hash = hash
+}
+
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
 	return r.cfg
@@ -75,17 +167,26 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, t restic.FileType, id r
 		return nil, err
 	}
 
-	if t != restic.ConfigFile && !restic.Hash(buf).Equal(id) {
+	if t != restic.ConfigFile && !r.cfg.Hash.Sum(buf).Equal(id) {
 		return nil, errors.Errorf("load %v: invalid data returned", h)
 	}
 
 	// decrypt
-	n, err := r.decryptTo(buf, buf)
+	var n int
+	if t == restic.LockFile && r.lockKey != nil {
+		n, err = r.lockKey.Decrypt(buf, buf)
+		if err == crypto.ErrUnauthenticated {
+			// the lock was saved by a client which does not share its locks
+			n, err = r.decryptTo(buf, buf)
+		}
+	} else {
+		n, err = r.decryptTo(buf, buf)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return buf[:n], nil
+	return r.decompressUnpacked(t, buf[:n])
 }
 
 // sortCachedPacks moves all cached pack files to the front of blobs.
@@ -135,13 +236,24 @@ func (r *Repository) loadBlob(ctx context.Context, id restic.ID, t restic.BlobTy
 		// load blob from pack
 		h := restic.Handle{Type: restic.DataFile, Name: blob.PackID.String()}
 
-		if uint(cap(plaintextBuf)) < blob.Length {
-			return 0, errors.Errorf("buffer is too small: %v < %v", cap(plaintextBuf), blob.Length)
+		size := blob.Length
+		if blob.IsCompressed() {
+			size = blob.UncompressedLength
 		}
 
-		plaintextBuf = plaintextBuf[:blob.Length]
+		if uint(cap(plaintextBuf)) < size {
+			return 0, errors.Errorf("buffer is too small: %v < %v", cap(plaintextBuf), size)
+		}
+
+		// compressed blobs are decompressed from a separate buffer into
+		// plaintextBuf
+		buf := plaintextBuf
+		if blob.IsCompressed() {
+			buf = make([]byte, blob.Length)
+		}
+		buf = buf[:blob.Length]
 
-		n, err := restic.ReadAt(ctx, r.be, h, int64(blob.Offset), plaintextBuf)
+		n, err := restic.ReadAt(ctx, r.be, h, int64(blob.Offset), buf)
 		if err != nil {
 			debug.Log("error loading blob %v: %v", blob, err)
 			lastError = err
@@ -156,15 +268,24 @@ func (r *Repository) loadBlob(ctx context.Context, id restic.ID, t restic.BlobTy
 		}
 
 		// decrypt
-		n, err = r.decryptTo(plaintextBuf, plaintextBuf)
+		n, err = r.decryptTo(buf, buf)
 		if err != nil {
 			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
 			continue
 		}
-		plaintextBuf = plaintextBuf[:n]
+		buf = buf[:n]
+
+		if blob.IsCompressed() {
+			buf, err = restic.Decompress(plaintextBuf, buf, blob.UncompressedLength)
+			if err != nil {
+				lastError = errors.Errorf("decompressing blob %v failed: %v", id, err)
+				continue
+			}
+		}
+		plaintextBuf = buf
 
 		// check hash
-		if !restic.Hash(plaintextBuf).Equal(id) {
+		if !r.cfg.Hash.Sum(plaintextBuf).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
 			continue
 		}
@@ -200,18 +321,36 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, er
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id *restic.ID) (restic.ID, error) {
 	if id == nil {
 		// compute plaintext hash
-		hashedID := restic.Hash(data)
+		_, span := tracing.Start(ctx, "repository.hash")
+		hashedID := r.cfg.Hash.Sum(data)
+		span.End()
 		id = &hashedID
 	}
 
 	debug.Log("save id %v (%v, %d bytes)", id.Str(), t, len(data))
 
+	// compress blob, it is saved uncompressed if this does not make it smaller
+	uncompressedLength := 0
+	_, span := tracing.Start(ctx, "repository.compress")
+	compressed, err := restic.Compress(r.compressionMode(), data)
+	tracing.End(span, err)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	if compressed != nil {
+		debug.Log("compressed %v from %d to %d bytes", id.Str(), len(data), len(compressed))
+		uncompressedLength = len(data)
+		data = compressed
+	}
+
 	// get buf from the pool
 	ciphertext := getBuf()
 	defer freeBuf(ciphertext)
 
 	// encrypt blob
-	ciphertext, err := r.Encrypt(ciphertext, data)
+	_, span = tracing.Start(ctx, "repository.encrypt")
+	ciphertext, err = r.Encrypt(ciphertext, data)
+	tracing.End(span, err)
 	if err != nil {
 		return restic.ID{}, err
 	}
@@ -234,13 +373,13 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, *id, ciphertext)
+	_, err = packer.Add(t, *id, ciphertext, uncompressedLength)
 	if err != nil {
 		return restic.ID{}, err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < r.packSize {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return *id, nil
@@ -265,13 +404,23 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
+	p, err = r.compressUnpacked(t, p)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
 	ciphertext := restic.NewBlobBuffer(len(p))
-	ciphertext, err = r.Encrypt(ciphertext, p)
+	if t == restic.LockFile && r.sharedLocks {
+		// clients with write-only keys must be able to read the lock
+		ciphertext, err = r.lockKey.Encrypt(ciphertext, p)
+	} else {
+		ciphertext, err = r.Encrypt(ciphertext, p)
+	}
 	if err != nil {
 		return restic.ID{}, err
 	}
 
-	id = restic.Hash(ciphertext)
+	id = r.cfg.Hash.Sum(ciphertext)
 	h := restic.Handle{Type: t, Name: id.String()}
 
 	err = r.be.Save(ctx, h, bytes.NewReader(ciphertext))
@@ -284,8 +433,27 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	return id, nil
 }
 
-// Flush saves all remaining packs.
+// Flush saves all remaining packs and waits until all packs have been
+// uploaded. It must not be called concurrently with SaveAndEncrypt.
 func (r *Repository) Flush() error {
+	err := r.flushPackers()
+
+	r.uploaderMu.Lock()
+	u := r.uploader
+	r.uploader = nil
+	r.uploaderMu.Unlock()
+
+	if u != nil {
+		if uerr := u.Close(); err == nil {
+			err = uerr
+		}
+	}
+
+	return err
+}
+
+// flushPackers saves the packs which are not full yet.
+func (r *Repository) flushPackers() error {
 	pms := []struct {
 		t  restic.BlobType
 		pm *packerManager
@@ -405,6 +573,10 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 		r.idx.Insert(idx)
 	}
 
+	if err := r.addObsoletePacks(); err != nil {
+		return err
+	}
+
 	if r.Cache != nil {
 		// clear old index files
 		err := r.Cache.Clear(restic.IndexFile, validIndex)
@@ -455,6 +627,54 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 	return nil
 }
 
+// addObsoletePacks makes the blobs in packs which prune has removed from the
+// index, but not deleted yet, available for reading. A backup which ran at the
+// same time as prune may reference them, the next prune adds these packs to
+// the index again. The blobs are added in an index after all other indexes,
+// so the live entries are found first.
+func (r *Repository) addObsoletePacks() error {
+	live := restic.NewIDSet()
+	var obsolete []ObsoletePack
+	for _, idx := range r.idx.All() {
+		for id := range idx.Packs() {
+			live.Insert(id)
+		}
+		obsolete = append(obsolete, idx.Obsolete()...)
+	}
+
+	oidx := NewIndex()
+	oidx.fallback = true
+
+	seen := restic.NewIDSet()
+	n := 0
+	for _, op := range obsolete {
+		if live.Has(op.ID) || seen.Has(op.ID) {
+			continue
+		}
+		seen.Insert(op.ID)
+
+		for _, blob := range op.Blobs {
+			if _, err := r.idx.Lookup(blob.ID, blob.Type); err == nil {
+				continue
+			}
+			oidx.Store(restic.PackedBlob{Blob: blob, PackID: op.ID})
+			n++
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	debug.Log("%d blobs are only contained in %d obsolete packs", n, len(seen))
+	if err := oidx.Finalize(ioutil.Discard); err != nil {
+		return err
+	}
+	r.idx.Insert(oidx)
+
+	return nil
+}
+
 // LoadIndex loads the index id from backend and returns it.
 func LoadIndex(ctx context.Context, repo restic.Repository, id restic.ID) (*Index, error) {
 	idx, err := LoadIndexWithDecoder(ctx, repo, id, DecodeIndex)
@@ -478,12 +698,79 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	return r.useKey(ctx, key)
+}
+
+// SearchWrappedKey finds a key which is wrapped with w, afterwards the config
+// is read and parsed. It tries at most maxKeys key files in the repo.
+func (r *Repository) SearchWrappedKey(ctx context.Context, w KeyWrapper, maxKeys int) error {
+	key, err := SearchWrappedKey(ctx, r, w, maxKeys)
+	if err != nil {
+		return err
+	}
+
+	return r.useKey(ctx, key)
+}
+
+// useKey uses the key for accessing the repository and loads the config.
+func (r *Repository) useKey(ctx context.Context, key *Key) error {
+	if key.WriteOnly() {
+		return r.useWriteOnlyKey(ctx, key)
+	}
+
 	r.key = key.master
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
+	r.lockKey = crypto.LockKey(key.master.PublicKey())
 	r.keyName = key.Name()
+	r.keyRole = key.Role
+	if key.AppendOnly() {
+		debug.Log("key %v is append-only", key.Name())
+		r.be = backend.NewAppendOnlyBackend(r.be)
+	}
+	if key.ReadOnly() {
+		debug.Log("key %v is read-only", key.Name())
+		r.be = backend.NewReadOnlyBackend(r.be)
+	}
+	var err error
 	r.cfg, err = restic.LoadConfig(ctx, r)
-	return err
+	if err != nil {
+		return err
+	}
+	r.useObjectLock()
+	r.dataPM.hash = r.cfg.Hash
+	r.treePM.hash = r.cfg.Hash
+
+	if key.Role == KeyRoleAppendOnly {
+		err = r.checkAppendOnlyStorage(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.loadSessionKeys(ctx)
+}
+
+// checkAppendOnlyStorage returns an error unless the storage keeps the data
+// of the repository when a client tries to remove it. restic refuses to remove
+// data when the repository is opened with an append-only key, but a modified
+// client could still use the key to remove everything.
+func (r *Repository) checkAppendOnlyStorage(ctx context.Context) error {
+	if r.cfg.ObjectLock != nil {
+		return nil
+	}
+
+	appendOnly, err := restic.AppendOnly(ctx, r.be)
+	if err != nil {
+		return errors.Wrap(err, "AppendOnly")
+	}
+
+	if !appendOnly {
+		return errors.Fatal("append-only keys can only be used if the storage refuses to remove files, " +
+			"e.g. with the REST server started with --append-only or with object lock")
+	}
+
+	return nil
 }
 
 // Init creates a new master key with the supplied password, initializes and
@@ -497,10 +784,23 @@ func (r *Repository) Init(ctx context.Context, password string) error {
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(r.hash)
 	if err != nil {
 		return err
 	}
+	cfg.MaxSize = r.maxSize
+
+	if r.objectLock != nil {
+		// enable the retention first, so that the key and the config are
+		// protected as well
+		err = r.enableObjectLock(ctx)
+		if err != nil {
+			return err
+		}
+
+		cfg.ObjectLock = r.objectLock
+		cfg.Features = append(cfg.Features, restic.FeatureObjectLock)
+	}
 
 	return r.init(ctx, password, cfg)
 }
@@ -516,8 +816,11 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 	r.key = key.master
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
+	r.lockKey = crypto.LockKey(key.master.PublicKey())
 	r.keyName = key.Name()
 	r.cfg = cfg
+	r.dataPM.hash = cfg.Hash
+	r.treePM.hash = cfg.Hash
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
 	return err
 }
@@ -552,6 +855,24 @@ func (r *Repository) KeyName() string {
 	return r.keyName
 }
 
+// AppendOnly returns true if the repository was opened with an append-only
+// or write-only key, in this case no data can be removed from the repository.
+func (r *Repository) AppendOnly() bool {
+	return r.keyRole == KeyRoleAppendOnly || r.keyRole == KeyRoleWriteOnly
+}
+
+// ReadOnly returns true if the repository was opened with a read-only key, in
+// this case no data can be added to or removed from the repository.
+func (r *Repository) ReadOnly() bool {
+	return r.keyRole == KeyRoleReadOnly
+}
+
+// WriteOnly returns true if the repository was opened with a write-only key,
+// in this case no data can be read from the repository.
+func (r *Repository) WriteOnly() bool {
+	return r.keyRole == KeyRoleWriteOnly
+}
+
 // List returns a channel that yields all IDs of type t in the backend.
 func (r *Repository) List(ctx context.Context, t restic.FileType) <-chan restic.ID {
 	out := make(chan restic.ID)
@@ -678,7 +999,7 @@ func (r *Repository) SaveTree(ctx context.Context, t *restic.Tree) (restic.ID, e
 	// adds a newline after each object)
 	buf = append(buf, '\n')
 
-	id := restic.Hash(buf)
+	id := r.cfg.Hash.Sum(buf)
 	if r.idx.Has(id, restic.TreeBlob) {
 		return id, nil
 	}
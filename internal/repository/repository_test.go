@@ -7,10 +7,14 @@ import (
 	"io"
 	"math/rand"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -56,6 +60,542 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestPackSize(t *testing.T) {
+	for _, test := range []struct {
+		size  uint
+		packs int
+	}{
+		{0, 2},
+		{8 * 1024 * 1024, 1},
+	} {
+		r, cleanup := repository.TestRepository(t)
+		repo := r.(*repository.Repository)
+
+		if test.size > 0 {
+			rtest.OK(t, repo.SetPackSize(test.size))
+		}
+
+		// save 6 MiB of data
+		for i := 0; i < 6; i++ {
+			data := make([]byte, 1024*1024)
+			_, err := io.ReadFull(rnd, data)
+			rtest.OK(t, err)
+
+			_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+			rtest.OK(t, err)
+		}
+		rtest.OK(t, repo.Flush())
+
+		packs := 0
+		for range repo.List(context.TODO(), restic.DataFile) {
+			packs++
+		}
+		rtest.Equals(t, test.packs, packs)
+
+		cleanup()
+	}
+
+	repo := repository.New(nil)
+	rtest.Assert(t, repo.SetPackSize(1024*1024) != nil, "pack size of 1 MiB accepted")
+	rtest.Assert(t, repo.SetPackSize(256*1024*1024) != nil, "pack size of 256 MiB accepted")
+}
+
+func TestMaxSize(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	ctx := context.TODO()
+	repo.SetMaxSize(6 * 1024 * 1024)
+
+	// the first pack file fits, the second one would exceed the maximum size
+	for i := 0; i < 5; i++ {
+		data := make([]byte, 1024*1024)
+		_, err := io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		_, err = repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{})
+		rtest.OK(t, err)
+	}
+	size, err := repo.Size(ctx)
+	rtest.OK(t, err)
+	rtest.Assert(t, size > 4*1024*1024, "size %d does not include the first pack", size)
+
+	for i := 0; i < 5 && err == nil; i++ {
+		data := make([]byte, 1024*1024)
+		_, err = io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		_, err = repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{})
+	}
+	if err == nil {
+		err = repo.Flush()
+	}
+	rtest.Equals(t, repository.ErrQuotaExceeded, errors.Cause(err))
+	size, err = repo.Size(ctx)
+	rtest.OK(t, err)
+	rtest.Assert(t, size <= repo.MaxSize(), "size %d exceeds the maximum", size)
+
+	// the size is computed from the pack files in the backend when the
+	// repository is opened again, the index is not needed
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 10))
+	size2, err := repo2.Size(ctx)
+	rtest.OK(t, err)
+	rtest.Equals(t, size, size2)
+	rtest.Equals(t, uint64(0), repo2.MaxSize())
+
+	// the sizes of the pack files in the index are computed from the blobs
+	rtest.OK(t, repo.SaveIndex(ctx))
+	be := &statCountingBackend{Backend: repo.Backend()}
+	repo3 := repository.New(be)
+	rtest.OK(t, repo3.SearchKey(ctx, rtest.TestPassword, 10))
+	rtest.OK(t, repo3.LoadIndex(ctx))
+	size3, err := repo3.Size(ctx)
+	rtest.OK(t, err)
+	rtest.Equals(t, size, size3)
+	rtest.Equals(t, 0, be.stats)
+}
+
+// statCountingBackend counts the requests for the size of pack files.
+type statCountingBackend struct {
+	restic.Backend
+
+	m     sync.Mutex
+	stats int
+}
+
+func (be *statCountingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if h.Type == restic.DataFile {
+		be.m.Lock()
+		be.stats++
+		be.m.Unlock()
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+// gatedBackend records the maximum number of pack files saved concurrently.
+// Saving a pack file waits until release is closed.
+type gatedBackend struct {
+	restic.Backend
+	release chan struct{}
+
+	m       sync.Mutex
+	running int
+	max     int
+	fail    bool
+}
+
+func (be *gatedBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	if h.Type != restic.DataFile {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	be.m.Lock()
+	be.running++
+	if be.running > be.max {
+		be.max = be.running
+	}
+	fail := be.fail
+	be.m.Unlock()
+
+	defer func() {
+		be.m.Lock()
+		be.running--
+		be.m.Unlock()
+	}()
+
+	<-be.release
+	if fail {
+		return errors.New("upload failed")
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *gatedBackend) uploads() int {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.running
+}
+
+func TestPackUploaders(t *testing.T) {
+	be := &gatedBackend{Backend: mem.New(), release: make(chan struct{})}
+	r, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	rtest.Assert(t, repo.SetPackUploaders(0) != nil, "zero pack uploaders accepted")
+	rtest.OK(t, repo.SetPackUploaders(2))
+
+	// save 24 MiB of data from several goroutines, this yields six packs
+	var wg sync.WaitGroup
+	ids := make([]restic.ID, 24)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := i; j < len(ids); j += 4 {
+				data := make([]byte, 1024*1024)
+				_, err := io.ReadFull(rand.New(rand.NewSource(int64(j))), data)
+				rtest.OK(t, err)
+
+				ids[j], err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+				rtest.OK(t, err)
+			}
+		}(i)
+	}
+
+	// the uploads only finish once two of them run at the same time
+	deadline := time.Now().Add(10 * time.Second)
+	for be.uploads() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pack files are not uploaded in parallel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(be.release)
+
+	wg.Wait()
+	rtest.OK(t, repo.Flush())
+
+	rtest.Assert(t, be.max <= 2, "%d pack files uploaded in parallel", be.max)
+	for _, id := range ids {
+		rtest.Assert(t, repo.Index().Has(id, restic.DataBlob), "blob %v is not in the index", id.Str())
+	}
+
+	// the error of a failed upload is returned
+	be.m.Lock()
+	be.fail = true
+	be.m.Unlock()
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		data := make([]byte, 1024*1024)
+		_, err = io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+	}
+	if flushErr := repo.Flush(); err == nil {
+		err = flushErr
+	}
+	rtest.Assert(t, err != nil, "failed upload not reported")
+}
+
+// blockingBackend blocks saving pack files until release is closed.
+type blockingBackend struct {
+	restic.Backend
+	release chan struct{}
+}
+
+func (be *blockingBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) error {
+	if h.Type == restic.DataFile {
+		<-be.release
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestPackUploadQueue(t *testing.T) {
+	be := &blockingBackend{Backend: mem.New(), release: make(chan struct{})}
+	r, cleanup := repository.TestRepositoryWithBackend(t, be)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	rtest.OK(t, repo.SetPackUploaders(1))
+
+	// two packs can be finished while the upload is blocked, one is uploaded
+	// and one waits in the queue
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 8; i++ {
+			data := make([]byte, 1024*1024)
+			_, err := io.ReadFull(rnd, data)
+			rtest.OK(t, err)
+
+			_, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+			rtest.OK(t, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("saving blobs is blocked by the upload")
+	}
+
+	close(be.release)
+	rtest.OK(t, repo.Flush())
+
+	packs := 0
+	for range repo.List(context.TODO(), restic.DataFile) {
+		packs++
+	}
+	rtest.Equals(t, 2, packs)
+}
+
+// lockingBackend records the object lock passed to it and the retention of
+// files.
+type lockingBackend struct {
+	restic.Backend
+	enabled *restic.ObjectLock
+	used    *restic.ObjectLock
+
+	m         sync.Mutex
+	retention map[restic.Handle]time.Time
+	extended  int
+}
+
+func (be *lockingBackend) EnableObjectLock(ctx context.Context, lock restic.ObjectLock) error {
+	be.enabled = &lock
+	return nil
+}
+
+func (be *lockingBackend) UseObjectLock(lock restic.ObjectLock) {
+	be.used = &lock
+}
+
+func (be *lockingBackend) ExtendRetention(ctx context.Context, h restic.Handle, until time.Time) error {
+	be.m.Lock()
+	defer be.m.Unlock()
+	if be.retention == nil {
+		be.retention = make(map[restic.Handle]time.Time)
+	}
+	be.retention[h] = until
+	be.extended++
+	return nil
+}
+
+func (be *lockingBackend) RetainUntil(ctx context.Context, h restic.Handle) (time.Time, error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.retention[h], nil
+}
+
+func TestObjectLock(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	ctx := context.TODO()
+	lock := restic.ObjectLock{Mode: restic.ObjectLockCompliance, Days: 30}
+
+	// the object lock can only be enabled for backends which support it
+	repo := repository.New(be)
+	rtest.OK(t, repo.SetObjectLock(lock))
+	err := repo.Init(ctx, rtest.TestPassword)
+	rtest.Assert(t, err != nil, "object lock enabled for a backend without support")
+
+	rtest.Assert(t, repo.SetObjectLock(restic.ObjectLock{Mode: "foo", Days: 1}) != nil, "invalid mode accepted")
+	rtest.Assert(t, repo.SetObjectLock(restic.ObjectLock{Mode: restic.ObjectLockGovernance}) != nil, "zero days accepted")
+
+	lbe := &lockingBackend{Backend: be}
+	repo = repository.New(lbe)
+	rtest.OK(t, repo.SetObjectLock(lock))
+	rtest.OK(t, repo.Init(ctx, rtest.TestPassword))
+	rtest.Equals(t, &lock, lbe.enabled)
+	rtest.Equals(t, &lock, repo.Config().ObjectLock)
+	rtest.Assert(t, repo.Config().HasFeature(restic.FeatureObjectLock), "feature not set")
+
+	// the backend is told about the object lock when the repository is opened
+	lbe = &lockingBackend{Backend: be}
+	repo = repository.New(lbe)
+	rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 10))
+	rtest.Equals(t, &lock, lbe.used)
+	rtest.Assert(t, lbe.enabled == nil, "object lock enabled again")
+
+	// the retention of all pack files referenced by a snapshot is extended,
+	// counted from now and not from the time of the snapshot, with a margin
+	// of three days
+	rtest.OK(t, repo.LoadIndex(ctx))
+	sn := restic.TestCreateSnapshot(t, repo, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), 2, 0)
+	start := time.Now().AddDate(0, 0, 33)
+	rtest.OK(t, repo.ExtendObjectLock(ctx, *sn.Tree))
+	end := time.Now().AddDate(0, 0, 33)
+
+	packs := 0
+	for name := range be.List(ctx, restic.DataFile) {
+		until := lbe.retention[restic.Handle{Type: restic.DataFile, Name: name}]
+		rtest.Assert(t, !until.Before(start) && !until.After(end),
+			"retention of pack %v not extended to 33 days from now: %v", name, until)
+		packs++
+	}
+	rtest.Assert(t, packs > 0, "no pack files saved")
+	rtest.Equals(t, packs, lbe.extended)
+
+	// pack files which are retained long enough are not extended again
+	rtest.OK(t, repo.ExtendObjectLock(ctx, *sn.Tree))
+	rtest.Equals(t, packs, lbe.extended)
+
+	// whether a snapshot is retained is decided by the storage
+	h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+	retained, err := repo.Retained(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !retained, "snapshot without retention is retained")
+
+	rtest.OK(t, lbe.ExtendRetention(ctx, h, time.Now().Add(time.Hour)))
+	retained, err = repo.Retained(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, retained, "snapshot within the retention period is not retained")
+}
+
+// copyingBackend copies files from other copyingBackends like a storage
+// server.
+type copyingBackend struct {
+	restic.Backend
+}
+
+func (be *copyingBackend) CopyFrom(ctx context.Context, src restic.Backend, h restic.Handle) (bool, error) {
+	other, ok := restic.Unwrap(src).(*copyingBackend)
+	if !ok {
+		return false, nil
+	}
+
+	buf, err := backend.LoadAll(ctx, other.Backend, h)
+	if err != nil {
+		return false, err
+	}
+
+	return true, be.Backend.Save(ctx, h, bytes.NewReader(buf))
+}
+
+// openReplica returns a repository in be which uses the same key and config
+// as src.
+func openReplica(t *testing.T, src restic.Backend, be restic.Backend) *repository.Repository {
+	ctx := context.TODO()
+
+	handles := []restic.Handle{{Type: restic.ConfigFile}}
+	for name := range src.List(ctx, restic.KeyFile) {
+		handles = append(handles, restic.Handle{Type: restic.KeyFile, Name: name})
+	}
+
+	for _, h := range handles {
+		buf, err := backend.LoadAll(ctx, src, h)
+		rtest.OK(t, err)
+		rtest.OK(t, be.Save(ctx, h, bytes.NewReader(buf)))
+	}
+
+	repo := repository.New(be)
+	rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 10))
+	rtest.OK(t, repo.LoadIndex(ctx))
+	return repo
+}
+
+func TestCopyPack(t *testing.T) {
+	ctx := context.TODO()
+
+	srcBe := &copyingBackend{Backend: mem.New()}
+	r, cleanup := repository.TestRepositoryWithBackend(t, srcBe)
+	defer cleanup()
+	src := r.(*repository.Repository)
+
+	data := rtest.Random(23, 5000)
+	id, err := src.SaveBlob(ctx, restic.DataBlob, data, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, src.Flush())
+
+	blobs, err := src.Index().Lookup(id, restic.DataBlob)
+	rtest.OK(t, err)
+	packID := blobs[0].PackID
+
+	other, cleanup2 := repository.TestRepository(t)
+	defer cleanup2()
+	rtest.Assert(t, !src.SameKey(other.(*repository.Repository)), "repositories with different keys have the same key")
+
+	// a backend without server-side copy
+	dst := openReplica(t, srcBe, mem.New())
+	rtest.Assert(t, dst.SameKey(src), "replica does not have the same key")
+	copied, err := dst.CopyPack(ctx, src, packID)
+	rtest.OK(t, err)
+	rtest.Assert(t, !copied, "pack copied by a backend without server-side copy")
+	rtest.Assert(t, !dst.Index().Has(id, restic.DataBlob), "blob added to the index")
+
+	dst = openReplica(t, srcBe, &copyingBackend{Backend: mem.New()})
+	copied, err = dst.CopyPack(ctx, src, packID)
+	rtest.OK(t, err)
+	rtest.Assert(t, copied, "pack not copied")
+
+	buf := make([]byte, restic.CiphertextLength(len(data)))
+	n, err := dst.LoadBlob(ctx, restic.DataBlob, id, buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf[:n])
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.TODO()
+
+	// data which compresses well
+	data := bytes.Repeat([]byte("restic compresses blobs with zstd\n"), 5000)
+
+	for _, mode := range []string{restic.CompressionOff, restic.CompressionAuto, restic.CompressionMax} {
+		t.Run(mode, func(t *testing.T) {
+			r, cleanup := repository.TestRepository(t)
+			defer cleanup()
+			repo := r.(*repository.Repository)
+			rtest.OK(t, repo.SetCompression(mode))
+
+			id, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{})
+			rtest.OK(t, err)
+			rtest.OK(t, repo.Flush())
+
+			blobs, err := repo.Index().Lookup(id, restic.DataBlob)
+			rtest.OK(t, err)
+			rtest.Equals(t, mode != restic.CompressionOff, blobs[0].IsCompressed())
+			if blobs[0].IsCompressed() {
+				rtest.Assert(t, blobs[0].Length < uint(len(data)), "compressed blob is not smaller: %v", blobs[0])
+			}
+
+			// the list of blobs in the pack header contains the same entry
+			list, _, err := repo.ListPack(ctx, blobs[0].PackID)
+			rtest.OK(t, err)
+			rtest.Equals(t, []restic.Blob{blobs[0].Blob}, list)
+
+			size, err := repo.LookupBlobSize(id, restic.DataBlob)
+			rtest.OK(t, err)
+			rtest.Equals(t, uint(len(data)), size)
+
+			buf := restic.NewBlobBuffer(int(size))
+			n, err := repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+			rtest.OK(t, err)
+			rtest.Equals(t, data, buf[:n])
+
+			// snapshots are compressed as well
+			sn := restic.Snapshot{Hostname: "foobar", Paths: []string{string(data)}}
+			snID, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, &sn)
+			rtest.OK(t, err)
+
+			fi, err := repo.Backend().Stat(ctx, restic.Handle{Type: restic.SnapshotFile, Name: snID.String()})
+			rtest.OK(t, err)
+			rtest.Equals(t, mode != restic.CompressionOff, fi.Size < int64(len(data)))
+
+			var sn2 restic.Snapshot
+			rtest.OK(t, repo.LoadJSONUnpacked(ctx, restic.SnapshotFile, snID, &sn2))
+			rtest.Equals(t, sn.Paths, sn2.Paths)
+		})
+	}
+}
+
+func TestCompressionUnsupported(t *testing.T) {
+	repodir, cleanup := rtest.Env(t, repoFixture)
+	defer cleanup()
+
+	// the repository has been created before compression was added
+	repo := repository.TestOpenLocal(t, repodir).(*repository.Repository)
+	rtest.Assert(t, repo.SetCompression(restic.CompressionMax) != nil,
+		"compression mode max accepted for a repository without compression")
+	rtest.OK(t, repo.SetCompression(restic.CompressionAuto))
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	data := bytes.Repeat([]byte("x"), 100000)
+	id, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush())
+
+	blobs, err := repo.Index().Lookup(id, restic.DataBlob)
+	rtest.OK(t, err)
+	rtest.Assert(t, !blobs[0].IsCompressed(), "blob saved compressed: %v", blobs[0])
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// useWriteOnlyKey prepares the repository for saving data with the write-only
+// key. A new session key is generated and saved to the repository sealed with
+// the public key, all data is encrypted with the session key afterwards.
+func (r *Repository) useWriteOnlyKey(ctx context.Context, key *Key) error {
+	debug.Log("key %v is write-only", key.Name())
+
+	r.keyName = key.Name()
+	r.keyRole = key.Role
+	r.be = backend.NewAppendOnlyBackend(r.be)
+	r.cfg = *key.config
+	r.useObjectLock()
+	r.dataPM.hash = r.cfg.Hash
+	r.treePM.hash = r.cfg.Hash
+
+	sk := crypto.NewSessionKey()
+	buf, err := crypto.SealKeys(key.public, sk)
+	if err != nil {
+		return err
+	}
+
+	h := restic.Handle{Type: restic.SessionFile, Name: restic.Hash(buf).String()}
+	err = r.be.Save(ctx, h, bytes.NewReader(buf))
+	if err != nil {
+		return errors.Wrap(err, "save session key")
+	}
+	debug.Log("saved session key %v", h.Name)
+
+	r.key = sk
+	r.dataPM.key = sk
+	r.treePM.key = sk
+	r.lockKey = crypto.LockKey(key.public)
+	r.sharedLocks = true
+
+	return nil
+}
+
+// loadSessionKeys loads the session keys saved by clients using write-only
+// keys, so that the data saved by these clients can be decrypted. If there are
+// any, the repository is used with write-only keys and new locks are
+// encrypted with the lock key, so that these clients can read them.
+func (r *Repository) loadSessionKeys(ctx context.Context) error {
+	for name := range r.be.List(ctx, restic.SessionFile) {
+		r.sharedLocks = true
+
+		h := restic.Handle{Type: restic.SessionFile, Name: name}
+		buf, err := backend.LoadAll(ctx, r.be, h)
+		if err != nil {
+			return errors.Wrapf(err, "load session key %v", name)
+		}
+
+		keys, err := r.key.OpenSealedKeys(buf)
+		if err != nil {
+			debug.Log("unable to open session key %v: %v", name, err)
+			continue
+		}
+
+		for _, sk := range keys {
+			r.key.AddSessionKey(sk)
+		}
+		r.sessionFiles = append(r.sessionFiles, name)
+	}
+
+	return nil
+}
+
+// FoldSessionKeys replaces the session files loaded by the repository with a
+// single file which contains all session keys, so that the number of files
+// loaded when the repository is opened does not grow with every backup made
+// with a write-only key. The repository must be locked exclusively.
+func (r *Repository) FoldSessionKeys(ctx context.Context) error {
+	if len(r.sessionFiles) < 2 {
+		return nil
+	}
+
+	buf, err := crypto.SealKeys(r.key.PublicKey(), r.key.SessionKeys()...)
+	if err != nil {
+		return err
+	}
+
+	h := restic.Handle{Type: restic.SessionFile, Name: restic.Hash(buf).String()}
+	err = r.be.Save(ctx, h, bytes.NewReader(buf))
+	if err != nil {
+		return errors.Wrap(err, "save session keys")
+	}
+	debug.Log("saved %d session keys to %v", len(r.key.SessionKeys()), h.Name)
+
+	for _, name := range r.sessionFiles {
+		if name == h.Name {
+			continue
+		}
+
+		err = r.be.Remove(ctx, restic.Handle{Type: restic.SessionFile, Name: name})
+		if err != nil {
+			return errors.Wrapf(err, "remove session key %v", name)
+		}
+	}
+	r.sessionFiles = []string{h.Name}
+
+	return nil
+}
@@ -3,6 +3,9 @@ package restic
 import (
 	"context"
 	"io"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
 )
 
 // Backend is used to store and access data.
@@ -45,3 +48,199 @@ type Backend interface {
 // FileInfo is returned by Stat() and contains information about a file in the
 // backend.
 type FileInfo struct{ Size int64 }
+
+// Thawer is implemented by backends which can move files to a storage tier
+// that cannot be read directly, e.g. an archival storage class.
+type Thawer interface {
+	// Thaw checks whether the file at h can be read. If the file is in an
+	// archival storage tier, it requests that the file is restored (unless
+	// this has already been done) and returns false. The call must be
+	// repeated later until it returns true.
+	Thaw(ctx context.Context, h Handle) (bool, error)
+}
+
+// Thaw requests that the file at h is made readable if be or a backend
+// wrapped by it implements Thawer and returns whether the file can be read
+// now. For other backends, it always returns true.
+func Thaw(ctx context.Context, be Backend, h Handle) (bool, error) {
+	for ; be != nil; be = unwrapOnce(be) {
+		if t, ok := be.(Thawer); ok {
+			return t.Thaw(ctx, h)
+		}
+	}
+
+	return true, nil
+}
+
+// ObjectLocker is implemented by backends which can keep files for a
+// retention period, so that they cannot be removed or overwritten, e.g. by
+// ransomware with access to the credentials.
+type ObjectLocker interface {
+	// EnableObjectLock configures the storage so that all files saved
+	// afterwards are kept for the retention period.
+	EnableObjectLock(ctx context.Context, lock ObjectLock) error
+
+	// UseObjectLock is called when a repository with object lock is opened,
+	// so that the backend can save files in the way the storage requires.
+	UseObjectLock(lock ObjectLock)
+
+	// ExtendRetention makes the storage keep the file h until the given time.
+	ExtendRetention(ctx context.Context, h Handle, until time.Time) error
+
+	// RetainUntil returns the time until which the storage keeps the file h.
+	RetainUntil(ctx context.Context, h Handle) (time.Time, error)
+}
+
+// EnableObjectLock enables the retention in be, an error is returned if
+// neither be nor a backend wrapped by it implements ObjectLocker. Like the
+// other functions for ObjectLocker below, it calls the method of the first
+// backend which has it, so that a wrapper can implement a single method.
+func EnableObjectLock(ctx context.Context, be Backend, lock ObjectLock) error {
+	for ; be != nil; be = unwrapOnce(be) {
+		if l, ok := be.(interface {
+			EnableObjectLock(context.Context, ObjectLock) error
+		}); ok {
+			return l.EnableObjectLock(ctx, lock)
+		}
+	}
+
+	return errors.Fatal("the backend does not support object lock")
+}
+
+// UseObjectLock passes the object lock of a repository to be or the backend
+// wrapped by it which implements ObjectLocker.
+func UseObjectLock(be Backend, lock ObjectLock) {
+	for ; be != nil; be = unwrapOnce(be) {
+		if l, ok := be.(interface{ UseObjectLock(ObjectLock) }); ok {
+			l.UseObjectLock(lock)
+			return
+		}
+	}
+}
+
+// ExtendRetention extends the retention of the file h in be, an error is
+// returned if neither be nor a backend wrapped by it implements ObjectLocker.
+func ExtendRetention(ctx context.Context, be Backend, h Handle, until time.Time) error {
+	for ; be != nil; be = unwrapOnce(be) {
+		if l, ok := be.(interface {
+			ExtendRetention(context.Context, Handle, time.Time) error
+		}); ok {
+			return l.ExtendRetention(ctx, h, until)
+		}
+	}
+
+	return errors.Fatal("the backend does not support object lock")
+}
+
+// RetainUntil returns the end of the retention of the file h in be, an error
+// is returned if neither be nor a backend wrapped by it implements
+// ObjectLocker.
+func RetainUntil(ctx context.Context, be Backend, h Handle) (time.Time, error) {
+	for ; be != nil; be = unwrapOnce(be) {
+		if l, ok := be.(interface {
+			RetainUntil(context.Context, Handle) (time.Time, error)
+		}); ok {
+			return l.RetainUntil(ctx, h)
+		}
+	}
+
+	return time.Time{}, errors.Fatal("the backend does not support object lock")
+}
+
+// UploadAborter is implemented by backends which may keep incomplete uploads
+// of failed or interrupted saves, e.g. S3 multipart uploads. They use storage
+// but are not visible as files.
+type UploadAborter interface {
+	// AbortUploads removes all incomplete uploads of files of type t which
+	// have been started before the given time and returns their number.
+	AbortUploads(ctx context.Context, t FileType, before time.Time) (int, error)
+}
+
+// AbortUploads aborts the incomplete uploads in be if it or a backend wrapped
+// by it implements UploadAborter, otherwise nothing is done.
+func AbortUploads(ctx context.Context, be Backend, t FileType, before time.Time) (int, error) {
+	for ; be != nil; be = unwrapOnce(be) {
+		if a, ok := be.(UploadAborter); ok {
+			return a.AbortUploads(ctx, t, before)
+		}
+	}
+
+	return 0, nil
+}
+
+// ServerSideCopier is implemented by backends which can copy files from
+// another backend in the same storage service, without transferring the data
+// through the client.
+type ServerSideCopier interface {
+	// CopyFrom copies the file h from src to this backend. It returns false
+	// if src is not in the same storage service, e.g. because it is a
+	// different kind of backend or uses another server.
+	CopyFrom(ctx context.Context, src Backend, h Handle) (bool, error)
+}
+
+// CopyFrom copies the file h from src to dst if dst or a backend wrapped by it
+// implements ServerSideCopier. It returns false if the file has not been
+// copied and must be transferred by the client instead.
+func CopyFrom(ctx context.Context, dst, src Backend, h Handle) (bool, error) {
+	for ; dst != nil; dst = unwrapOnce(dst) {
+		if c, ok := dst.(ServerSideCopier); ok {
+			return c.CopyFrom(ctx, src, h)
+		}
+	}
+
+	return false, nil
+}
+
+// AppendOnlyChecker is implemented by backends whose storage can be set up to
+// refuse removing or overwriting files other than lock files, e.g. the REST
+// server started with --append-only.
+type AppendOnlyChecker interface {
+	// AppendOnly returns true if the storage refuses to remove files other
+	// than lock files.
+	AppendOnly(ctx context.Context) (bool, error)
+}
+
+// AppendOnly returns true if the storage of be refuses to remove files other
+// than lock files. It returns false unless be or a backend wrapped by it
+// implements AppendOnlyChecker.
+func AppendOnly(ctx context.Context, be Backend) (bool, error) {
+	for ; be != nil; be = unwrapOnce(be) {
+		if c, ok := be.(AppendOnlyChecker); ok {
+			return c.AppendOnly(ctx)
+		}
+	}
+
+	return false, nil
+}
+
+// Unwrapper is implemented by backends which wrap another backend, e.g. to
+// add a cache or to collect metrics. The functions above which call the
+// optional methods of a backend look for them in the wrapped backends as
+// well, so a wrapper only needs to implement such a method if it changes the
+// behaviour, e.g. to refuse the request.
+type Unwrapper interface {
+	// Unwrap returns the wrapped backend.
+	Unwrap() Backend
+}
+
+// unwrapOnce returns the backend wrapped by be, or nil if be does not wrap
+// another backend.
+func unwrapOnce(be Backend) Backend {
+	u, ok := be.(Unwrapper)
+	if !ok {
+		return nil
+	}
+	return u.Unwrap()
+}
+
+// Unwrap returns the innermost backend wrapped by be, or be itself if it does
+// not wrap another backend.
+func Unwrap(be Backend) Backend {
+	for {
+		u, ok := be.(Unwrapper)
+		if !ok {
+			return be
+		}
+		be = u.Unwrap()
+	}
+}
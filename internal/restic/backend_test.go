@@ -0,0 +1,83 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// wrapper wraps a backend without implementing any optional methods.
+type wrapper struct {
+	restic.Backend
+}
+
+func (w wrapper) Unwrap() restic.Backend {
+	return w.Backend
+}
+
+// lockingBackend records the calls of the optional methods.
+type lockingBackend struct {
+	restic.Backend
+	thawed   []restic.Handle
+	extended []restic.Handle
+}
+
+func (be *lockingBackend) Thaw(ctx context.Context, h restic.Handle) (bool, error) {
+	be.thawed = append(be.thawed, h)
+	return false, nil
+}
+
+func (be *lockingBackend) EnableObjectLock(ctx context.Context, lock restic.ObjectLock) error {
+	return nil
+}
+
+func (be *lockingBackend) UseObjectLock(lock restic.ObjectLock) {}
+
+func (be *lockingBackend) ExtendRetention(ctx context.Context, h restic.Handle, until time.Time) error {
+	be.extended = append(be.extended, h)
+	return nil
+}
+
+func (be *lockingBackend) RetainUntil(ctx context.Context, h restic.Handle) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func TestOptionalMethodsWrapped(t *testing.T) {
+	ctx := context.TODO()
+	h := restic.Handle{Type: restic.DataFile, Name: "foo"}
+
+	inner := &lockingBackend{Backend: mem.New()}
+	be := wrapper{wrapper{inner}}
+
+	ready, err := restic.Thaw(ctx, be, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ready, "Thaw of the wrapped backend has not been called")
+	rtest.Equals(t, []restic.Handle{h}, inner.thawed)
+
+	rtest.OK(t, restic.ExtendRetention(ctx, be, h, time.Now()))
+	rtest.Equals(t, []restic.Handle{h}, inner.extended)
+
+	// the read-only backend refuses to extend the retention itself
+	err = restic.ExtendRetention(ctx, wrapper{backend.NewReadOnlyBackend(inner)}, h, time.Now())
+	rtest.Assert(t, errors.Cause(err) == backend.ErrReadOnly, "wrong error %v", err)
+	rtest.Equals(t, 1, len(inner.extended))
+
+	// backends without the optional methods
+	be = wrapper{mem.New()}
+	ready, err = restic.Thaw(ctx, be, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ready, "file of a backend without Thaw is not ready")
+
+	err = restic.EnableObjectLock(ctx, be, restic.ObjectLock{})
+	rtest.Assert(t, err != nil, "object lock enabled for a backend without support")
+
+	copied, err := restic.CopyFrom(ctx, be, mem.New(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !copied, "file copied by a backend without CopyFrom")
+}
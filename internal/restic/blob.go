@@ -1,6 +1,7 @@
 package restic
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/restic/restic/internal/errors"
@@ -12,11 +13,29 @@ type Blob struct {
 	Length uint
 	ID     ID
 	Offset uint
+
+	// UncompressedLength is the length of the plaintext of a blob which is
+	// stored compressed, it is zero for uncompressed blobs.
+	UncompressedLength uint
 }
 
 func (b Blob) String() string {
-	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v>",
-		b.Type, b.ID.Str(), b.Offset, b.Length)
+	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v, uncompressed length %v>",
+		b.Type, b.ID.Str(), b.Offset, b.Length, b.UncompressedLength)
+}
+
+// IsCompressed returns true if the blob is stored compressed.
+func (b Blob) IsCompressed() bool {
+	return b.UncompressedLength != 0
+}
+
+// DataLength returns the length of the plaintext of the blob, after it has
+// been decrypted and decompressed.
+func (b Blob) DataLength() uint {
+	if b.IsCompressed() {
+		return b.UncompressedLength
+	}
+	return uint(PlaintextLength(int(b.Length)))
 }
 
 // PackedBlob is a blob stored within a file.
@@ -84,6 +103,22 @@ func (t *BlobType) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
+type blobTypeKey struct{}
+
+// ContextWithBlobType returns a context which records that the pack file
+// saved with it contains blobs of type t. Backends can use this to store pack
+// files with tree blobs differently.
+func ContextWithBlobType(ctx context.Context, t BlobType) context.Context {
+	return context.WithValue(ctx, blobTypeKey{}, t)
+}
+
+// BlobTypeFromContext returns the blob type recorded with ContextWithBlobType.
+// The second return value is false if ctx does not contain a blob type.
+func BlobTypeFromContext(ctx context.Context) (BlobType, bool) {
+	t, ok := ctx.Value(blobTypeKey{}).(BlobType)
+	return t, ok
+}
+
 // BlobHandles is an ordered list of BlobHandles that implements sort.Interface.
 type BlobHandles []BlobHandle
 
@@ -0,0 +1,112 @@
+package restic
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression modes for new blobs. In mode "auto", blobs are compressed with
+// a fast level, in mode "max" with the best level zstd offers. In both modes
+// a blob is stored uncompressed if compression does not make it smaller.
+const (
+	CompressionOff  = "off"
+	CompressionAuto = "auto"
+	CompressionMax  = "max"
+)
+
+// ValidCompression returns an error if mode is not a valid compression mode.
+func ValidCompression(mode string) error {
+	switch mode {
+	case CompressionOff, CompressionAuto, CompressionMax:
+		return nil
+	default:
+		return errors.Fatalf("invalid compression mode %q, valid modes are %q, %q and %q",
+			mode, CompressionOff, CompressionAuto, CompressionMax)
+	}
+}
+
+var (
+	zstdOnce      sync.Once
+	zstdFast      *zstd.Encoder
+	zstdBest      *zstd.Encoder
+	zstdDecoder   *zstd.Decoder
+	zstdInitError error
+)
+
+// initZstd creates the encoders and the decoder, they can be used
+// concurrently.
+func initZstd() {
+	zstdFast, zstdInitError = zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.SpeedDefault),
+		zstd.WithEncoderCRC(false),
+		zstd.WithZeroFrames(true))
+	if zstdInitError != nil {
+		return
+	}
+
+	zstdBest, zstdInitError = zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+		zstd.WithEncoderCRC(false),
+		zstd.WithZeroFrames(true))
+	if zstdInitError != nil {
+		return
+	}
+
+	zstdDecoder, zstdInitError = zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxWindow(128*1024*1024))
+}
+
+// Compress compresses data with the given mode and returns the result. Nil is
+// returned if the mode is "off" or if the compressed data would not be
+// smaller than data, the blob is then stored uncompressed.
+func Compress(mode string, data []byte) ([]byte, error) {
+	if mode == CompressionOff || len(data) == 0 {
+		return nil, nil
+	}
+
+	zstdOnce.Do(initZstd)
+	if zstdInitError != nil {
+		return nil, errors.Wrap(zstdInitError, "zstd")
+	}
+
+	enc := zstdFast
+	if mode == CompressionMax {
+		enc = zstdBest
+	}
+
+	buf := enc.EncodeAll(data, make([]byte, 0, len(data)))
+	if len(buf) >= len(data) {
+		return nil, nil
+	}
+
+	return buf, nil
+}
+
+// Decompress decompresses src, which must yield exactly size bytes unless size
+// is zero. The result is stored in dst if it is large enough, otherwise a new
+// buffer is allocated.
+func Decompress(dst, src []byte, size uint) ([]byte, error) {
+	zstdOnce.Do(initZstd)
+	if zstdInitError != nil {
+		return nil, errors.Wrap(zstdInitError, "zstd")
+	}
+
+	if uint(cap(dst)) < size {
+		dst = make([]byte, 0, size)
+	}
+
+	buf, err := zstdDecoder.DecodeAll(src, dst[:0])
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress")
+	}
+
+	if size > 0 && uint(len(buf)) != size {
+		return nil, errors.Errorf("decompressed data has wrong length, want %d, got %d", size, len(buf))
+	}
+
+	return buf, nil
+}
@@ -16,20 +16,84 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// Hash is the algorithm used for computing IDs, empty means SHA-256.
+	Hash HashAlgorithm `json:"hash,omitempty"`
+
+	// Features lists optional features used by the repository. Clients
+	// refuse to open a repository which uses a feature they don't support.
+	Features []string `json:"features,omitempty"`
+
+	// MaxSize is the maximum size of all pack files in bytes, zero means
+	// unlimited. It is checked by clients before saving new pack files.
+	MaxSize uint64 `json:"max_size,omitempty"`
+
+	// ObjectLock is set for repositories in storage which keeps all files
+	// for a retention period, e.g. S3 with Object Lock.
+	ObjectLock *ObjectLock `json:"object_lock,omitempty"`
+}
+
+// ObjectLock describes how long files saved to the storage of a repository
+// cannot be removed or overwritten.
+type ObjectLock struct {
+	// Mode is the retention mode, either "governance" or "compliance".
+	Mode string `json:"mode"`
+	// Days is the retention period in days.
+	Days uint `json:"days"`
 }
 
+// The retention modes supported for ObjectLock. In governance mode, users
+// with special permissions can remove files before the retention period has
+// ended, in compliance mode nobody can.
+const (
+	ObjectLockGovernance = "governance"
+	ObjectLockCompliance = "compliance"
+)
+
 // RepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
 const RepoVersion = 1
 
+// supportedFeatures are the repository features this version of restic
+// knows about.
+var supportedFeatures = map[string]bool{
+	FeatureHash:        true,
+	FeatureObjectLock:  true,
+	FeatureCompression: true,
+}
+
+// FeatureHash is used by repositories which compute IDs with a hash algorithm
+// other than SHA-256.
+const FeatureHash = "hash"
+
+// FeatureObjectLock is used by repositories with an ObjectLock. Older clients
+// would neither keep snapshots within the retention period nor upload files
+// in the way the storage requires.
+const FeatureObjectLock = "object-lock"
+
+// FeatureCompression is used by repositories which may contain compressed
+// blobs, snapshots and indexes. It is enabled for new repositories and can be
+// added to existing ones with "restic migrate compression".
+const FeatureCompression = "compression"
+
+// HasFeature returns true if the repository uses the feature.
+func (cfg Config) HasFeature(feature string) bool {
+	for _, f := range cfg.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
 	LoadJSONUnpacked(context.Context, FileType, ID, interface{}) error
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID, IDs are computed with the hash algorithm.
+func CreateConfig(hash HashAlgorithm) (Config, error) {
 	var (
 		err error
 		cfg Config
@@ -42,6 +106,12 @@ func CreateConfig() (Config, error) {
 
 	cfg.ID = NewRandomID().String()
 	cfg.Version = RepoVersion
+	cfg.Features = append(cfg.Features, FeatureCompression)
+
+	if hash != "" && hash != HashSHA256 {
+		cfg.Hash = hash
+		cfg.Features = append(cfg.Features, FeatureHash)
+	}
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
@@ -53,6 +123,7 @@ func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
 
 	cfg.ID = NewRandomID().String()
 	cfg.Version = RepoVersion
+	cfg.Features = []string{FeatureCompression}
 
 	return cfg
 }
@@ -76,5 +147,15 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, errors.New("invalid chunker polynomial")
 	}
 
+	for _, f := range cfg.Features {
+		if !supportedFeatures[f] {
+			return Config{}, errors.Errorf("repository uses feature %q which is not supported by this version of restic, please upgrade", f)
+		}
+	}
+
+	if _, err := ParseHashAlgorithm(string(cfg.Hash)); err != nil {
+		return Config{}, errors.Errorf("unsupported hash algorithm %q", cfg.Hash)
+	}
+
 	return cfg, nil
 }
@@ -32,7 +32,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(restic.HashSHA256)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -50,6 +50,40 @@ func TestConfig(t *testing.T) {
 	cfg2, err := restic.LoadConfig(context.TODO(), loader(load))
 	rtest.OK(t, err)
 
-	rtest.Assert(t, cfg1 == cfg2,
-		"configs aren't equal: %v != %v", cfg1, cfg2)
+	rtest.Equals(t, cfg1, cfg2)
+}
+
+func TestConfigUnsupportedFeature(t *testing.T) {
+	cfg1, err := restic.CreateConfig(restic.HashSHA256)
+	rtest.OK(t, err)
+	cfg1.Features = []string{"feature-from-the-future"}
+
+	load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+		*arg.(*restic.Config) = cfg1
+		return nil
+	}
+
+	_, err = restic.LoadConfig(context.TODO(), loader(load))
+	rtest.Assert(t, err != nil, "config with unsupported feature was loaded")
+}
+
+func TestConfigHashAlgorithm(t *testing.T) {
+	cfg, err := restic.CreateConfig(restic.HashSHA256)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.HashAlgorithm(""), cfg.Hash)
+	rtest.Assert(t, !cfg.HasFeature(restic.FeatureHash), "SHA-256 config uses the hash feature")
+
+	cfg, err = restic.CreateConfig(restic.HashBLAKE3)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.HashBLAKE3, cfg.Hash)
+	rtest.Assert(t, cfg.HasFeature(restic.FeatureHash), "BLAKE3 config does not use the hash feature")
+
+	cfg.Hash = "md5"
+	load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+		*arg.(*restic.Config) = cfg
+		return nil
+	}
+
+	_, err = restic.LoadConfig(context.TODO(), loader(load))
+	rtest.Assert(t, err != nil, "config with unsupported hash algorithm was loaded")
 }
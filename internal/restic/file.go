@@ -17,6 +17,8 @@ const (
 	SnapshotFile          = "snapshot"
 	IndexFile             = "index"
 	ConfigFile            = "config"
+	ScrubFile             = "scrub"
+	SessionFile           = "session"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +48,8 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case ScrubFile:
+	case SessionFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
@@ -0,0 +1,93 @@
+package restic
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// HashAlgorithm is the algorithm a repository uses for computing the IDs of
+// blobs, packs and other files. It is chosen when the repository is created
+// and recorded in the config. All algorithms produce IDs of the same length.
+type HashAlgorithm string
+
+// The supported hash algorithms. SHA-512/256, BLAKE2b and BLAKE3 are
+// considerably faster than SHA-256 on 64 bit CPUs without SHA extensions.
+const (
+	HashSHA256     HashAlgorithm = "sha256"
+	HashSHA512_256 HashAlgorithm = "sha512-256"
+	HashBLAKE2b    HashAlgorithm = "blake2b-256"
+	HashBLAKE3     HashAlgorithm = "blake3"
+)
+
+// HashAlgorithms lists the names of all supported hash algorithms.
+var HashAlgorithms = []HashAlgorithm{HashSHA256, HashSHA512_256, HashBLAKE2b, HashBLAKE3}
+
+// ParseHashAlgorithm returns the hash algorithm with the name, an empty name
+// selects SHA-256.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	if name == "" {
+		return HashSHA256, nil
+	}
+
+	for _, a := range HashAlgorithms {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+
+	return "", errors.Fatalf("invalid hash algorithm %q, valid algorithms are %v", name, HashAlgorithms)
+}
+
+// Sum returns the ID for data. An empty algorithm is SHA-256.
+func (a HashAlgorithm) Sum(data []byte) ID {
+	switch a {
+	case HashSHA512_256:
+		return sha512.Sum512_256(data)
+	case HashBLAKE2b:
+		return blake2b.Sum256(data)
+	case HashBLAKE3:
+		return blake3.Sum256(data)
+	default:
+		return sha256.Sum256(data)
+	}
+}
+
+// Equal returns true if a and other compute the same IDs.
+func (a HashAlgorithm) Equal(other HashAlgorithm) bool {
+	if a == "" {
+		a = HashSHA256
+	}
+	if other == "" {
+		other = HashSHA256
+	}
+	return a == other
+}
+
+// New returns a hash for computing the ID of streamed data.
+func (a HashAlgorithm) New() hash.Hash {
+	switch a {
+	case HashSHA512_256:
+		return sha512.New512_256()
+	case HashBLAKE2b:
+		// New256 only fails for keys which are too long
+		h, _ := blake2b.New256(nil)
+		return h
+	case HashBLAKE3:
+		return blake3.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// Hash returns the SHA-256 ID for data. It is used for files whose name does
+// not depend on the hash algorithm of the repository, e.g. keys. The IDs of
+// blobs and packs are computed with the HashAlgorithm from the config.
+func Hash(data []byte) ID {
+	return sha256.Sum256(data)
+}
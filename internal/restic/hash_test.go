@@ -0,0 +1,54 @@
+package restic
+
+import "testing"
+
+func TestHashAlgorithm(t *testing.T) {
+	var tests = []struct {
+		algorithm HashAlgorithm
+		id        string
+	}{
+		{"", "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+		{HashSHA256, "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"},
+		{HashSHA512_256, "d014c752bc2be868e16330f47e0c316a5967bcbc9c286a457761d7055b9214ce"},
+		{HashBLAKE2b, "93a0e84a8cdd4166267dbe1263e937f08087723ac24e7dcc35b3d5941775ef47"},
+		{HashBLAKE3, "aa51dcd43d5c6c5203ee16906fd6b35db298b9b2e1de3fce81811d4806b76b7d"},
+	}
+
+	for _, test := range tests {
+		h := test.algorithm.New()
+		if h.Size() != idSize {
+			t.Errorf("%v: wrong size, want %v, got %v", test.algorithm, idSize, h.Size())
+		}
+
+		id := test.algorithm.Sum([]byte("foobar"))
+		if id.String() != test.id {
+			t.Errorf("%v: wrong ID, want %v, got %v", test.algorithm, test.id, id)
+		}
+
+		_, _ = h.Write([]byte("foobar"))
+		if !id.Equal(IDFromHash(h.Sum(nil))) {
+			t.Errorf("%v: Sum and New differ", test.algorithm)
+		}
+	}
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for _, name := range []string{"sha256", "sha512-256", "blake2b-256", "blake3"} {
+		a, err := ParseHashAlgorithm(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(a) != name {
+			t.Errorf("wrong algorithm, want %v, got %v", name, a)
+		}
+	}
+
+	a, err := ParseHashAlgorithm("")
+	if err != nil || a != HashSHA256 {
+		t.Errorf("empty name: want %v, got %v (%v)", HashSHA256, a, err)
+	}
+
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Errorf("invalid algorithm was accepted")
+	}
+}
@@ -2,7 +2,6 @@ package restic
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"io"
@@ -10,13 +9,9 @@ import (
 	"github.com/restic/restic/internal/errors"
 )
 
-// Hash returns the ID for data.
-func Hash(data []byte) ID {
-	return sha256.Sum256(data)
-}
-
-// idSize contains the size of an ID, in bytes.
-const idSize = sha256.Size
+// idSize contains the size of an ID, in bytes. It does not depend on the
+// HashAlgorithm of the repository, all algorithms produce 256 bit digests.
+const idSize = 32
 
 // ID references content within a repository.
 type ID [idSize]byte
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 
 	"github.com/restic/restic/internal/debug"
@@ -139,8 +140,15 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 			return nil
 		}
 
-		// ignore locks that cannot be loaded
 		if err != nil {
+			// a lock which cannot be decrypted may have been created by a
+			// client with a write-only key (or, for such clients, by a client
+			// which does not share its locks yet), its type is unknown
+			if errors.Cause(err) == crypto.ErrUnauthenticated {
+				return errors.Fatalf("unable to decrypt lock %v, it may be held by a client using another key: %v", id.Str(), err)
+			}
+
+			// ignore locks that cannot be loaded
 			return nil
 		}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"strconv"
@@ -74,8 +75,9 @@ func (node Node) String() string {
 }
 
 // NodeFromFileInfo returns a new node from the given path and FileInfo. It
-// returns the first error that is encountered, together with a node.
-func NodeFromFileInfo(path string, fi os.FileInfo) (*Node, error) {
+// returns the first error that is encountered, together with a node. Extended
+// attributes are not read at all if skipXattrs is set.
+func NodeFromFileInfo(path string, fi os.FileInfo, skipXattrs bool) (*Node, error) {
 	mask := os.ModePerm | os.ModeType | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
 	node := &Node{
 		Path:    path,
@@ -89,7 +91,7 @@ func NodeFromFileInfo(path string, fi os.FileInfo) (*Node, error) {
 		node.Size = uint64(fi.Size())
 	}
 
-	err := node.fillExtra(path, fi)
+	err := node.fillExtra(path, fi, skipXattrs)
 	return node, err
 }
 
@@ -124,17 +126,38 @@ func (node Node) GetExtendedAttribute(a string) []byte {
 	return nil
 }
 
+// CreateOptions control how a node is created by CreateAt.
+type CreateOptions struct {
+	// Sparse restores blocks of files which only contain zero bytes as holes.
+	Sparse bool
+
+	// NoOwner does not restore the owner and group.
+	NoOwner bool
+
+	// NoPerms does not restore the permissions, new files and directories
+	// are created with the default permissions.
+	NoPerms bool
+}
+
+// fileMode returns the permissions for new files.
+func (opts CreateOptions) fileMode() os.FileMode {
+	if opts.NoPerms {
+		return 0666
+	}
+	return 0600
+}
+
 // CreateAt creates the node at the given path and restores all the meta data.
-func (node *Node) CreateAt(ctx context.Context, path string, repo Repository, idx *HardlinkIndex) error {
+func (node *Node) CreateAt(ctx context.Context, path string, repo Repository, idx *HardlinkIndex, opts CreateOptions) error {
 	debug.Log("create node %v at %v", node.Name, path)
 
 	switch node.Type {
 	case "dir":
-		if err := node.createDirAt(path); err != nil {
+		if err := node.createDirAt(path, opts); err != nil {
 			return err
 		}
 	case "file":
-		if err := node.createFileAt(ctx, path, repo, idx); err != nil {
+		if err := node.createFileAt(ctx, path, repo, idx, opts); err != nil {
 			return err
 		}
 	case "symlink":
@@ -154,12 +177,14 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo Repository, id
 			return err
 		}
 	case "socket":
-		return nil
+		if err := node.createSocketAt(path); err != nil {
+			return err
+		}
 	default:
 		return errors.Errorf("filetype %q not implemented!\n", node.Type)
 	}
 
-	err := node.restoreMetadata(path)
+	err := node.restoreMetadata(path, opts)
 	if err != nil {
 		debug.Log("restoreMetadata(%s) error %v", path, err)
 	}
@@ -167,14 +192,16 @@ func (node *Node) CreateAt(ctx context.Context, path string, repo Repository, id
 	return err
 }
 
-func (node Node) restoreMetadata(path string) error {
+func (node Node) restoreMetadata(path string, opts CreateOptions) error {
 	var firsterr error
 
-	if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
-		firsterr = errors.Wrap(err, "Lchown")
+	if !opts.NoOwner {
+		if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
+			firsterr = errors.Wrap(err, "Lchown")
+		}
 	}
 
-	if node.Type != "symlink" {
+	if node.Type != "symlink" && !opts.NoPerms {
 		if err := fs.Chmod(path, node.Mode); err != nil {
 			if firsterr != nil {
 				firsterr = errors.Wrap(err, "Chmod")
@@ -228,8 +255,13 @@ func (node Node) RestoreTimestamps(path string) error {
 	return nil
 }
 
-func (node Node) createDirAt(path string) error {
-	err := fs.Mkdir(path, node.Mode)
+func (node Node) createDirAt(path string, opts CreateOptions) error {
+	mode := node.Mode
+	if opts.NoPerms {
+		mode = 0777
+	}
+
+	err := fs.Mkdir(path, mode)
 	if err != nil && !os.IsExist(err) {
 		return errors.Wrap(err, "Mkdir")
 	}
@@ -237,25 +269,54 @@ func (node Node) createDirAt(path string) error {
 	return nil
 }
 
-func (node Node) createFileAt(ctx context.Context, path string, repo Repository, idx *HardlinkIndex) error {
+// createEmptyFileAt creates an empty file at path, or a hard link if another
+// link to the same file has already been restored. It returns true if the
+// content of the file still needs to be written.
+func (node Node) createEmptyFileAt(path string, idx *HardlinkIndex, opts CreateOptions) (needContent bool, err error) {
 	if node.Links > 1 && idx.Has(node.Inode, node.DeviceID) {
 		if err := fs.Remove(path); !os.IsNotExist(err) {
-			return errors.Wrap(err, "RemoveCreateHardlink")
+			return false, errors.Wrap(err, "RemoveCreateHardlink")
 		}
 		err := fs.Link(idx.GetFilename(node.Inode, node.DeviceID), path)
 		if err != nil {
-			return errors.Wrap(err, "CreateHardlink")
+			return false, errors.Wrap(err, "CreateHardlink")
 		}
-		return nil
+		return false, nil
+	}
+
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.fileMode())
+	if err != nil {
+		return false, errors.Wrap(err, "OpenFile")
+	}
+
+	if err = f.Close(); err != nil {
+		return false, errors.Wrap(err, "Close")
+	}
+
+	if node.Links > 1 {
+		idx.Add(node.Inode, node.DeviceID, path)
+	}
+
+	return true, nil
+}
+
+func (node Node) createFileAt(ctx context.Context, path string, repo Repository, idx *HardlinkIndex, opts CreateOptions) error {
+	if node.Links > 1 && idx.Has(node.Inode, node.DeviceID) {
+		_, err := node.createEmptyFileAt(path, idx, opts)
+		return err
 	}
 
-	f, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, opts.fileMode())
 	if err != nil {
 		return errors.Wrap(err, "OpenFile")
 	}
 	defer f.Close()
 
-	var buf []byte
+	var (
+		buf    []byte
+		offset int64
+		holes  bool
+	)
 	for _, id := range node.Content {
 		size, err := repo.LookupBlobSize(id, DataBlob)
 		if err != nil {
@@ -272,6 +333,16 @@ func (node Node) createFileAt(ctx context.Context, path string, repo Repository,
 			return err
 		}
 		buf = buf[:n]
+		offset += int64(n)
+
+		// skip over blocks of zeroes, so that the file system creates a hole
+		if opts.Sparse && isZero(buf) {
+			if _, err = f.Seek(offset, io.SeekStart); err != nil {
+				return errors.Wrap(err, "Seek")
+			}
+			holes = true
+			continue
+		}
 
 		_, err = f.Write(buf)
 		if err != nil {
@@ -279,6 +350,13 @@ func (node Node) createFileAt(ctx context.Context, path string, repo Repository,
 		}
 	}
 
+	// a hole at the end of the file is not created by seeking alone
+	if holes {
+		if err = f.Truncate(offset); err != nil {
+			return errors.Wrap(err, "Truncate")
+		}
+	}
+
 	if node.Links > 1 {
 		idx.Add(node.Inode, node.DeviceID, path)
 	}
@@ -286,6 +364,16 @@ func (node Node) createFileAt(ctx context.Context, path string, repo Repository,
 	return nil
 }
 
+// isZero returns true if buf only contains zero bytes.
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (node Node) createSymlinkAt(path string) error {
 	// Windows does not allow non-admins to create soft links.
 	if runtime.GOOS == "windows" {
@@ -311,6 +399,15 @@ func (node *Node) createFifoAt(path string) error {
 	return mkfifo(path, 0600)
 }
 
+// createSocketAt creates a socket file at path, nothing is listening on it.
+func (node *Node) createSocketAt(path string) error {
+	// Windows does not have socket files, they are skipped.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return mknod(path, syscall.S_IFSOCK|0600, 0)
+}
+
 func (node Node) MarshalJSON() ([]byte, error) {
 	if node.ModTime.Year() < 0 || node.ModTime.Year() > 9999 {
 		err := errors.Errorf("node %v has invalid ModTime year %d: %v",
@@ -608,7 +705,7 @@ func lookupGroup(gid string) (string, error) {
 	return group, nil
 }
 
-func (node *Node) fillExtra(path string, fi os.FileInfo) error {
+func (node *Node) fillExtra(path string, fi os.FileInfo, skipXattrs bool) error {
 	stat, ok := toStatT(fi.Sys())
 	if !ok {
 		return nil
@@ -648,6 +745,10 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 		return errors.Errorf("invalid node type %q", node.Type)
 	}
 
+	if skipXattrs {
+		return nil
+	}
+
 	if err = node.fillExtendedAttributes(path); err != nil {
 		return err
 	}
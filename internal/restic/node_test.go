@@ -29,7 +29,7 @@ func BenchmarkNodeFillUser(t *testing.B) {
 	t.ResetTimer()
 
 	for i := 0; i < t.N; i++ {
-		restic.NodeFromFileInfo(path, fi)
+		restic.NodeFromFileInfo(path, fi, false)
 	}
 
 	rtest.OK(t, tempfile.Close())
@@ -52,7 +52,7 @@ func BenchmarkNodeFromFileInfo(t *testing.B) {
 	t.ResetTimer()
 
 	for i := 0; i < t.N; i++ {
-		_, err := restic.NodeFromFileInfo(path, fi)
+		_, err := restic.NodeFromFileInfo(path, fi, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -181,7 +181,7 @@ func TestNodeRestoreAt(t *testing.T) {
 
 	for _, test := range nodeTests {
 		nodePath := filepath.Join(tempdir, test.Name)
-		rtest.OK(t, test.CreateAt(context.TODO(), nodePath, nil, idx))
+		rtest.OK(t, test.CreateAt(context.TODO(), nodePath, nil, idx, restic.CreateOptions{}))
 
 		if test.Type == "symlink" && runtime.GOOS == "windows" {
 			continue
@@ -193,7 +193,7 @@ func TestNodeRestoreAt(t *testing.T) {
 		fi, err := os.Lstat(nodePath)
 		rtest.OK(t, err)
 
-		n2, err := restic.NodeFromFileInfo(nodePath, fi)
+		n2, err := restic.NodeFromFileInfo(nodePath, fi, false)
 		rtest.OK(t, err)
 
 		rtest.Assert(t, test.Name == n2.Name,
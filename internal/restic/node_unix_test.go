@@ -3,11 +3,15 @@
 package restic
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"runtime"
 	"syscall"
 	"testing"
 	"time"
+
+	rtest "github.com/restic/restic/internal/test"
 )
 
 func stat(t testing.TB, filename string) (fi os.FileInfo, ok bool) {
@@ -116,7 +120,7 @@ func TestNodeFromFileInfo(t *testing.T) {
 				return
 			}
 
-			node, err := NodeFromFileInfo(test.filename, fi)
+			node, err := NodeFromFileInfo(test.filename, fi, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -133,3 +137,46 @@ func TestNodeFromFileInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeRestoreSpecialFiles(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, tpe := range []string{"fifo", "socket"} {
+		node := Node{
+			Name:    "test-" + tpe,
+			Type:    tpe,
+			UID:     uint32(os.Getuid()),
+			GID:     uint32(os.Getgid()),
+			Mode:    0640,
+			ModTime: time.Unix(1500000000, 0),
+		}
+
+		nodePath := filepath.Join(tempdir, node.Name)
+		if err := node.CreateAt(context.TODO(), nodePath, nil, NewHardlinkIndex(), CreateOptions{}); err != nil {
+			t.Fatalf("unable to create %v: %v", tpe, err)
+		}
+
+		fi, err := os.Lstat(nodePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n2, err := NodeFromFileInfo(nodePath, fi, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n2.Type != tpe {
+			t.Errorf("wrong type restored, want %v, got %v", tpe, n2.Type)
+		}
+
+		if n2.Mode&os.ModePerm != node.Mode {
+			t.Errorf("%v: wrong mode restored, want %v, got %v", tpe, node.Mode, n2.Mode&os.ModePerm)
+		}
+
+		if !n2.ModTime.Equal(node.ModTime) {
+			t.Errorf("%v: wrong modification time restored, want %v, got %v", tpe, node.ModTime, n2.ModTime)
+		}
+	}
+}
@@ -24,6 +24,9 @@ type Repository interface {
 
 	Config() Config
 
+	// PackSize returns the target size for new pack files.
+	PackSize() uint
+
 	LookupBlobSize(ID, BlobType) (uint, error)
 
 	List(context.Context, FileType) <-chan ID
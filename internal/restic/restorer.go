@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -11,6 +12,39 @@ import (
 	"github.com/restic/restic/internal/fs"
 )
 
+// OverwriteBehavior controls how the restorer handles files which already
+// exist in the target directory.
+type OverwriteBehavior int
+
+// The overwrite behaviors supported by the restorer.
+const (
+	// OverwriteAlways replaces all existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged replaces files whose size or modification time
+	// differ from the snapshot.
+	OverwriteIfChanged
+	// OverwriteIfNewer replaces files which are older than in the snapshot.
+	OverwriteIfNewer
+	// OverwriteNever keeps all existing files.
+	OverwriteNever
+)
+
+// ParseOverwriteBehavior parses the name of an overwrite behavior.
+func ParseOverwriteBehavior(s string) (OverwriteBehavior, error) {
+	switch s {
+	case "", "always":
+		return OverwriteAlways, nil
+	case "if-changed":
+		return OverwriteIfChanged, nil
+	case "if-newer":
+		return OverwriteIfNewer, nil
+	case "never":
+		return OverwriteNever, nil
+	}
+
+	return OverwriteAlways, errors.Errorf("invalid overwrite behavior %q", s)
+}
+
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
 	repo Repository
@@ -18,6 +52,47 @@ type Restorer struct {
 
 	Error        func(dir string, node *Node, err error) error
 	SelectFilter func(item string, dstpath string, node *Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// Progress, if set, is updated for each restored item.
+	Progress *Progress
+
+	// SkipExtendedAttributes can be set to not restore extended attributes,
+	// e.g. when the target file system does not support them.
+	SkipExtendedAttributes bool
+
+	// Sparse restores blocks of zeroes in files as holes.
+	Sparse bool
+
+	// Workers is the number of pack files which are downloaded in parallel
+	// to restore the content of files. When it is zero, a default is used.
+	Workers uint
+
+	// NoOwner does not restore the owner and group of files.
+	NoOwner bool
+
+	// NoPerms does not restore the permissions of files.
+	NoPerms bool
+
+	// Delta updates files which already exist in the target in place, only
+	// the parts which differ from the snapshot are downloaded and written.
+	Delta bool
+
+	// Overwrite controls which files already existing in the target are
+	// replaced. Directories are always restored.
+	Overwrite OverwriteBehavior
+
+	// Delete removes files and directories in the target which are not
+	// contained in the snapshot. Only items selected by SelectFilter are
+	// removed.
+	Delete bool
+
+	// PreparePacks, if set, is called with all pack files needed to restore
+	// the content of files before they are downloaded, e.g. to make sure
+	// that archived pack files can be read.
+	PreparePacks func(ctx context.Context, packs IDSet) error
+
+	// files are the regular files restored by RestoreTo.
+	files []*restoreFile
 }
 
 var restorerAbortOnAllErrors = func(str string, node *Node, err error) error { return err }
@@ -39,7 +114,7 @@ func NewRestorer(repo Repository, id ID) (*Restorer, error) {
 	return r, nil
 }
 
-func (res *Restorer) restoreTo(ctx context.Context, dst string, dir string, treeID ID, idx *HardlinkIndex) error {
+func (res *Restorer) restoreTo(ctx context.Context, dst string, dir string, treeID ID, idx *HardlinkIndex, files *filesRestorer) error {
 	tree, err := res.repo.LoadTree(ctx, treeID)
 	if err != nil {
 		return res.Error(dir, nil, err)
@@ -51,7 +126,7 @@ func (res *Restorer) restoreTo(ctx context.Context, dst string, dir string, tree
 		debug.Log("SelectFilter returned %v %v", selectedForRestore, childMayBeSelected)
 
 		if selectedForRestore {
-			err := res.restoreNodeTo(ctx, node, dir, dst, idx)
+			err := res.restoreNodeTo(ctx, node, dir, dst, idx, files)
 			if err != nil {
 				return err
 			}
@@ -63,7 +138,7 @@ func (res *Restorer) restoreTo(ctx context.Context, dst string, dir string, tree
 			}
 
 			subp := filepath.Join(dir, node.Name)
-			err = res.restoreTo(ctx, dst, subp, *node.Subtree, idx)
+			err = res.restoreTo(ctx, dst, subp, *node.Subtree, idx, files)
 			if err != nil {
 				err = res.Error(subp, node, err)
 				if err != nil {
@@ -81,16 +156,108 @@ func (res *Restorer) restoreTo(ctx context.Context, dst string, dir string, tree
 		}
 	}
 
+	if res.Delete {
+		return res.removeExtraneous(dst, dir, tree)
+	}
+
+	return nil
+}
+
+// removeExtraneous removes all items in the target directory for dir which
+// are not contained in tree and which are selected by the filter.
+func (res *Restorer) removeExtraneous(dst string, dir string, tree *Tree) error {
+	target := filepath.Join(dst, dir)
+
+	d, err := fs.Open(target)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil
+	}
+	if err != nil {
+		return res.Error(target, nil, errors.Wrap(err, "Open"))
+	}
+
+	names, err := d.Readdirnames(-1)
+	_ = d.Close()
+	if err != nil {
+		return res.Error(target, nil, errors.Wrap(err, "Readdirnames"))
+	}
+
+	known := make(map[string]struct{}, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		known[node.Name] = struct{}{}
+	}
+
+	for _, name := range names {
+		if _, ok := known[name]; ok {
+			continue
+		}
+
+		dstPath := filepath.Join(target, name)
+		fi, err := fs.Lstat(dstPath)
+		if err != nil {
+			if err = res.Error(dstPath, nil, errors.Wrap(err, "Lstat")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		node := &Node{Name: name, Type: nodeTypeFromFileInfo(fi)}
+		selected, _ := res.SelectFilter(filepath.Join(dir, name), dstPath, node)
+		if !selected {
+			continue
+		}
+
+		debug.Log("removing extraneous %v", dstPath)
+		if err = fs.RemoveAll(dstPath); err != nil {
+			if err = res.Error(dstPath, node, errors.Wrap(err, "RemoveAll")); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func (res *Restorer) restoreNodeTo(ctx context.Context, node *Node, dir string, dst string, idx *HardlinkIndex) error {
+func (res *Restorer) restoreNodeTo(ctx context.Context, node *Node, dir string, dst string, idx *HardlinkIndex, files *filesRestorer) error {
 	debug.Log("node %v, dir %v, dst %v", node.Name, dir, dst)
 	dstPath := filepath.Join(dst, dir, node.Name)
 
-	err := node.CreateAt(ctx, dstPath, res.repo, idx)
+	if res.SkipExtendedAttributes && len(node.ExtendedAttributes) > 0 {
+		n := *node
+		n.ExtendedAttributes = nil
+		node = &n
+	}
+
+	if node.Type != "dir" && !res.shouldOverwrite(node, dstPath) {
+		debug.Log("keeping existing file %v", dstPath)
+		res.Progress.Report(nodeStat(node))
+		return nil
+	}
+
+	// the content and metadata of files is restored later by files
+	create := func() error {
+		if node.Type != "file" {
+			return node.CreateAt(ctx, dstPath, res.repo, idx, res.createOptions())
+		}
+
+		hardlink := node.Links > 1 && idx.Has(node.Inode, node.DeviceID)
+		if res.Delta && !hardlink && isRegularFile(dstPath) {
+			if node.Links > 1 {
+				idx.Add(node.Inode, node.DeviceID, dstPath)
+			}
+			return files.addExistingFile(node, dstPath)
+		}
+
+		needContent, err := node.createEmptyFileAt(dstPath, idx, res.createOptions())
+		if err != nil {
+			return err
+		}
+		return files.addFile(node, dstPath, needContent)
+	}
+
+	err := create()
 	if err != nil {
-		debug.Log("node.CreateAt(%s) error %v", dstPath, err)
+		debug.Log("creating %s failed: %v", dstPath, err)
 	}
 
 	// Did it fail because of ENOENT?
@@ -100,28 +267,244 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *Node, dir string,
 		// Create parent directories and retry
 		err = fs.MkdirAll(filepath.Dir(dstPath), 0700)
 		if err == nil || os.IsExist(errors.Cause(err)) {
-			err = node.CreateAt(ctx, dstPath, res.repo, idx)
+			err = create()
 		}
 	}
 
 	if err != nil {
 		debug.Log("error %v", err)
+		res.Progress.Report(Stat{Errors: 1})
 		err = res.Error(dstPath, node, err)
 		if err != nil {
 			return err
 		}
+		return nil
 	}
 
+	if node.Type == "file" {
+		return nil
+	}
+
+	res.Progress.Report(nodeStat(node))
 	debug.Log("successfully restored %v", node.Name)
 
 	return nil
 }
 
+// restoreFiles writes the content of all files created during the restore
+// and restores their metadata afterwards.
+func (res *Restorer) restoreFiles(ctx context.Context, files *filesRestorer) error {
+	if res.PreparePacks != nil {
+		packs := NewIDSet()
+		for id := range files.packs {
+			packs.Insert(id)
+		}
+
+		err := res.PreparePacks(ctx, packs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := files.restoreFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files.files {
+		err := files.finish(f)
+		if err != nil {
+			debug.Log("error %v", err)
+			res.Progress.Report(Stat{Errors: 1})
+			err = res.Error(f.path, f.node, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		res.Progress.Report(nodeStat(f.node))
+		debug.Log("successfully restored %v", f.path)
+	}
+
+	return nil
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
+	res.Progress.Start()
+	defer res.Progress.Done()
+
 	idx := NewHardlinkIndex()
-	return res.restoreTo(ctx, dst, string(filepath.Separator), *res.sn.Tree, idx)
+	files := newFilesRestorer(res.repo, int(res.Workers), res.createOptions())
+
+	err := res.restoreTo(ctx, dst, string(filepath.Separator), *res.sn.Tree, idx, files)
+	if err != nil {
+		return err
+	}
+
+	res.files = files.files
+	return res.restoreFiles(ctx, files)
+}
+
+// VerifyFiles reads all regular files restored by RestoreTo again and checks
+// that their content matches the blobs in the snapshot. Mismatches are
+// reported to res.Error. It returns the number of files which were checked.
+func (res *Restorer) VerifyFiles(ctx context.Context) (int, error) {
+	var buf []byte
+	count := 0
+
+	for _, f := range res.files {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+
+		count++
+		err := res.verifyFile(f, &buf)
+		if err != nil {
+			debug.Log("verifying %v failed: %v", f.path, err)
+			err = res.Error(f.path, f.node, err)
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// verifyFile checks that the content of the file f matches its blobs.
+func (res *Restorer) verifyFile(f *restoreFile, buf *[]byte) error {
+	rd, err := fs.Open(f.path)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+	defer rd.Close()
+
+	var offset int64
+	for _, id := range f.node.Content {
+		size, err := res.repo.LookupBlobSize(id, DataBlob)
+		if err != nil {
+			return err
+		}
+
+		if uint(cap(*buf)) < size {
+			*buf = make([]byte, size)
+		}
+		data := (*buf)[:size]
+
+		_, err = io.ReadFull(rd, data)
+		if err != nil {
+			return errors.Errorf("file is too short at offset %d: %v", offset, err)
+		}
+
+		if !res.repo.Config().Hash.Sum(data).Equal(id) {
+			return errors.Errorf("content at offset %d does not match blob %v", offset, id.Str())
+		}
+
+		offset += int64(size)
+	}
+
+	// the file must not contain any additional data
+	n, err := rd.Read(make([]byte, 1))
+	if n > 0 {
+		return errors.Errorf("file is larger than %d bytes", offset)
+	}
+	if err != io.EOF {
+		return errors.Wrap(err, "Read")
+	}
+
+	return nil
+}
+
+// createOptions returns the options for creating nodes.
+func (res *Restorer) createOptions() CreateOptions {
+	return CreateOptions{
+		Sparse:  res.Sparse,
+		NoOwner: res.NoOwner,
+		NoPerms: res.NoPerms,
+	}
+}
+
+// shouldOverwrite returns true if node should be restored at path, depending
+// on the overwrite behavior and the file which already exists there.
+func (res *Restorer) shouldOverwrite(node *Node, path string) bool {
+	if res.Overwrite == OverwriteAlways {
+		return true
+	}
+
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		// the file does not exist or cannot be accessed, try to restore it
+		return true
+	}
+
+	switch res.Overwrite {
+	case OverwriteIfChanged:
+		return !fi.Mode().IsRegular() || node.Type != "file" ||
+			uint64(fi.Size()) != node.Size || !fi.ModTime().Equal(node.ModTime)
+	case OverwriteIfNewer:
+		return node.ModTime.After(fi.ModTime())
+	}
+
+	return false
+}
+
+// isRegularFile returns true if path is a regular file.
+func isRegularFile(path string) bool {
+	fi, err := fs.Lstat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// nodeStat returns the statistics for restoring node.
+func nodeStat(node *Node) Stat {
+	switch node.Type {
+	case "dir":
+		return Stat{Dirs: 1}
+	case "file":
+		return Stat{Files: 1, Bytes: node.Size}
+	default:
+		return Stat{Files: 1}
+	}
+}
+
+// Scan returns the statistics for the items which are restored by RestoreTo
+// for the target directory dst, without restoring anything. It is used to
+// calculate the progress of the restore.
+func (res *Restorer) Scan(ctx context.Context, dst string) (Stat, error) {
+	var stat Stat
+	err := res.scan(ctx, dst, string(filepath.Separator), *res.sn.Tree, &stat)
+	return stat, err
+}
+
+func (res *Restorer) scan(ctx context.Context, dst string, dir string, treeID ID, stat *Stat) error {
+	tree, err := res.repo.LoadTree(ctx, treeID)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range tree.Nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		selectedForRestore, childMayBeSelected := res.SelectFilter(filepath.Join(dir, node.Name),
+			filepath.Join(dst, dir, node.Name), node)
+
+		if selectedForRestore {
+			stat.Add(nodeStat(node))
+		}
+
+		if node.Type == "dir" && childMayBeSelected && node.Subtree != nil {
+			err = res.scan(ctx, dst, filepath.Join(dir, node.Name), *node.Subtree, stat)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 // Snapshot returns the snapshot this restorer is configured to use.
@@ -0,0 +1,304 @@
+package restic
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+)
+
+// defaultRestoreWorkers is the number of pack files downloaded in parallel
+// when Restorer.Workers is not set.
+const defaultRestoreWorkers = 8
+
+// restoreFile is a regular file which has been created during the restore,
+// its content is written by the filesRestorer.
+type restoreFile struct {
+	node *Node
+	path string
+	size int64
+
+	// existing is set when the file was already present in the target and
+	// only the blobs which differ are written.
+	existing bool
+
+	m   sync.Mutex
+	err error
+}
+
+func (f *restoreFile) setError(err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// packBlob is a blob in a pack file which needs to be written to a file at
+// the given offset.
+type packBlob struct {
+	Blob
+	file   *restoreFile
+	offset int64
+}
+
+// filesRestorer writes the content of files. Instead of restoring the files
+// one by one, the blobs of all files are grouped by the pack they are stored
+// in, and each pack is downloaded only once. Several packs are processed
+// concurrently, so that large restores are not bound by the latency of the
+// backend.
+type filesRestorer struct {
+	repo    Repository
+	workers int
+	opts    CreateOptions
+
+	files []*restoreFile
+	packs map[ID][]packBlob
+}
+
+func newFilesRestorer(repo Repository, workers int, opts CreateOptions) *filesRestorer {
+	if workers <= 0 {
+		workers = defaultRestoreWorkers
+	}
+
+	return &filesRestorer{
+		repo:    repo,
+		workers: workers,
+		opts:    opts,
+		packs:   make(map[ID][]packBlob),
+	}
+}
+
+// addFile records that the file for node has been created at path. When
+// withContent is set, the content of the file is written by restoreFiles.
+func (r *filesRestorer) addFile(node *Node, path string, withContent bool) error {
+	f := &restoreFile{node: node, path: path}
+
+	if withContent {
+		var offset int64
+		for _, id := range node.Content {
+			blobs, err := r.repo.Index().Lookup(id, DataBlob)
+			if err != nil {
+				return err
+			}
+
+			pb := blobs[0]
+			r.packs[pb.PackID] = append(r.packs[pb.PackID], packBlob{Blob: pb.Blob, file: f, offset: offset})
+			offset += int64(pb.DataLength())
+		}
+		f.size = offset
+	}
+
+	r.files = append(r.files, f)
+	return nil
+}
+
+// addExistingFile records that the file for node already exists at path. The
+// current content is compared against the blobs of the node, and only the
+// blobs which differ are written by restoreFiles.
+func (r *filesRestorer) addExistingFile(node *Node, path string) error {
+	f := &restoreFile{node: node, path: path, existing: true}
+
+	rd, err := fs.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+	defer rd.Close()
+
+	var (
+		buf      []byte
+		offset   int64
+		readable = true
+	)
+	for _, id := range node.Content {
+		blobs, err := r.repo.Index().Lookup(id, DataBlob)
+		if err != nil {
+			return err
+		}
+
+		pb := blobs[0]
+		size := int(pb.DataLength())
+
+		// once the end of the existing file is reached, all following blobs
+		// need to be written
+		if readable {
+			if cap(buf) < size {
+				buf = make([]byte, size)
+			}
+			buf = buf[:size]
+
+			_, err = io.ReadFull(rd, buf)
+			if err != nil {
+				readable = false
+			} else if r.repo.Config().Hash.Sum(buf).Equal(id) {
+				offset += int64(size)
+				continue
+			}
+		}
+
+		r.packs[pb.PackID] = append(r.packs[pb.PackID], packBlob{Blob: pb.Blob, file: f, offset: offset})
+		offset += int64(size)
+	}
+	f.size = offset
+
+	r.files = append(r.files, f)
+	return nil
+}
+
+// restoreFiles downloads all packs needed for the files and writes the blobs
+// to the files. Errors for individual files are recorded for the file.
+func (r *filesRestorer) restoreFiles(ctx context.Context) error {
+	ch := make(chan ID)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ch {
+				r.restorePack(ctx, id, r.packs[id])
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for id := range r.packs {
+		select {
+		case ch <- id:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	return err
+}
+
+// restorePack downloads the part of the pack which contains the blobs and
+// writes them to the files.
+func (r *filesRestorer) restorePack(ctx context.Context, id ID, blobs []packBlob) {
+	debug.Log("restore %d blobs from pack %v", len(blobs), id.Str())
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Offset < blobs[j].Offset
+	})
+
+	start := blobs[0].Offset
+	end := start
+	for _, blob := range blobs {
+		if blob.Offset+blob.Length > end {
+			end = blob.Offset + blob.Length
+		}
+	}
+
+	fail := func(err error) {
+		for _, blob := range blobs {
+			blob.file.setError(err)
+		}
+	}
+
+	h := Handle{Type: DataFile, Name: id.String()}
+	buf := make([]byte, end-start)
+	if _, err := ReadAt(ctx, r.repo.Backend(), h, int64(start), buf); err != nil {
+		fail(err)
+		return
+	}
+
+	files := make(map[*restoreFile]*os.File)
+	defer func() {
+		for f, wr := range files {
+			if err := wr.Close(); err != nil {
+				f.setError(errors.Wrap(err, "Close"))
+			}
+		}
+	}()
+
+	var (
+		plaintext []byte
+		lastID    ID
+		lastErr   error
+	)
+	for i, blob := range blobs {
+		// the same blob may be needed several times, only decrypt it once
+		if i == 0 || !blob.ID.Equal(lastID) {
+			lastID = blob.ID
+			plaintext, lastErr = r.decryptBlob(blob, buf[blob.Offset-start:blob.Offset-start+blob.Length])
+		}
+
+		if lastErr != nil {
+			blob.file.setError(lastErr)
+			continue
+		}
+
+		// skip over blocks of zeroes, so that the file system creates a hole
+		if r.opts.Sparse && !blob.file.existing && isZero(plaintext) {
+			continue
+		}
+
+		wr, ok := files[blob.file]
+		if !ok {
+			var err error
+			wr, err = fs.OpenFile(blob.file.path, os.O_WRONLY, 0600)
+			if err != nil {
+				blob.file.setError(errors.Wrap(err, "OpenFile"))
+				continue
+			}
+			files[blob.file] = wr
+		}
+
+		if _, err := wr.WriteAt(plaintext, blob.offset); err != nil {
+			blob.file.setError(errors.Wrap(err, "WriteAt"))
+		}
+	}
+}
+
+// decryptBlob decrypts and decompresses the ciphertext of blob and checks the
+// plaintext hash.
+func (r *filesRestorer) decryptBlob(blob packBlob, ciphertext []byte) ([]byte, error) {
+	plaintext := make([]byte, len(ciphertext))
+	n, err := r.repo.Key().Decrypt(plaintext, ciphertext)
+	if err != nil {
+		return nil, errors.Errorf("decrypting blob %v failed: %v", blob.ID, err)
+	}
+	plaintext = plaintext[:n]
+
+	if blob.IsCompressed() {
+		plaintext, err = Decompress(nil, plaintext, blob.UncompressedLength)
+		if err != nil {
+			return nil, errors.Errorf("decompressing blob %v failed: %v", blob.ID, err)
+		}
+	}
+
+	if !r.repo.Config().Hash.Sum(plaintext).Equal(blob.ID) {
+		return nil, errors.Errorf("blob %v returned invalid hash", blob.ID)
+	}
+
+	return plaintext, nil
+}
+
+// finish completes a file after its content has been written: the size of
+// sparse files is set, and the metadata is restored.
+func (r *filesRestorer) finish(f *restoreFile) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	// a hole at the end of the file is not created by skipping the blocks,
+	// and an existing file may have been larger before
+	if (r.opts.Sparse && f.size > 0) || f.existing {
+		if err := fs.Truncate(f.path, f.size); err != nil {
+			return errors.Wrap(err, "Truncate")
+		}
+	}
+
+	return f.node.restoreMetadata(f.path, r.opts)
+}
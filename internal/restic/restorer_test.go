@@ -0,0 +1,71 @@
+package restic_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerVerifyFiles(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	sn := restic.TestCreateSnapshot(t, repo, testSnapshotTime, testDepth, 0)
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	res, err := restic.NewRestorer(repo, *sn.ID())
+	rtest.OK(t, err)
+
+	var errs []string
+	res.Error = func(path string, node *restic.Node, err error) error {
+		errs = append(errs, path)
+		return nil
+	}
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+	rtest.Equals(t, 0, len(errs))
+
+	count, err := res.VerifyFiles(context.TODO())
+	rtest.OK(t, err)
+	rtest.Assert(t, count > 0, "no files were verified")
+	rtest.Equals(t, 0, len(errs))
+
+	// modify one byte in a restored file
+	var modified string
+	err = filepath.Walk(tempdir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || modified != "" || !fi.Mode().IsRegular() || fi.Size() == 0 {
+			return err
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		buf := []byte{0}
+		if _, err = f.ReadAt(buf, fi.Size()/2); err != nil {
+			_ = f.Close()
+			return err
+		}
+		buf[0]++
+		if _, err = f.WriteAt(buf, fi.Size()/2); err != nil {
+			_ = f.Close()
+			return err
+		}
+		modified = path
+		return f.Close()
+	})
+	rtest.OK(t, err)
+	rtest.Assert(t, modified != "", "no file found to modify")
+
+	_, err = res.VerifyFiles(context.TODO())
+	rtest.OK(t, err)
+	rtest.Assert(t, len(errs) > 0 && errs[0] == modified,
+		"modified file %v not reported, errors: %v", modified, errs)
+}
@@ -22,6 +22,7 @@ type Snapshot struct {
 	GID      uint32    `json:"gid,omitempty"`
 	Excludes []string  `json:"excludes,omitempty"`
 	Tags     []string  `json:"tags,omitempty"`
+	Holds    []string  `json:"holds,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
 	id *ID // plaintext ID, used during restore
@@ -100,36 +101,66 @@ func (sn *Snapshot) fillUserInfo() error {
 // AddTags adds the given tags to the snapshots tags, preventing duplicates.
 // It returns true if any changes were made.
 func (sn *Snapshot) AddTags(addTags []string) (changed bool) {
-nextTag:
-	for _, add := range addTags {
-		for _, tag := range sn.Tags {
-			if tag == add {
-				continue nextTag
+	sn.Tags, changed = addToList(sn.Tags, addTags)
+	return changed
+}
+
+// RemoveTags removes the given tags from the snapshots tags and
+// returns true if any changes were made.
+func (sn *Snapshot) RemoveTags(removeTags []string) (changed bool) {
+	sn.Tags, changed = removeFromList(sn.Tags, removeTags)
+	return changed
+}
+
+// AddHolds adds the given holds to the snapshot, preventing duplicates. A
+// snapshot with holds is never removed by forget. It returns true if any
+// changes were made.
+func (sn *Snapshot) AddHolds(holds []string) (changed bool) {
+	sn.Holds, changed = addToList(sn.Holds, holds)
+	return changed
+}
+
+// RemoveHolds removes the given holds from the snapshot and returns true if
+// any changes were made.
+func (sn *Snapshot) RemoveHolds(holds []string) (changed bool) {
+	sn.Holds, changed = removeFromList(sn.Holds, holds)
+	return changed
+}
+
+// Held returns true if the snapshot has at least one hold.
+func (sn *Snapshot) Held() bool {
+	return len(sn.Holds) > 0
+}
+
+func addToList(list []string, add []string) (result []string, changed bool) {
+next:
+	for _, a := range add {
+		for _, item := range list {
+			if item == a {
+				continue next
 			}
 		}
-		sn.Tags = append(sn.Tags, add)
+		list = append(list, a)
 		changed = true
 	}
-	return
+	return list, changed
 }
 
-// RemoveTags removes the given tags from the snapshots tags and
-// returns true if any changes were made.
-func (sn *Snapshot) RemoveTags(removeTags []string) (changed bool) {
-	for _, remove := range removeTags {
-		for i, tag := range sn.Tags {
-			if tag == remove {
+func removeFromList(list []string, remove []string) (result []string, changed bool) {
+	for _, r := range remove {
+		for i, item := range list {
+			if item == r {
 				// https://github.com/golang/go/wiki/SliceTricks
-				sn.Tags[i] = sn.Tags[len(sn.Tags)-1]
-				sn.Tags[len(sn.Tags)-1] = ""
-				sn.Tags = sn.Tags[:len(sn.Tags)-1]
+				list[i] = list[len(list)-1]
+				list[len(list)-1] = ""
+				list = list[:len(list)-1]
 
 				changed = true
 				break
 			}
 		}
 	}
-	return
+	return list, changed
 }
 
 func (sn *Snapshot) hasTag(tag string) bool {
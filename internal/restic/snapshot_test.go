@@ -14,3 +14,18 @@ func TestNewSnapshot(t *testing.T) {
 	_, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
 	rtest.OK(t, err)
 }
+
+func TestSnapshotHolds(t *testing.T) {
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, []string{"foo"}, "foo", time.Now())
+	rtest.OK(t, err)
+	rtest.Assert(t, !sn.Held(), "new snapshot is held")
+
+	rtest.Assert(t, sn.AddHolds([]string{"legal-2024"}), "adding a hold did not change the snapshot")
+	rtest.Assert(t, !sn.AddHolds([]string{"legal-2024"}), "adding a hold twice changed the snapshot")
+	rtest.Assert(t, sn.Held(), "snapshot is not held")
+	rtest.Equals(t, []string{"foo"}, sn.Tags)
+
+	rtest.Assert(t, !sn.RemoveHolds([]string{"other"}), "removing an unknown hold changed the snapshot")
+	rtest.Assert(t, sn.RemoveHolds([]string{"legal-2024"}), "removing the hold did not change the snapshot")
+	rtest.Assert(t, !sn.Held(), "snapshot is still held")
+}
@@ -52,7 +52,7 @@ func (fs *fakeFileSystem) saveFile(ctx context.Context, rd io.Reader) (blobs IDs
 			fs.t.Fatalf("unable to save chunk in repo: %v", err)
 		}
 
-		id := Hash(chunk.Data)
+		id := fs.repo.Config().Hash.Sum(chunk.Data)
 		if !fs.blobIsKnown(id, DataBlob) {
 			_, err := fs.repo.SaveBlob(ctx, DataBlob, chunk.Data, id)
 			if err != nil {
@@ -82,7 +82,7 @@ func (fs *fakeFileSystem) treeIsKnown(tree *Tree) (bool, []byte, ID) {
 	}
 	data = append(data, '\n')
 
-	id := Hash(data)
+	id := fs.repo.Config().Hash.Sum(data)
 	return fs.blobIsKnown(id, TreeBlob), data, id
 }
 
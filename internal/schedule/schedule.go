@@ -0,0 +1,210 @@
+// Package schedule parses cron expressions and computes when they are due.
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar are set if the day of the month or the day of the
+	// week is "*". If both are restricted, a day matches if either of them
+	// matches, as with cron.
+	domStar, dowStar bool
+}
+
+// field describes the range of the values of a field in a cron expression.
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField = field{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Parse parses a cron expression with the five fields minute, hour, day of
+// month, month and day of week. Each field may be "*", a value, a range
+// "a-b", a list "a,b,c", and have a step "*/n" or "a-b/n". Months and days
+// of the week can also be given by their English three-letter names. The
+// shortcuts @hourly, @daily, @weekly, @monthly and @yearly are supported.
+func Parse(spec string) (*Schedule, error) {
+	if s, ok := shortcuts[spec]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+	for _, f := range []struct {
+		bits *uint64
+		def  field
+		str  string
+	}{
+		{&s.minute, minuteField, fields[0]},
+		{&s.hour, hourField, fields[1]},
+		{&s.dom, domField, fields[2]},
+		{&s.month, monthField, fields[3]},
+		{&s.dow, dowField, fields[4]},
+	} {
+		*f.bits, err = parseField(f.str, f.def)
+		if err != nil {
+			return nil, errors.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+
+	// 7 is an alias for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	return s, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	for i, name := range f.names {
+		if strings.ToLower(s) == name {
+			return i + f.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid %s %q", f.name, s)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, errors.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// parseField returns a bit set of the values matched by s.
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %s %q", f.name, part)
+			}
+			part = part[:i]
+		}
+
+		var start, end int
+		switch {
+		case part == "*":
+			start, end = f.min, f.max
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			start, err = parseValue(part[:i], f)
+			if err != nil {
+				return 0, err
+			}
+			end, err = parseValue(part[i+1:], f)
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, errors.Errorf("invalid range in %s %q", f.name, part)
+			}
+		default:
+			v, err := parseValue(part, f)
+			if err != nil {
+				return 0, err
+			}
+			start, end = v, v
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first time after t at which the schedule is due. If the
+// schedule is never due (e.g. on February 30th), the zero time is returned.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
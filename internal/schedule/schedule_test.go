@@ -0,0 +1,59 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNext(t *testing.T) {
+	// Tuesday
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2018, 1, 2, 3, 5, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2018, 1, 2, 3, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2018, 1, 3, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, 1, 2, 3, 15, 0, 0, time.UTC)},
+		{"0 1-5/2 * * *", time.Date(2018, 1, 2, 5, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 5", time.Date(2018, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@daily", time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			s, err := Parse(test.spec)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.next, s.Next(start))
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"foo * * * *",
+		"@often",
+	} {
+		_, err := Parse(spec)
+		rtest.Assert(t, err != nil, "expected an error for %q", spec)
+	}
+}
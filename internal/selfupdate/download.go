@@ -0,0 +1,283 @@
+package selfupdate
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+// findHash returns the SHA256 hash for filename from the contents of a
+// SHA256SUMS file.
+func findHash(buf []byte, filename string) (hash []byte, err error) {
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	for sc.Scan() {
+		data := strings.Fields(sc.Text())
+		if len(data) != 2 {
+			continue
+		}
+
+		if data[1] == filename {
+			h, err := hex.DecodeString(data[0])
+			if err != nil {
+				return nil, err
+			}
+
+			return h, nil
+		}
+	}
+
+	return nil, errors.Errorf("hash for file %v not found", filename)
+}
+
+// releaseKeyFingerprint is the fingerprint of the PGP key which signs the
+// SHA256SUMS files of the restic releases.
+const releaseKeyFingerprint = "CF8F18F2844575973F79D4E191A6868BD3F7A907"
+
+// releaseKeyURL is the location the release key is downloaded from, it is
+// changed in the tests. Only a key with the embedded fingerprint is used, so
+// the server does not need to be trusted.
+var releaseKeyURL = "https://keys.openpgp.org/vks/v1/by-fingerprint/" + releaseKeyFingerprint
+
+// filterFingerprint returns the keys from the list with the fingerprint given
+// in hexadecimal.
+func filterFingerprint(keys openpgp.EntityList, fingerprint string) openpgp.EntityList {
+	var res openpgp.EntityList
+	for _, key := range keys {
+		if fmt.Sprintf("%X", key.PrimaryKey.Fingerprint) == fingerprint {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// releaseKey downloads the key which signs the releases and checks its
+// fingerprint.
+func releaseKey(ctx context.Context, printf func(string, ...interface{})) (openpgp.EntityList, error) {
+	printf("download release key %v\n", releaseKeyFingerprint)
+	buf, err := getGithubData(ctx, releaseKeyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "download release key")
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadArmoredKeyRing")
+	}
+
+	keys = filterFingerprint(keys, releaseKeyFingerprint)
+	if len(keys) == 0 {
+		return nil, errors.Errorf("downloaded key does not have the fingerprint %v", releaseKeyFingerprint)
+	}
+
+	return keys, nil
+}
+
+// verifySignature checks that sig is a valid armored detached signature for
+// data, made by one of the keys.
+func verifySignature(keys openpgp.EntityList, data, sig []byte) error {
+	_, err := openpgp.CheckArmoredDetachedSignature(keys, bytes.NewReader(data), bytes.NewReader(sig))
+	if err != nil {
+		return errors.Wrap(err, "CheckArmoredDetachedSignature")
+	}
+
+	return nil
+}
+
+// extract returns the binary from the release archive buf.
+func extract(buf []byte, filename string) ([]byte, error) {
+	switch filepath.Ext(filename) {
+	case ".bz2":
+		return ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(buf)))
+	case ".zip":
+		zrd, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(zrd.File) != 1 {
+			return nil, errors.New("ZIP archive contains more than one file")
+		}
+
+		rd, err := zrd.File[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rd.Close()
+
+		return ioutil.ReadAll(rd)
+	default:
+		return nil, errors.Errorf("unknown archive format for %v", filename)
+	}
+}
+
+// replaceFile atomically replaces the file target with the data, the new file
+// is executed to check that it works before.
+func replaceFile(target string, data []byte, printf func(string, ...interface{})) error {
+	fi, err := os.Stat(target)
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+
+	// write the new binary to a temporary file in the same directory, so that
+	// it can be renamed to the target afterwards
+	f, err := ioutil.TempFile(filepath.Dir(target), "restic-update-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+	tmpname := f.Name()
+
+	removeTemp := func() {
+		_ = f.Close()
+		_ = os.Remove(tmpname)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		removeTemp()
+		return errors.Wrap(err, "Write")
+	}
+
+	if err = f.Close(); err != nil {
+		removeTemp()
+		return errors.Wrap(err, "Close")
+	}
+
+	if err = os.Chmod(tmpname, fi.Mode()); err != nil {
+		removeTemp()
+		return errors.Wrap(err, "Chmod")
+	}
+
+	printf("test new binary\n")
+	out, err := exec.Command(tmpname, "version").Output()
+	if err != nil {
+		removeTemp()
+		return errors.Errorf("running the new binary failed: %v", err)
+	}
+	printf("%s", out)
+
+	if runtime.GOOS == "windows" {
+		// a running executable cannot be replaced on Windows, but it can be
+		// renamed
+		old := target + ".old"
+		_ = os.Remove(old)
+		if err = os.Rename(target, old); err != nil {
+			removeTemp()
+			return errors.Wrap(err, "Rename")
+		}
+	}
+
+	if err = os.Rename(tmpname, target); err != nil {
+		removeTemp()
+		return errors.Wrap(err, "Rename")
+	}
+
+	return nil
+}
+
+// DownloadLatestStableRelease downloads the latest stable released version of
+// restic and replaces the binary at target with it. The signature of the
+// checksums is verified with the keys in the armored keyring, or with the
+// release key if keyring is nil, and the checksum of the downloaded file is
+// verified. Nothing is done if the latest version is currentVersion.
+func DownloadLatestStableRelease(ctx context.Context, target, currentVersion string, keyring io.Reader, printf func(string, ...interface{})) (version string, err error) {
+	if printf == nil {
+		printf = func(string, ...interface{}) {}
+	}
+
+	var keys openpgp.EntityList
+	if keyring != nil {
+		keys, err = openpgp.ReadArmoredKeyRing(keyring)
+		if err != nil {
+			return "", errors.Wrap(err, "ReadArmoredKeyRing")
+		}
+	}
+
+	printf("find latest release of restic at GitHub\n")
+
+	rel, err := GitHubLatestRelease(ctx, "restic", "restic")
+	if err != nil {
+		return "", err
+	}
+
+	if rel.Version == currentVersion {
+		printf("restic is up to date\n")
+		return currentVersion, nil
+	}
+
+	printf("latest version is %v\n", rel.Version)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	sums, err := getGithubDataFile(ctx, rel.Assets, "SHA256SUMS", printf)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := getGithubDataFile(ctx, rel.Assets, "SHA256SUMS.asc", printf)
+	if err != nil {
+		return "", err
+	}
+
+	if keys == nil {
+		keys, err = releaseKey(ctx, printf)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err = verifySignature(keys, sums, sig); err != nil {
+		return "", errors.Errorf("signature verification failed: %v", err)
+	}
+
+	printf("signature for SHA256SUMS is valid\n")
+
+	ext := "bz2"
+	if runtime.GOOS == "windows" {
+		ext = "zip"
+	}
+
+	filename := fmt.Sprintf("restic_%s_%s_%s.%s", rel.Version, runtime.GOOS, runtime.GOARCH, ext)
+	hash, err := findHash(sums, filename)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := getGithubDataFile(ctx, rel.Assets, filename, printf)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(buf)
+	if !bytes.Equal(sum[:], hash) {
+		return "", errors.Errorf("hash of downloaded file %v does not match, expected %x, got %x", filename, hash, sum)
+	}
+
+	printf("hash for %v is valid\n", filename)
+
+	data, err := extract(buf, filename)
+	if err != nil {
+		return "", err
+	}
+
+	if err = replaceFile(target, data, printf); err != nil {
+		return "", err
+	}
+
+	return rel.Version, nil
+}
@@ -0,0 +1,98 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+var testSums = []byte(`
+4b9a1e32b3d7e5b1d8e0ba6a4bc1b2f2a3c8e5a4f1f1e0b3e8e8b0a3e0a1f1c2  restic_0.8.0_linux_amd64.bz2
+1f2e3d4c5b6a79880f1e2d3c4b5a69788f9e0d1c2b3a4958675f4e3d2c1b0a99  restic_0.8.0_windows_amd64.zip
+invalid line
+`)
+
+func TestFindHash(t *testing.T) {
+	hash, err := findHash(testSums, "restic_0.8.0_windows_amd64.zip")
+	rtest.OK(t, err)
+	rtest.Equals(t, "1f2e3d4c5b6a79880f1e2d3c4b5a69788f9e0d1c2b3a4958675f4e3d2c1b0a99", hex.EncodeToString(hash))
+
+	_, err = findHash(testSums, "restic_0.8.0_darwin_amd64.bz2")
+	rtest.Assert(t, err != nil, "expected an error for a missing file")
+}
+
+func TestVerifySignature(t *testing.T) {
+	entity, err := openpgp.NewEntity("restic test", "", "test@example.com", nil)
+	rtest.OK(t, err)
+
+	// SerializePrivate signs the identities of the new key, which is required
+	// before the public key can be serialized
+	rtest.OK(t, entity.SerializePrivate(ioutil.Discard, nil))
+
+	var keyring bytes.Buffer
+	w, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	rtest.OK(t, err)
+	rtest.OK(t, entity.Serialize(w))
+	rtest.OK(t, w.Close())
+
+	var sig bytes.Buffer
+	rtest.OK(t, openpgp.ArmoredDetachSign(&sig, entity, bytes.NewReader(testSums), nil))
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring.Bytes()))
+	rtest.OK(t, err)
+
+	rtest.OK(t, verifySignature(keys, testSums, sig.Bytes()))
+
+	modified := append([]byte("x"), testSums...)
+	err = verifySignature(keys, modified, sig.Bytes())
+	rtest.Assert(t, err != nil, "signature for modified data is valid")
+}
+
+func TestFilterFingerprint(t *testing.T) {
+	entity, err := openpgp.NewEntity("restic test", "", "test@example.com", nil)
+	rtest.OK(t, err)
+
+	keys := openpgp.EntityList{entity}
+	fingerprint := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+
+	rtest.Equals(t, 1, len(filterFingerprint(keys, fingerprint)))
+	rtest.Equals(t, 0, len(filterFingerprint(keys, releaseKeyFingerprint)))
+}
+
+func TestGitHubLatestRelease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/restic/restic/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name": "restic 0.8.0", "tag_name": "v0.8.0",
+			"assets": [{"id": 1, "name": "SHA256SUMS", "url": "http://example.com/1", "size": 23}]}`)
+	}))
+	defer srv.Close()
+
+	oldAPI := githubAPI
+	githubAPI = srv.URL
+	defer func() {
+		githubAPI = oldAPI
+	}()
+
+	rel, err := GitHubLatestRelease(context.TODO(), "restic", "restic")
+	rtest.OK(t, err)
+	rtest.Equals(t, "0.8.0", rel.Version)
+	rtest.Equals(t, 1, len(rel.Assets))
+	rtest.Equals(t, "SHA256SUMS", rel.Assets[0].Name)
+
+	_, err = GitHubLatestRelease(context.TODO(), "restic", "other")
+	rtest.Assert(t, err != nil, "expected an error for a missing release")
+}
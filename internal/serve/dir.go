@@ -0,0 +1,136 @@
+package serve
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/dirstruct"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// fileInfo implements os.FileInfo for the directories of the virtual
+// structure.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func virtualDirInfo(name string, modTime time.Time) os.FileInfo {
+	return fileInfo{name: name, mode: os.ModeDir | 0555, modTime: modTime}
+}
+
+// nodeInfo implements os.FileInfo for a node in a snapshot.
+type nodeInfo struct {
+	node *restic.Node
+}
+
+func (fi nodeInfo) Name() string       { return fi.node.Name }
+func (fi nodeInfo) Size() int64        { return int64(fi.node.Size) }
+func (fi nodeInfo) ModTime() time.Time { return fi.node.ModTime }
+func (fi nodeInfo) IsDir() bool        { return fi.node.Type == "dir" }
+func (fi nodeInfo) Sys() interface{}   { return fi.node }
+
+func (fi nodeInfo) Mode() os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir | fi.node.Mode.Perm()
+	}
+	return fi.node.Mode.Perm()
+}
+
+// dir is an open directory, either of the virtual structure or within a
+// snapshot.
+type dir struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+	pos     int
+}
+
+func newVirtualDir(name string, modTime time.Time, d *dirstruct.Dir) *dir {
+	var entries []os.FileInfo
+	for name := range d.Dirs {
+		entries = append(entries, virtualDirInfo(name, modTime))
+	}
+
+	for name, sn := range d.Snapshots {
+		entries = append(entries, nodeInfo{snapshotNode(name, sn)})
+	}
+
+	if latest := d.LatestName(); latest != "" {
+		entries = append(entries, nodeInfo{snapshotNode("latest", d.Snapshots[latest])})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return &dir{info: virtualDirInfo(name, modTime), entries: entries}
+}
+
+func newTreeDir(ctx context.Context, repo restic.Repository, node *restic.Node) (*dir, error) {
+	tree, err := repo.LoadTree(ctx, *node.Subtree)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []os.FileInfo
+	for _, n := range tree.Nodes {
+		if served(n) {
+			entries = append(entries, nodeInfo{n})
+		}
+	}
+
+	return &dir{info: nodeInfo{node}, entries: entries}, nil
+}
+
+// Readdir returns the next count entries of the directory, or all remaining
+// entries if count <= 0.
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.pos += count
+	return rest[:count], nil
+}
+
+func (d *dir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *dir) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *dir) Close() error {
+	return nil
+}
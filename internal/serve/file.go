@@ -0,0 +1,134 @@
+package serve
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// file is an open file within a snapshot. The content is loaded one blob at
+// a time while the file is read.
+type file struct {
+	ctx  context.Context
+	repo restic.Repository
+	node *restic.Node
+
+	// offsets contains the offset of each blob in the file, followed by the
+	// size of the file.
+	offsets []int64
+	pos     int64
+
+	blobIdx int
+	blob    []byte
+}
+
+func newFile(ctx context.Context, repo restic.Repository, node *restic.Node) (*file, error) {
+	debug.Log("open file %v with %d blobs", node.Name, len(node.Content))
+	offsets := make([]int64, len(node.Content)+1)
+	for i, id := range node.Content {
+		size, err := repo.LookupBlobSize(id, restic.DataBlob)
+		if err != nil {
+			return nil, err
+		}
+
+		offsets[i+1] = offsets[i] + int64(size)
+	}
+
+	size := offsets[len(offsets)-1]
+	if uint64(size) != node.Size {
+		debug.Log("sizes do not match: node.Size %v != size %v, using real size", node.Size, size)
+		n := *node
+		n.Size = uint64(size)
+		node = &n
+	}
+
+	return &file{
+		ctx:     ctx,
+		repo:    repo,
+		node:    node,
+		offsets: offsets,
+		blobIdx: -1,
+	}, nil
+}
+
+func (f *file) size() int64 {
+	return f.offsets[len(f.offsets)-1]
+}
+
+func (f *file) loadBlob(i int) ([]byte, error) {
+	if i == f.blobIdx {
+		return f.blob, nil
+	}
+
+	buf := restic.NewBlobBuffer(int(f.offsets[i+1] - f.offsets[i]))
+	n, err := f.repo.LoadBlob(f.ctx, restic.DataBlob, f.node.Content[i], buf)
+	if err != nil {
+		debug.Log("LoadBlob(%v, %v) failed: %v", f.node.Name, f.node.Content[i], err)
+		return nil, err
+	}
+
+	f.blobIdx = i
+	f.blob = buf[:n]
+	return f.blob, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.pos >= f.size() {
+		return 0, io.EOF
+	}
+
+	// find the blob containing the current position
+	i := sort.Search(len(f.node.Content), func(i int) bool {
+		return f.offsets[i+1] > f.pos
+	})
+
+	blob, err := f.loadBlob(i)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, blob[f.pos-f.offsets[i]:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	f.pos = offset
+	return f.pos, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return nodeInfo{f.node}, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *file) Close() error {
+	f.blob = nil
+	return nil
+}
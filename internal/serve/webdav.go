@@ -0,0 +1,212 @@
+// Package serve makes the snapshots in a repository available over HTTP.
+package serve
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/dirstruct"
+	"github.com/restic/restic/internal/restic"
+
+	"golang.org/x/net/webdav"
+)
+
+// Config holds settings for serving the repository.
+type Config struct {
+	Host  string
+	Tags  []restic.TagList
+	Paths []string
+
+	// PathTemplates describe the directory structure for the snapshots, see
+	// package dirstruct for the supported placeholders.
+	PathTemplates []string
+}
+
+// NewWebDAV returns a read-only WebDAV handler which serves the snapshots in
+// repo in the same directory structure as the mount command.
+func NewWebDAV(ctx context.Context, repo restic.Repository, cfg Config) (*webdav.Handler, error) {
+	fs, err := NewRepoFileSystem(ctx, repo, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(req *http.Request, err error) {
+			if err != nil {
+				debug.Log("webdav: %v %v: %v", req.Method, req.URL.Path, err)
+			}
+		},
+	}
+	return h, nil
+}
+
+// RepoFileSystem is a read-only webdav.FileSystem which contains the
+// snapshots of a repository.
+type RepoFileSystem struct {
+	repo    restic.Repository
+	root    *dirstruct.Dir
+	modTime time.Time
+}
+
+// statically ensure that RepoFileSystem implements webdav.FileSystem
+var _ webdav.FileSystem = &RepoFileSystem{}
+
+// NewRepoFileSystem returns a new file system for the snapshots in repo
+// which match cfg. The index of repo must already be loaded.
+func NewRepoFileSystem(ctx context.Context, repo restic.Repository, cfg Config) (*RepoFileSystem, error) {
+	snapshots := restic.FindFilteredSnapshots(ctx, repo, cfg.Host, cfg.Tags, cfg.Paths)
+	debug.Log("found %d matching snapshots", len(snapshots))
+
+	root, err := dirstruct.Build(cfg.PathTemplates, snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoFileSystem{
+		repo:    repo,
+		root:    root,
+		modTime: time.Now(),
+	}, nil
+}
+
+// Mkdir is not supported.
+func (fs *RepoFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// RemoveAll is not supported.
+func (fs *RepoFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+// Rename is not supported.
+func (fs *RepoFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+// OpenFile opens the file or directory name for reading.
+func (fs *RepoFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	debug.Log("OpenFile(%v, %x)", name, flag)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	e, err := fs.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.dir != nil:
+		return newVirtualDir(e.name, fs.modTime, e.dir), nil
+	case e.node.Type == "dir":
+		return newTreeDir(ctx, fs.repo, e.node)
+	default:
+		return newFile(ctx, fs.repo, e.node)
+	}
+}
+
+// Stat returns information about the file or directory name.
+func (fs *RepoFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	debug.Log("Stat(%v)", name)
+	e, err := fs.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.dir != nil {
+		return virtualDirInfo(e.name, fs.modTime), nil
+	}
+	return nodeInfo{e.node}, nil
+}
+
+// entry is either a directory of the virtual structure or a node within a
+// snapshot.
+type entry struct {
+	name string
+	dir  *dirstruct.Dir
+	node *restic.Node
+}
+
+// lookup returns the entry for name.
+func (fs *RepoFileSystem) lookup(ctx context.Context, name string) (entry, error) {
+	var components []string
+	for _, c := range strings.Split(path.Clean("/"+name), "/") {
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+
+	e := entry{name: "/", dir: fs.root}
+	for _, c := range components {
+		var err error
+		e, err = fs.lookupChild(ctx, e, c)
+		if err != nil {
+			return entry{}, err
+		}
+	}
+
+	return e, nil
+}
+
+// lookupChild returns the entry called name in the directory e.
+func (fs *RepoFileSystem) lookupChild(ctx context.Context, e entry, name string) (entry, error) {
+	if e.dir != nil {
+		if dir, ok := e.dir.Dirs[name]; ok {
+			return entry{name: name, dir: dir}, nil
+		}
+
+		sn, ok := e.dir.Snapshots[name]
+		if !ok && name == "latest" {
+			sn, ok = e.dir.Snapshots[e.dir.LatestName()]
+		}
+		if !ok {
+			return entry{}, os.ErrNotExist
+		}
+
+		return entry{name: name, node: snapshotNode(name, sn)}, nil
+	}
+
+	if e.node.Type != "dir" {
+		return entry{}, os.ErrNotExist
+	}
+
+	tree, err := fs.repo.LoadTree(ctx, *e.node.Subtree)
+	if err != nil {
+		return entry{}, err
+	}
+
+	for _, node := range tree.Nodes {
+		if node.Name == name && served(node) {
+			return entry{name: name, node: node}, nil
+		}
+	}
+
+	return entry{}, os.ErrNotExist
+}
+
+// snapshotNode returns a directory node for the root of the snapshot sn.
+func snapshotNode(name string, sn *restic.Snapshot) *restic.Node {
+	return &restic.Node{
+		Name:       name,
+		Type:       "dir",
+		Mode:       os.ModeDir | 0555,
+		ModTime:    sn.Time,
+		AccessTime: sn.Time,
+		ChangeTime: sn.Time,
+		Subtree:    sn.Tree,
+	}
+}
+
+// served returns true if node is presented via WebDAV. Only files and
+// directories are served, WebDAV cannot represent symlinks and special files.
+func served(node *restic.Node) bool {
+	return node.Type == "file" || node.Type == "dir"
+}
@@ -0,0 +1,112 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/repository"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRepoFileSystem(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	data := rtest.Random(23, 5*1024*1024+123)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file"), data, 0644))
+	rtest.OK(t, os.Symlink("file", filepath.Join(tempdir, "link")))
+
+	archiver.TestSnapshot(t, repo, tempdir, nil)
+	base := filepath.Base(tempdir)
+
+	ctx := context.TODO()
+	fs, err := NewRepoFileSystem(ctx, repo, Config{})
+	rtest.OK(t, err)
+
+	names := func(name string) []string {
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		rtest.OK(t, err)
+		defer f.Close()
+
+		entries, err := f.Readdir(0)
+		rtest.OK(t, err)
+
+		var names []string
+		for _, fi := range entries {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	rtest.Equals(t, []string{"hosts", "ids", "snapshots", "tags"}, names("/"))
+	ids := names("/ids")
+	rtest.Equals(t, 1, len(ids))
+	rtest.Equals(t, []string{base}, names("/ids/"+ids[0]))
+	rtest.Equals(t, []string{"file"}, names("/snapshots/latest/"+base))
+
+	fi, err := fs.Stat(ctx, "/snapshots/latest/"+base+"/file")
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size())
+	rtest.Assert(t, !fi.IsDir(), "file is reported as a directory")
+
+	_, err = fs.Stat(ctx, "/snapshots/latest/"+base+"/link")
+	rtest.Assert(t, os.IsNotExist(err), "expected not exist error for symlink, got %v", err)
+
+	_, err = fs.OpenFile(ctx, "/snapshots/latest/"+base+"/file", os.O_RDWR, 0)
+	rtest.Equals(t, os.ErrPermission, err)
+
+	f, err := fs.OpenFile(ctx, "/snapshots/latest/"+base+"/file", os.O_RDONLY, 0)
+	rtest.OK(t, err)
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "file content does not match")
+
+	offset := int64(len(data) - 1000)
+	pos, err := f.Seek(offset, io.SeekStart)
+	rtest.OK(t, err)
+	rtest.Equals(t, offset, pos)
+
+	buf, err = ioutil.ReadAll(f)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data[offset:], buf), "file content after seek does not match")
+}
+
+func TestWebDAVGet(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	data := rtest.Random(42, 1234)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "file"), data, 0644))
+
+	archiver.TestSnapshot(t, repo, tempdir, nil)
+
+	h, err := NewWebDAV(context.TODO(), repo, Config{})
+	rtest.OK(t, err)
+
+	url := "/snapshots/latest/" + filepath.Base(tempdir) + "/file"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	rtest.Equals(t, http.StatusOK, rec.Code)
+	rtest.Assert(t, bytes.Equal(data, rec.Body.Bytes()), "wrong content returned")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", url, bytes.NewReader([]byte("foo"))))
+	rtest.Assert(t, rec.Code >= 400, "PUT returned status %v", rec.Code)
+}
@@ -0,0 +1,131 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8), so that a
+// secret can be split into n shares of which any k are needed to reconstruct
+// it.
+package shamir
+
+import (
+	"crypto/rand"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// expTable and logTable contain powers and discrete logarithms for the
+// generator 3 in GF(2^8) with the reduction polynomial x^8+x^4+x^3+x+1.
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+
+		// multiply by 3 = x + 1
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	expTable[255] = expTable[0]
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Split splits the secret into n shares, any k of which can be combined to
+// reconstruct the secret. The first byte of each share is its x coordinate,
+// the remaining bytes are the values of the polynomials at x.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, errors.Errorf("invalid parameters, need 2 <= k (%d) <= n (%d) <= 255", k, n)
+	}
+
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	// one random polynomial of degree k-1 per byte of the secret, with the
+	// secret as the constant term
+	coeffs := make([]byte, k)
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, errors.Wrap(err, "Read")
+		}
+
+		for _, share := range shares {
+			x := share[0]
+
+			// evaluate with Horner's method
+			var y byte
+			for c := k - 1; c >= 0; c-- {
+				y = mul(y, x) ^ coeffs[c]
+			}
+			share[j+1] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs the secret from the shares. At least k shares which
+// have been returned by the same call to Split are needed, otherwise the
+// result is garbage.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are needed")
+	}
+
+	size := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, errors.New("shares have different or invalid sizes")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, errors.New("shares are invalid or duplicated")
+		}
+		seen[share[0]] = true
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, size-1)
+	for i, si := range shares {
+		var num, den byte = 1, 1
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			num = mul(num, sj[0])
+			den = mul(den, si[0]^sj[0])
+		}
+		l := div(num, den)
+
+		for b := range secret {
+			secret[b] ^= mul(si[b+1], l)
+		}
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,56 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMul(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			p := mul(byte(a), byte(b))
+			rtest.Assert(t, div(p, byte(b)) == byte(a), "div(mul(%d, %d)) != %d", a, b, a)
+		}
+	}
+
+	// the example from FIPS 197
+	rtest.Equals(t, byte(0xc1), mul(0x57, 0x83))
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := rtest.Random(23, 64)
+
+	shares, err := Split(secret, 5, 3)
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(shares))
+
+	// all combinations of three shares reconstruct the secret
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				res, err := Combine([][]byte{shares[i], shares[j], shares[k]})
+				rtest.OK(t, err)
+				rtest.Equals(t, secret, res)
+			}
+		}
+	}
+
+	res, err := Combine(shares)
+	rtest.OK(t, err)
+	rtest.Equals(t, secret, res)
+
+	// two shares are not enough
+	res, err = Combine(shares[:2])
+	rtest.OK(t, err)
+	rtest.Assert(t, !bytes.Equal(secret, res), "secret reconstructed from two shares")
+
+	_, err = Combine([][]byte{shares[0], shares[0], shares[1]})
+	rtest.Assert(t, err != nil, "duplicate shares accepted")
+
+	for _, p := range [][2]int{{1, 1}, {3, 4}, {256, 3}, {5, 1}} {
+		_, err = Split(secret, p[0], p[1])
+		rtest.Assert(t, err != nil, "Split(n=%d, k=%d) succeeded", p[0], p[1])
+	}
+}
@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// The types below are the JSON encoding of an ExportTraceServiceRequest of
+// OTLP, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// Only the fields used by restic are included.
+
+type jsonRequest struct {
+	ResourceSpans []jsonResourceSpans `json:"resourceSpans"`
+}
+
+type jsonResourceSpans struct {
+	Resource   jsonResource     `json:"resource"`
+	ScopeSpans []jsonScopeSpans `json:"scopeSpans"`
+}
+
+type jsonResource struct {
+	Attributes []Attribute `json:"attributes"`
+}
+
+type jsonScopeSpans struct {
+	Scope jsonScope  `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type jsonScope struct {
+	Name string `json:"name"`
+}
+
+const spanKindInternal = 1
+
+type jsonSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []Attribute `json:"attributes,omitempty"`
+	Status            *jsonStatus `json:"status,omitempty"`
+}
+
+const statusCodeError = 2
+
+type jsonStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// newOTLPExporter returns an exporter which sends the spans to an OTLP
+// collector via HTTP, using the JSON encoding. The endpoint and additional
+// headers are taken from the environment variables defined by OpenTelemetry.
+func newOTLPExporter() (exportFunc, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = "http://localhost:4318"
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, errors.Wrap(err, "invalid OTLP endpoint")
+	}
+
+	header := make(http.Header)
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		if err := parseHeaders(header, os.Getenv(env)); err != nil {
+			return nil, errors.Wrapf(err, "invalid $%v", env)
+		}
+	}
+
+	return func(ctx context.Context, res jsonResource, spans []jsonSpan) error {
+		body, err := json.Marshal(jsonRequest{
+			ResourceSpans: []jsonResourceSpans{{
+				Resource: res,
+				ScopeSpans: []jsonScopeSpans{{
+					Scope: jsonScope{Name: scopeName},
+					Spans: spans,
+				}},
+			}},
+		})
+		if err != nil {
+			return errors.Wrap(err, "Marshal")
+		}
+
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "NewRequest")
+		}
+		req = req.WithContext(ctx)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "export spans")
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.Errorf("export spans to %v: %v", endpoint, resp.Status)
+		}
+		return nil
+	}, nil
+}
+
+// parseHeaders adds the headers in s, which has the form
+// "key1=value1,key2=value2" with URL encoded values, to header.
+func parseHeaders(header http.Header, s string) error {
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("header %q has no value", item)
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return err
+		}
+		header.Set(strings.TrimSpace(parts[0]), value)
+	}
+
+	return nil
+}
+
+// newWriterExporter returns an exporter which writes each span as a line of
+// JSON to w.
+func newWriterExporter(w io.Writer) exportFunc {
+	var m sync.Mutex
+	return func(ctx context.Context, res jsonResource, spans []jsonSpan) error {
+		m.Lock()
+		defer m.Unlock()
+
+		enc := json.NewEncoder(w)
+		for _, span := range spans {
+			if err := enc.Encode(span); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,292 @@
+// Package tracing records spans for backend requests and the stages of the
+// archiver and exports them in the OpenTelemetry protocol (OTLP). Unless Setup
+// has been called, spans are not recorded and the functions in this package do
+// nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Exporters which can be passed to Setup.
+const (
+	// ExporterOTLP sends the spans to an OTLP collector via HTTP. The
+	// collector is configured with the standard environment variables, e.g.
+	// OTEL_EXPORTER_OTLP_ENDPOINT (default: http://localhost:4318).
+	ExporterOTLP = "otlp"
+	// ExporterStderr writes the spans as JSON to the writer passed to Setup,
+	// which is stderr so that the output of restic is not affected.
+	ExporterStderr = "stderr"
+)
+
+const scopeName = "github.com/restic/restic"
+
+// batchSize is the number of ended spans which are exported together.
+const batchSize = 512
+
+// Attribute is a key and a value which describe a span.
+type Attribute struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue is the JSON encoding of an attribute value in OTLP, integers are
+// encoded as strings.
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+// String returns an attribute with a string value.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+// Int returns an attribute with an integer value.
+func Int(key string, value int) Attribute {
+	return Int64(key, int64(value))
+}
+
+// Int64 returns an attribute with an integer value.
+func Int64(key string, value int64) Attribute {
+	s := strconv.FormatInt(value, 10)
+	return Attribute{Key: key, Value: anyValue{IntValue: &s}}
+}
+
+// Span is an operation with a start and an end time. Spans with the same
+// trace ID form a tree. The methods of a nil *Span do nothing.
+type Span struct {
+	t        *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+
+	m     sync.Mutex
+	attrs []Attribute
+	err   error
+	ended bool
+}
+
+// SetAttributes adds the attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.m.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.m.Unlock()
+}
+
+// End ends the span, it is exported afterwards. Calling End more than once
+// does nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	end := time.Now()
+
+	s.m.Lock()
+	if s.ended {
+		s.m.Unlock()
+		return
+	}
+	s.ended = true
+
+	js := jsonSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        s.attrs,
+	}
+	if s.parentID != [8]byte{} {
+		js.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		js.Status = &jsonStatus{Code: statusCodeError, Message: s.err.Error()}
+	}
+	s.m.Unlock()
+
+	s.t.add(js)
+}
+
+type spanKey struct{}
+
+var (
+	currentMutex sync.RWMutex
+	current      *tracer
+)
+
+// Start starts a span with the name and the attributes, it is a child of the
+// span in ctx. The returned context contains the new span.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	currentMutex.RLock()
+	t := current
+	currentMutex.RUnlock()
+
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		t:     t,
+		name:  name,
+		start: time.Now(),
+		attrs: attrs,
+	}
+
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		randomID(s.traceID[:])
+	}
+	randomID(s.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// End ends the span, a non-nil err is recorded and marks the span as failed.
+func End(s *Span, err error) {
+	if s == nil {
+		return
+	}
+
+	if err != nil {
+		s.m.Lock()
+		s.err = err
+		s.m.Unlock()
+	}
+	s.End()
+}
+
+func randomID(buf []byte) {
+	// an ID which is not unique only confuses the trace viewer
+	_, _ = io.ReadFull(rand.Reader, buf)
+}
+
+// exportFunc exports a batch of spans of the resource.
+type exportFunc func(ctx context.Context, res jsonResource, spans []jsonSpan) error
+
+// tracer collects ended spans and exports them in batches.
+type tracer struct {
+	export   exportFunc
+	resource jsonResource
+
+	m     sync.Mutex
+	spans []jsonSpan
+	err   error
+	wg    sync.WaitGroup
+}
+
+func (t *tracer) add(s jsonSpan) {
+	t.m.Lock()
+	t.spans = append(t.spans, s)
+	if len(t.spans) < batchSize {
+		t.m.Unlock()
+		return
+	}
+
+	batch := t.spans
+	t.spans = nil
+	t.m.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.send(context.Background(), batch)
+	}()
+}
+
+func (t *tracer) send(ctx context.Context, spans []jsonSpan) {
+	err := t.export(ctx, t.resource, spans)
+	if err != nil {
+		debug.Log("exporting %d spans failed: %v", len(spans), err)
+
+		t.m.Lock()
+		if t.err == nil {
+			t.err = err
+		}
+		t.m.Unlock()
+	}
+}
+
+// flush exports the remaining spans and returns the first error that
+// occurred while exporting spans.
+func (t *tracer) flush(ctx context.Context) error {
+	t.m.Lock()
+	batch := t.spans
+	t.spans = nil
+	t.m.Unlock()
+
+	if len(batch) > 0 {
+		t.send(ctx, batch)
+	}
+	t.wg.Wait()
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.err
+}
+
+// shutdownTimeout is the time the function returned by Setup waits for the
+// remaining spans to be exported.
+const shutdownTimeout = 5 * time.Second
+
+// Setup starts recording spans and exporting them with the exporter, the
+// stderr exporter writes to w. The returned function must be called before
+// restic exits, it exports the remaining spans and stops recording spans.
+func Setup(exporter string, version string, w io.Writer) (shutdown func() error, err error) {
+	var export exportFunc
+	switch exporter {
+	case ExporterOTLP:
+		export, err = newOTLPExporter()
+	case ExporterStderr:
+		export = newWriterExporter(w)
+	default:
+		return nil, errors.Fatalf("invalid trace exporter %q, valid exporters are %q and %q",
+			exporter, ExporterOTLP, ExporterStderr)
+	}
+	if err != nil {
+		return nil, errors.Fatalf("unable to export traces: %v", err)
+	}
+
+	t := &tracer{
+		export: export,
+		resource: jsonResource{Attributes: []Attribute{
+			String("service.name", "restic"),
+			String("service.version", version),
+		}},
+	}
+
+	currentMutex.Lock()
+	current = t
+	currentMutex.Unlock()
+
+	return func() error {
+		currentMutex.Lock()
+		if current == t {
+			current = nil
+		}
+		currentMutex.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return t.flush(ctx)
+	}, nil
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestNotSetup(t *testing.T) {
+	ctx, span := Start(context.TODO(), "foo", String("key", "value"))
+	rtest.Assert(t, span == nil, "span recorded without Setup")
+	rtest.Equals(t, context.TODO(), ctx)
+
+	// the methods of a nil span do nothing
+	span.SetAttributes(Int("n", 1))
+	End(span, errors.New("failed"))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		m        sync.Mutex
+		requests []jsonRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+			r.Header.Get("Authorization") != "Bearer secret token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var req jsonRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m.Lock()
+		requests = append(requests, req)
+		m.Unlock()
+	}))
+	defer srv.Close()
+
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	rtest.OK(t, os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL+"/"))
+	rtest.OK(t, os.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""))
+	rtest.OK(t, os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20secret%20token"))
+
+	shutdown, err := Setup(ExporterOTLP, "test", nil)
+	rtest.OK(t, err)
+
+	ctx, root := Start(context.TODO(), "root")
+	for i := 0; i < batchSize; i++ {
+		_, span := Start(ctx, "child", Int("i", i))
+		End(span, nil)
+	}
+	End(root, errors.New("failed"))
+
+	rtest.OK(t, shutdown())
+
+	// spans are not recorded after shutdown
+	_, span := Start(context.TODO(), "foo")
+	rtest.Assert(t, span == nil, "span recorded after shutdown")
+
+	rtest.Equals(t, 2, len(requests))
+
+	var spans []jsonSpan
+	for _, req := range requests {
+		rtest.Equals(t, 1, len(req.ResourceSpans))
+		rs := req.ResourceSpans[0]
+		rtest.Equals(t, "service.name", rs.Resource.Attributes[0].Key)
+		rtest.Equals(t, "restic", *rs.Resource.Attributes[0].Value.StringValue)
+		rtest.Equals(t, scopeName, rs.ScopeSpans[0].Scope.Name)
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	rtest.Equals(t, batchSize+1, len(spans))
+
+	// the batches are sent concurrently
+	var rootSpan jsonSpan
+	for _, span := range spans {
+		if span.Name == "root" {
+			rootSpan = span
+		}
+	}
+	rtest.Equals(t, "", rootSpan.ParentSpanID)
+	rtest.Equals(t, statusCodeError, rootSpan.Status.Code)
+	rtest.Equals(t, "failed", rootSpan.Status.Message)
+
+	for _, span := range spans {
+		if span.Name == "root" {
+			continue
+		}
+		rtest.Equals(t, "child", span.Name)
+		rtest.Equals(t, rootSpan.TraceID, span.TraceID)
+		rtest.Equals(t, rootSpan.SpanID, span.ParentSpanID)
+		rtest.Assert(t, span.Status == nil, "unexpected status %v", span.Status)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	header := make(http.Header)
+	rtest.OK(t, parseHeaders(header, "api-key=foo%3Dbar, x-tenant = restic,"))
+	rtest.Equals(t, "foo=bar", header.Get("Api-Key"))
+	rtest.Equals(t, "restic", header.Get("X-Tenant"))
+
+	rtest.Assert(t, parseHeaders(header, "api-key") != nil, "expected error for header without value")
+}